@@ -1,23 +1,43 @@
 // Package main implements a configurable MCP (Model Context Protocol) server.
-// The server supports both stdio and SSE transports and can be configured
+// The server supports stdio, SSE and streamable HTTP transports and can be configured
 // via YAML files to provide various tools including builtin, command, and script types.
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"dizi/internal/approval"
+	"dizi/internal/audit"
+	"dizi/internal/auth"
 	"dizi/internal/config"
+	"dizi/internal/listener"
 	"dizi/internal/logger"
+	"dizi/internal/profile"
+	"dizi/internal/sandbox"
+	"dizi/internal/server"
 	"dizi/internal/tools"
 
 	"github.com/chzyer/readline"
+	"github.com/mark3labs/mcp-go/mcp"
 	mcpserver "github.com/mark3labs/mcp-go/server"
 	libs "github.com/vadv/gopher-lua-libs"
 	lua "github.com/yuin/gopher-lua"
@@ -55,6 +75,9 @@ var helpersLuaScript string
 // It parses command line arguments, loads configuration, registers tools,
 // and starts the server with the specified transport method.
 func main() {
+	// A sandboxed tool runs through this binary first
+	sandbox.Init()
+
 	// Check for subcommands before parsing flags
 	if len(os.Args) > 1 {
 		subcommand := os.Args[1]
@@ -73,19 +96,38 @@ func main() {
 			case "version":
 				versionCommand()
 				return
+			case "config":
+				configCommand()
+				return
+			case "validate":
+				validateCommand()
+				return
+			case "approve":
+				approveCommand()
+				return
+			case "audit":
+				auditCommand()
+				return
+			case "token":
+				tokenCommand()
+				return
 			}
 		}
 	}
 
 	// Parse command line flags for server mode
 	var (
-		transport     = flag.String("transport", "sse", "Transport method: stdio or sse")
-		host          = flag.String("host", "localhost", "Host for SSE transport")
-		portFlag      = flag.Int("port", 0, "Port for SSE transport (overrides config)")
+		transport     = flag.String("transport", "sse", "Transport method: stdio, sse, streamable-http or both as sse,streamable-http")
+		host          = flag.String("host", "localhost", "Host for HTTP transports")
+		profileName   = flag.String("profile", "", "Limit the stdio client to a profile from dizi.yml")
+		listen        = flag.String("listen", "", "Address for HTTP transports, host:port or unix:/path/to.sock (overrides -host, -port and server.listen)")
+		portFlag      = flag.Int("port", 0, "Port for HTTP transports (overrides config)")
 		enableFsTools = flag.Bool("fs-tools", false, "Enable filesystem tools")
 		// fsRootDir     = flag.String("fs-root", "", "Root directory for filesystem tools")
-		workDir = flag.String("workdir", "", "Working directory for the server")
-		help    = flag.Bool("help", false, "Show help information")
+		workDir    = flag.String("workdir", "", "Working directory for the server")
+		configPath = flag.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+		watch      = flag.Bool("watch", true, "Reload dizi.yml when it changes")
+		help       = flag.Bool("help", false, "Show help information")
 	)
 
 	flag.Parse()
@@ -99,10 +141,16 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load()
+	loadOptions := config.LoadOptions{Path: *configPath}
+	layered, err := config.LoadLayered(loadOptions)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	cfg := layered.Config
+	logger.AddSecrets(cfg.Secrets()...)
+	if layered.Path != "" {
+		logger.InfoLog("Using config file: %s", layered.Path)
+	}
 
 	if *help {
 		showHelp(cfg)
@@ -115,17 +163,86 @@ func main() {
 		port = *portFlag
 	}
 
+	// The HTTP transports listen on -listen, server.listen or -host and the
+	// port; with server.tls they and the approval endpoint serve HTTPS
+	address := *listen
+	if address == "" {
+		address = cfg.Server.Listen
+	}
+	if address == "" {
+		address = net.JoinHostPort(*host, strconv.Itoa(port))
+	}
+	if *transport == "stdio" {
+		address = approvalAddress(cfg, *listen, projectDir(layered))
+	}
+	tlsConfig, err := listener.ServerTLS(cfg.Server.TLS, listener.Host(address))
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+
+	// Profiles limit clients to some tools: over stdio the one named by
+	// -profile, over HTTP the one of the token or ?profile=
+	profiles, err := profile.NewSet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load profiles: %v", err)
+	}
+	if err := profiles.Check(*profileName); err != nil {
+		log.Fatalf("Invalid -profile: %v, expected one of %s", err, strings.Join(profiles.Names(), ", "))
+	}
+	if *profileName != "" && *transport != "stdio" {
+		log.Fatalf("-profile only applies to stdio, HTTP clients choose a profile with their token or ?profile=%s", *profileName)
+	}
+
 	// Create MCP server with config values
-	mcpServer := mcpserver.NewMCPServer(cfg.Name, cfg.Version)
+	// The HTTP server adds its own hooks for per-session tools, and limits
+	// each request to the tools its token and profile may use
+	hooks := &mcpserver.Hooks{}
+	mcpServer := mcpserver.NewMCPServer(cfg.Name, cfg.Version, append(tools.ServerOptions(hooks), server.ScopeOptions(profiles)...)...)
+
+	// Requests need a bearer token once server.auth is configured
+	authenticators, err := auth.New(cfg.Server.Auth)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %v", err)
+	}
+
+	// Calls to tools with confirm: true wait here until someone decides on
+	// them. The HTTP server serves the approval endpoint itself; in stdio mode
+	// it listens on its own once a call waits, requiring the same tokens.
+	// Tools approved for a session are forgotten when the session ends.
+	approvalOptions := approval.Options{Timeout: cfg.Server.Approval.Timeout, Log: cfg.Server.Approval.Log}
+	if *transport == "stdio" {
+		approvalOptions.Listen = address
+		approvalOptions.TLS = tlsConfig
+		approvalOptions.Auth = authenticators
+		if address == approvalSocket(projectDir(layered)) {
+			socket, _ := listener.SocketPath(address)
+			if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+				log.Fatalf("Failed to create the directory of the approval socket: %v", err)
+			}
+		}
+	}
+	approvals := approval.NewBroker(approvalOptions)
+	hooks.AddOnUnregisterSession(func(_ context.Context, session mcpserver.ClientSession) {
+		approvals.Forget(session.SessionID())
+	})
+
+	// Every tool call is recorded once server.audit.path is set
+	auditLog := newAuditLog(cfg)
 
 	// Register tools from config
-	if err := tools.RegisterTools(mcpServer, cfg.Tools); err != nil {
+	toolSet := tools.NewToolSet(mcpServer)
+	if _, err := toolSet.Apply(cfg.Tools, toolOptions(cfg, approvals, auditLog)...); err != nil {
 		log.Fatalf("Failed to register tools: %v", err)
 	}
 
+	// Reload tools when dizi.yml changes, keeping connected clients
+	if *watch {
+		go watchConfig(mcpServer, toolSet, profiles, loadOptions, approvals, auditLog)
+	}
+
 	// Register filesystem tools if enabled
 	if *enableFsTools {
-		fsConfig := &tools.FilesystemConfig{}
+		fsConfig := &tools.FilesystemConfig{Confirm: cfg.Server.Approval.Confirm, Approvals: approvals, Audit: auditLog}
 
 		// Use command line fs-root if provided, otherwise default to project directory
 		// if *fsRootDir != "" {
@@ -151,51 +268,501 @@ func main() {
 	logger.SetupLogger(*transport)
 
 	// Start server based on transport
-	switch *transport {
-	case "stdio":
+	if *transport == "stdio" {
 		// Silent start for stdio mode
-		if err := mcpserver.ServeStdio(mcpServer); err != nil {
+		stdioProfile := mcpserver.WithStdioContextFunc(func(ctx context.Context) context.Context {
+			return profile.WithProfile(ctx, *profileName)
+		})
+		if err := mcpserver.ServeStdio(mcpServer, stdioProfile); err != nil {
 			log.Fatalf("Failed to start stdio server: %v", err)
 		}
-	case "sse":
-		logger.InfoLog("Starting %s v%s - %s with SSE transport", cfg.Name, cfg.Version, cfg.Description)
-
-		// Register filesystem tools if enabled
-		if *enableFsTools {
-			fsConfig := &tools.FilesystemConfig{}
-
-			// Use command line fs-root if provided, otherwise default to project directory
-			// if *fsRootDir != "" {
-			// 	fsConfig.RootDirectory = *fsRootDir
-			// } else {
-			// Default to current working directory (project directory)
-			pwd, err := os.Getwd()
-			if err != nil {
-				fsConfig.RootDirectory = "."
+		return
+	}
+
+	// The HTTP transports share the MCP server and a port, so SSE and
+	// streamable HTTP clients can connect at the same time
+	transports, err := server.ParseTransports(*transport)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unsupported transport: %s\n", *transport)
+		showHelp(cfg)
+		os.Exit(1)
+	}
+	logger.InfoLog("Starting %s v%s - %s with %s transport", cfg.Name, cfg.Version, cfg.Description, strings.Join(transports, " and "))
+
+	// Each SSE session may ask for filesystem tools of its own with
+	// ?include_fs_tools=true, rooted in the project directory or ?fs_root
+	pwd, err := os.Getwd()
+	if err != nil {
+		pwd = "."
+	}
+	serverOptions := server.Options{
+		Address:    address,
+		TLS:        tlsConfig,
+		Transports: transports,
+		Filesystem: tools.FilesystemConfig{RootDirectory: pwd, Confirm: cfg.Server.Approval.Confirm, Approvals: approvals, Audit: auditLog},
+		FsRoots:    cfg.Server.FsRoots,
+		Handlers:   map[string]http.Handler{approval.Path: approvals.Handler(), approval.Path + "/": approvals.Handler()},
+		Auth:       authenticators,
+		Profiles:   profiles,
+	}
+	if err := server.Start(cfg, mcpServer, hooks, serverOptions); err != nil {
+		log.Fatalf("Failed to start HTTP server: %v", err)
+	}
+}
+
+// toolOptions returns the server-wide settings for registering tools
+func toolOptions(cfg *config.Config, approvals *approval.Broker, auditLog *audit.Log) []tools.RegisterOption {
+	return []tools.RegisterOption{
+		tools.WithDefaultTimeout(cfg.Server.DefaultTimeout),
+		tools.WithRoot(cfg.Root),
+		tools.WithOutputLimit(cfg.Server.OutputLimit),
+		tools.WithShellEnv(cfg.Server.ShellEnv),
+		tools.WithApprovals(approvals),
+		tools.WithAudit(auditLog),
+	}
+}
+
+// newAuditLog returns the audit log configured in server.audit, or nil if
+// calls aren't recorded
+func newAuditLog(cfg *config.Config) *audit.Log {
+	if cfg.Server.Audit.Path == "" {
+		return nil
+	}
+	return audit.New(audit.Options{
+		Path:     cfg.Server.Audit.Path,
+		MaxSize:  int64(cfg.Server.Audit.MaxSizeMB) << 20,
+		MaxFiles: cfg.Server.Audit.MaxFiles,
+	})
+}
+
+// approvalAddress returns the address of the approval endpoint in stdio
+// mode: server.approval.listen, then listen, then a Unix socket of the
+// project that only the user can connect to
+func approvalAddress(cfg *config.Config, listen, project string) string {
+	if cfg.Server.Approval.Listen != "" {
+		return cfg.Server.Approval.Listen
+	}
+	if listen != "" {
+		return listen
+	}
+	return approvalSocket(project)
+}
+
+// approvalSocket returns the default approval socket of the project, in a
+// directory of the user's cache that only they can enter
+func approvalSocket(project string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(project))
+	return config.UnixPrefix + filepath.Join(dir, "dizi", "approval-"+hex.EncodeToString(sum[:8])+".sock")
+}
+
+// projectDir returns the directory of the project config file, or the
+// working directory without one
+func projectDir(layered *config.Layered) string {
+	if layered.Path != "" {
+		if dir, err := filepath.Abs(filepath.Dir(layered.Path)); err == nil {
+			return dir
+		}
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
+// watchConfig applies changes to the config files to the running server. A config
+// that fails to load or register is logged and the previous tools stay active.
+func watchConfig(mcpServer *mcpserver.MCPServer, toolSet *tools.ToolSet, profiles *profile.Set, loadOptions config.LoadOptions, approvals *approval.Broker, auditLog *audit.Log) {
+	config.Watch(context.Background(), loadOptions, time.Second, func(cfg *config.Config) {
+		logger.AddSecrets(cfg.Secrets()...)
+		changes, err := toolSet.Apply(cfg.Tools, toolOptions(cfg, approvals, auditLog)...)
+		if err != nil {
+			logger.ErrorLog("Failed to reload config, keeping previous tools: %v", err)
+			return
+		}
+
+		// Clients limited to a profile see other tools once it changes
+		if changed, err := profiles.Update(cfg); err != nil {
+			logger.ErrorLog("Failed to reload profiles, keeping previous profiles: %v", err)
+		} else if changed && changes.Empty() {
+			logger.InfoLog("Reloaded profiles")
+			mcpServer.SendNotificationToAllClients(mcp.MethodNotificationToolsListChanged, nil)
+		}
+		if changes.Empty() {
+			return
+		}
+		logger.InfoLog("Reloaded config: %d added, %d updated, %d removed",
+			len(changes.Added), len(changes.Updated), len(changes.Removed))
+	}, func(err error) {
+		logger.ErrorLog("Failed to reload config, keeping previous tools: %v", err)
+	})
+}
+
+// validateCommand checks config files and reports every problem found,
+// exiting with a non-zero status so it can be used as a pre-commit hook
+func validateCommand() {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dizi validate [-config path] [file ...]\n")
+		fmt.Fprintf(os.Stderr, "Check the configuration (or each given file) and report all problems\n")
+	}
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{*configPath}
+	}
+
+	failed := false
+	for _, path := range paths {
+		layered, err := config.LoadLayered(config.LoadOptions{Path: path})
+		if err != nil {
+			failed = true
+			var validationErr *config.ValidationError
+			if errors.As(err, &validationErr) {
+				for _, problem := range validationErr.Problems {
+					fmt.Fprintln(os.Stderr, problem.String())
+				}
 			} else {
-				fsConfig.RootDirectory = pwd
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			continue
+		}
+
+		name := layered.Path
+		if name == "" {
+			name = "built-in defaults"
+		}
+		fmt.Printf("✅ %s: %d tools OK\n", name, len(layered.Config.Tools))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// approveCommand decides calls waiting for approval on a running server,
+// from a terminal next to the MCP client
+func approveCommand() {
+	flags := flag.NewFlagSet("approve", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+	serverURL := flags.String("url", "", "Server URL or unix:/path/to.sock (default: the approval socket of a stdio server of this project, else the listen address or http://localhost:<port> from the config)")
+	token := flags.String("token", os.Getenv("DIZI_TOKEN"), "Bearer token, if the server requires one (default $DIZI_TOKEN)")
+	certFile := flags.String("cert", "", "Client certificate, if server.tls.client_ca requires one")
+	keyFile := flags.String("key", "", "Private key of the client certificate")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dizi approve [-config path] [-url http://localhost:8080] [-token token] [-cert file -key file]\n")
+		fmt.Fprintf(os.Stderr, "Show each tool call waiting for approval and ask whether it may run\n")
+	}
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	// The config tells where the server listens and which certificate it
+	// uses; with -url it is only needed to trust a self-signed certificate
+	address := *serverURL
+	var tlsSettings config.TLSConfig
+	layered, err := config.LoadLayered(config.LoadOptions{Path: *configPath})
+	if err == nil {
+		tlsSettings = layered.Config.Server.TLS
+		if address == "" {
+			address = defaultApprovalURL(layered)
+		}
+	} else if address == "" {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := listener.ClientTLS(tlsSettings, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	httpClient, baseURL := listener.Client(address, tlsConfig)
+	if strings.Contains(address, "://") {
+		baseURL = address
+	}
+
+	by := "dizi approve"
+	if current, err := user.Current(); err == nil {
+		by = current.Username
+	}
+
+	client := approval.NewClient(baseURL)
+	client.HTTP = httpClient
+	client.Token = *token
+	input := bufio.NewReader(os.Stdin)
+	seen := make(map[string]bool)
+	failing := false
+	where := client.URL
+	if socket, ok := listener.SocketPath(address); ok {
+		where = socket
+	}
+	fmt.Printf("Waiting for tool calls to approve on %s (Ctrl-C to stop)\n", where)
+	for {
+		requests, err := client.Pending(context.Background())
+		if err != nil {
+			// Report once until the server is back, it may just be restarting
+			if !failing {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			}
-			// }
+			failing = true
+			time.Sleep(time.Second)
+			continue
+		}
+		failing = false
 
-			if err := tools.RegisterFilesystemTools(mcpServer, fsConfig); err != nil {
-				log.Fatalf("Failed to register filesystem tools: %v", err)
+		for _, request := range requests {
+			if seen[request.ID] {
+				continue
 			}
+			seen[request.ID] = true
 
-			logger.InfoLog("Filesystem tools enabled with root: %s", fsConfig.RootDirectory)
+			arguments, _ := json.MarshalIndent(request.Arguments, "  ", "  ")
+			fmt.Printf("\n🔔 %s wants to run with arguments:\n  %s\n", request.Tool, arguments)
+			decision, reason, ok := promptDecision(input)
+			if !ok {
+				return
+			}
+			if err := client.Decide(context.Background(), request.ID, decision, by, reason); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			fmt.Printf("✅ %s: %s\n", request.Tool, decision)
 		}
+		time.Sleep(time.Second)
+	}
+}
 
-		// Create and start SSE server
-		sseServer := mcpserver.NewSSEServer(mcpServer)
-		addr := *host + ":" + strconv.Itoa(port)
-		logger.InfoLog("SSE server starting on http://%s", addr)
-		if err := sseServer.Start(addr); err != nil {
-			log.Fatalf("Failed to start SSE server: %v", err)
+// defaultApprovalURL returns where dizi approve finds pending calls: the
+// approval endpoint of a stdio server of the project, or else the HTTP server
+func defaultApprovalURL(layered *config.Layered) string {
+	cfg := layered.Config
+	if cfg.Server.Approval.Listen != "" {
+		return cfg.Server.Approval.Listen
+	}
+	socket := approvalSocket(projectDir(layered))
+	if path, _ := listener.SocketPath(socket); exists(path) {
+		return socket
+	}
+	if cfg.Server.Listen != "" {
+		return cfg.Server.Listen
+	}
+	return "localhost:" + strconv.Itoa(cfg.Server.Port)
+}
+
+// exists reports whether there is a file at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// promptDecision asks for a decision on one call until the answer is
+// understood; ok is false once the input ends
+func promptDecision(input *bufio.Reader) (decision approval.Decision, reason string, ok bool) {
+	for {
+		fmt.Print("Approve? [y]es / [s]ession (this tool for the rest of the session) / [n]o: ")
+		line, err := input.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return "", "", false
 		}
-	default:
-		fmt.Fprintf(os.Stderr, "Unsupported transport: %s\n", *transport)
-		showHelp(cfg)
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return approval.Approve, "", true
+		case "s", "session":
+			return approval.ApproveSession, "", true
+		case "n", "no":
+			fmt.Print("Reason (optional, shown to the client): ")
+			line, err := input.ReadString('\n')
+			if err != nil && line == "" {
+				return approval.Deny, "", true
+			}
+			return approval.Deny, strings.TrimSpace(line), true
+		}
+	}
+}
+
+// auditCommand prints the tool calls recorded in the audit log
+func auditCommand() {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+	file := flags.String("file", "", "Audit log (default: server.audit.path from the config)")
+	tool := flags.String("tool", "", "Only calls to this tool")
+	session := flags.String("session", "", "Only calls from this client session")
+	since := flags.String("since", "", "Only calls at or after this time, e.g. 2h or \"2024-05-01 14:30\"")
+	until := flags.String("until", "", "Only calls before this time")
+	asJSON := flags.Bool("json", false, "Print the matching entries as JSON lines")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dizi audit [-config path] [-file path] [-tool name] [-session id] [-since time] [-until time] [-json]\n")
+		fmt.Fprintf(os.Stderr, "Show the tool calls recorded in the audit log, oldest first\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(os.Args[2:]); err != nil {
 		os.Exit(1)
 	}
+
+	if *file == "" {
+		layered, err := config.LoadLayered(config.LoadOptions{Path: *configPath})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *file = layered.Config.Server.Audit.Path; *file == "" {
+			fmt.Fprintf(os.Stderr, "Error: no audit log, set server.audit.path in the config or pass -file\n")
+			os.Exit(1)
+		}
+	}
+
+	filter := audit.Filter{Tool: *tool, Session: *session}
+	now := time.Now()
+	for _, bound := range []struct {
+		value string
+		into  *time.Time
+	}{{*since, &filter.Since}, {*until, &filter.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := audit.ParseTime(bound.value, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*bound.into = t
+	}
+
+	entries, err := audit.Query(*file, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			_ = encoder.Encode(entry)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSESSION\tTOOL\tDURATION\tEXIT\tOUTPUT\tERROR")
+	for _, entry := range entries {
+		exitCode := "-"
+		if entry.ExitCode != nil {
+			exitCode = strconv.Itoa(*entry.ExitCode)
+		}
+		message, _, _ := strings.Cut(entry.Error, "\n")
+		if runes := []rune(message); len(runes) > 60 {
+			message = string(runes[:57]) + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Session, entry.Tool,
+			time.Duration(entry.DurationMS)*time.Millisecond, exitCode, entry.OutputBytes, message)
+	}
+	w.Flush()
+}
+
+// tokenCommand creates bearer tokens signed with server.auth.hmac.secret
+func tokenCommand() {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+	name := flags.String("name", "", "Who the token is for, shown in logs")
+	ttl := flags.Duration("ttl", 24*time.Hour, "How long the token is valid")
+	toolList := flags.String("tools", "", "Comma-separated tool name patterns the token may use (default: all tools)")
+	profileName := flags.String("profile", "", "Profile the token is limited to")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dizi token create -name name [-ttl 24h] [-tools build,test_*] [-profile name] [-config path]\n")
+		fmt.Fprintf(os.Stderr, "Create an expiring bearer token for the HTTP transports\n")
+		flags.PrintDefaults()
+	}
+
+	if len(os.Args) < 3 || os.Args[2] != "create" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if err := flags.Parse(os.Args[3:]); err != nil {
+		os.Exit(1)
+	}
+
+	layered, err := config.LoadLayered(config.LoadOptions{Path: *configPath})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	secret := layered.Config.Server.Auth.HMAC.Secret
+	if secret == "" {
+		fmt.Fprintf(os.Stderr, "Error: set server.auth.hmac.secret in the config or DIZI_SERVER_AUTH_HMAC_SECRET first\n")
+		os.Exit(1)
+	}
+	signer, err := auth.NewHMAC(secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var tools []string
+	for _, pattern := range strings.Split(*toolList, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			tools = append(tools, pattern)
+		}
+	}
+	if _, ok := layered.Config.Profiles[*profileName]; *profileName != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown profile %q\n", *profileName)
+		os.Exit(1)
+	}
+	token, err := signer.Create(*name, tools, *profileName, *ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
+
+// configCommand inspects the effective configuration
+func configCommand() {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default: dizi.yml in the current or a parent directory)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dizi config show [-config path]\n")
+		fmt.Fprintf(os.Stderr, "Print the effective merged configuration and where each value comes from\n")
+	}
+
+	if len(os.Args) < 3 || os.Args[2] != "show" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if err := flags.Parse(os.Args[3:]); err != nil {
+		os.Exit(1)
+	}
+
+	layered, err := config.LoadLayered(config.LoadOptions{Path: *configPath})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	output, err := layered.Show()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(layered.Files) == 0 {
+		fmt.Println("# No config file found, using built-in defaults")
+	} else {
+		fmt.Println("# Config files (lowest precedence first):")
+		for _, file := range layered.Files {
+			fmt.Printf("#   %s\n", file)
+		}
+	}
+	fmt.Print(string(output))
 }
 
 func showHelp(cfg *config.Config) {
@@ -214,20 +781,38 @@ func showHelp(cfg *config.Config) {
 	fmt.Println("        Start interactive Lua REPL")
 	fmt.Println("  version")
 	fmt.Println("        Show version information")
+	fmt.Println("  validate [-config path] [file ...]")
+	fmt.Println("        Check the configuration and report all problems with their location")
+	fmt.Println("  config show [-config path]")
+	fmt.Println("        Print the effective configuration and the origin of each value")
+	fmt.Println("  approve [-config path] [-url url] [-token token] [-cert file -key file]")
+	fmt.Println("        Approve or deny tool calls that wait for confirmation")
+	fmt.Println("  audit [-tool name] [-session id] [-since time] [-until time] [-json]")
+	fmt.Println("        Show the tool calls recorded in the audit log")
+	fmt.Println("  token create -name name [-ttl 24h] [-tools pattern,...] [-profile name]")
+	fmt.Println("        Create an expiring bearer token signed with server.auth.hmac.secret")
 	fmt.Println("")
 	fmt.Println("Flags:")
 	fmt.Println("  -transport string")
-	fmt.Println("        Transport method: stdio or sse (default \"sse\")")
+	fmt.Println("        Transport method: stdio, sse, streamable-http or sse,streamable-http for both (default \"sse\")")
 	fmt.Println("  -host string")
-	fmt.Println("        Host for SSE transport (default \"localhost\")")
+	fmt.Println("        Host for HTTP transports (default \"localhost\")")
+	fmt.Println("  -listen string")
+	fmt.Println("        Address for HTTP transports, host:port or unix:/path/to.sock (default server.listen, else -host and -port)")
 	fmt.Println("  -port int")
-	fmt.Printf("        Port for SSE transport (default %d from config)\n", cfg.Server.Port)
+	fmt.Printf("        Port for HTTP transports (default %d from config)\n", cfg.Server.Port)
 	fmt.Println("  -fs-tools")
 	fmt.Println("        Enable filesystem tools (restricted to project directory)")
+	fmt.Println("  -profile string")
+	fmt.Println("        Limit the stdio client to a profile from dizi.yml")
 	// fmt.Println("  -fs-root string")
 	// fmt.Println("        Root directory for filesystem tools (default: project directory)")
 	fmt.Println("  -workdir string")
 	fmt.Println("        Working directory for the server")
+	fmt.Println("  -config string")
+	fmt.Println("        Config file (default: dizi.yml in the current or a parent directory)")
+	fmt.Println("  -watch")
+	fmt.Println("        Reload dizi.yml when it changes (default true, use -watch=false to disable)")
 	fmt.Println("  -help")
 	fmt.Println("        Show this help information")
 	fmt.Println("")
@@ -235,7 +820,10 @@ func showHelp(cfg *config.Config) {
 	fmt.Println("  dizi                           # Start with SSE transport (default)")
 	fmt.Println("  dizi -port=9000                # Start with SSE transport on port 9000")
 	fmt.Println("  dizi -transport=stdio          # Start with stdio transport")
+	fmt.Println("  dizi -transport=sse,streamable-http  # Serve /sse and /mcp on one port")
+	fmt.Println("  dizi -listen=unix:/tmp/dizi.sock  # Serve on a Unix socket only the current user can connect to")
 	fmt.Println("  dizi -transport=stdio -workdir=/path/to/project  # Start stdio in specific directory")
+	fmt.Println("  dizi -transport=stdio -profile=reviewer  # Only offer the tools of the reviewer profile")
 	fmt.Println("  dizi -fs-tools                 # Enable filesystem tools (project only)")
 	fmt.Println("  dizi -fs-tools -fs-root=/home  # Enable filesystem tools with custom root")
 	fmt.Println("  dizi lua script.lua            # Run a Lua script")
@@ -243,9 +831,10 @@ func showHelp(cfg *config.Config) {
 	fmt.Println("  dizi repl                      # Start interactive Lua REPL")
 	fmt.Println("")
 	fmt.Println("SSE Query Parameters:")
-	fmt.Println("  ?include_fs_tools=true         # Enable filesystem tools (project only)")
-	fmt.Println("  ?fs_root=/path                 # Set custom filesystem root")
-	fmt.Println("  Example: http://localhost:8081/sse?include_fs_tools=true&fs_root=/home")
+	fmt.Println("  ?include_fs_tools=true         # Give this session filesystem tools (project only)")
+	fmt.Println("  ?fs_root=/path                 # Root them in a directory within the project or server.fs_roots")
+	fmt.Println("  ?profile=name                  # Limit this session to a profile (also on /mcp)")
+	fmt.Println("  Example: http://localhost:8081/sse?include_fs_tools=true&fs_root=docs")
 	fmt.Println("")
 	fmt.Println("Filesystem Tools (when enabled):")
 	fmt.Println("  read_file, write_file, list_directory, create_directory,")
@@ -316,6 +905,7 @@ This project contains a configured Dizi MCP (Model Context Protocol) server with
    ` + "```bash" + `
    dizi                          # Start with SSE transport (default)
    dizi -transport=stdio         # Start with stdio transport
+   dizi -transport=sse,streamable-http  # Serve SSE and streamable HTTP
    ` + "```" + `
 
 2. **Test Lua functionality:**
@@ -352,6 +942,7 @@ Check ` + "`dizi.yml`" + ` for configured tools including:
 
 Connect your MCP client to:
 - SSE: ` + "`http://localhost:8082/sse`" + `
+- Streamable HTTP: ` + "`http://localhost:8082/mcp`" + ` (with ` + "`-transport=streamable-http`" + `)
 - stdio: Run with ` + "`-transport=stdio`" + `
 
 ### Direct Lua Execution
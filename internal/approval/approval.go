@@ -0,0 +1,329 @@
+// Package approval holds calls to tools that need a person's approval until
+// someone decides on them. Calls the MCP client can't ask its user about are
+// served over HTTP, so that a second terminal (dizi approve) or any other
+// client can list and decide them, and every decision is logged.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dizi/internal/auth"
+	"dizi/internal/listener"
+	"dizi/internal/logger"
+)
+
+// DefaultTimeout is how long a call waits for a decision when no timeout is set
+const DefaultTimeout = 5 * time.Minute
+
+// Decision is the answer to a pending call
+type Decision string
+
+const (
+	// Approve lets the call run
+	Approve Decision = "approve"
+	// ApproveSession lets the call run along with all further calls to the
+	// same tool from the same client session
+	ApproveSession Decision = "approve_session"
+	// Deny rejects the call
+	Deny Decision = "deny"
+)
+
+// Decisions are the answers a person can give
+var Decisions = []Decision{Approve, ApproveSession, Deny}
+
+// Request is a call waiting for approval
+type Request struct {
+	ID        string                 `json:"id"`
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Session   string                 `json:"session,omitempty"` // MCP client session the call came from
+	Created   time.Time              `json:"created"`
+}
+
+// Result is the outcome of asking for approval
+type Result struct {
+	Decision Decision
+	By       string // who decided: the approver, "session" for a remembered approval, "timeout" or "client"
+	Reason   string // optional explanation, returned to the client on denial
+}
+
+// Approved reports whether the call may run
+func (r Result) Approved() bool {
+	return r.Decision == Approve || r.Decision == ApproveSession
+}
+
+// Decider decides on a call without the endpoint, such as by asking the
+// user through the MCP client. It returns false if it can't, and the call
+// then waits on the endpoint.
+type Decider func(ctx context.Context, request Request) (Result, bool)
+
+// Options configures a Broker
+type Options struct {
+	Timeout time.Duration        // defaults to DefaultTimeout
+	Log     string               // JSONL file decisions are appended to, none if empty
+	Listen  string               // serve Handler on this address, host:port or unix:/path, once a call waits
+	TLS     *tls.Config          // serve Handler over HTTPS if set
+	Auth    []auth.Authenticator // bearer tokens required on Listen; without any, only a Unix socket is served
+}
+
+// Broker keeps the calls waiting for approval and the tools approved for
+// the rest of a session
+type Broker struct {
+	options Options
+
+	mu        sync.Mutex
+	pending   map[string]*pendingCall
+	approved  map[string]bool // session and tool approved with ApproveSession
+	listening bool
+	listenErr error
+}
+
+// pendingCall is a request and where its decision goes
+type pendingCall struct {
+	Request
+	decided chan Result
+}
+
+// NewBroker creates a broker with no pending calls
+func NewBroker(options Options) *Broker {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Broker{
+		options:  options,
+		pending:  make(map[string]*pendingCall),
+		approved: make(map[string]bool),
+	}
+}
+
+// Ask waits until a person decides on a call to tool, the timeout passes or
+// ctx is done. Calls to a tool approved for the session are approved right
+// away. Otherwise decide is asked first if set; when it can't decide,
+// waiting is called with the pending request before Ask blocks.
+func (b *Broker) Ask(ctx context.Context, tool string, arguments map[string]interface{}, session string, decide Decider, waiting func(Request)) Result {
+	request := Request{ID: newID(), Tool: tool, Arguments: arguments, Session: session, Created: time.Now()}
+
+	b.mu.Lock()
+	if b.approved[sessionKey(session, tool)] {
+		b.mu.Unlock()
+		result := Result{Decision: Approve, By: "session"}
+		b.record(request, result)
+		return result
+	}
+	b.mu.Unlock()
+
+	if decide != nil {
+		decideCtx, cancel := context.WithTimeout(ctx, b.options.Timeout)
+		result, ok := decide(decideCtx, request)
+		timedOut := decideCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if ok {
+			switch {
+			case timedOut:
+				result = Result{Decision: Deny, By: "timeout", Reason: fmt.Sprintf("no decision within %s", b.options.Timeout)}
+			case ctx.Err() != nil:
+				result = Result{Decision: Deny, By: "client", Reason: "the call was cancelled"}
+			case result.Decision == ApproveSession:
+				b.mu.Lock()
+				b.approved[sessionKey(session, tool)] = true
+				b.mu.Unlock()
+			}
+			b.record(request, result)
+			return result
+		}
+	}
+
+	if err := b.listen(); err != nil {
+		result := Result{Decision: Deny, By: "server", Reason: fmt.Sprintf("the approval endpoint is not available: %v", err)}
+		b.record(request, result)
+		return result
+	}
+
+	call := &pendingCall{Request: request, decided: make(chan Result, 1)}
+	b.mu.Lock()
+	b.pending[request.ID] = call
+	b.mu.Unlock()
+
+	logger.InfoLog("Tool %s is waiting for approval (request %s)", tool, request.ID)
+	if waiting != nil {
+		waiting(request)
+	}
+
+	timer := time.NewTimer(b.options.Timeout)
+	defer timer.Stop()
+
+	var result Result
+	select {
+	case result = <-call.decided:
+	case <-timer.C:
+		result = Result{Decision: Deny, By: "timeout", Reason: fmt.Sprintf("no decision within %s", b.options.Timeout)}
+	case <-ctx.Done():
+		result = Result{Decision: Deny, By: "client", Reason: "the call was cancelled"}
+	}
+
+	b.mu.Lock()
+	delete(b.pending, request.ID)
+	b.mu.Unlock()
+
+	b.record(request, result)
+	return result
+}
+
+// Decide answers the pending call with the given ID. It fails if no such
+// call is waiting, for example because it timed out.
+func (b *Broker) Decide(id string, result Result) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	call, ok := b.pending[id]
+	if !ok {
+		return fmt.Errorf("no pending request %s", id)
+	}
+	delete(b.pending, id)
+	if result.Decision == ApproveSession {
+		b.approved[sessionKey(call.Session, call.Tool)] = true
+	}
+	call.decided <- result
+	return nil
+}
+
+// Pending returns the calls waiting for approval, oldest first
+func (b *Broker) Pending() []Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests := make([]Request, 0, len(b.pending))
+	for _, call := range b.pending {
+		requests = append(requests, call.Request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Created.Before(requests[j].Created) })
+	return requests
+}
+
+// Forget drops the tools approved for the rest of session, once it has ended
+func (b *Broker) Forget(session string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prefix := sessionKey(session, "")
+	for key := range b.approved {
+		if strings.HasPrefix(key, prefix) {
+			delete(b.approved, key)
+		}
+	}
+}
+
+// listen starts serving Handler on Options.Listen the first time it is
+// called; later calls report whether that worked
+func (b *Broker) listen() error {
+	if b.options.Listen == "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listening {
+		return b.listenErr
+	}
+	b.listening = true
+
+	// Anyone who can reach a TCP port could approve calls, while a socket
+	// is only open to the user running the server
+	socket, isSocket := listener.SocketPath(b.options.Listen)
+	if !isSocket && len(b.options.Auth) == 0 {
+		b.listenErr = fmt.Errorf("%s is a TCP address, which needs server.auth so that only token holders can decide; use a unix: address instead", b.options.Listen)
+		return b.listenErr
+	}
+
+	l, err := listener.Listen(b.options.Listen, b.options.TLS)
+	if err != nil {
+		b.listenErr = err
+		return b.listenErr
+	}
+	if isSocket {
+		logger.InfoLog("Approval endpoint listening on Unix socket %s", socket)
+	} else {
+		logger.InfoLog("Approval endpoint listening on %s%s", listener.URL(l.Addr().String(), b.options.TLS != nil), Path)
+	}
+	handler := b.Handler()
+	if len(b.options.Auth) > 0 {
+		handler = requireToken(b.options.Auth, handler)
+	}
+	go func() {
+		if err := http.Serve(l, handler); err != nil {
+			logger.ErrorLog("Approval endpoint stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// logEntry is a line of the approval log
+type logEntry struct {
+	Time      time.Time              `json:"time"`
+	ID        string                 `json:"id,omitempty"`
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Session   string                 `json:"session,omitempty"`
+	Decision  Decision               `json:"decision"`
+	By        string                 `json:"by"`
+	Reason    string                 `json:"reason,omitempty"`
+}
+
+// record logs a decision and appends it to the approval log
+func (b *Broker) record(request Request, result Result) {
+	logger.InfoLog("Tool %s: %s by %s", request.Tool, result.Decision, result.By)
+	if b.options.Log == "" {
+		return
+	}
+
+	line, err := json.Marshal(logEntry{
+		Time:      time.Now(),
+		ID:        request.ID,
+		Tool:      request.Tool,
+		Arguments: request.Arguments,
+		Session:   request.Session,
+		Decision:  result.Decision,
+		By:        result.By,
+		Reason:    result.Reason,
+	})
+	if err != nil {
+		logger.ErrorLog("Failed to encode approval log entry: %v", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	file, err := os.OpenFile(b.options.Log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.ErrorLog("Failed to open approval log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		logger.ErrorLog("Failed to write approval log: %v", err)
+	}
+}
+
+// sessionKey identifies a tool within a client session
+func sessionKey(session, tool string) string {
+	return session + "\x00" + tool
+}
+
+// newID returns a random request ID, hard to guess so that a web page can't
+// decide a call without reading the list of pending calls first
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
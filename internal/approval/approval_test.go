@@ -0,0 +1,237 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/listener"
+)
+
+// decideWhenPending decides the first call that shows up on the broker
+func decideWhenPending(t *testing.T, b *Broker, result Result) {
+	t.Helper()
+	go func() {
+		for i := 0; i < 500; i++ {
+			if pending := b.Pending(); len(pending) > 0 {
+				if err := b.Decide(pending[0].ID, result); err != nil {
+					t.Errorf("Decide failed: %v", err)
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("No call became pending")
+	}()
+}
+
+func TestAsk(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "approvals.jsonl")
+	b := NewBroker(Options{Timeout: time.Minute, Log: logPath})
+	arguments := map[string]interface{}{"path": "main.go"}
+
+	var waited Request
+	decideWhenPending(t, b, Result{Decision: Deny, By: "alice", Reason: "not now"})
+	result := b.Ask(context.Background(), "write_project_file", arguments, "s1", nil, func(r Request) { waited = r })
+	if result.Approved() || result.By != "alice" || result.Reason != "not now" {
+		t.Errorf("Expected the denial, got %+v", result)
+	}
+	if waited.ID == "" || waited.Tool != "write_project_file" {
+		t.Errorf("Expected waiting to get the pending request, got %+v", waited)
+	}
+
+	decideWhenPending(t, b, Result{Decision: ApproveSession, By: "alice"})
+	if result := b.Ask(context.Background(), "write_project_file", arguments, "s1", nil, nil); !result.Approved() {
+		t.Errorf("Expected approval, got %+v", result)
+	}
+	if result := b.Ask(context.Background(), "write_project_file", arguments, "s1", nil, nil); !result.Approved() || result.By != "session" {
+		t.Errorf("Expected the session approval to be remembered, got %+v", result)
+	}
+
+	// Other sessions and tools still need approval
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if result := b.Ask(ctx, "write_project_file", arguments, "s2", nil, nil); result.Approved() || result.By != "client" {
+		t.Errorf("Expected another session to wait until cancelled, got %+v", result)
+	}
+	if len(b.Pending()) != 0 {
+		t.Errorf("Expected no pending calls left, got %v", b.Pending())
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 log entries, got %q", data)
+	}
+	var entry logEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Invalid log entry: %v", err)
+	}
+	if entry.Decision != Deny || entry.By != "alice" || entry.Arguments["path"] != "main.go" || entry.Session != "s1" {
+		t.Errorf("Unexpected log entry %+v", entry)
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	b := NewBroker(Options{Timeout: 20 * time.Millisecond})
+	result := b.Ask(context.Background(), "deploy", nil, "", nil, nil)
+	if result.Approved() || result.By != "timeout" || result.Reason != "no decision within 20ms" {
+		t.Errorf("Expected a timeout, got %+v", result)
+	}
+	if err := b.Decide("unknown", Result{Decision: Approve}); err == nil {
+		t.Error("Expected deciding an unknown request to fail")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	b := NewBroker(Options{Timeout: time.Minute})
+	server := httptest.NewServer(b.Handler())
+	defer server.Close()
+	client := NewClient(server.URL + "/")
+
+	done := make(chan Result)
+	go func() {
+		done <- b.Ask(context.Background(), "deploy", map[string]interface{}{"env": "prod"}, "", nil, nil)
+	}()
+
+	var pending []Request
+	for i := 0; i < 500 && len(pending) == 0; i++ {
+		var err error
+		if pending, err = client.Pending(context.Background()); err != nil {
+			t.Fatalf("Pending failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pending) != 1 || pending[0].Tool != "deploy" || pending[0].Arguments["env"] != "prod" {
+		t.Fatalf("Expected the deploy call to be listed, got %+v", pending)
+	}
+
+	// Decisions must be JSON, so that other sites can't post them from a browser
+	resp, err := http.Post(server.URL+Path+"/"+pending[0].ID, "text/plain", strings.NewReader(`{"decision":"approve"}`))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a form post to be refused, got %s", resp.Status)
+	}
+
+	if err := client.Decide(context.Background(), pending[0].ID, "maybe", "bob", ""); err == nil || !strings.Contains(err.Error(), `unknown decision "maybe"`) {
+		t.Errorf("Expected an unknown decision to be refused, got %v", err)
+	}
+	if err := client.Decide(context.Background(), pending[0].ID, Approve, "bob", ""); err != nil {
+		t.Fatalf("Decide failed: %v", err)
+	}
+	if result := <-done; !result.Approved() || result.By != "bob" {
+		t.Errorf("Expected approval by bob, got %+v", result)
+	}
+	if err := client.Decide(context.Background(), pending[0].ID, Approve, "bob", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a decided call to be gone, got %v", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	// Without tokens anyone reaching the port could decide
+	b := NewBroker(Options{Timeout: time.Minute, Listen: "localhost:0"})
+	if result := b.Ask(context.Background(), "deploy", nil, "", nil, nil); result.Approved() || result.By != "server" || !strings.Contains(result.Reason, "needs server.auth") {
+		t.Errorf("Expected a TCP endpoint without tokens to be refused, got %+v", result)
+	}
+
+	static, err := auth.NewStatic([]config.AuthToken{
+		{Name: "admin", Token: "admin-token"},
+		{Name: "ci", Token: "ci-token", Tools: []string{"test"}},
+	})
+	if err != nil {
+		t.Fatalf("NewStatic failed: %v", err)
+	}
+	address := config.UnixPrefix + filepath.Join(t.TempDir(), "approval.sock")
+	b = NewBroker(Options{Timeout: time.Minute, Listen: address, Auth: []auth.Authenticator{static}})
+	done := make(chan Result)
+	go func() {
+		done <- b.Ask(context.Background(), "deploy", nil, "", nil, nil)
+	}()
+
+	httpClient, baseURL := listener.Client(address, nil)
+	client := NewClient(baseURL)
+	client.HTTP = httpClient
+	var pending []Request
+	for i := 0; i < 500 && len(pending) == 0; i++ {
+		pending = b.Pending()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("Expected the call to wait, got %+v", pending)
+	}
+
+	for token, status := range map[string]string{"": "401", "wrong": "401", "ci-token": "403"} {
+		client.Token = token
+		if _, err := client.Pending(context.Background()); err == nil || !strings.Contains(err.Error(), status) {
+			t.Errorf("Expected token %q to get %s, got %v", token, status, err)
+		}
+	}
+	client.Token = "admin-token"
+	if err := client.Decide(context.Background(), pending[0].ID, Approve, "admin", ""); err != nil {
+		t.Fatalf("Decide failed: %v", err)
+	}
+	if result := <-done; !result.Approved() {
+		t.Errorf("Expected approval, got %+v", result)
+	}
+}
+
+func TestAskDecider(t *testing.T) {
+	b := NewBroker(Options{Timeout: time.Minute})
+	var asked Request
+	decide := func(ctx context.Context, request Request) (Result, bool) {
+		asked = request
+		return Result{Decision: ApproveSession, By: "user"}, true
+	}
+	if result := b.Ask(context.Background(), "deploy", nil, "s1", decide, nil); !result.Approved() || result.By != "user" {
+		t.Errorf("Expected the decider's approval, got %+v", result)
+	}
+	if asked.ID == "" || asked.Tool != "deploy" || asked.Session != "s1" {
+		t.Errorf("Expected the decider to get the request, got %+v", asked)
+	}
+	if result := b.Ask(context.Background(), "deploy", nil, "s1", decide, nil); result.By != "session" {
+		t.Errorf("Expected the session approval to be remembered, got %+v", result)
+	}
+
+	// Approvals end with their session
+	b.Forget("s1")
+	deny := func(ctx context.Context, request Request) (Result, bool) {
+		return Result{Decision: Deny, By: "user"}, true
+	}
+	if result := b.Ask(context.Background(), "deploy", nil, "s1", deny, nil); result.Approved() {
+		t.Errorf("Expected the session approval to be forgotten, got %+v", result)
+	}
+	if len(b.approved) != 0 {
+		t.Errorf("Expected no approvals left, got %v", b.approved)
+	}
+
+	// A decider that can't decide leaves the call to the endpoint
+	decideWhenPending(t, b, Result{Decision: Deny, By: "alice"})
+	undecided := func(ctx context.Context, request Request) (Result, bool) { return Result{}, false }
+	if result := b.Ask(context.Background(), "deploy", nil, "s2", undecided, nil); result.By != "alice" {
+		t.Errorf("Expected the endpoint to decide, got %+v", result)
+	}
+
+	// The decider is bound by the timeout too
+	b = NewBroker(Options{Timeout: 20 * time.Millisecond})
+	waiting := func(ctx context.Context, request Request) (Result, bool) {
+		<-ctx.Done()
+		return Result{Decision: Deny, By: "user", Reason: ctx.Err().Error()}, true
+	}
+	if result := b.Ask(context.Background(), "deploy", nil, "s1", waiting, nil); result.Approved() || result.By != "timeout" {
+		t.Errorf("Expected a timeout, got %+v", result)
+	}
+}
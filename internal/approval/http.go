@@ -0,0 +1,161 @@
+// Package approval holds calls to tools that need a person's approval.
+// This file serves the pending calls over HTTP and provides a client for it.
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"dizi/internal/auth"
+)
+
+// Path is where Handler serves the pending calls
+const Path = "/approvals"
+
+// decisionBody is the body of a POST to Path/{id}
+type decisionBody struct {
+	Decision Decision `json:"decision"`
+	Reason   string   `json:"reason,omitempty"`
+	By       string   `json:"by,omitempty"` // who decides, defaults to the remote address
+}
+
+// Handler serves the pending calls:
+//
+//	GET  /approvals       lists them as JSON
+//	POST /approvals/{id}  decides one, with {"decision": "approve" | "approve_session" | "deny"}
+//
+// Decisions must be sent as application/json, which browsers don't allow
+// other sites to send without asking first.
+func (b *Broker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(b.Pending())
+	})
+	mux.HandleFunc("POST "+Path+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "decisions must be sent as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var body decisionBody
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid decision: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(Decisions, body.Decision) {
+			http.Error(w, fmt.Sprintf("unknown decision %q, expected approve, approve_session or deny", body.Decision), http.StatusBadRequest)
+			return
+		}
+		if body.By == "" {
+			body.By = r.RemoteAddr
+		}
+		if err := b.Decide(r.PathValue("id"), Result{Decision: body.Decision, By: body.By, Reason: body.Reason}); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// requireToken rejects requests without a bearer token that may use every
+// tool, like the HTTP transports do for their approval endpoint
+func requireToken(authenticators []auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dizi"`)
+			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			return
+		}
+		identity, err := auth.Authenticate(authenticators, strings.TrimSpace(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dizi", error="invalid_token"`)
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		if !identity.Unrestricted() {
+			http.Error(w, fmt.Sprintf("Token %s is limited to some tools and may not approve calls", identity.Name), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Client talks to the approval endpoint of a running server
+type Client struct {
+	URL   string // base URL of the server, e.g. http://localhost:8080
+	Token string // bearer token, if the server requires one
+	HTTP  *http.Client
+}
+
+// NewClient creates a client for the server at url
+func NewClient(url string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Pending lists the calls waiting for approval
+func (c *Client) Pending(ctx context.Context) ([]Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending calls: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var requests []Request
+	if err := json.NewDecoder(resp.Body).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("failed to decode pending calls: %w", err)
+	}
+	return requests, nil
+}
+
+// Decide answers a pending call
+func (c *Client) Decide(ctx context.Context, id string, decision Decision, by, reason string) error {
+	body, err := json.Marshal(decisionBody{Decision: decision, By: by, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("failed to encode decision: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+Path+"/"+id, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send decision: %w", err)
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// do sends a request with the client's token
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTP.Do(req)
+}
+
+// responseError returns the error message of a failed response
+func responseError(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
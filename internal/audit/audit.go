@@ -0,0 +1,140 @@
+// Package audit keeps an append-only record of every tool call as JSON
+// lines, rotating the file once it grows too large, and reads it back for
+// dizi audit.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSize is the size at which the log is rotated when no size is set
+	DefaultMaxSize = 10 << 20
+	// DefaultMaxFiles is how many rotated files are kept when no count is set
+	DefaultMaxFiles = 5
+)
+
+// Entry is one tool call
+type Entry struct {
+	Time        time.Time              `json:"time"`
+	Session     string                 `json:"session,omitempty"`
+	Tool        string                 `json:"tool"`
+	Arguments   map[string]interface{} `json:"arguments,omitempty"` // with secrets redacted
+	DurationMS  int64                  `json:"duration_ms"`
+	ExitCode    *int                   `json:"exit_code,omitempty"` // for commands and scripts that ran
+	OutputBytes int                    `json:"output_bytes"`        // size of the result returned to the client
+	Error       string                 `json:"error,omitempty"`
+}
+
+// Options configures a Log
+type Options struct {
+	Path     string
+	MaxSize  int64 // rotate before the file grows past this many bytes, defaults to DefaultMaxSize
+	MaxFiles int   // rotated files kept as path.1 (newest) to path.N, defaults to DefaultMaxFiles
+}
+
+// Log appends entries to the audit file
+type Log struct {
+	options Options
+	mu      sync.Mutex
+}
+
+// New creates a log writing to options.Path; the file is created on the
+// first entry
+func New(options Options) *Log {
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if options.MaxFiles <= 0 {
+		options.MaxFiles = DefaultMaxFiles
+	}
+	return &Log{options: options}
+}
+
+// Write appends an entry, rotating the file first if the entry would make
+// it too large
+func (l *Log) Write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.options.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	if info, err := os.Stat(l.options.Path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > l.options.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(l.options.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N and so on, then path to path.1, dropping
+// the oldest file
+func (l *Log) rotate() error {
+	for i := l.options.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(l.options.Path, i), rotatedPath(l.options.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(l.options.Path, rotatedPath(l.options.Path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return nil
+}
+
+// rotatedPath returns the name of the n-th rotated file
+func rotatedPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// recordKey is the context key of the record of a running call
+type recordKey struct{}
+
+// record collects what the handler of a call reports about it
+type record struct {
+	mu       sync.Mutex
+	exitCode *int
+}
+
+// WithRecord returns a context that SetExitCode reports to, and a function
+// returning the exit code reported so far
+func WithRecord(ctx context.Context) (context.Context, func() *int) {
+	r := &record{}
+	return context.WithValue(ctx, recordKey{}, r), func() *int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.exitCode
+	}
+}
+
+// SetExitCode records the exit code of the command a call ran, if the call
+// is audited
+func SetExitCode(ctx context.Context, code int) {
+	if r, ok := ctx.Value(recordKey{}).(*record); ok {
+		r.mu.Lock()
+		r.exitCode = &code
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRotateQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	log := New(Options{Path: path, MaxSize: 250, MaxFiles: 2})
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		entry := Entry{Time: start.Add(time.Duration(i) * time.Minute), Session: "s1", Tool: "build", OutputBytes: i}
+		if i%3 == 0 {
+			entry.Tool, entry.Session = "test", "s2"
+		}
+		if err := log.Write(entry); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 250 {
+			t.Errorf("Expected %s to stay within the size limit, got %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("Expected only 2 rotated files to be kept")
+	}
+
+	entries, err := Query(path, Filter{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(entries) == 0 || len(entries) == 12 || entries[len(entries)-1].OutputBytes != 11 {
+		t.Fatalf("Expected the newest entries without the dropped file, got %+v", entries)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			t.Errorf("Expected entries oldest first, got %v before %v", entries[i-1].Time, entries[i].Time)
+		}
+	}
+
+	entries, err = Query(path, Filter{Tool: "test", Session: "s2", Since: start.Add(6 * time.Minute), Until: start.Add(9 * time.Minute)})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].OutputBytes != 6 {
+		t.Errorf("Expected only the test call at 12:06, got %+v", entries)
+	}
+}
+
+func TestQueryMissingLog(t *testing.T) {
+	entries, err := Query(filepath.Join(t.TempDir(), "none.jsonl"), Filter{})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries for a missing log, got %v %v", entries, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("{\"tool\":\"a\"}\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Query(path, Filter{}); err == nil || !strings.Contains(err.Error(), "audit.jsonl:2: invalid audit entry") {
+		t.Errorf("Expected the broken line to be reported, got %v", err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	SetExitCode(context.Background(), 1) // Not audited, nothing to record
+
+	ctx, exitCode := WithRecord(context.Background())
+	if exitCode() != nil {
+		t.Error("Expected no exit code before one is set")
+	}
+	SetExitCode(ctx, 2)
+	if code := exitCode(); code == nil || *code != 2 {
+		t.Errorf("Expected exit code 2, got %v", code)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2h", now.Add(-2 * time.Hour)},
+		{"2024-04-30", time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)},
+		{"2024-04-30 08:15", time.Date(2024, 4, 30, 8, 15, 0, 0, time.UTC)},
+		{"2024-04-30T08:15:00+02:00", time.Date(2024, 4, 30, 6, 15, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.value, now)
+		if err != nil || !got.Equal(tt.expected) {
+			t.Errorf("ParseTime(%q) = %v, %v; expected %v", tt.value, got, err, tt.expected)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("Expected an error for an unknown time")
+	}
+}
@@ -0,0 +1,110 @@
+// Package audit keeps an append-only record of every tool call.
+// This file reads the log back, including its rotated files.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Filter selects entries; zero fields match everything
+type Filter struct {
+	Tool    string
+	Session string
+	Since   time.Time // entries at or after this time
+	Until   time.Time // entries before this time
+}
+
+// Match reports whether an entry passes the filter
+func (f Filter) Match(entry Entry) bool {
+	switch {
+	case f.Tool != "" && entry.Tool != f.Tool:
+		return false
+	case f.Session != "" && entry.Session != f.Session:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Query returns the entries of the log at path and its rotated files that
+// pass filter, oldest first
+func Query(path string, filter Filter) ([]Entry, error) {
+	var files []string
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotatedPath(path, n)); err != nil {
+			break
+		}
+		files = append([]string{rotatedPath(path, n)}, files...)
+	}
+	files = append(files, path)
+
+	var entries []Entry
+	for _, file := range files {
+		found, err := readEntries(file, filter)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+// readEntries reads the entries of one file that pass filter; a missing
+// file has none
+func readEntries(path string, filter Filter) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid audit entry: %w", path, line, err)
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// timeLayouts are the absolute times ParseTime accepts, in local time unless
+// they carry a zone
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// ParseTime parses a time given as a duration before now, such as "2h", or
+// as a date with an optional time, such as "2024-05-01" or "2024-05-01 14:30"
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration such as \"2h\" or a date such as \"2024-05-01 14:30\"", value)
+}
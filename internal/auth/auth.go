@@ -0,0 +1,136 @@
+// Package auth checks the bearer tokens of HTTP clients: static tokens from
+// the config, expiring HMAC tokens created with dizi token create and JWT
+// access tokens from an OAuth 2.1 authorization server. Each token may be
+// limited to some tools and to a profile.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dizi/internal/config"
+
+	"github.com/gobwas/glob"
+)
+
+// Token kinds, reported as Identity.Method
+const (
+	MethodToken = "token"
+	MethodHMAC  = "hmac"
+	MethodJWT   = "jwt"
+)
+
+// ErrUnknownToken is returned by an Authenticator for tokens it doesn't
+// handle, so that the next one may try
+var ErrUnknownToken = errors.New("unknown token")
+
+// Authenticator checks a bearer token and returns whom it belongs to
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+// Identity is whom a token belongs to and what it may do
+type Identity struct {
+	Name    string   // token name, or the subject of a JWT
+	Method  string   // MethodToken, MethodHMAC or MethodJWT
+	Tools   []string // tool name patterns it may use; empty allows all tools
+	Profile string   // profile it is limited to besides Tools, if any
+
+	matchers []glob.Glob
+}
+
+// NewIdentity creates an identity limited to the tools matching patterns
+func NewIdentity(name, method string, tools []string) (*Identity, error) {
+	identity := &Identity{Name: name, Method: method, Tools: tools}
+	for _, pattern := range tools {
+		matcher, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+		}
+		identity.matchers = append(identity.matchers, matcher)
+	}
+	return identity, nil
+}
+
+// Unrestricted reports whether the identity may use every tool. A nil
+// identity, as in stdio mode, is unrestricted.
+func (i *Identity) Unrestricted() bool {
+	return i == nil || (len(i.Tools) == 0 && i.Profile == "")
+}
+
+// ProfileName returns the profile the identity is limited to, "" for none
+func (i *Identity) ProfileName() string {
+	if i == nil {
+		return ""
+	}
+	return i.Profile
+}
+
+// Allows reports whether the identity's tool patterns include a tool. Its
+// profile is checked separately, as profiles change with the config.
+func (i *Identity) Allows(tool string) bool {
+	if i == nil || len(i.Tools) == 0 {
+		return true
+	}
+	for _, matcher := range i.matchers {
+		if matcher.Match(tool) {
+			return true
+		}
+	}
+	return false
+}
+
+// New creates the authenticators configured in cfg. None means the HTTP
+// transports don't require a token.
+func New(cfg config.AuthConfig) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if len(cfg.Tokens) > 0 {
+		static, err := NewStatic(cfg.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, static)
+	}
+	if cfg.HMAC.Secret != "" {
+		hmac, err := NewHMAC(cfg.HMAC.Secret)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, hmac)
+	}
+	if cfg.JWT.JWKS != "" {
+		jwt, err := NewJWT(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	return authenticators, nil
+}
+
+// Authenticate asks each authenticator in turn about token
+func Authenticate(authenticators []Authenticator, token string) (*Identity, error) {
+	for _, authenticator := range authenticators {
+		identity, err := authenticator.Authenticate(token)
+		if errors.Is(err, ErrUnknownToken) {
+			continue
+		}
+		return identity, err
+	}
+	return nil, ErrUnknownToken
+}
+
+// identityKey is the context key of the identity of a request
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity of a request
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of a request, or nil if it carries none
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/config"
+)
+
+func TestStatic(t *testing.T) {
+	authenticators, err := New(config.AuthConfig{Tokens: []config.AuthToken{
+		{Name: "admin", Token: "admin-token"},
+		{Name: "ci", Token: "ci-token", Tools: []string{"build", "test_*"}},
+	}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	admin, err := Authenticate(authenticators, "admin-token")
+	if err != nil || admin.Name != "admin" || !admin.Unrestricted() || !admin.Allows("zephyr_flash") {
+		t.Errorf("Expected an unrestricted admin, got %+v %v", admin, err)
+	}
+	ci, err := Authenticate(authenticators, "ci-token")
+	if err != nil || ci.Method != MethodToken || ci.Unrestricted() {
+		t.Fatalf("Expected a restricted ci token, got %+v %v", ci, err)
+	}
+	for tool, allowed := range map[string]bool{"build": true, "test_unit": true, "zephyr_flash": false, "builder": false} {
+		if ci.Allows(tool) != allowed {
+			t.Errorf("ci.Allows(%q) = %v, expected %v", tool, !allowed, allowed)
+		}
+	}
+	if _, err := Authenticate(authenticators, "admin-token2"); !errors.Is(err, ErrUnknownToken) {
+		t.Errorf("Expected an unknown token, got %v", err)
+	}
+	var none *Identity
+	if !none.Allows("anything") {
+		t.Error("Expected requests without an identity to be unrestricted")
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	if _, err := NewHMAC("short"); err == nil {
+		t.Error("Expected a short secret to be refused")
+	}
+	signer, err := NewHMAC(strings.Repeat("s", MinSecretLength))
+	if err != nil {
+		t.Fatalf("NewHMAC failed: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.Create("ci", []string{"build"}, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	identity, err := signer.Authenticate(token)
+	if err != nil || identity.Name != "ci" || identity.Method != MethodHMAC || !identity.Allows("build") || identity.Allows("deploy") {
+		t.Errorf("Expected the ci token limited to build, got %+v %v", identity, err)
+	}
+
+	reviewer, err := signer.Create("reviewer", nil, "reviewer", time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	identity, err = signer.Authenticate(reviewer)
+	if err != nil || identity.ProfileName() != "reviewer" || identity.Unrestricted() || !identity.Allows("deploy") {
+		t.Errorf("Expected a token limited to the reviewer profile only, got %+v %v", identity, err)
+	}
+
+	other, _ := NewHMAC(strings.Repeat("o", MinSecretLength))
+	if _, err := other.Authenticate(token); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Expected a token from another secret to be refused, got %v", err)
+	}
+	tampered := strings.Replace(token, ".", "x.", 1)
+	if _, err := signer.Authenticate(tampered); err == nil {
+		t.Error("Expected a tampered token to be refused")
+	}
+	if _, err := signer.Authenticate("not-a-dizi-token"); !errors.Is(err, ErrUnknownToken) {
+		t.Errorf("Expected other tokens to be left to other authenticators, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := signer.Authenticate(token); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected the token to expire, got %v", err)
+	}
+
+	if _, err := signer.Create("", nil, "", time.Hour); err == nil {
+		t.Error("Expected a name to be required")
+	}
+	if _, err := signer.Create("ci", []string{"[build"}, "", time.Hour); err == nil {
+		t.Error("Expected an invalid tool pattern to be refused")
+	}
+}
+
+// signer signs JWTs in tests
+type signer struct {
+	alg  string
+	kid  string
+	sign func(digest, signed []byte) []byte
+}
+
+// token creates a JWT with claims signed by s
+func (s signer) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.sign(digest[:], []byte(signed)))
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	pad := func(n *big.Int) []byte { return n.FillBytes(make([]byte, 32)) }
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeys := func(keys ...map[string]string) {
+		t.Helper()
+		data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+		if err := os.WriteFile(jwks, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rsaJWK := map[string]string{"kty": "RSA", "kid": "rsa", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())}
+	ecJWK := map[string]string{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(pad(ecKey.X)), "y": encode(pad(ecKey.Y))}
+	edJWK := map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(edPublic)}
+	writeKeys(rsaJWK, ecJWK, edJWK)
+
+	rs256 := signer{alg: "RS256", kid: "rsa", sign: func(digest, _ []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+		return sig
+	}}
+	es256 := signer{alg: "ES256", kid: "ec", sign: func(digest, _ []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest)
+		return append(pad(r), pad(s)...)
+	}}
+	eddsa := signer{alg: "EdDSA", kid: "ed", sign: func(_, signed []byte) []byte {
+		return ed25519.Sign(edPrivate, signed)
+	}}
+
+	verifier, err := NewJWT(config.JWTConfig{JWKS: jwks, Issuer: "https://auth.example.com", Audience: "dizi"})
+	if err != nil {
+		t.Fatalf("NewJWT failed: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://auth.example.com", "aud": []string{"dizi", "other"}, "sub": "reviewer",
+			"exp": now.Add(time.Hour).Unix(), "scope": "openid tools:read_* tools:list_project_files",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	for _, s := range []signer{rs256, es256, eddsa} {
+		identity, err := verifier.Authenticate(s.token(t, claims(nil)))
+		if err != nil {
+			t.Errorf("%s: expected a valid token, got %v", s.alg, err)
+			continue
+		}
+		if identity.Name != "reviewer" || identity.Method != MethodJWT || !identity.Allows("read_project_file") || identity.Allows("write_project_file") {
+			t.Errorf("%s: expected the reviewer limited to read tools, got %+v", s.alg, identity)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		errMsg string
+	}{
+		{"expired", rs256.token(t, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), "expired"},
+		{"no exp", rs256.token(t, claims(map[string]interface{}{"exp": nil})), "no exp"},
+		{"not yet valid", rs256.token(t, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), "not valid before"},
+		{"wrong issuer", rs256.token(t, claims(map[string]interface{}{"iss": "https://evil.example.com"})), "issued by"},
+		{"wrong audience", rs256.token(t, claims(map[string]interface{}{"aud": "other"})), "audience"},
+		{"no tool scopes", rs256.token(t, claims(map[string]interface{}{"scope": "openid"})), "grants no tools"},
+		{"unknown key", signer{alg: "RS256", kid: "gone", sign: rs256.sign}.token(t, claims(nil)), "for key \"gone\""},
+		{"wrong key", signer{alg: "RS256", kid: "ec", sign: rs256.sign}.token(t, claims(nil)), "invalid JWT signature"},
+		{"symmetric", signer{alg: "HS256", sign: func(digest, _ []byte) []byte { return digest }}.token(t, claims(nil)), "unsupported JWT algorithm"},
+		{"none", signer{alg: "none", sign: func(_, _ []byte) []byte { return nil }}.token(t, claims(nil)), "unsupported JWT algorithm"},
+	}
+	for _, tt := range tests {
+		if _, err := verifier.Authenticate(tt.token); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.errMsg, err)
+		}
+	}
+
+	if identity, err := verifier.Authenticate(es256.token(t, claims(map[string]interface{}{"scope": nil, "scp": []string{"tools:*"}, "aud": "dizi"}))); err != nil || !identity.Allows("anything") {
+		t.Errorf("Expected scp and a single aud to be accepted, got %+v %v", identity, err)
+	}
+
+	// Rotating the keys takes effect without a restart
+	token := rs256.token(t, claims(nil))
+	writeKeys(ecJWK)
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(jwks, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Authenticate(token); err == nil {
+		t.Error("Expected a token signed with a removed key to be refused")
+	}
+}
@@ -0,0 +1,352 @@
+// Package auth checks the bearer tokens of HTTP clients.
+// This file verifies JWT access tokens against the keys of a local JWKS
+// file, as an OAuth 2.1 resource server.
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dizi/internal/config"
+)
+
+const (
+	// ScopePrefix marks the scopes of a JWT that grant tools, e.g.
+	// "tools:build" or "tools:*"
+	ScopePrefix = "tools:"
+	// clockSkew is how far the clocks of dizi and the authorization server
+	// may differ
+	clockSkew = time.Minute
+)
+
+// JWT accepts access tokens signed by one of the keys in a JWKS file. The
+// file is read again when it changes, so keys can be rotated without a
+// restart.
+type JWT struct {
+	config config.JWTConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    []jwk
+}
+
+// jwk is a public key of a JWKS file
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	key crypto.PublicKey
+}
+
+// jwtClaims are the registered and scope claims dizi checks
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	ClientID  string          `json:"client_id"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+}
+
+// NewJWT creates an authenticator for JWTs, reading the keys of cfg.JWKS
+func NewJWT(cfg config.JWTConfig) (*JWT, error) {
+	j := &JWT{config: cfg, now: time.Now}
+	if _, err := j.loadKeys(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Authenticate verifies the signature and claims of a JWT. Its tools are
+// those granted by "tools:" scopes; a token without any is refused.
+func (j *JWT) Authenticate(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnknownToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed JWT header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+	if err := j.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	name := claims.Subject
+	if name == "" {
+		name = claims.ClientID
+	}
+	tools := claims.tools()
+	if len(tools) == 0 {
+		return nil, fmt.Errorf("JWT grants no tools, expected scopes such as %s* or %sbuild", ScopePrefix, ScopePrefix)
+	}
+	return NewIdentity(name, MethodJWT, tools)
+}
+
+// verify checks the signature of signed with the key named kid, or with
+// every key that fits the algorithm if the token names none
+func (j *JWT) verify(alg, kid, signed string, signature []byte) error {
+	keys, err := j.loadKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if (kid != "" && key.Kid != kid) || (key.Alg != "" && key.Alg != alg) || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		ok, err := verifySignature(alg, key.key, []byte(signed), signature)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	if kid != "" {
+		return fmt.Errorf("invalid JWT signature for key %q", kid)
+	}
+	return errors.New("invalid JWT signature")
+}
+
+// checkClaims checks expiry, issuer and audience
+func (j *JWT) checkClaims(claims jwtClaims) error {
+	now := j.now()
+	if claims.Expires == nil {
+		return errors.New("JWT has no exp claim")
+	}
+	if expires := time.Unix(int64(*claims.Expires), 0); now.After(expires.Add(clockSkew)) {
+		return fmt.Errorf("JWT expired at %s", expires.Format(time.RFC3339))
+	}
+	if claims.NotBefore != nil {
+		if notBefore := time.Unix(int64(*claims.NotBefore), 0); now.Add(clockSkew).Before(notBefore) {
+			return fmt.Errorf("JWT not valid before %s", notBefore.Format(time.RFC3339))
+		}
+	}
+	if j.config.Issuer != "" && claims.Issuer != j.config.Issuer {
+		return fmt.Errorf("JWT issued by %q, expected %q", claims.Issuer, j.config.Issuer)
+	}
+	if j.config.Audience != "" && !containsAudience(claims.Audience, j.config.Audience) {
+		return fmt.Errorf("JWT is not intended for audience %q", j.config.Audience)
+	}
+	return nil
+}
+
+// tools returns the tool patterns granted by the scopes of a token, from
+// the space-separated scope claim or the scp claim
+func (c jwtClaims) tools() []string {
+	scopes := strings.Fields(c.Scope)
+	var list []string
+	var single string
+	if json.Unmarshal(c.Scp, &list) == nil {
+		scopes = append(scopes, list...)
+	} else if json.Unmarshal(c.Scp, &single) == nil {
+		scopes = append(scopes, strings.Fields(single)...)
+	}
+
+	var tools []string
+	for _, scope := range scopes {
+		if pattern, ok := strings.CutPrefix(scope, ScopePrefix); ok && pattern != "" {
+			tools = append(tools, pattern)
+		}
+	}
+	return tools
+}
+
+// containsAudience reports whether the aud claim, a string or a list,
+// names audience
+func containsAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, aud := range list {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadKeys returns the keys of the JWKS file, reading it again if it
+// changed since the last call
+func (j *JWT) loadKeys() ([]jwk, error) {
+	info, err := os.Stat(j.config.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.keys != nil && info.ModTime().Equal(j.modTime) {
+		return j.keys, nil
+	}
+
+	data, err := os.ReadFile(j.config.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %w", j.config.JWKS, err)
+	}
+	var keys []jwk
+	for _, key := range set.Keys {
+		if key.key, err = key.publicKey(); err != nil {
+			return nil, fmt.Errorf("invalid key %q in JWKS file %s: %w", key.Kid, j.config.JWKS, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s has no keys", j.config.JWKS)
+	}
+
+	j.keys, j.modTime = keys, info.ModTime()
+	return keys, nil
+}
+
+// publicKey decodes an RSA, EC or Ed25519 key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature reports whether signature is valid for signed under key.
+// Only asymmetric algorithms are accepted: an HS256 token would be signed
+// with the public key.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) (bool, error) {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if alg == "EdDSA" {
+		public, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(public, signed, signature), nil
+	}
+	if len(alg) != 5 {
+		return false, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return false, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		public, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(public, hash, digest, signature) == nil, nil
+	case "PS":
+		public, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(public, hash, digest, signature, nil) == nil, nil
+	case "ES":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false, nil
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false, nil
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(public, digest, r, s), nil
+	}
+	return false, fmt.Errorf("unsupported JWT algorithm %q", alg)
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeInt decodes a base64url big-endian integer of a JWK
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,44 @@
+// Package auth checks the bearer tokens of HTTP clients.
+// This file handles the static tokens listed in server.auth.tokens.
+package auth
+
+import (
+	"crypto/subtle"
+
+	"dizi/internal/config"
+)
+
+// Static accepts the tokens listed in the config
+type Static struct {
+	tokens     [][]byte
+	identities []*Identity
+}
+
+// NewStatic creates an authenticator for static tokens
+func NewStatic(tokens []config.AuthToken) (*Static, error) {
+	static := &Static{}
+	for _, token := range tokens {
+		identity, err := NewIdentity(token.Name, MethodToken, token.Tools)
+		if err != nil {
+			return nil, err
+		}
+		identity.Profile = token.Profile
+		static.tokens = append(static.tokens, []byte(token.Token))
+		static.identities = append(static.identities, identity)
+	}
+	return static, nil
+}
+
+// Authenticate compares token to every configured token in constant time
+func (s *Static) Authenticate(token string) (*Identity, error) {
+	var found *Identity
+	for i, candidate := range s.tokens {
+		if len(candidate) > 0 && subtle.ConstantTimeCompare(candidate, []byte(token)) == 1 {
+			found = s.identities[i]
+		}
+	}
+	if found == nil {
+		return nil, ErrUnknownToken
+	}
+	return found, nil
+}
@@ -3,58 +3,188 @@
 package config
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 // Config represents the dizi.yml configuration structure
 type Config struct {
-	Name        string       `yaml:"name"`
-	Version     string       `yaml:"version"`
-	Description string       `yaml:"description"`
-	Server      ServerConfig `yaml:"server"`
-	Tools       []ToolConfig `yaml:"tools"`
+	Name        string                   `yaml:"name"`
+	Version     string                   `yaml:"version"`
+	Description string                   `yaml:"description"`
+	Server      ServerConfig             `yaml:"server"`
+	Root        string                   `yaml:"root,omitempty"`     // templated tool cwd must stay within, defaults to the project directory
+	Env         map[string]EnvValue      `yaml:"env,omitempty"`      // passed to every tool
+	EnvFile     string                   `yaml:"env_file,omitempty"` // dotenv file, relative to the config file
+	Tools       []ToolConfig             `yaml:"tools"`
+	Profiles    map[string]ProfileConfig `yaml:"profiles,omitempty"` // named sets of tools a client may be limited to
+
+	// Environment is the resolved global env_file and env, filled in on load
+	Environment []EnvVar `yaml:"-"`
+}
+
+// ProfileConfig is a set of tools, selected with -profile, an auth token or
+// ?profile=. A tool belongs to the profile if it matches tools or has one
+// of tags, and doesn't match exclude.
+type ProfileConfig struct {
+	Tools   []string `yaml:"tools,omitempty"`   // tool name patterns, e.g. "read_*"
+	Tags    []string `yaml:"tags,omitempty"`    // tools with any of these tags
+	Exclude []string `yaml:"exclude,omitempty"` // tool name patterns left out even if they match
 }
 
 // ServerConfig represents server configuration
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port           int               `yaml:"port"`
+	Listen         string            `yaml:"listen,omitempty"`          // address of the HTTP transports, host:port or unix:/path/to.sock; overrides -host and port
+	TLS            TLSConfig         `yaml:"tls,omitempty"`             // serve the HTTP transports and approval endpoint over HTTPS
+	DefaultTimeout time.Duration     `yaml:"default_timeout,omitempty"` // applies to tools without their own timeout
+	OutputLimit    OutputLimitConfig `yaml:"output_limit,omitempty"`    // applies to tools without their own limits
+	ShellEnv       string            `yaml:"shell_env,omitempty"`       // login-env (default), cached-env or clean-env
+	Approval       ApprovalConfig    `yaml:"approval,omitempty"`        // how calls to tools with confirm: true are approved
+	Audit          AuditConfig       `yaml:"audit,omitempty"`           // where every tool call is recorded
+	FsRoots        []string          `yaml:"fs_roots,omitempty"`        // directories SSE clients may root filesystem tools in besides the project
+	Auth           AuthConfig        `yaml:"auth,omitempty"`            // bearer tokens the HTTP transports require
 }
 
-// ToolConfig represents a tool configuration
-type ToolConfig struct {
-	Name        string                 `yaml:"name"`
-	Description string                 `yaml:"description"`
-	Type        string                 `yaml:"type"` // "command", "script", etc.
-	Command     string                 `yaml:"command,omitempty"`
-	Script      string                 `yaml:"script,omitempty"`
-	Args        []string               `yaml:"args,omitempty"`
-	Parameters  map[string]interface{} `yaml:"parameters,omitempty"`
+// UnixPrefix marks a listen address as the path of a Unix domain socket
+const UnixPrefix = "unix:"
+
+// TLSConfig serves the HTTP endpoints over TLS once a certificate is set or
+// self_signed is true
+type TLSConfig struct {
+	Cert       string `yaml:"cert,omitempty"`        // PEM certificate chain, relative to the config file
+	Key        string `yaml:"key,omitempty"`         // PEM private key of the certificate
+	ClientCA   string `yaml:"client_ca,omitempty"`   // PEM CA bundle; clients must present a certificate it signed (mTLS)
+	SelfSigned bool   `yaml:"self_signed,omitempty"` // create a certificate for localhost if cert and key don't exist
 }
 
-// Load loads configuration from dizi.yml in the current directory
-func Load() (*Config, error) {
-	configPath := filepath.Join(".", "dizi.yml")
+// Enabled reports whether the HTTP endpoints are served over TLS
+func (t TLSConfig) Enabled() bool {
+	return t.Cert != "" || t.SelfSigned
+}
 
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return getDefaultConfig(), nil
+// AuthConfig controls who may use the HTTP transports. Once any kind of
+// token is configured, every request needs one as a bearer token.
+type AuthConfig struct {
+	Tokens []AuthToken `yaml:"tokens,omitempty"` // static tokens
+	HMAC   HMACConfig  `yaml:"hmac,omitempty"`   // expiring tokens signed by dizi token create
+	JWT    JWTConfig   `yaml:"jwt,omitempty"`    // OAuth 2.1 access tokens from an authorization server
+}
+
+// AuthToken is a static bearer token
+type AuthToken struct {
+	Name    string   `yaml:"name"`
+	Token   string   `yaml:"token"`             // may use ${VAR} from env and the server's environment
+	Tools   []string `yaml:"tools,omitempty"`   // tool name patterns the token may use, all tools if empty
+	Profile string   `yaml:"profile,omitempty"` // profile the token is limited to
+}
+
+// HMACConfig controls the tokens created with dizi token create
+type HMACConfig struct {
+	Secret string `yaml:"secret,omitempty"` // signing key, may use ${VAR}; changing it revokes all tokens
+}
+
+// JWTConfig makes the server an OAuth 2.1 resource server accepting JWT
+// access tokens. Tool scopes come from "tools:<pattern>" scopes.
+type JWTConfig struct {
+	JWKS     string `yaml:"jwks,omitempty"`     // JWKS file with the authorization server's keys, relative to the config file
+	Issuer   string `yaml:"issuer,omitempty"`   // required iss claim, also published as the authorization server
+	Audience string `yaml:"audience,omitempty"` // required aud claim
+}
+
+// MarshalYAML hides the token in dizi config show
+func (t AuthToken) MarshalYAML() (interface{}, error) {
+	type plain AuthToken
+	if t.Token != "" {
+		t.Token = Redacted
 	}
+	return plain(t), nil
+}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// MarshalYAML hides the secret in dizi config show
+func (h HMACConfig) MarshalYAML() (interface{}, error) {
+	type plain HMACConfig
+	if h.Secret != "" {
+		h.Secret = Redacted
 	}
+	return plain(h), nil
+}
+
+// AuditConfig controls the audit log, a JSON line for every tool call.
+// Calls are only recorded when a path is set.
+type AuditConfig struct {
+	Path      string `yaml:"path,omitempty"`        // JSONL file, relative to the config file
+	MaxSizeMB int    `yaml:"max_size_mb,omitempty"` // rotate once the file reaches this size, defaults to 10
+	MaxFiles  int    `yaml:"max_files,omitempty"`   // rotated files kept next to it, defaults to 5
+}
+
+// ApprovalConfig controls how a person approves calls to tools that need
+// confirmation. Pending calls are listed on the approval endpoint, which
+// dizi approve polls from a second terminal.
+type ApprovalConfig struct {
+	Listen  string        `yaml:"listen,omitempty"`  // address of the approval endpoint in stdio mode, host:port (needs auth) or unix:/path, defaults to a socket of the project
+	Timeout time.Duration `yaml:"timeout,omitempty"` // how long a call waits for a decision before it is denied, defaults to 5m
+	Log     string        `yaml:"log,omitempty"`     // JSONL file every decision is appended to, relative to the config file
+	Confirm []string      `yaml:"confirm,omitempty"` // filesystem tools (-fs-tools) that need approval, e.g. write_project_file
+}
+
+// ToolConfig represents a tool configuration
+type ToolConfig struct {
+	Name             string                 `yaml:"name"`
+	Description      string                 `yaml:"description"`
+	Type             string                 `yaml:"type"` // "command", "script", etc.
+	Command          string                 `yaml:"command,omitempty"`
+	Script           string                 `yaml:"script,omitempty"`
+	Args             []string               `yaml:"args,omitempty"`
+	Parameters       map[string]interface{} `yaml:"parameters,omitempty"`
+	Cwd              string                 `yaml:"cwd,omitempty"`     // working directory, may use placeholders
+	Stdin            string                 `yaml:"stdin,omitempty"`   // text piped to the process, may use placeholders
+	Timeout          time.Duration          `yaml:"timeout,omitempty"` // e.g. "30s", "10m"; 0 means no limit
+	Progress         ProgressConfig         `yaml:"progress,omitempty"`
+	Output           OutputConfig           `yaml:"output,omitempty"`
+	OutputLimit      OutputLimitConfig      `yaml:"output_limit,omitempty"`       // overrides server.output_limit
+	SuccessExitCodes []int                  `yaml:"success_exit_codes,omitempty"` // exit codes that count as success, defaults to 0 only
+	Async            bool                   `yaml:"async,omitempty"`              // run in the background and return a job ID
+	IgnoreExitCode   bool                   `yaml:"ignore_exit_code,omitempty"`   // return any exit code as a normal result, e.g. for grep
+	Env              map[string]EnvValue    `yaml:"env,omitempty"`                // overrides the global env
+	EnvFile          string                 `yaml:"env_file,omitempty"`           // dotenv file, relative to the config file
+	Sandbox          SandboxConfig          `yaml:"sandbox,omitempty"`            // restrict files, network and resources (Linux)
+	Policy           PolicyConfig           `yaml:"policy,omitempty"`             // commands the tool may run, checked before it starts
+	Confirm          bool                   `yaml:"confirm,omitempty"`            // a person must approve each call before it runs
+	Tags             []string               `yaml:"tags,omitempty"`               // labels profiles select tools by, e.g. "readonly"
+
+	// Environment is the resolved global and tool environment, filled in on load
+	Environment []EnvVar `yaml:"-"`
+	// Dir is the directory of the config file defining the tool, filled in on
+	// load; relative cwd and lua script paths are resolved against it
+	Dir string `yaml:"-"`
+}
+
+// PolicyConfig limits the commands a shell tool may run. A rule is an
+// executable followed by argument patterns, e.g. "git push --force".
+type PolicyConfig struct {
+	Allow []string `yaml:"allow,omitempty"` // if set, every command must match one of these
+	Deny  []string `yaml:"deny,omitempty"`  // no command may match any of these
+}
+
+// ProgressConfig controls how tool output is batched into progress notifications
+type ProgressConfig struct {
+	Lines int `yaml:"lines,omitempty"` // lines per notification, defaults to 1
+	Bytes int `yaml:"bytes,omitempty"` // send early once this many bytes are pending; 0 means no limit
+}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// Load loads the effective configuration for the current directory: dizi.yml
+// found here or in a parent directory, layered over the user-level config and
+// overridden by DIZI_* environment variables
+func Load() (*Config, error) {
+	layered, err := LoadLayered(LoadOptions{})
+	if err != nil {
+		return nil, err
 	}
+	return layered.Config, nil
+}
 
-	// Set defaults if not specified
+// applyDefaults sets defaults for values not specified
+func applyDefaults(config *Config) {
 	if config.Name == "" {
 		config.Name = "dizi"
 	}
@@ -67,8 +197,6 @@ func Load() (*Config, error) {
 	if config.Server.Port == 0 {
 		config.Server.Port = 8080
 	}
-
-	return &config, nil
 }
 
 // getDefaultConfig returns a default configuration
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadDefaultConfig(t *testing.T) {
@@ -150,6 +151,47 @@ tools:
 	}
 }
 
+func TestLoadConfigTimeouts(t *testing.T) {
+	tempDir := t.TempDir()
+	originalWd, _ := os.Getwd()
+
+	_ = os.Chdir(tempDir)
+	defer func() { _ = os.Chdir(originalWd) }()
+
+	configContent := `server:
+  default_timeout: "2m"
+tools:
+  - name: "build"
+    description: "Build"
+    type: "script"
+    script: "make"
+    timeout: "90s"
+  - name: "status"
+    description: "Status"
+    type: "script"
+    script: "git status"
+`
+
+	if err := os.WriteFile("dizi.yml", []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	config, err := Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if config.Server.DefaultTimeout != 2*time.Minute {
+		t.Errorf("Expected default timeout 2m, got %v", config.Server.DefaultTimeout)
+	}
+	if config.Tools[0].Timeout != 90*time.Second {
+		t.Errorf("Expected tool timeout 90s, got %v", config.Tools[0].Timeout)
+	}
+	if config.Tools[1].Timeout != 0 {
+		t.Errorf("Expected no tool timeout, got %v", config.Tools[1].Timeout)
+	}
+}
+
 func TestLoadConfigInvalidYAML(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
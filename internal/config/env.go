@@ -0,0 +1,271 @@
+// Package config provides configuration management for the MCP server.
+// This file resolves the environment variables configured for tools and
+// the ${VAR} references in auth secrets.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secret values in tool results, errors and logs
+const Redacted = "[REDACTED]"
+
+// EnvValue is the value of a variable in an env: mapping. It is written
+// either as a plain string or as a mapping that marks it secret:
+//
+//	env:
+//	  REGION: "cn-north"
+//	  API_TOKEN:
+//	    value: "${API_TOKEN}"
+//	    secret: true
+type EnvValue struct {
+	Value  string `yaml:"value"`
+	Secret bool   `yaml:"secret,omitempty"`
+}
+
+// EnvVar is a resolved environment variable
+type EnvVar struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// UnmarshalYAML accepts a plain string or a {value, secret} mapping
+func (v *EnvValue) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			*v = EnvValue{}
+			return nil
+		}
+		*v = EnvValue{Value: node.Value}
+		return nil
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; key != "value" && key != "secret" {
+				return fmt.Errorf("unknown key %q, expected value or secret", key)
+			}
+		}
+		type plain EnvValue
+		var decoded plain
+		if err := node.Decode(&decoded); err != nil {
+			return err
+		}
+		*v = EnvValue(decoded)
+		return nil
+	}
+	return fmt.Errorf("must be a string or a mapping with value and secret")
+}
+
+// MarshalYAML writes plain values as strings and hides secret ones
+func (v EnvValue) MarshalYAML() (interface{}, error) {
+	if !v.Secret {
+		return v.Value, nil
+	}
+	return map[string]interface{}{"value": Redacted, "secret": true}, nil
+}
+
+// Secrets returns the values of all variables marked secret
+func (c *Config) Secrets() []string {
+	var secrets []string
+	seen := make(map[string]bool)
+	add := func(vars []EnvVar) {
+		for _, v := range vars {
+			if v.Secret && v.Value != "" && !seen[v.Value] {
+				seen[v.Value] = true
+				secrets = append(secrets, v.Value)
+			}
+		}
+	}
+	add(c.Environment)
+	for _, tool := range c.Tools {
+		add(tool.Environment)
+	}
+	auth := []EnvVar{{Value: c.Server.Auth.HMAC.Secret, Secret: true}}
+	for _, token := range c.Server.Auth.Tokens {
+		auth = append(auth, EnvVar{Value: token.Token, Secret: true})
+	}
+	add(auth)
+	return secrets
+}
+
+// resolveEnv fills in the Environment of the config and each tool: the global
+// env_file and env, then the tool's env_file and env, each overriding the
+// previous ones. ${VAR} in env values refers to variables set by the earlier
+// layers or to the server's environ. Returns the env files that were read.
+func resolveEnv(config *Config, environ []string) ([]string, error) {
+	server := make(map[string]string, len(environ))
+	for _, entry := range environ {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			server[name] = value
+		}
+	}
+
+	var files []string
+	global, err := resolveEnvLayer(nil, config.EnvFile, config.Env, server, &files)
+	if err != nil {
+		return nil, err
+	}
+	config.Environment = global
+	resolveAuth(&config.Server.Auth, global, server)
+
+	for i := range config.Tools {
+		tool := &config.Tools[i]
+		if tool.EnvFile == "" && len(tool.Env) == 0 {
+			tool.Environment = global
+			continue
+		}
+		vars, err := resolveEnvLayer(global, tool.EnvFile, tool.Env, server, &files)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
+		tool.Environment = vars
+	}
+	return files, nil
+}
+
+// resolveAuth expands ${VAR} in the auth tokens and secret against the
+// global env and the server's environ
+func resolveAuth(auth *AuthConfig, global []EnvVar, server map[string]string) {
+	lookup := make(map[string]string, len(server)+len(global))
+	for name, value := range server {
+		lookup[name] = value
+	}
+	for _, v := range global {
+		lookup[v.Name] = v.Value
+	}
+	for i := range auth.Tokens {
+		auth.Tokens[i].Token = expandVars(auth.Tokens[i].Token, lookup)
+	}
+	auth.HMAC.Secret = expandVars(auth.HMAC.Secret, lookup)
+}
+
+// resolveEnvLayer applies an env_file and an env mapping on top of base
+func resolveEnvLayer(base []EnvVar, envFile string, env map[string]EnvValue, server map[string]string, files *[]string) ([]EnvVar, error) {
+	vars := make(map[string]EnvVar, len(base)+len(env))
+	for _, v := range base {
+		vars[v.Name] = v
+	}
+	set := func(name, value string, secret bool) {
+		// Overriding a secret must not make its name any less sensitive
+		secret = secret || vars[name].Secret
+		vars[name] = EnvVar{Name: name, Value: value, Secret: secret}
+	}
+
+	if envFile != "" {
+		entries, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		*files = append(*files, envFile)
+		for _, entry := range entries {
+			set(entry.Name, entry.Value, false)
+		}
+	}
+
+	// Expand against the layers below only, so the order of the mapping doesn't matter
+	lookup := make(map[string]string, len(server)+len(vars))
+	for name, value := range server {
+		lookup[name] = value
+	}
+	for name, v := range vars {
+		lookup[name] = v.Value
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := env[name]
+		set(name, expandVars(value.Value, lookup), value.Secret)
+	}
+
+	resolved := make([]EnvVar, 0, len(vars))
+	for _, v := range vars {
+		resolved = append(resolved, v)
+	}
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].Name < resolved[j].Name })
+	return resolved, nil
+}
+
+// expandVars replaces ${VAR} and ${VAR:-default} in s. Unlike os.Expand,
+// a bare $VAR is left alone so values may contain literal dollar signs.
+func expandVars(s string, lookup map[string]string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		out.WriteString(s[:start])
+		name, fallback, hasFallback := strings.Cut(s[start+2:end], ":-")
+		if value, ok := lookup[name]; ok && (value != "" || !hasFallback) {
+			out.WriteString(value)
+		} else {
+			out.WriteString(fallback)
+		}
+		s = s[end+1:]
+	}
+	out.WriteString(s)
+	return out.String()
+}
+
+// readEnvFile parses a dotenv file: KEY=VALUE lines, optionally prefixed with
+// "export", with # comments and single or double quoted values
+func readEnvFile(path string) ([]EnvVar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []EnvVar
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("failed to parse env file %s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			// Unquoted values end at an inline comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		entries = append(entries, EnvVar{Name: name, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	return entries, nil
+}
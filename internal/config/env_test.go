@@ -0,0 +1,126 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envValue returns the resolved value of name in vars
+func envValue(vars []EnvVar, name string) (EnvVar, bool) {
+	for _, v := range vars {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return EnvVar{}, false
+}
+
+func TestLoadLayeredEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "project/.env", `# shared settings
+export REGION=cn-north
+API_TOKEN="tok\"en-123"
+GREETING='hello # not a comment'
+DEBUG=1 # inline comment
+`)
+	configPath := writeFile(t, tempDir, "project/dizi.yml", `env_file: ".env"
+env:
+  LOG_LEVEL: "info"
+  TOKEN:
+    value: "${API_TOKEN}"
+    secret: true
+tools:
+  - name: "deploy"
+    type: "script"
+    script: "deploy.sh"
+    env:
+      LOG_LEVEL: "debug"
+      TARGET: "${HOME}/${REGION}"
+      FALLBACK: "${MISSING:-none}"
+      PRICE: "$5"
+  - name: "status"
+    type: "script"
+    script: "status.sh"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"HOME=/home/dev"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	config := layered.Config
+
+	expected := map[string]string{
+		"REGION":    "cn-north",
+		"API_TOKEN": `tok"en-123`,
+		"GREETING":  "hello # not a comment",
+		"DEBUG":     "1",
+		"LOG_LEVEL": "debug",
+		"TOKEN":     `tok"en-123`,
+		"TARGET":    "/home/dev/cn-north",
+		"FALLBACK":  "none",
+		"PRICE":     "$5",
+	}
+	for name, value := range expected {
+		v, ok := envValue(config.Tools[0].Environment, name)
+		if !ok || v.Value != value {
+			t.Errorf("Expected %s=%q, got %q (set: %v)", name, value, v.Value, ok)
+		}
+	}
+
+	if v, _ := envValue(config.Tools[1].Environment, "LOG_LEVEL"); v.Value != "info" {
+		t.Errorf("Expected global LOG_LEVEL for status tool, got %q", v.Value)
+	}
+	if v, _ := envValue(config.Tools[1].Environment, "TOKEN"); !v.Secret {
+		t.Error("Expected TOKEN to be secret")
+	}
+	if secrets := config.Secrets(); len(secrets) != 1 || secrets[0] != `tok"en-123` {
+		t.Errorf("Expected one secret, got %v", secrets)
+	}
+
+	envFile := filepath.Join(tempDir, "project", ".env")
+	found := false
+	for _, file := range layered.Files {
+		if file == envFile {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected env file to be watched, got %v", layered.Files)
+	}
+
+	// Secret values are hidden from config show
+	output, err := layered.Show()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(output), Redacted) {
+		t.Errorf("Expected secret value to be redacted, got:\n%s", output)
+	}
+}
+
+func TestLoadLayeredEnvErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	noUser := filepath.Join(tempDir, "none.yml")
+
+	missing := writeFile(t, tempDir, "missing/dizi.yml", "env_file: \"absent.env\"\n")
+	if _, err := LoadLayered(LoadOptions{Path: missing, UserPath: noUser, Environ: []string{}}); err == nil {
+		t.Error("Expected error for missing env file")
+	}
+
+	writeFile(t, tempDir, "bad/.env", "NOT A VALID LINE\n")
+	bad := writeFile(t, tempDir, "bad/dizi.yml", "env_file: \".env\"\n")
+	if _, err := LoadLayered(LoadOptions{Path: bad, UserPath: noUser, Environ: []string{}}); err == nil {
+		t.Error("Expected error for malformed env file")
+	}
+
+	typo := writeFile(t, tempDir, "typo/dizi.yml", `env:
+  TOKEN:
+    value: "x"
+    secert: true
+`)
+	_, err := LoadLayered(LoadOptions{Path: typo, UserPath: noUser, Environ: []string{}})
+	if err == nil || !strings.Contains(err.Error(), `env.TOKEN: unknown key "secert"`) {
+		t.Errorf("Expected validation error for unknown key, got %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+// Package config provides configuration management for the MCP server.
+// This file locates configuration files and merges them into one effective configuration.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the project configuration file
+const FileName = "dizi.yml"
+
+// envPrefix prefixes environment variables that override configuration values
+const envPrefix = "DIZI_"
+
+// LoadOptions controls how configuration files are located and layered
+type LoadOptions struct {
+	Path     string   // explicit project config file; disables the upward search
+	Dir      string   // directory the upward search starts from, defaults to the working directory
+	UserPath string   // user-level config file, defaults to UserConfigPath()
+	Environ  []string // environment used for DIZI_* overrides, defaults to os.Environ()
+}
+
+// Layered is the effective configuration together with where each value came from
+type Layered struct {
+	Config *Config
+	Path   string   // project config file, empty if none was found
+	Files  []string // every file that contributed, lowest precedence first
+
+	tree    *yaml.Node            // merged document before decoding
+	sources map[*yaml.Node]string // node -> file or env var it was read from
+}
+
+// UserConfigPath returns the user-level configuration file,
+// $XDG_CONFIG_HOME/dizi/dizi.yml or ~/.config/dizi/dizi.yml
+func UserConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "dizi", FileName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "dizi", FileName)
+}
+
+// FindConfig searches dir and its parents for dizi.yml
+func FindConfig(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		candidate := filepath.Join(dir, FileName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// resolve fills in the defaults for unset options
+func (opts LoadOptions) resolve() LoadOptions {
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	if opts.UserPath == "" {
+		opts.UserPath = UserConfigPath()
+	}
+	if opts.Environ == nil {
+		opts.Environ = os.Environ()
+	}
+	return opts
+}
+
+// projectPath returns the project config file to load, or "" if there is none
+func (opts LoadOptions) projectPath() string {
+	if opts.Path != "" {
+		return opts.Path
+	}
+	path, _ := FindConfig(opts.Dir)
+	return path
+}
+
+// LoadLayered loads the effective configuration: the user-level config, the
+// project config (with its includes) on top, then DIZI_* environment overrides.
+// Without any config file the built-in default configuration is used.
+func LoadLayered(opts LoadOptions) (*Layered, error) {
+	opts = opts.resolve()
+
+	layered := &Layered{
+		Path:    opts.projectPath(),
+		sources: make(map[*yaml.Node]string),
+	}
+	if opts.Path != "" {
+		if _, err := os.Stat(opts.Path); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	var layers []string
+	if opts.UserPath != "" {
+		if _, err := os.Stat(opts.UserPath); err == nil {
+			layers = append(layers, opts.UserPath)
+		}
+	}
+	if layered.Path != "" {
+		layers = append(layers, layered.Path)
+	}
+
+	tree := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(layers) == 0 {
+		// Nothing on disk: start from the built-in defaults
+		if err := tree.Encode(getDefaultConfig()); err != nil {
+			return nil, fmt.Errorf("failed to encode default config: %w", err)
+		}
+	}
+	for _, path := range layers {
+		layer, err := layered.parseFile(path, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		tree = mergeMapping(tree, layer, true)
+	}
+
+	if err := layered.applyEnv(tree, opts.Environ); err != nil {
+		return nil, err
+	}
+	layered.tree = tree
+
+	if problems := layered.validate(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	var config Config
+	if err := tree.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	applyDefaults(&config)
+	layered.resolveDirs(&config)
+
+	envFiles, err := resolveEnv(&config, opts.Environ)
+	if err != nil {
+		return nil, err
+	}
+	layered.Files = append(layered.Files, envFiles...)
+	layered.Config = &config
+
+	return layered, nil
+}
+
+// parseFile reads a config file and merges the files it includes beneath it
+func (l *Layered) parseFile(path string, visiting map[string]bool) (*yaml.Node, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config file %s: %w", path, err)
+	}
+	if visiting[absPath] {
+		return nil, fmt.Errorf("config file %s includes itself", path)
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	root, err := l.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch root.Kind {
+	case yaml.MappingNode:
+	case yaml.SequenceNode:
+		// A bare list is a fragment of tools
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "tools"}, root,
+		}}
+	default:
+		return nil, fmt.Errorf("failed to parse config file %s: expected a mapping or a list of tools", path)
+	}
+	resolveRelativePaths(root, filepath.Dir(path))
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	self := &yaml.Node{Kind: yaml.MappingNode, Tag: root.Tag}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "include" {
+			self.Content = append(self.Content, key, value)
+			continue
+		}
+
+		patterns, err := includePatterns(path, value)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range patterns {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern %q in %s: %w", pattern, path, err)
+			}
+			if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				return nil, fmt.Errorf("included file %s not found (in %s)", pattern, path)
+			}
+			for _, match := range matches {
+				fragment, err := l.parseFile(match, visiting)
+				if err != nil {
+					return nil, err
+				}
+				merged = mergeMapping(merged, fragment, true)
+			}
+		}
+	}
+
+	// The including file takes precedence over what it includes
+	return mergeMapping(merged, self, true), nil
+}
+
+// readFile parses a YAML file and records it as the source of its nodes
+func (l *Layered) readFile(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	l.Files = append(l.Files, path)
+
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	root := doc.Content[0]
+	walkNodes(root, func(n *yaml.Node) { l.sources[n] = path })
+	return root, nil
+}
+
+// resolveRelativePaths makes the paths in a parsed config file that name
+// files or directories (env_file, root) relative to that file's directory
+// rather than the server's working directory
+func resolveRelativePaths(root *yaml.Node, dir string) {
+	absolutize := func(mapping *yaml.Node, key string) {
+		if index := mappingIndex(mapping, key); index >= 0 {
+			value := mapping.Content[index+1]
+			if value.Kind == yaml.ScalarNode && value.Value != "" && !filepath.IsAbs(value.Value) {
+				value.Value = filepath.Join(dir, value.Value)
+			}
+		}
+	}
+
+	absolutize(root, "env_file")
+	absolutize(root, "root")
+	if index := mappingIndex(root, "server"); index >= 0 && root.Content[index+1].Kind == yaml.MappingNode {
+		server := root.Content[index+1]
+		if index := mappingIndex(server, "approval"); index >= 0 && server.Content[index+1].Kind == yaml.MappingNode {
+			absolutize(server.Content[index+1], "log")
+		}
+		if index := mappingIndex(server, "audit"); index >= 0 && server.Content[index+1].Kind == yaml.MappingNode {
+			absolutize(server.Content[index+1], "path")
+		}
+		if index := mappingIndex(server, "auth"); index >= 0 && server.Content[index+1].Kind == yaml.MappingNode {
+			auth := server.Content[index+1]
+			if index := mappingIndex(auth, "jwt"); index >= 0 && auth.Content[index+1].Kind == yaml.MappingNode {
+				absolutize(auth.Content[index+1], "jwks")
+			}
+		}
+		if index := mappingIndex(server, "tls"); index >= 0 && server.Content[index+1].Kind == yaml.MappingNode {
+			for _, key := range []string{"cert", "key", "client_ca"} {
+				absolutize(server.Content[index+1], key)
+			}
+		}
+		if index := mappingIndex(server, "listen"); index >= 0 {
+			value := server.Content[index+1]
+			if path, ok := strings.CutPrefix(value.Value, UnixPrefix); ok && path != "" && !filepath.IsAbs(path) {
+				value.Value = UnixPrefix + filepath.Join(dir, path)
+			}
+		}
+		if index := mappingIndex(server, "fs_roots"); index >= 0 && server.Content[index+1].Kind == yaml.SequenceNode {
+			for _, item := range server.Content[index+1].Content {
+				if item.Kind == yaml.ScalarNode && item.Value != "" && !filepath.IsAbs(item.Value) {
+					item.Value = filepath.Join(dir, item.Value)
+				}
+			}
+		}
+	}
+	if index := mappingIndex(root, "tools"); index >= 0 && root.Content[index+1].Kind == yaml.SequenceNode {
+		for _, tool := range root.Content[index+1].Content {
+			if tool.Kind == yaml.MappingNode {
+				absolutize(tool, "env_file")
+			}
+		}
+	}
+}
+
+// resolveDirs records the directory each tool was defined in and defaults
+// the root to the project directory
+func (l *Layered) resolveDirs(config *Config) {
+	projectDir, err := filepath.Abs(".")
+	if err != nil {
+		projectDir = "."
+	}
+	if l.Path != "" {
+		if absPath, err := filepath.Abs(l.Path); err == nil {
+			projectDir = filepath.Dir(absPath)
+		}
+	}
+	if config.Root == "" {
+		config.Root = projectDir
+	}
+
+	var tools []*yaml.Node
+	if index := mappingIndex(l.tree, "tools"); index >= 0 {
+		tools = l.tree.Content[index+1].Content
+	}
+	for i := range config.Tools {
+		config.Tools[i].Dir = projectDir
+		if i < len(tools) {
+			if source, ok := l.sources[tools[i]]; ok && tools[i].Line > 0 {
+				if absPath, err := filepath.Abs(source); err == nil {
+					config.Tools[i].Dir = filepath.Dir(absPath)
+				}
+			}
+		}
+	}
+}
+
+// includePatterns returns the include: entries resolved against the including file
+func includePatterns(path string, value *yaml.Node) ([]string, error) {
+	var entries []string
+	switch value.Kind {
+	case yaml.ScalarNode:
+		entries = []string{value.Value}
+	case yaml.SequenceNode:
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("failed to parse config file %s: include entries must be strings (line %d)", path, item.Line)
+			}
+			entries = append(entries, item.Value)
+		}
+	default:
+		return nil, fmt.Errorf("failed to parse config file %s: include must be a string or a list (line %d)", path, value.Line)
+	}
+
+	dir := filepath.Dir(path)
+	for i, entry := range entries {
+		if !filepath.IsAbs(entry) {
+			entries[i] = filepath.Join(dir, entry)
+		}
+	}
+	return entries, nil
+}
+
+// mergeMapping returns dst with src laid over it. Nested mappings are merged,
+// other values replaced; at the top level tools are merged by name.
+func mergeMapping(dst, src *yaml.Node, top bool) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	merged.Content = append(merged.Content, dst.Content...)
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		index := mappingIndex(merged, key.Value)
+		if index < 0 {
+			merged.Content = append(merged.Content, key, value)
+			continue
+		}
+
+		existing := merged.Content[index+1]
+		switch {
+		case top && key.Value == "tools" && existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			merged.Content[index+1] = mergeTools(existing, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			merged.Content[index+1] = mergeMapping(existing, value, false)
+		default:
+			merged.Content[index+1] = value
+		}
+		merged.Content[index] = key
+	}
+	return merged
+}
+
+// mergeTools replaces tools in dst with the tools of the same name in src
+// and appends the rest. Duplicates within src are kept so validation can
+// report them.
+func mergeTools(dst, src *yaml.Node) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	merged.Content = append(merged.Content, dst.Content...)
+
+	replaced := make(map[int]bool)
+	for _, tool := range src.Content {
+		name := toolName(tool)
+		index := -1
+		for i, existing := range dst.Content {
+			if name != "" && !replaced[i] && toolName(existing) == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			merged.Content = append(merged.Content, tool)
+			continue
+		}
+		merged.Content[index] = tool
+		replaced[index] = true
+	}
+	return merged
+}
+
+// toolName returns the name: of a tool node
+func toolName(tool *yaml.Node) string {
+	if tool.Kind != yaml.MappingNode {
+		return ""
+	}
+	if index := mappingIndex(tool, "name"); index >= 0 {
+		return tool.Content[index+1].Value
+	}
+	return ""
+}
+
+// mappingIndex returns the index of key in a mapping node's content, or -1
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyEnv overrides settings from DIZI_* environment variables, e.g.
+// DIZI_SERVER_PORT for server.port
+func (l *Layered) applyEnv(tree *yaml.Node, environ []string) error {
+	fields := envFields()
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		path, known := fields[name]
+		if !known {
+			continue
+		}
+
+		node := tree
+		for _, key := range path[:len(path)-1] {
+			index := mappingIndex(node, key)
+			if index < 0 {
+				child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+				node = child
+				continue
+			}
+			if node.Content[index+1].Kind != yaml.MappingNode {
+				return fmt.Errorf("cannot apply %s: %s is not a mapping", name, key)
+			}
+			node = node.Content[index+1]
+		}
+
+		leaf := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+		l.sources[leaf] = "env " + name
+		key := path[len(path)-1]
+		if index := mappingIndex(node, key); index >= 0 {
+			node.Content[index+1] = leaf
+		} else {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, leaf)
+		}
+	}
+	return nil
+}
+
+// envFields maps environment variable names to the scalar settings they
+// override. Lists such as tools cannot be set from the environment.
+func envFields() map[string][]string {
+	fields := make(map[string][]string)
+	var collect func(t reflect.Type, path []string)
+	collect = func(t reflect.Type, path []string) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := append(append([]string(nil), path...), name)
+			switch field.Type.Kind() {
+			case reflect.Struct:
+				collect(field.Type, fieldPath)
+			case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+			default:
+				fields[envPrefix+strings.ToUpper(strings.Join(fieldPath, "_"))] = fieldPath
+			}
+		}
+	}
+	collect(reflect.TypeOf(Config{}), nil)
+	return fields
+}
+
+// walkNodes calls fn for node and all of its descendants
+func walkNodes(node *yaml.Node, fn func(*yaml.Node)) {
+	fn(node)
+	for _, child := range node.Content {
+		walkNodes(child, fn)
+	}
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile creates a file with content below dir, creating parent directories
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+	return path
+}
+
+// toolNames returns the names of the configured tools in order
+func toolNames(config *Config) []string {
+	var names []string
+	for _, tool := range config.Tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestFindConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", "name: \"root\"\n")
+	nested := filepath.Join(tempDir, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	found, ok := FindConfig(nested)
+	if !ok {
+		t.Fatal("Expected dizi.yml to be found in a parent directory")
+	}
+	if found != configPath {
+		t.Errorf("Expected %s, got %s", configPath, found)
+	}
+}
+
+func TestLoadLayeredUserAndProject(t *testing.T) {
+	tempDir := t.TempDir()
+	userPath := writeFile(t, tempDir, "user/dizi.yml", `name: "user"
+server:
+  port: 7000
+  default_timeout: "1m"
+tools:
+  - name: "status"
+    type: "script"
+    script: "git status"
+  - name: "build"
+    type: "script"
+    script: "make"
+`)
+	projectPath := writeFile(t, tempDir, "project/dizi.yml", `name: "project"
+server:
+  default_timeout: "5m"
+tools:
+  - name: "build"
+    type: "script"
+    script: "make all"
+  - name: "test"
+    type: "script"
+    script: "make test"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Dir: filepath.Dir(projectPath), UserPath: userPath, Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	config := layered.Config
+
+	if config.Name != "project" {
+		t.Errorf("Expected project name to win, got '%s'", config.Name)
+	}
+	if config.Server.Port != 7000 {
+		t.Errorf("Expected port from user config, got %d", config.Server.Port)
+	}
+	if config.Server.DefaultTimeout != 5*time.Minute {
+		t.Errorf("Expected project timeout, got %v", config.Server.DefaultTimeout)
+	}
+	if names := strings.Join(toolNames(config), ","); names != "status,build,test" {
+		t.Errorf("Expected tools status,build,test, got %s", names)
+	}
+	if config.Tools[1].Script != "make all" {
+		t.Errorf("Expected project build tool to replace the user one, got '%s'", config.Tools[1].Script)
+	}
+	if layered.Path != projectPath {
+		t.Errorf("Expected project path %s, got %s", projectPath, layered.Path)
+	}
+}
+
+func TestLoadLayeredIncludes(t *testing.T) {
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "tools/a.yml", `- name: "lint"
+  type: "script"
+  script: "golangci-lint run"
+- name: "build"
+  type: "script"
+  script: "go build"
+`)
+	writeFile(t, tempDir, "tools/b.yml", `server:
+  port: 9100
+tools:
+  - name: "vet"
+    type: "script"
+    script: "go vet"
+`)
+	configPath := writeFile(t, tempDir, "dizi.yml", `include:
+  - "tools/*.yml"
+tools:
+  - name: "build"
+    type: "script"
+    script: "make"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if names := strings.Join(toolNames(layered.Config), ","); names != "lint,build,vet" {
+		t.Errorf("Expected tools lint,build,vet, got %s", names)
+	}
+	if layered.Config.Tools[1].Script != "make" {
+		t.Errorf("Expected including file to override fragments, got '%s'", layered.Config.Tools[1].Script)
+	}
+	if layered.Config.Server.Port != 9100 {
+		t.Errorf("Expected port from fragment, got %d", layered.Config.Server.Port)
+	}
+	if len(layered.Files) != 3 {
+		t.Errorf("Expected 3 files, got %v", layered.Files)
+	}
+}
+
+func TestLoadLayeredIncludeErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	noUser := filepath.Join(tempDir, "none.yml")
+
+	missing := writeFile(t, tempDir, "missing/dizi.yml", "include: \"tools.yml\"\n")
+	if _, err := LoadLayered(LoadOptions{Path: missing, UserPath: noUser}); err == nil {
+		t.Error("Expected error for missing include")
+	}
+
+	writeFile(t, tempDir, "cycle/other.yml", "include: \"dizi.yml\"\n")
+	cycle := writeFile(t, tempDir, "cycle/dizi.yml", "include: \"other.yml\"\n")
+	if _, err := LoadLayered(LoadOptions{Path: cycle, UserPath: noUser}); err == nil {
+		t.Error("Expected error for include cycle")
+	}
+
+	if _, err := LoadLayered(LoadOptions{Path: filepath.Join(tempDir, "absent.yml"), UserPath: noUser}); err == nil {
+		t.Error("Expected error for missing explicit config file")
+	}
+}
+
+func TestLoadLayeredEnvOverrides(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `name: "file"
+server:
+  port: 8000
+`)
+
+	environ := []string{
+		"DIZI_NAME=env",
+		"DIZI_SERVER_PORT=9000",
+		"DIZI_SERVER_DEFAULT_TIMEOUT=30s",
+		"DIZI_UNKNOWN=ignored",
+		"PATH=/usr/bin",
+	}
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: environ})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if layered.Config.Name != "env" {
+		t.Errorf("Expected name 'env', got '%s'", layered.Config.Name)
+	}
+	if layered.Config.Server.Port != 9000 {
+		t.Errorf("Expected port 9000, got %d", layered.Config.Server.Port)
+	}
+	if layered.Config.Server.DefaultTimeout != 30*time.Second {
+		t.Errorf("Expected default timeout 30s, got %v", layered.Config.Server.DefaultTimeout)
+	}
+
+	if _, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_PORT=abc"}}); err == nil {
+		t.Error("Expected error for invalid port override")
+	}
+}
+
+func TestShowOrigins(t *testing.T) {
+	tempDir := t.TempDir()
+	userPath := writeFile(t, tempDir, "user.yml", `description: "from user"
+`)
+	configPath := writeFile(t, tempDir, "dizi.yml", `name: "shown"
+tools:
+  - name: "build"
+    type: "script"
+    script: "make"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: userPath, Environ: []string{"DIZI_SERVER_PORT=9000"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	origins := map[string]string{
+		"name":        displayPath(configPath) + ":1",
+		"description": displayPath(userPath) + ":1",
+		"version":     "default",
+		"server.port": "env DIZI_SERVER_PORT",
+		"tools.build": displayPath(configPath) + ":3",
+	}
+	for path, expected := range origins {
+		if origin := layered.Origin(strings.Split(path, ".")...); origin != expected {
+			t.Errorf("Expected origin of %s to be %s, got %s", path, expected, origin)
+		}
+	}
+
+	output, err := layered.Show()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, line := range []string{
+		"name: shown # " + displayPath(configPath) + ":1",
+		"version: 1.0.0 # default",
+		"port: 9000 # env DIZI_SERVER_PORT",
+		"# from " + displayPath(configPath) + ":3",
+	} {
+		if !strings.Contains(string(output), line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+}
+
+func TestLoadLayeredToolDirs(t *testing.T) {
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "project/tools/lua.yml", `- name: "hello"
+  type: "lua"
+  script: "hello.lua"
+`)
+	configPath := writeFile(t, tempDir, "project/dizi.yml", `include: "tools/*.yml"
+tools:
+  - name: "build"
+    type: "command"
+    command: "make"
+    cwd: "build"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	projectDir := filepath.Join(tempDir, "project")
+	if layered.Config.Root != projectDir {
+		t.Errorf("Expected root %s, got %s", projectDir, layered.Config.Root)
+	}
+	if dir := layered.Config.Tools[0].Dir; dir != filepath.Join(projectDir, "tools") {
+		t.Errorf("Expected included tool dir %s, got %s", filepath.Join(projectDir, "tools"), dir)
+	}
+	if dir := layered.Config.Tools[1].Dir; dir != projectDir {
+		t.Errorf("Expected tool dir %s, got %s", projectDir, dir)
+	}
+
+	// A relative root is resolved against the file that sets it
+	rooted := writeFile(t, tempDir, "rooted/dizi.yml", "root: \"..\"\nserver:\n  fs_roots: [\"docs\", \"/srv/shared\"]\n")
+	layered, err = LoadLayered(LoadOptions{Path: rooted, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if layered.Config.Root != tempDir {
+		t.Errorf("Expected root %s, got %s", tempDir, layered.Config.Root)
+	}
+	if roots := layered.Config.Server.FsRoots; len(roots) != 2 || roots[0] != filepath.Join(tempDir, "rooted", "docs") || roots[1] != "/srv/shared" {
+		t.Errorf("Expected fs_roots relative to the config file, got %v", roots)
+	}
+
+	// cwd makes no sense for tools running inside the server
+	invalid := writeFile(t, tempDir, "invalid/dizi.yml", `tools:
+  - name: "hello"
+    type: "lua"
+    script: "hello.lua"
+    cwd: "src"
+`)
+	if _, err := LoadLayered(LoadOptions{Path: invalid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}}); err == nil || !strings.Contains(err.Error(), "cannot have a cwd") {
+		t.Errorf("Expected cwd validation error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package config provides configuration management for the MCP server.
+// This file defines how a tool's output is turned into its result.
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputModes are the supported values of output.mode
+var OutputModes = []string{"text", "json", "lines", "image", "file"}
+
+// OutputConfig controls how a tool's output becomes the content of its
+// result. It is written either as just the mode or as a mapping:
+//
+//	output: "json"
+//	output:
+//	  mode: "image"
+//	  path: "out/{{name}}.png"
+type OutputConfig struct {
+	Mode     string                 `yaml:"mode,omitempty"`      // text (default), json, lines, image or file
+	Schema   map[string]interface{} `yaml:"schema,omitempty"`    // json: schema the parsed output must match
+	Path     string                 `yaml:"path,omitempty"`      // image/file: file the tool produces, may use {{param}}
+	MimeType string                 `yaml:"mime_type,omitempty"` // image/file: defaults to a guess from the file
+}
+
+// TruncateModes are the supported values of output_limit.truncate
+var TruncateModes = []string{"head", "tail", "both"}
+
+// OutputLimitConfig bounds the text a tool returns to the client. Longer
+// output is truncated and kept on the server to be read with fetch_output.
+type OutputLimitConfig struct {
+	Bytes    int    `yaml:"bytes,omitempty"`    // defaults to 64 KiB; negative means no limit
+	Lines    int    `yaml:"lines,omitempty"`    // 0 or negative means no limit
+	Truncate string `yaml:"truncate,omitempty"` // keep the head, the tail or both (default) of long output
+}
+
+// outputKeys are the keys of an output mapping
+var outputKeys = []string{"mode", "schema", "path", "mime_type"}
+
+// UnmarshalYAML accepts a mode name or a mapping and checks that the
+// settings fit the mode
+func (o *OutputConfig) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			*o = OutputConfig{}
+			return nil
+		}
+		*o = OutputConfig{Mode: node.Value}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; !containsString(outputKeys, key) {
+				return fmt.Errorf("unknown key %q%s, expected one of %s", key, didYouMean(key, outputKeys), strings.Join(outputKeys, ", "))
+			}
+		}
+		type plain OutputConfig
+		var decoded plain
+		if err := node.Decode(&decoded); err != nil {
+			return err
+		}
+		*o = OutputConfig(decoded)
+	default:
+		return fmt.Errorf("must be a mode or a mapping with mode, schema, path and mime_type")
+	}
+
+	switch mode := o.OutputMode(); {
+	case !containsString(OutputModes, mode):
+		return fmt.Errorf("unknown output mode %q%s, expected one of %s", mode, didYouMean(mode, OutputModes), strings.Join(OutputModes, ", "))
+	case (mode == "image" || mode == "file") && o.Path == "":
+		return fmt.Errorf("output mode %q requires a path", mode)
+	case mode != "json" && o.Schema != nil:
+		return fmt.Errorf("schema is only used with output mode \"json\"")
+	}
+	return nil
+}
+
+// MarshalYAML writes an output that only sets the mode as the mode alone
+func (o OutputConfig) MarshalYAML() (interface{}, error) {
+	if o.Schema == nil && o.Path == "" && o.MimeType == "" {
+		return o.Mode, nil
+	}
+	type plain OutputConfig
+	return plain(o), nil
+}
+
+// OutputMode returns the mode, defaulting to text
+func (o OutputConfig) OutputMode() string {
+	if o.Mode == "" {
+		return "text"
+	}
+	return o.Mode
+}
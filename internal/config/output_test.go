@@ -0,0 +1,130 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLayeredOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	noUser := filepath.Join(tempDir, "none.yml")
+	configPath := writeFile(t, tempDir, "ok/dizi.yml", `tools:
+  - name: "list"
+    type: "script"
+    script: "ls"
+    output: "lines"
+  - name: "plot"
+    type: "script"
+    script: "plot.sh"
+    output:
+      mode: "image"
+      path: "out/{{name}}.png"
+  - name: "stats"
+    type: "script"
+    script: "stats.sh"
+    output:
+      mode: "json"
+      schema:
+        type: "object"
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: noUser, Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tools := layered.Config.Tools
+	if tools[0].Output.Mode != "lines" {
+		t.Errorf("Expected lines mode, got '%s'", tools[0].Output.Mode)
+	}
+	if tools[1].Output.Mode != "image" || tools[1].Output.Path != "out/{{name}}.png" {
+		t.Errorf("Expected image output, got %+v", tools[1].Output)
+	}
+	if tools[2].Output.Schema["type"] != "object" {
+		t.Errorf("Expected output schema, got %+v", tools[2].Output)
+	}
+
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{"unknown mode", `"jsno"`, `unknown output mode "jsno" (did you mean "json"?)`},
+		{"image without path", `"image"`, `output mode "image" requires a path`},
+		{"schema without json", "\n      mode: \"text\"\n      schema:\n        type: \"object\"", `schema is only used with output mode "json"`},
+		{"unknown key", "\n      mode: \"json\"\n      shema: {}", `unknown key "shema" (did you mean "schema"?)`},
+		{"invalid schema", "\n      mode: \"json\"\n      schema:\n        type: \"strng\"", `output.schema.type: unknown type "strng"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "dizi.yml", `tools:
+  - name: "bad"
+    type: "script"
+    script: "true"
+    output: `+tt.output+"\n")
+			_, err := LoadLayered(LoadOptions{Path: path, UserPath: noUser, Environ: []string{}})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateExitCodeSettings(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `tools:
+  - name: "search"
+    type: "command"
+    command: "grep"
+    success_exit_codes: [0, 1]
+  - name: "hello"
+    type: "lua"
+    script: "hello.lua"
+    ignore_exit_code: true
+    async: true
+    stdin: "yes"
+`)
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err == nil || !strings.Contains(err.Error(), `:9:5: lua tool "hello" has no exit code`) {
+		t.Errorf("Expected exit code validation error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), `:10:5: lua tool "hello" runs inside the server and cannot be async`) {
+		t.Errorf("Expected async validation error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), `:11:12: lua tool "hello" runs inside the server and cannot have stdin`) {
+		t.Errorf("Expected stdin validation error, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "search") {
+		t.Errorf("Expected success_exit_codes to be accepted for command tools, got %v", err)
+	}
+}
+
+func TestValidateOutputLimit(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `server:
+  output_limit:
+    bytes: 10000
+    truncate: "tial"
+tools:
+  - name: "fetch_output"
+    type: "script"
+    script: "cat log"
+    output_limit:
+      lines: 200
+      truncate: "head"
+`)
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, expected := range []string{
+		`:4:15: unknown truncate mode "tial" (did you mean "tail"?)`,
+		`:6:11: tool name "fetch_output" is reserved`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
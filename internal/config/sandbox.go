@@ -0,0 +1,81 @@
+// Package config provides configuration management for the MCP server.
+// This file defines the per-tool sandbox settings.
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SandboxConfig restricts what a tool may do on the machine. It is written
+// either as true for the defaults or as a mapping:
+//
+//	sandbox: true
+//	sandbox:
+//	  writable: ["build"]
+//	  cpu: "30s"
+//	  memory_mb: 512
+type SandboxConfig struct {
+	Enabled   bool          `yaml:"enabled,omitempty"`
+	Network   bool          `yaml:"network,omitempty"`   // keep network access, off by default
+	Writable  []string      `yaml:"writable,omitempty"`  // paths besides the temp dir that stay writable, relative to the config file
+	CPU       time.Duration `yaml:"cpu,omitempty"`       // CPU time per process; 0 means no limit
+	MemoryMB  int           `yaml:"memory_mb,omitempty"` // address space per process; 0 means no limit
+	Processes int           `yaml:"processes,omitempty"` // processes of the user; 0 means no limit
+}
+
+// sandboxKeys are the keys of a sandbox mapping
+var sandboxKeys = []string{"enabled", "network", "writable", "cpu", "memory_mb", "processes"}
+
+// UnmarshalYAML accepts true, false or a mapping, which enables the sandbox
+// unless it sets enabled to false
+func (s *SandboxConfig) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			*s = SandboxConfig{}
+			return nil
+		}
+		var enabled bool
+		if err := node.Decode(&enabled); err != nil {
+			return fmt.Errorf("must be true, false or a mapping with %s", strings.Join(sandboxKeys, ", "))
+		}
+		*s = SandboxConfig{Enabled: enabled}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; !containsString(sandboxKeys, key) {
+				return fmt.Errorf("unknown key %q%s, expected one of %s", key, didYouMean(key, sandboxKeys), strings.Join(sandboxKeys, ", "))
+			}
+		}
+		type plain SandboxConfig
+		decoded := plain{Enabled: true}
+		if err := node.Decode(&decoded); err != nil {
+			return err
+		}
+		*s = SandboxConfig(decoded)
+	default:
+		return fmt.Errorf("must be true, false or a mapping with %s", strings.Join(sandboxKeys, ", "))
+	}
+
+	switch {
+	case s.CPU < 0:
+		return fmt.Errorf("cpu must not be negative")
+	case s.MemoryMB < 0:
+		return fmt.Errorf("memory_mb must not be negative")
+	case s.Processes < 0:
+		return fmt.Errorf("processes must not be negative")
+	}
+	return nil
+}
+
+// MarshalYAML writes a sandbox that only enables the defaults as true
+func (s SandboxConfig) MarshalYAML() (interface{}, error) {
+	if !s.Enabled || (!s.Network && s.Writable == nil && s.CPU == 0 && s.MemoryMB == 0 && s.Processes == 0) {
+		return s.Enabled, nil
+	}
+	type plain SandboxConfig
+	return plain(s), nil
+}
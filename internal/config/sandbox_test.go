@@ -0,0 +1,66 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLayeredSandbox(t *testing.T) {
+	tempDir := t.TempDir()
+	noUser := filepath.Join(tempDir, "none.yml")
+	configPath := writeFile(t, tempDir, "ok/dizi.yml", `tools:
+  - name: "test"
+    type: "script"
+    script: "make test"
+    sandbox: true
+  - name: "build"
+    type: "script"
+    script: "make"
+    sandbox:
+      writable: ["build"]
+      cpu: "30s"
+      memory_mb: 512
+  - name: "fetch"
+    type: "command"
+    command: "curl"
+    sandbox:
+      enabled: false
+      network: true
+`)
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: noUser, Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tools := layered.Config.Tools
+	if !tools[0].Sandbox.Enabled || tools[0].Sandbox.Network {
+		t.Errorf("Expected the default sandbox, got %+v", tools[0].Sandbox)
+	}
+	if sandbox := tools[1].Sandbox; !sandbox.Enabled || sandbox.CPU != 30*time.Second || sandbox.MemoryMB != 512 || sandbox.Writable[0] != "build" {
+		t.Errorf("Expected a mapping to enable the sandbox with its settings, got %+v", sandbox)
+	}
+	if tools[2].Sandbox.Enabled {
+		t.Errorf("Expected enabled: false to turn the sandbox off, got %+v", tools[2].Sandbox)
+	}
+
+	tests := []struct {
+		name     string
+		tool     string
+		expected string
+	}{
+		{"unknown key", "type: \"script\"\n    script: \"true\"\n    sandbox:\n      netwrok: true", `unknown key "netwrok" (did you mean "network"?)`},
+		{"negative limit", "type: \"script\"\n    script: \"true\"\n    sandbox:\n      memory_mb: -1", "memory_mb must not be negative"},
+		{"builtin", "type: \"builtin\"\n    sandbox: true", `builtin tool "bad" runs inside the server and cannot be sandboxed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "dizi.yml", "tools:\n  - name: \"bad\"\n    "+tt.tool+"\n")
+			_, err := LoadLayered(LoadOptions{Path: path, UserPath: noUser, Environ: []string{}})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package config provides configuration management for the MCP server.
+// This file renders the effective configuration annotated with the origin of each value.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Origin returns where the value at path came from, e.g. "dizi.yml:12",
+// "env DIZI_SERVER_PORT" or "default". Tools are addressed by name:
+// Origin("tools", "build", "timeout").
+func (l *Layered) Origin(path ...string) string {
+	node := l.tree
+	for i := 0; i < len(path); i++ {
+		if node == nil {
+			return "default"
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			index := mappingIndex(node, path[i])
+			if index < 0 {
+				return "default"
+			}
+			node = node.Content[index+1]
+		case yaml.SequenceNode:
+			var found *yaml.Node
+			for _, item := range node.Content {
+				if toolName(item) == path[i] {
+					found = item
+					break
+				}
+			}
+			node = found
+		default:
+			return "default"
+		}
+	}
+	if node == nil {
+		return "default"
+	}
+	return l.origin(node)
+}
+
+// origin formats the source of a node
+func (l *Layered) origin(node *yaml.Node) string {
+	source, ok := l.sources[node]
+	if !ok {
+		return "default"
+	}
+	if node.Line == 0 {
+		return source // environment variables have no position
+	}
+	return fmt.Sprintf("%s:%d", displayPath(source), node.Line)
+}
+
+// Show renders the effective configuration as YAML with the origin of each
+// value as a trailing comment
+func (l *Layered) Show() ([]byte, error) {
+	var effective yaml.Node
+	if err := effective.Encode(l.Config); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	l.annotate(&effective, l.tree)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&effective); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// annotate adds origin comments to the effective config from the merged tree.
+// Tools are annotated as a whole since they are only ever replaced as a whole.
+func (l *Layered) annotate(effective, merged *yaml.Node) {
+	switch effective.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(effective.Content); i += 2 {
+			key, value := effective.Content[i], effective.Content[i+1]
+
+			var source *yaml.Node
+			if merged != nil && merged.Kind == yaml.MappingNode {
+				if index := mappingIndex(merged, key.Value); index >= 0 {
+					source = merged.Content[index+1]
+				}
+			}
+
+			if key.Value == "tools" && value.Kind == yaml.SequenceNode && merged == l.tree {
+				for j, tool := range value.Content {
+					if source != nil && j < len(source.Content) {
+						tool.HeadComment = "from " + l.origin(source.Content[j])
+					}
+				}
+				continue
+			}
+			if value.Kind == yaml.ScalarNode {
+				if source == nil {
+					key.LineComment = "default"
+				} else {
+					key.LineComment = l.origin(source)
+				}
+				continue
+			}
+			l.annotate(value, source)
+		}
+	case yaml.SequenceNode:
+		for _, item := range effective.Content {
+			l.annotate(item, nil)
+		}
+	}
+}
+
+// displayPath shortens path relative to the working directory when possible
+func displayPath(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if wd, err := filepath.Abs("."); err == nil {
+		if rel, err := filepath.Rel(wd, absPath); err == nil && !filepath.IsAbs(rel) && !startsWithDotDot(rel) {
+			return rel
+		}
+	}
+	return absPath
+}
+
+// startsWithDotDot reports whether a relative path leaves its base directory
+func startsWithDotDot(rel string) bool {
+	return rel == ".." || len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator)
+}
@@ -0,0 +1,669 @@
+// Package config provides configuration management for the MCP server.
+// This file validates the merged configuration and reports problems with their position.
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dizi/internal/policy"
+	"dizi/internal/schema"
+
+	"github.com/gobwas/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// ToolTypes are the supported values of a tool's type
+var ToolTypes = []string{"command", "script", "lua", "builtin"}
+
+// ShellEnvModes are the supported values of server.shell_env
+var ShellEnvModes = []string{"login-env", "cached-env", "clean-env"}
+
+// FilesystemTools are the tools registered by -fs-tools
+var FilesystemTools = []string{"list_project_files", "read_project_file", "write_project_file", "edit_project_file", "grep_project_files"}
+
+// FetchOutputTool is the name of the builtin tool that pages through truncated output
+const FetchOutputTool = "fetch_output"
+
+// ReservedToolNames are the builtin tools a server provides when its tools
+// need them, which configured tools cannot use
+var ReservedToolNames = []string{FetchOutputTool, "job_status", "job_output", "job_wait", "job_cancel", "job_list", "job_write_stdin"}
+
+// Tags of the tools dizi provides itself, for selecting them in profiles
+const (
+	TagFilesystem = "fs"       // the filesystem tools
+	TagReadOnly   = "readonly" // the filesystem tools that don't change files
+	TagBuiltin    = "builtin"  // fetch_output and the job_* tools
+)
+
+// ReadOnlyFilesystemTools are the filesystem tools that don't change files
+var ReadOnlyFilesystemTools = []string{"list_project_files", "read_project_file", "grep_project_files"}
+
+// BuiltinTags returns the tags of a tool dizi provides itself, none for
+// other tools
+func BuiltinTags(name string) []string {
+	switch {
+	case containsString(ReadOnlyFilesystemTools, name):
+		return []string{TagFilesystem, TagReadOnly}
+	case containsString(FilesystemTools, name):
+		return []string{TagFilesystem}
+	case containsString(ReservedToolNames, name):
+		return []string{TagBuiltin}
+	}
+	return nil
+}
+
+// Problem is a configuration error at a position in a config file
+type Problem struct {
+	File    string `json:"file"` // config file, or "env NAME" for environment overrides
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats the problem as "file:line:column: message"
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// ValidationError reports every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error lists the problems one per line
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = "  " + problem.String()
+	}
+	return fmt.Sprintf("invalid config (%d problems):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// validator collects problems while walking the merged configuration tree
+type validator struct {
+	layered  *Layered
+	problems []Problem
+}
+
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// validate checks the merged tree against the Config structure: unknown keys,
+// values of the wrong type and tool definitions that cannot be registered
+func (l *Layered) validate() []Problem {
+	v := &validator{layered: l}
+	v.checkFields(l.tree, reflect.TypeOf(Config{}))
+	if index := mappingIndex(l.tree, "tools"); index >= 0 && l.tree.Content[index+1].Kind == yaml.SequenceNode {
+		v.checkTools(l.tree.Content[index+1])
+	}
+	if index := mappingIndex(l.tree, "server"); index >= 0 && l.tree.Content[index+1].Kind == yaml.MappingNode {
+		v.checkOutputLimit(l.tree.Content[index+1])
+		v.checkShellEnv(l.tree.Content[index+1])
+		v.checkApproval(l.tree.Content[index+1])
+		v.checkAuth(l.tree.Content[index+1])
+		v.checkListen(l.tree.Content[index+1])
+	}
+	v.checkProfiles(l.tree)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.problems
+}
+
+// report records a problem at node
+func (v *validator) report(node *yaml.Node, format string, args ...interface{}) {
+	problem := Problem{Message: fmt.Sprintf(format, args...)}
+	if source, ok := v.layered.sources[node]; ok {
+		problem.File = source
+		if node.Line > 0 {
+			problem.File = displayPath(source)
+			problem.Line = node.Line
+			problem.Column = node.Column
+		}
+	} else {
+		problem.File = "default"
+	}
+	v.problems = append(v.problems, problem)
+}
+
+// position formats where node is defined, for problems that refer to another node
+func (v *validator) position(node *yaml.Node) string {
+	return v.layered.origin(node)
+}
+
+// checkFields checks a mapping node against the yaml fields of struct type t
+func (v *validator) checkFields(node *yaml.Node, t reflect.Type) {
+	fields := make(map[string]reflect.Type)
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = t.Field(i).Type
+		names = append(names, name)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		fieldType, ok := fields[key.Value]
+		if !ok {
+			v.report(key, "unknown key %q%s", key.Value, didYouMean(key.Value, names))
+			continue
+		}
+		v.checkValue(key.Value, value, fieldType)
+	}
+}
+
+// checkValue checks that node can be decoded into a value of type t
+func (v *validator) checkValue(name string, node *yaml.Node, t reflect.Type) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return // Same as leaving the key out
+	}
+
+	// Types with their own YAML syntax know best what they accept
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.report(node, "%s: %v", name, err)
+		}
+		return
+	}
+
+	if t == durationType {
+		if node.Kind != yaml.ScalarNode {
+			v.report(node, "%s must be a duration such as \"30s\" or \"5m\", got %s", name, describeNode(node))
+		} else if _, err := time.ParseDuration(node.Value); err != nil {
+			v.report(node, "%s must be a duration such as \"30s\" or \"5m\", got %q", name, node.Value)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.report(node, "%s must be a mapping, got %s", name, describeNode(node))
+			return
+		}
+		v.checkFields(node, t)
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.report(node, "%s must be a list, got %s", name, describeNode(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.checkValue(fmt.Sprintf("%s[%d]", name, i), item, t.Elem())
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.report(node, "%s must be a mapping, got %s", name, describeNode(node))
+			return
+		}
+		if t.Elem().Kind() != reflect.Interface {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				v.checkValue(name+"."+node.Content[i].Value, node.Content[i+1], t.Elem())
+			}
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			v.report(node, "%s must be a string, got %s", name, describeNode(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if node.Kind != yaml.ScalarNode || node.Decode(&n) != nil {
+			v.report(node, "%s must be an integer, got %s", name, describeNode(node))
+		}
+	case reflect.Bool:
+		var b bool
+		if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+			v.report(node, "%s must be true or false, got %s", name, describeNode(node))
+		}
+	}
+}
+
+// checkTools checks the tool definitions for problems that would only show
+// up when registering or calling them
+func (v *validator) checkTools(tools *yaml.Node) {
+	seen := make(map[string]*yaml.Node)
+	for _, tool := range tools.Content {
+		if tool.Kind != yaml.MappingNode {
+			continue // Reported by checkValue
+		}
+		field := func(key string) *yaml.Node {
+			if index := mappingIndex(tool, key); index >= 0 {
+				return tool.Content[index+1]
+			}
+			return nil
+		}
+
+		nameNode := field("name")
+		name := ""
+		if nameNode == nil || nameNode.Value == "" {
+			v.report(tool, "tool is missing a name")
+		} else {
+			name = nameNode.Value
+			if previous, ok := seen[name]; ok {
+				v.report(nameNode, "duplicate tool name %q, first defined at %s", name, v.position(previous))
+			} else {
+				seen[name] = nameNode
+			}
+			if containsString(ReservedToolNames, name) {
+				v.report(nameNode, "tool name %q is reserved for a builtin tool", name)
+			}
+		}
+		label := strconv.Quote(name)
+		if name == "" {
+			label = "without a name"
+		}
+
+		typeNode := field("type")
+		switch {
+		case typeNode == nil || typeNode.Value == "":
+			v.report(tool, "tool %s is missing a type, expected one of %s", label, strings.Join(ToolTypes, ", "))
+		case !containsString(ToolTypes, typeNode.Value):
+			v.report(typeNode, "unknown tool type %q%s, expected one of %s",
+				typeNode.Value, didYouMean(typeNode.Value, ToolTypes), strings.Join(ToolTypes, ", "))
+		default:
+			required := map[string]string{"command": "command", "script": "script", "lua": "script"}[typeNode.Value]
+			if required != "" {
+				if node := field(required); node == nil || node.Value == "" {
+					v.report(typeNode, "%s tool %s requires %q", typeNode.Value, label, required)
+				}
+			}
+		}
+
+		if typeNode != nil && (typeNode.Value == "lua" || typeNode.Value == "builtin") {
+			if cwd := field("cwd"); cwd != nil {
+				v.report(cwd, "%s tool %s runs inside the server and cannot have a cwd", typeNode.Value, label)
+			}
+			if stdin := field("stdin"); stdin != nil {
+				v.report(stdin, "%s tool %s runs inside the server and cannot have stdin", typeNode.Value, label)
+			}
+			for _, key := range []string{"success_exit_codes", "ignore_exit_code"} {
+				if index := mappingIndex(tool, key); index >= 0 {
+					v.report(tool.Content[index], "%s tool %s has no exit code, %s only applies to command and script tools", typeNode.Value, label, key)
+				}
+			}
+			if index := mappingIndex(tool, "async"); index >= 0 {
+				v.report(tool.Content[index], "%s tool %s runs inside the server and cannot be async", typeNode.Value, label)
+			}
+		}
+		if typeNode != nil && typeNode.Value == "builtin" {
+			if index := mappingIndex(tool, "sandbox"); index >= 0 {
+				v.report(tool.Content[index], "builtin tool %s runs inside the server and cannot be sandboxed", label)
+			}
+		}
+		if policy := field("policy"); policy != nil {
+			if typeNode != nil && (typeNode.Value == "lua" || (typeNode.Value == "builtin" && name != "shell_session")) {
+				v.report(tool.Content[mappingIndex(tool, "policy")], "%s tool %s runs no shell commands, policy only applies to command, script and shell_session tools", typeNode.Value, label)
+			}
+			v.checkPolicy(policy)
+		}
+
+		if parameters := field("parameters"); parameters != nil && parameters.Kind == yaml.MappingNode {
+			v.checkParameters(parameters)
+		}
+		v.checkOutputLimit(tool)
+		if output := field("output"); output != nil && output.Kind == yaml.MappingNode {
+			if index := mappingIndex(output, "schema"); index >= 0 && output.Content[index+1].Kind == yaml.MappingNode {
+				v.checkSchema("output.schema", output.Content[index+1])
+			}
+		}
+	}
+}
+
+// checkPolicy checks that the rules of a policy compile
+func (v *validator) checkPolicy(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return // Reported by checkValue
+	}
+	for _, key := range []string{"allow", "deny"} {
+		index := mappingIndex(node, key)
+		if index < 0 || node.Content[index+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range node.Content[index+1].Content {
+			if item.Kind != yaml.ScalarNode {
+				continue
+			}
+			if _, err := policy.Compile([]string{item.Value}, nil); err != nil {
+				v.report(item, "policy.%s: %v", key, err)
+			}
+		}
+	}
+}
+
+// checkOutputLimit checks the truncate mode of the output_limit in parent
+func (v *validator) checkOutputLimit(parent *yaml.Node) {
+	index := mappingIndex(parent, "output_limit")
+	if index < 0 || parent.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	limit := parent.Content[index+1]
+	if index := mappingIndex(limit, "truncate"); index >= 0 {
+		node := limit.Content[index+1]
+		if node.Kind == yaml.ScalarNode && node.Value != "" && !containsString(TruncateModes, node.Value) {
+			v.report(node, "unknown truncate mode %q%s, expected one of %s",
+				node.Value, didYouMean(node.Value, TruncateModes), strings.Join(TruncateModes, ", "))
+		}
+	}
+}
+
+// checkShellEnv checks the shell_env mode of the server settings
+func (v *validator) checkShellEnv(server *yaml.Node) {
+	index := mappingIndex(server, "shell_env")
+	if index < 0 {
+		return
+	}
+	node := server.Content[index+1]
+	if node.Kind == yaml.ScalarNode && node.Value != "" && !containsString(ShellEnvModes, node.Value) {
+		v.report(node, "unknown shell_env mode %q%s, expected one of %s",
+			node.Value, didYouMean(node.Value, ShellEnvModes), strings.Join(ShellEnvModes, ", "))
+	}
+}
+
+// checkApproval checks that server.approval.confirm names filesystem tools
+func (v *validator) checkApproval(server *yaml.Node) {
+	index := mappingIndex(server, "approval")
+	if index < 0 || server.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	approval := server.Content[index+1]
+	index = mappingIndex(approval, "confirm")
+	if index < 0 || approval.Content[index+1].Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range approval.Content[index+1].Content {
+		if item.Kind == yaml.ScalarNode && !containsString(FilesystemTools, item.Value) {
+			v.report(item, "unknown filesystem tool %q%s, expected one of %s; configured tools use confirm: true instead",
+				item.Value, didYouMean(item.Value, FilesystemTools), strings.Join(FilesystemTools, ", "))
+		}
+	}
+}
+
+// checkAuth checks that static tokens are named, distinct and have valid
+// tool patterns, and that JWT settings come with a JWKS file
+func (v *validator) checkAuth(server *yaml.Node) {
+	index := mappingIndex(server, "auth")
+	if index < 0 || server.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	auth := server.Content[index+1]
+
+	if index := mappingIndex(auth, "tokens"); index >= 0 && auth.Content[index+1].Kind == yaml.SequenceNode {
+		names := make(map[string]*yaml.Node)
+		for _, token := range auth.Content[index+1].Content {
+			if token.Kind != yaml.MappingNode {
+				continue
+			}
+			for _, key := range []string{"name", "token"} {
+				if index := mappingIndex(token, key); index < 0 || token.Content[index+1].Value == "" {
+					v.report(token, "auth token is missing %s", key)
+				}
+			}
+			if index := mappingIndex(token, "name"); index >= 0 {
+				name := token.Content[index+1]
+				if first, ok := names[name.Value]; ok && name.Value != "" {
+					v.report(name, "duplicate auth token %q, first defined at %s", name.Value, v.position(first))
+				}
+				names[name.Value] = name
+			}
+			if index := mappingIndex(token, "tools"); index >= 0 && token.Content[index+1].Kind == yaml.SequenceNode {
+				for _, pattern := range token.Content[index+1].Content {
+					if _, err := glob.Compile(pattern.Value); err != nil {
+						v.report(pattern, "invalid tool pattern %q: %v", pattern.Value, err)
+					}
+				}
+			}
+		}
+	}
+
+	if index := mappingIndex(auth, "jwt"); index >= 0 && auth.Content[index+1].Kind == yaml.MappingNode {
+		jwt := auth.Content[index+1]
+		if index := mappingIndex(jwt, "jwks"); (index < 0 || jwt.Content[index+1].Value == "") && len(jwt.Content) > 0 {
+			v.report(jwt, "auth.jwt needs a jwks file to verify tokens")
+		}
+	}
+}
+
+// checkListen checks the listen addresses and that TLS settings name both a
+// certificate and its key
+func (v *validator) checkListen(server *yaml.Node) {
+	addresses := []*yaml.Node{}
+	if index := mappingIndex(server, "listen"); index >= 0 {
+		addresses = append(addresses, server.Content[index+1])
+	}
+	if index := mappingIndex(server, "approval"); index >= 0 && server.Content[index+1].Kind == yaml.MappingNode {
+		approval := server.Content[index+1]
+		if index := mappingIndex(approval, "listen"); index >= 0 {
+			addresses = append(addresses, approval.Content[index+1])
+		}
+	}
+	for _, node := range addresses {
+		if node.Kind != yaml.ScalarNode || node.Value == "" {
+			continue
+		}
+		if path, ok := strings.CutPrefix(node.Value, UnixPrefix); ok {
+			if path == "" {
+				v.report(node, "listen address %q is missing the socket path", node.Value)
+			}
+			continue
+		}
+		if _, port, err := net.SplitHostPort(node.Value); err != nil {
+			v.report(node, "listen address must be host:port or unix:/path/to.sock, got %q", node.Value)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			v.report(node, "listen address %q has an invalid port", node.Value)
+		}
+	}
+
+	index := mappingIndex(server, "tls")
+	if index < 0 || server.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	tls := server.Content[index+1]
+	value := func(key string) string {
+		if index := mappingIndex(tls, key); index >= 0 {
+			return tls.Content[index+1].Value
+		}
+		return ""
+	}
+	selfSigned, _ := strconv.ParseBool(value("self_signed"))
+	switch {
+	case (value("cert") == "") != (value("key") == ""):
+		v.report(tls, "tls needs both cert and key")
+	case value("client_ca") != "" && value("cert") == "" && !selfSigned:
+		v.report(tls, "tls.client_ca needs a server certificate, set cert and key or self_signed: true")
+	}
+}
+
+// checkProfiles checks that profiles select tools with valid patterns and
+// known tags, and that auth tokens name existing profiles
+func (v *validator) checkProfiles(root *yaml.Node) {
+	tags := []string{TagFilesystem, TagReadOnly, TagBuiltin}
+	if index := mappingIndex(root, "tools"); index >= 0 && root.Content[index+1].Kind == yaml.SequenceNode {
+		for _, tool := range root.Content[index+1].Content {
+			if index := mappingIndex(tool, "tags"); index >= 0 {
+				for _, tag := range tool.Content[index+1].Content {
+					if !containsString(tags, tag.Value) {
+						tags = append(tags, tag.Value)
+					}
+				}
+			}
+		}
+	}
+
+	var names []string
+	if index := mappingIndex(root, "profiles"); index >= 0 && root.Content[index+1].Kind == yaml.MappingNode {
+		profiles := root.Content[index+1]
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			name, profile := profiles.Content[i], profiles.Content[i+1]
+			names = append(names, name.Value)
+			if profile.Kind != yaml.MappingNode {
+				continue
+			}
+			if mappingIndex(profile, "tools") < 0 && mappingIndex(profile, "tags") < 0 {
+				v.report(name, "profile %q includes no tools, set tools or tags", name.Value)
+			}
+			for _, key := range []string{"tools", "exclude"} {
+				if index := mappingIndex(profile, key); index >= 0 && profile.Content[index+1].Kind == yaml.SequenceNode {
+					for _, pattern := range profile.Content[index+1].Content {
+						if _, err := glob.Compile(pattern.Value); err != nil {
+							v.report(pattern, "invalid tool pattern %q: %v", pattern.Value, err)
+						}
+					}
+				}
+			}
+			if index := mappingIndex(profile, "tags"); index >= 0 && profile.Content[index+1].Kind == yaml.SequenceNode {
+				for _, tag := range profile.Content[index+1].Content {
+					if !containsString(tags, tag.Value) {
+						v.report(tag, "unknown tag %q, no tool has it%s", tag.Value, didYouMean(tag.Value, tags))
+					}
+				}
+			}
+		}
+	}
+
+	index := mappingIndex(root, "server")
+	if index < 0 || root.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	server := root.Content[index+1]
+	if index = mappingIndex(server, "auth"); index < 0 || server.Content[index+1].Kind != yaml.MappingNode {
+		return
+	}
+	auth := server.Content[index+1]
+	if index = mappingIndex(auth, "tokens"); index < 0 || auth.Content[index+1].Kind != yaml.SequenceNode {
+		return
+	}
+	for _, token := range auth.Content[index+1].Content {
+		if index := mappingIndex(token, "profile"); index >= 0 {
+			profile := token.Content[index+1]
+			if profile.Value != "" && !containsString(names, profile.Value) {
+				v.report(profile, "unknown profile %q%s", profile.Value, didYouMean(profile.Value, names))
+			}
+		}
+	}
+}
+
+// checkParameters checks that a tool's parameters are a valid JSON schema
+func (v *validator) checkParameters(parameters *yaml.Node) {
+	s := v.checkSchema("parameters", parameters)
+	if types := schema.StringList(s["type"]); len(types) > 0 && !(len(types) == 1 && types[0] == "object") {
+		v.report(schemaNode(parameters, "type"), "parameters must describe an object (type: object)")
+	}
+}
+
+// checkSchema checks that node is a valid JSON schema and returns it decoded
+func (v *validator) checkSchema(name string, node *yaml.Node) map[string]interface{} {
+	var s map[string]interface{}
+	if err := node.Decode(&s); err != nil {
+		v.report(node, "%s must be a JSON schema mapping: %v", name, err)
+		return nil
+	}
+	for _, violation := range schema.Check(s) {
+		v.report(schemaNode(node, violation.Path), "%s.%s", name, violation.String())
+	}
+	return s
+}
+
+// schemaNode finds the node addressed by a schema violation path such as
+// "properties.board.type" or "required[1]", or the closest ancestor found
+func schemaNode(node *yaml.Node, path string) *yaml.Node {
+	for _, segment := range strings.Split(path, ".") {
+		key, index := segment, -1
+		if open := strings.Index(segment, "["); open >= 0 && strings.HasSuffix(segment, "]") {
+			key = segment[:open]
+			index, _ = strconv.Atoi(segment[open+1 : len(segment)-1])
+		}
+
+		if node.Kind != yaml.MappingNode {
+			return node
+		}
+		i := mappingIndex(node, key)
+		if i < 0 {
+			return node
+		}
+		node = node.Content[i+1]
+
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return node
+			}
+			node = node.Content[index]
+		}
+	}
+	return node
+}
+
+// describeNode renders a node's value for error messages
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return strconv.Quote(node.Value)
+}
+
+// didYouMean suggests the candidate closest to word, if any is close enough
+func didYouMean(word string, candidates []string) string {
+	best, bestDistance := "", len(word)/2+1
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(word), candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b,
+// counting a swap of adjacent characters as one edit
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `name: "broken"
+server:
+  port: "eighty"
+  default_timout: "1m"
+tools:
+  - name: "build"
+    type: "scirpt"
+  - name: "build"
+    type: "script"
+    script: "make"
+    timeout: "soon"
+  - name: "run"
+    type: "lua"
+  - name: "call"
+    type: "command"
+    command: "curl"
+    parameters:
+      type: "object"
+      properties:
+        url:
+          type: "strng"
+        retries:
+          type: "integer"
+          default: "three"
+      required: "url"
+`)
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+
+	file := displayPath(configPath)
+	expected := []string{
+		file + `:3:9: port must be an integer, got "eighty"`,
+		file + `:4:3: unknown key "default_timout" (did you mean "default_timeout"?)`,
+		file + `:7:11: unknown tool type "scirpt" (did you mean "script"?), expected one of command, script, lua, builtin`,
+		file + `:8:11: duplicate tool name "build", first defined at ` + file + `:6`,
+		file + `:11:14: timeout must be a duration such as "30s" or "5m", got "soon"`,
+		file + `:13:11: lua tool "run" requires "script"`,
+		file + `:24:20: parameters.properties.retries.default: must be of type integer, got string`,
+		file + `:25:17: parameters.required: must be a list of property names`,
+		file + `:21:17: parameters.properties.url.type: unknown type "strng", expected one of string, number, integer, boolean, object, array, null`,
+	}
+
+	var got []string
+	for _, problem := range validationErr.Problems {
+		got = append(got, problem.String())
+	}
+	for _, want := range expected {
+		found := false
+		for _, line := range got {
+			if line == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected problem %q, got:\n%s", want, strings.Join(got, "\n"))
+		}
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(got), strings.Join(got, "\n"))
+	}
+}
+
+func TestValidateEnvOverride(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", "name: \"env\"\n")
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_DEFAULT_TIMEOUT=forever"}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	if len(validationErr.Problems) != 1 || validationErr.Problems[0].File != "env DIZI_SERVER_DEFAULT_TIMEOUT" {
+		t.Errorf("Expected problem attributed to the environment variable, got %v", validationErr.Problems)
+	}
+}
+
+func TestValidateShellEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", "name: \"env\"\n")
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_SHELL_ENV=cached-evn"}})
+	if err == nil || !strings.Contains(err.Error(), `unknown shell_env mode "cached-evn" (did you mean "cached-env"?)`) {
+		t.Errorf("Expected shell_env validation error, got %v", err)
+	}
+
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_SHELL_ENV=clean-env"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if layered.Config.Server.ShellEnv != "clean-env" {
+		t.Errorf("Expected shell_env from the environment, got %q", layered.Config.Server.ShellEnv)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `tools:
+  - name: "shell_eval"
+    type: "script"
+    script: "{{command|raw}}"
+    policy:
+      allow: ["git", "make"]
+      deny: ["git push --force", "git [push"]
+  - name: "shell_session"
+    type: "builtin"
+    policy:
+      deny: ["rm -rf /"]
+  - name: "hello"
+    type: "lua"
+    script: "hello.lua"
+    policy:
+      deny: ["rm"]
+`)
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, expected := range []string{
+		`:7:34: policy.deny: invalid rule "git [push"`,
+		`:15:5: lua tool "hello" runs no shell commands, policy only applies to command, script and shell_session tools`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), `builtin tool "shell_session"`) {
+		t.Errorf("Expected policy to be accepted for shell_session, got %v", err)
+	}
+}
+
+func TestValidateApproval(t *testing.T) {
+	tempDir := t.TempDir()
+	const configText = `server:
+  approval:
+    timeout: "30s"
+    log: "logs/approvals.jsonl"
+    confirm: ["write_project_file"%s]
+tools:
+  - name: "deploy"
+    type: "command"
+    command: "make deploy"
+    confirm: true
+`
+	configPath := writeFile(t, tempDir, "dizi.yml", fmt.Sprintf(configText, `, "edit_projetc_file"`))
+
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err == nil || !strings.Contains(err.Error(), `:5:37: unknown filesystem tool "edit_projetc_file" (did you mean "edit_project_file"?)`) {
+		t.Errorf("Expected approval.confirm validation error, got %v", err)
+	}
+
+	configPath = writeFile(t, tempDir, "dizi.yml", fmt.Sprintf(configText, ""))
+	layered, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_APPROVAL_TIMEOUT=2m"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	approval := layered.Config.Server.Approval
+	if approval.Timeout != 2*time.Minute || approval.Log != filepath.Join(tempDir, "logs", "approvals.jsonl") || len(approval.Confirm) != 1 {
+		t.Errorf("Expected the timeout from the environment and a log path relative to the config file, got %+v", approval)
+	}
+	if !layered.Config.Tools[0].Confirm {
+		t.Error("Expected confirm to be set on the tool")
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	tempDir := t.TempDir()
+	invalid := writeFile(t, tempDir, "invalid/dizi.yml", `server:
+  auth:
+    tokens:
+      - name: "ci"
+        token: "a"
+        tools: ["build[", "test_*"]
+      - name: "ci"
+        token: "b"
+      - token: "c"
+    jwt:
+      issuer: "https://auth.example.com"
+`)
+	_, err := LoadLayered(LoadOptions{Path: invalid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	for _, expected := range []string{
+		`:6:17: invalid tool pattern "build["`,
+		`:7:15: duplicate auth token "ci", first defined at`,
+		`:9:9: auth token is missing name`,
+		`:11:7: auth.jwt needs a jwks file`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q, got %v", expected, err)
+		}
+	}
+
+	valid := writeFile(t, tempDir, "valid/dizi.yml", `env:
+  CI_TOKEN:
+    value: "ci-${SUFFIX}"
+    secret: true
+server:
+  auth:
+    tokens:
+      - name: "ci"
+        token: "${CI_TOKEN}"
+        tools: ["build"]
+    jwt:
+      jwks: "keys/jwks.json"
+`)
+	layered, err := LoadLayered(LoadOptions{Path: valid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"SUFFIX=42", "DIZI_SERVER_AUTH_HMAC_SECRET=from-env"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	auth := layered.Config.Server.Auth
+	if auth.Tokens[0].Token != "ci-42" || auth.HMAC.Secret != "from-env" || auth.JWT.JWKS != filepath.Join(tempDir, "valid", "keys", "jwks.json") {
+		t.Errorf("Expected expanded tokens, the secret from the environment and an absolute JWKS path, got %+v", auth)
+	}
+	if secrets := strings.Join(layered.Config.Secrets(), " "); !strings.Contains(secrets, "ci-42") || !strings.Contains(secrets, "from-env") {
+		t.Errorf("Expected auth tokens among the secrets, got %s", secrets)
+	}
+	output, err := layered.Show()
+	if err != nil {
+		t.Fatalf("Show failed: %v", err)
+	}
+	if strings.Contains(string(output), "ci-42") || strings.Contains(string(output), "from-env") {
+		t.Errorf("Expected tokens to be hidden in config show, got:\n%s", output)
+	}
+}
+
+func TestValidateListen(t *testing.T) {
+	tempDir := t.TempDir()
+	invalid := writeFile(t, tempDir, "invalid/dizi.yml", `server:
+  listen: "localhost"
+  approval:
+    listen: "unix:"
+  tls:
+    cert: "cert.pem"
+    client_ca: "ca.pem"
+`)
+	_, err := LoadLayered(LoadOptions{Path: invalid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	for _, expected := range []string{
+		`:2:11: listen address must be host:port or unix:/path/to.sock, got "localhost"`,
+		`:4:13: listen address "unix:" is missing the socket path`,
+		`:6:5: tls needs both cert and key`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q, got %v", expected, err)
+		}
+	}
+
+	valid := writeFile(t, tempDir, "valid/dizi.yml", `server:
+  listen: "unix:run/dizi.sock"
+  tls:
+    client_ca: "ca.pem"
+    self_signed: true
+`)
+	layered, err := LoadLayered(LoadOptions{Path: valid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := layered.Config.Server
+	if server.Listen != UnixPrefix+filepath.Join(tempDir, "valid", "run", "dizi.sock") || server.TLS.ClientCA != filepath.Join(tempDir, "valid", "ca.pem") || !server.TLS.Enabled() {
+		t.Errorf("Expected the socket and CA bundle relative to the config file, got %+v", server)
+	}
+
+	layered, err = LoadLayered(LoadOptions{Path: valid, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{"DIZI_SERVER_LISTEN=127.0.0.1:9000"}})
+	if err != nil || layered.Config.Server.Listen != "127.0.0.1:9000" {
+		t.Errorf("Expected the listen address from the environment, got %v", err)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := writeFile(t, tempDir, "dizi.yml", `tools:
+  - name: "zephyr_flash"
+    type: "command"
+    command: "west flash"
+    tags: ["hardware"]
+profiles:
+  ci:
+    tools: ["*"]
+    exclude: ["zephyr_[flash"]
+  reviewer:
+    tags: ["readonly", "hardwre"]
+  empty: {}
+server:
+  auth:
+    tokens:
+      - name: "bot"
+        token: "secret"
+        profile: "cl"
+`)
+	_, err := LoadLayered(LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "none.yml"), Environ: []string{}})
+	for _, expected := range []string{
+		`:9:15: invalid tool pattern "zephyr_[flash"`,
+		`:11:24: unknown tag "hardwre", no tool has it (did you mean "hardware"?)`,
+		`:12:3: profile "empty" includes no tools, set tools or tags`,
+		`:18:18: unknown profile "cl" (did you mean "ci"?)`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q, got %v", expected, err)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), `"readonly"`) {
+		t.Errorf("Expected the builtin readonly tag to be known, got %v", err)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"script", "script", 0},
+		{"scirpt", "script", 1},
+		{"comand", "command", 1},
+		{"lau", "lua", 1},
+		{"builtin", "command", 7},
+	}
+
+	for _, tt := range tests {
+		if d := editDistance(tt.a, tt.b); d != tt.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tt.a, tt.b, d, tt.expected)
+		}
+	}
+}
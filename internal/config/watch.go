@@ -0,0 +1,113 @@
+// Package config provides configuration management for the MCP server.
+// This file watches the configuration files and reloads them when they change.
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Watch polls the configuration files every interval and calls onChange with
+// the reloaded configuration whenever one of them changes. If the new content
+// fails to load, onError is called instead and nothing is applied, so the
+// previous configuration stays in effect until the files are fixed. Watch
+// blocks until ctx is done.
+func Watch(ctx context.Context, opts LoadOptions, interval time.Duration, onChange func(*Config), onError func(error)) {
+	opts = opts.resolve()
+
+	// Polling by content rather than mtime also catches editors that
+	// replace the file or restore its modification time
+	files := watchedFiles(opts, nil)
+	if layered, err := LoadLayered(opts); err == nil {
+		files = watchedFiles(opts, layered.Files)
+	}
+	last := readFiles(files)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A dizi.yml created closer to the working directory takes over
+		files = watchedFiles(opts, files)
+		current := readFiles(files)
+		if current == nil || equalContents(current, last) {
+			continue
+		}
+		last = current
+
+		layered, err := LoadLayered(opts)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		files = watchedFiles(opts, layered.Files)
+		last = readFiles(files)
+		onChange(layered.Config)
+	}
+}
+
+// watchedFiles returns the files whose changes affect the configuration
+func watchedFiles(opts LoadOptions, loaded []string) []string {
+	files := []string{opts.UserPath}
+	if project := opts.projectPath(); project != "" {
+		files = append(files, project)
+	} else {
+		files = append(files, filepath.Join(opts.Dir, FileName))
+	}
+	for _, file := range loaded {
+		if !containsString(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// readFiles returns the content of each file, nil if any of them could not be
+// read for a reason other than not existing (e.g. while it is being replaced)
+func readFiles(files []string) map[string][]byte {
+	contents := make(map[string][]byte, len(files))
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil
+		}
+		contents[file] = data
+	}
+	return contents
+}
+
+// equalContents reports whether two snapshots hold the same files and content
+func equalContents(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, data := range a {
+		other, ok := b[file]
+		if !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
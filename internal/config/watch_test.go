@@ -0,0 +1,63 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "dizi.yml")
+	if err := os.WriteFile(configPath, []byte("name: \"first\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan *Config, 10)
+	errors := make(chan error, 10)
+	opts := LoadOptions{Path: configPath, UserPath: filepath.Join(tempDir, "user.yml"), Environ: []string{}}
+	go Watch(ctx, opts, 10*time.Millisecond, func(cfg *Config) { changes <- cfg }, func(err error) { errors <- err })
+
+	// A valid change is reported with the reloaded configuration
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(configPath, []byte("name: \"second\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to update test config file: %v", err)
+	}
+	select {
+	case cfg := <-changes:
+		if cfg.Name != "second" {
+			t.Errorf("Expected name 'second', got '%s'", cfg.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected change notification")
+	}
+
+	// An invalid change is reported as an error and not applied
+	if err := os.WriteFile(configPath, []byte("name: \"broken\n  invalid"), 0644); err != nil {
+		t.Fatalf("Failed to update test config file: %v", err)
+	}
+	select {
+	case err := <-errors:
+		if err == nil {
+			t.Error("Expected parse error")
+		}
+	case cfg := <-changes:
+		t.Fatalf("Expected invalid config to be rejected, got %+v", cfg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected error notification")
+	}
+
+	// Unchanged content does not trigger anything
+	select {
+	case cfg := <-changes:
+		t.Errorf("Unexpected change notification: %+v", cfg)
+	case err := <-errors:
+		t.Errorf("Unexpected error notification: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,116 @@
+// Package listener opens the sockets the HTTP endpoints are served on, TCP
+// or Unix domain sockets, optionally with TLS.
+// This file opens the listeners and the clients that connect to them.
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"dizi/internal/config"
+)
+
+// SocketMode are the permissions of Unix sockets: only the user running the
+// server may connect
+const SocketMode = 0600
+
+// Listen listens on address, host:port or unix:/path/to.sock, and serves TLS
+// on it if tlsConfig is set
+func Listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if path, ok := SocketPath(address); ok {
+		listener, err = listenUnix(path)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener, nil
+}
+
+// SocketPath returns the path of a unix: address
+func SocketPath(address string) (string, bool) {
+	return strings.CutPrefix(address, config.UnixPrefix)
+}
+
+// listenUnix listens on the socket at path, replacing a socket left behind
+// by a server that is gone
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another server is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, SocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// URL returns the base URL of a server listening on address
+func URL(address string, secure bool) string {
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+	if _, ok := SocketPath(address); ok {
+		return scheme + Host(address)
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return scheme + address
+	}
+	return scheme + net.JoinHostPort(Host(address), port)
+}
+
+// Host returns the name clients reach a server listening on address by,
+// which its certificate must include. That is localhost for Unix sockets and
+// addresses listening on all interfaces.
+func Host(address string) string {
+	if _, ok := SocketPath(address); ok {
+		return "localhost"
+	}
+	host, _, err := net.SplitHostPort(address)
+	if ip := net.ParseIP(host); err != nil || host == "" || (ip != nil && ip.IsUnspecified()) {
+		return "localhost"
+	}
+	return host
+}
+
+// Client returns an HTTP client for the server listening on address and the
+// base URL to send its requests to
+func Client(address string, tlsConfig *tls.Config) (*http.Client, string) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if path, ok := SocketPath(address); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, URL(address, tlsConfig != nil)
+}
@@ -0,0 +1,258 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/config"
+)
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		address  string
+		secure   bool
+		expected string
+	}{
+		{"localhost:8081", false, "http://localhost:8081"},
+		{"127.0.0.1:8081", true, "https://127.0.0.1:8081"},
+		{":8081", false, "http://localhost:8081"},
+		{"0.0.0.0:8081", true, "https://localhost:8081"},
+		{"[::]:8081", false, "http://localhost:8081"},
+		{"[::1]:8081", false, "http://[::1]:8081"},
+		{"unix:/tmp/dizi.sock", true, "https://localhost"},
+	}
+
+	for _, tt := range tests {
+		if url := URL(tt.address, tt.secure); url != tt.expected {
+			t.Errorf("URL(%q, %v) = %q, expected %q", tt.address, tt.secure, url, tt.expected)
+		}
+	}
+}
+
+// serve serves a greeting on address until the test ends and returns the
+// address it listens on
+func serve(t *testing.T, address string, tlsConfig *tls.Config) string {
+	t.Helper()
+	l, err := Listen(address, tlsConfig)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	if _, ok := SocketPath(address); ok {
+		return address
+	}
+	return l.Addr().String()
+}
+
+// get fetches / from the server on address
+func get(client *http.Client, baseURL string) (string, error) {
+	response, err := client.Get(baseURL + "/")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return string(body), err
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dizi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "dizi.sock")
+	address := config.UnixPrefix + socket
+
+	// A socket left behind by a server that is gone is replaced
+	stale, err := Listen(address, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	stale.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	stale.Close()
+
+	serve(t, address, nil)
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != SocketMode {
+		t.Errorf("Expected socket permissions %o, got %o", SocketMode, info.Mode().Perm())
+	}
+	client, baseURL := Client(address, nil)
+	if body, err := get(client, baseURL); err != nil || body != "hello" {
+		t.Errorf("Expected a response over the socket, got %q %v", body, err)
+	}
+
+	if _, err := Listen(address, nil); err == nil || !strings.Contains(err.Error(), "another server is listening") {
+		t.Errorf("Expected a socket in use to be refused, got %v", err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(config.UnixPrefix+file, nil); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("Expected a regular file not to be replaced, got %v", err)
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	cfg := config.TLSConfig{SelfSigned: true}
+	certFile, keyFile, err := CertificatePaths(cfg)
+	if err != nil {
+		t.Fatalf("CertificatePaths failed: %v", err)
+	}
+
+	if _, err := ServerTLS(cfg, "localhost"); err != nil {
+		t.Fatalf("ServerTLS failed: %v", err)
+	}
+	created, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("Expected a certificate to be created: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private key only the user can read, got %v %v", info, err)
+	}
+
+	// The certificate is kept while it is usable, so clients trust it once
+	if _, err := ServerTLS(cfg, "localhost"); err != nil {
+		t.Fatalf("ServerTLS failed: %v", err)
+	}
+	if again, _ := os.ReadFile(certFile); string(again) != string(created) {
+		t.Error("Expected the certificate to be reused")
+	}
+	tlsConfig, err := ServerTLS(cfg, "dizi.internal")
+	if err != nil {
+		t.Fatalf("ServerTLS failed: %v", err)
+	}
+	if again, _ := os.ReadFile(certFile); string(again) == string(created) {
+		t.Error("Expected a new certificate for another host")
+	}
+
+	address := serve(t, "127.0.0.1:0", tlsConfig)
+
+	clientTLS, err := ClientTLS(cfg, "", "")
+	if err != nil {
+		t.Fatalf("ClientTLS failed: %v", err)
+	}
+	client, baseURL := Client(address, clientTLS)
+	if !strings.HasPrefix(baseURL, "https://") {
+		t.Errorf("Expected an https URL, got %s", baseURL)
+	}
+	if body, err := get(client, baseURL); err != nil || body != "hello" {
+		t.Errorf("Expected the self-signed certificate to be trusted, got %q %v", body, err)
+	}
+	untrusted, _ := Client(address, &tls.Config{})
+	if _, err := get(untrusted, baseURL); err == nil {
+		t.Error("Expected a client without the certificate to refuse it")
+	}
+}
+
+// writeCA creates a CA in dir and a client certificate it signed
+func writeCA(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dizi test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ide"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	caFile, certFile, keyFile = filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	for file, data := range map[string][]byte{
+		caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	} {
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return caFile, certFile, keyFile
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	caFile, clientCert, clientKey := writeCA(t, dir)
+	cfg := config.TLSConfig{
+		Cert:       filepath.Join(dir, "server.pem"),
+		Key:        filepath.Join(dir, "server-key.pem"),
+		ClientCA:   caFile,
+		SelfSigned: true,
+	}
+	tlsConfig, err := ServerTLS(cfg)
+	if err != nil {
+		t.Fatalf("ServerTLS failed: %v", err)
+	}
+	socketDir, err := os.MkdirTemp("", "dizi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+	address := config.UnixPrefix + filepath.Join(socketDir, "dizi.sock")
+	serve(t, address, tlsConfig)
+
+	anonymous, err := ClientTLS(cfg, "", "")
+	if err != nil {
+		t.Fatalf("ClientTLS failed: %v", err)
+	}
+	client, baseURL := Client(address, anonymous)
+	if _, err := get(client, baseURL); err == nil {
+		t.Error("Expected a client without a certificate to be refused")
+	}
+
+	identified, err := ClientTLS(cfg, clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("ClientTLS failed: %v", err)
+	}
+	client, baseURL = Client(address, identified)
+	if body, err := get(client, baseURL); err != nil || body != "hello" {
+		t.Errorf("Expected a client with a certificate from the CA to connect, got %q %v", body, err)
+	}
+
+	if _, err := ServerTLS(config.TLSConfig{Cert: cfg.Cert, Key: cfg.Key, ClientCA: clientKey}); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("Expected a CA bundle without certificates to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package listener opens the sockets the HTTP endpoints are served on, TCP
+// or Unix domain sockets, optionally with TLS.
+// This file loads the TLS certificates and creates self-signed ones.
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dizi/internal/config"
+	"dizi/internal/logger"
+)
+
+// selfSignedValidity is how long a created certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// ServerTLS returns the TLS settings of the HTTP endpoints, or nil if cfg
+// doesn't enable TLS. With self_signed, a certificate for localhost and
+// hosts is created when there is none, or when the one created before has
+// expired or doesn't name all hosts.
+func ServerTLS(cfg config.TLSConfig, hosts ...string) (*tls.Config, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	certFile, keyFile, err := CertificatePaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SelfSigned {
+		if err := ensureSelfSigned(certFile, keyFile, hosts); err != nil {
+			return nil, err
+		}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCA != "" {
+		pool, err := loadPool(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLS returns the TLS settings to connect to a server configured with
+// cfg, or nil if it doesn't use TLS. The server's certificate is trusted
+// besides the system roots, so self-signed ones work; certFile and keyFile,
+// if set, are presented to servers that require client certificates.
+func ClientTLS(cfg config.TLSConfig, certFile, keyFile string) (*tls.Config, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	serverCert, _, err := CertificatePaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	data, err := os.ReadFile(serverCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server certificate: %w", err)
+	}
+	roots.AppendCertsFromPEM(data)
+
+	tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// CertificatePaths returns the certificate and key files of cfg. Self-signed
+// certificates without their own paths are kept next to the user-level
+// config, so clients only need to trust them once.
+func CertificatePaths(cfg config.TLSConfig) (string, string, error) {
+	if cfg.Cert != "" {
+		return cfg.Cert, cfg.Key, nil
+	}
+	userConfig := config.UserConfigPath()
+	if userConfig == "" {
+		return "", "", errors.New("failed to find a directory for the self-signed certificate, set server.tls.cert and key")
+	}
+	dir := filepath.Join(filepath.Dir(userConfig), "tls")
+	return filepath.Join(dir, "localhost.pem"), filepath.Join(dir, "localhost-key.pem"), nil
+}
+
+// ensureSelfSigned creates a self-signed certificate unless certFile holds
+// one that is usable for hosts. Certificates signed by someone else are
+// left alone.
+func ensureSelfSigned(certFile, keyFile string, hosts []string) error {
+	hosts = append([]string{"localhost", "127.0.0.1", "::1"}, hosts...)
+	if data, err := os.ReadFile(certFile); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s holds no PEM certificate", certFile)
+		}
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", certFile, err)
+		}
+		if leaf.CheckSignatureFrom(leaf) != nil || usable(leaf, hosts) {
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", certFile, err)
+	}
+
+	certPEM, keyPEM, err := selfSigned(hosts, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return fmt.Errorf("failed to create certificate directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	fingerprint := sha256.Sum256(block.Bytes)
+	logger.InfoLog("Created self-signed certificate %s (SHA-256 %s)", certFile, hex.EncodeToString(fingerprint[:]))
+	return nil
+}
+
+// usable reports whether leaf is valid for another day and names every host
+func usable(leaf *x509.Certificate, hosts []string) bool {
+	if time.Now().Add(24 * time.Hour).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// selfSigned creates a PEM certificate for hosts, names or IP addresses,
+// and its private key
+func selfSigned(hosts []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"dizi"}, CommonName: "dizi self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// loadPool reads a PEM CA bundle
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
@@ -4,8 +4,12 @@
 package logger
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 )
 
 var (
@@ -13,8 +17,18 @@ var (
 	silentMode = false
 	// logger is the standard logger instance
 	logger = log.New(os.Stderr, "", log.LstdFlags)
+
+	// secretsMu guards secrets and redactor
+	secretsMu sync.Mutex
+	// secrets are values that must never appear in the log
+	secrets = make(map[string]bool)
+	// redactor replaces secrets, nil when there are none
+	redactor *strings.Replacer
 )
 
+// redactedText replaces secret values in log messages
+const redactedText = "[REDACTED]"
+
 // SetupLogger configures logging based on the transport mode
 func SetupLogger(transport string) {
 	if transport == "stdio" {
@@ -28,6 +42,59 @@ func SetupLogger(transport string) {
 // InfoLog logs an info message if not in silent mode
 func InfoLog(format string, args ...interface{}) {
 	if !silentMode {
-		logger.Printf("[INFO] "+format, args...)
+		logger.Print("[INFO] " + redact(fmt.Sprintf(format, args...)))
+	}
+}
+
+// ErrorLog logs an error message if not in silent mode
+func ErrorLog(format string, args ...interface{}) {
+	if !silentMode {
+		logger.Print("[ERROR] " + redact(fmt.Sprintf(format, args...)))
+	}
+}
+
+// AddSecrets registers values that are redacted from all further log messages
+func AddSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			secrets[value] = true
+		}
+	}
+	if len(secrets) == 0 {
+		return
 	}
+
+	// Longest first so a secret containing another is replaced as a whole
+	sorted := make([]string, 0, len(secrets))
+	for value := range secrets {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, redactedText)
+	}
+	redactor = strings.NewReplacer(pairs...)
+}
+
+// redact removes registered secrets from a log message
+func redact(message string) string {
+	secretsMu.Lock()
+	r := redactor
+	secretsMu.Unlock()
+
+	if r == nil {
+		return message
+	}
+	return r.Replace(message)
+}
+
+// Redact removes registered secrets from text written outside the log, such
+// as the audit log
+func Redact(text string) string {
+	return redact(text)
 }
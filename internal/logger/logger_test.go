@@ -229,4 +229,49 @@ func TestConcurrentLogging(t *testing.T) {
 	if infoCount != 10 {
 		t.Errorf("Expected 10 log entries, got %d", infoCount)
 	}
-}
\ No newline at end of file
+}
+
+func TestErrorLog(t *testing.T) {
+	var buf bytes.Buffer
+	originalLogger := logger
+	logger = log.New(&buf, "", 0)
+	defer func() { logger = originalLogger }()
+
+	silentMode = false
+	ErrorLog("Failed to %s", "reload")
+	if output := buf.String(); !strings.Contains(output, "[ERROR] Failed to reload") {
+		t.Errorf("Expected error log output, got: %s", output)
+	}
+
+	buf.Reset()
+	silentMode = true
+	defer func() { silentMode = false }()
+	ErrorLog("Failed to %s", "reload")
+	if output := buf.String(); output != "" {
+		t.Errorf("Expected empty output in silent mode, got: %s", output)
+	}
+}
+
+func TestAddSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	originalLogger := logger
+	logger = log.New(&buf, "", 0)
+	defer func() {
+		logger = originalLogger
+		secrets = make(map[string]bool)
+		redactor = nil
+	}()
+
+	silentMode = false
+	AddSecrets("s3cr3t-token", "")
+	InfoLog("Calling API with %s", "s3cr3t-token")
+	ErrorLog("Request failed: token=s3cr3t-token")
+
+	output := buf.String()
+	if strings.Contains(output, "s3cr3t-token") {
+		t.Errorf("Expected secret to be redacted, got: %s", output)
+	}
+	if strings.Count(output, "[REDACTED]") != 2 {
+		t.Errorf("Expected two redacted values, got: %s", output)
+	}
+}
@@ -0,0 +1,401 @@
+// Package policy checks shell commands against allow and deny rules before
+// they run. Commands are parsed as shell, so every executable in pipelines,
+// lists, subshells and command substitutions is checked, as are the
+// commands run through wrappers like sudo, xargs or sh -c.
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// maxDepth bounds how deeply commands nested in sh -c, eval and wrappers
+// are followed
+const maxDepth = 8
+
+// Policy is a compiled set of allow and deny rules
+type Policy struct {
+	allow []rule
+	deny  []rule
+}
+
+// rule is an executable pattern followed by argument patterns, each of
+// which must match some argument of the command
+type rule struct {
+	text string
+	name glob.Glob
+	path bool // the executable pattern is matched against the full path
+	args []argPattern
+}
+
+// argPattern matches one argument; single-dash options also match letter
+// by letter, so that -rf matches -fr and -r -f
+type argPattern struct {
+	glob    glob.Glob
+	letters string
+}
+
+// shortOptions matches single-dash options like -rf
+var shortOptions = regexp.MustCompile(`^-[A-Za-z0-9]+$`)
+
+// Compile compiles allow and deny rules. Each rule is an executable
+// followed by argument patterns, all of which may use * and ? globs.
+func Compile(allow, deny []string) (*Policy, error) {
+	p := &Policy{}
+	for _, list := range []struct {
+		rules []string
+		into  *[]rule
+	}{{allow, &p.allow}, {deny, &p.deny}} {
+		for _, text := range list.rules {
+			r, err := compileRule(text)
+			if err != nil {
+				return nil, err
+			}
+			*list.into = append(*list.into, r)
+		}
+	}
+	return p, nil
+}
+
+// compileRule parses one rule
+func compileRule(text string) (rule, error) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return rule{}, fmt.Errorf("empty rule")
+	}
+	name, err := glob.Compile(words[0])
+	if err != nil {
+		return rule{}, fmt.Errorf("invalid rule %q: %w", text, err)
+	}
+	r := rule{text: strings.Join(words, " "), name: name, path: strings.Contains(words[0], "/")}
+	for _, word := range words[1:] {
+		g, err := glob.Compile(word)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid rule %q: %w", text, err)
+		}
+		pattern := argPattern{glob: g}
+		if shortOptions.MatchString(word) {
+			pattern.letters = word[1:]
+		}
+		r.args = append(r.args, pattern)
+	}
+	return r, nil
+}
+
+// Violation is a command the policy does not let run
+type Violation struct {
+	Command string // the offending command as written
+	Reason  string
+}
+
+func (v *Violation) Error() string {
+	if v.Command == "" {
+		return v.Reason
+	}
+	return fmt.Sprintf("%q %s", v.Command, v.Reason)
+}
+
+// safeBuiltins are shell builtins that run nothing else, allowed without
+// being listed
+var safeBuiltins = map[string]bool{
+	":": true, "[": true, "cd": true, "echo": true, "exit": true, "false": true, "printf": true, "pwd": true,
+	"read": true, "return": true, "set": true, "shift": true, "test": true, "true": true, "unset": true, "wait": true,
+}
+
+// shells run the script given with -c
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "fish": true}
+
+// wrappers run the command that follows their own options
+var wrappers = map[string]bool{
+	"builtin": true, "command": true, "doas": true, "env": true, "exec": true, "nice": true, "nohup": true,
+	"setsid": true, "stdbuf": true, "sudo": true, "time": true, "timeout": true, "xargs": true,
+}
+
+// Check parses script and returns a *Violation for the first command that
+// is denied or not allowed. Scripts that don't parse are violations too.
+func (p *Policy) Check(script string) error {
+	return p.check(script, 0)
+}
+
+// check checks script, which is nested depth levels deep
+func (p *Policy) check(script string, depth int) error {
+	if depth > maxDepth {
+		return &Violation{Command: script, Reason: "nests commands too deeply to check"}
+	}
+	file, err := syntax.NewParser().Parse(strings.NewReader(script), "")
+	if err != nil {
+		return &Violation{Reason: fmt.Sprintf("cannot parse the command: %v", err)}
+	}
+
+	var violation error
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if violation != nil {
+			return false
+		}
+		if call, ok := node.(*syntax.CallExpr); ok && len(call.Args) > 0 {
+			args := make([]word, len(call.Args))
+			for i, arg := range call.Args {
+				args[i] = wordOf(script, arg)
+			}
+			violation = p.checkCommand(args, depth)
+		}
+		return true
+	})
+	return violation
+}
+
+// checkCommand checks one simple command and the commands it runs
+func (p *Policy) checkCommand(args []word, depth int) error {
+	if depth > maxDepth {
+		return &Violation{Command: commandText(args), Reason: "nests commands too deeply to check"}
+	}
+	if !args[0].known {
+		return &Violation{Command: commandText(args), Reason: "has a command name that is only known when it runs"}
+	}
+	if err := p.checkRules(args); err != nil {
+		return err
+	}
+
+	base := path.Base(args[0].value)
+	switch {
+	case shells[base]:
+		for i, arg := range args[1:] {
+			if arg.known && shortOptions.MatchString(arg.value) && strings.Contains(arg.value, "c") {
+				if i+2 >= len(args) {
+					break
+				}
+				if !args[i+2].known {
+					return &Violation{Command: commandText(args), Reason: "runs a script that is only known when it runs"}
+				}
+				return p.check(args[i+2].value, depth+1)
+			}
+		}
+	case base == "eval":
+		script := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if !arg.known {
+				return &Violation{Command: commandText(args), Reason: "runs a script that is only known when it runs"}
+			}
+			script = append(script, arg.value)
+		}
+		return p.check(strings.Join(script, " "), depth+1)
+	case wrappers[base]:
+		// Options of the wrapper can't be told apart from the command in
+		// general, so every word may start a denied command
+		for i := 1; i < len(args); i++ {
+			if err := p.checkDenied(args[i:]); err != nil {
+				return err
+			}
+		}
+		if start := wrappedCommand(base, args); start < len(args) {
+			wrapped := args[start:]
+			if base == "xargs" {
+				wrapped = append(wrapped[:len(wrapped):len(wrapped)], word{split: true, source: "<input>"})
+			}
+			return p.checkCommand(wrapped, depth+1)
+		}
+	case base == "find":
+		for i := 1; i < len(args); i++ {
+			switch args[i].value {
+			case "-exec", "-execdir", "-ok", "-okdir":
+				end := i + 1
+				for end < len(args) && args[end].value != ";" && args[end].value != "+" {
+					end++
+				}
+				if end > i+1 {
+					if err := p.checkCommand(args[i+1:end], depth+1); err != nil {
+						return err
+					}
+				}
+				i = end
+			}
+		}
+	}
+	return nil
+}
+
+// checkRules checks a command against the deny rules and then the allow rules
+func (p *Policy) checkRules(args []word) error {
+	if err := p.checkDenied(args); err != nil {
+		return err
+	}
+	if len(p.allow) == 0 || safeBuiltins[args[0].value] {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.matches(args, false) {
+			return nil
+		}
+	}
+	return &Violation{Command: commandText(args), Reason: "is not allowed by the policy"}
+}
+
+// checkDenied checks a command against the deny rules; arguments that are
+// only known when the command runs may match any pattern
+func (p *Policy) checkDenied(args []word) error {
+	if !args[0].known {
+		return nil
+	}
+	for _, r := range p.deny {
+		if r.matches(args, true) {
+			return &Violation{Command: commandText(args), Reason: fmt.Sprintf("is denied by rule %q", r.text)}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule matches a command. With unknownMatches,
+// arguments that are only known when the command runs stand in for the
+// patterns no known argument matches: one each, or any number for words
+// that may split into several.
+func (r rule) matches(args []word, unknownMatches bool) bool {
+	name := args[0].value
+	if !r.path {
+		name = path.Base(name)
+	}
+	if !r.name.Match(name) {
+		return false
+	}
+
+	var letters strings.Builder
+	single, split := 0, false
+	for _, arg := range args[1:] {
+		switch {
+		case !arg.known && arg.split:
+			split = true
+		case !arg.known:
+			single++
+		case shortOptions.MatchString(arg.value):
+			letters.WriteString(arg.value[1:])
+		}
+	}
+	unmatched := 0
+	for _, pattern := range r.args {
+		if !pattern.matchesAny(args[1:], letters.String()) {
+			unmatched++
+		}
+	}
+	return unmatched == 0 || (unknownMatches && (split || unmatched <= single))
+}
+
+// matchesAny reports whether some argument matches the pattern, or the
+// short options together contain all of its letters
+func (a argPattern) matchesAny(args []word, letters string) bool {
+	for _, arg := range args {
+		if arg.known && a.glob.Match(arg.value) {
+			return true
+		}
+	}
+	if a.letters == "" {
+		return false
+	}
+	for _, letter := range a.letters {
+		if !strings.ContainsRune(letters, letter) {
+			return false
+		}
+	}
+	return true
+}
+
+// wrappedCommand returns the index of the command a wrapper runs: the first
+// word that is not an option of the wrapper, a variable assignment of env
+// or the duration of timeout
+func wrappedCommand(wrapper string, args []word) int {
+	i := 1
+	for i < len(args) && args[i].known {
+		value := args[i].value
+		if value == "--" {
+			return i + 1
+		}
+		if strings.HasPrefix(value, "-") || (wrapper == "env" && strings.Contains(value, "=")) {
+			i++
+			continue
+		}
+		break
+	}
+	if wrapper == "timeout" && i < len(args) {
+		i++
+	}
+	return i
+}
+
+// word is a word of a command, with its value if it is known before the
+// command runs
+type word struct {
+	value  string
+	known  bool
+	split  bool // an unknown word that may expand to several words
+	source string
+}
+
+// wordOf returns the value of w, which is unknown if it depends on
+// variables, command substitutions, globs or brace expansion
+func wordOf(script string, w *syntax.Word) word {
+	result := word{known: true, source: script[w.Pos().Offset():w.End().Offset()]}
+	var value strings.Builder
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			if strings.ContainsAny(part.Value, "*?[{") {
+				result.known, result.split = false, true
+			}
+			value.WriteString(unescape(part.Value, ""))
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				result.known = false
+			}
+			value.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			if part.Dollar {
+				result.known = false
+			}
+			for _, inner := range part.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok {
+					result.known = false
+					continue
+				}
+				value.WriteString(unescape(lit.Value, "$`\"\\\n"))
+			}
+		default:
+			result.known, result.split = false, true
+		}
+	}
+	if result.known {
+		result.value = value.String()
+	}
+	return result
+}
+
+// unescape removes the backslashes that escape the next character, only
+// before the characters in special if it isn't empty
+func unescape(s, special string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (special == "" || strings.IndexByte(special, s[i+1]) >= 0) {
+			i++
+			if s[i] == '\n' {
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// commandText returns a command as written
+func commandText(args []word) string {
+	sources := make([]string, len(args))
+	for i, arg := range args {
+		sources[i] = arg.source
+	}
+	return strings.Join(sources, " ")
+}
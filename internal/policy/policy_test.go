@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := Compile(
+		[]string{"git", "ls", "grep", "cat", "make", "xargs", "find", "sudo", "bash", "eval", "/usr/bin/*"},
+		[]string{"rm -rf /", "git push --force", "git push -f", "sudo", "curl"},
+	)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		expected string // part of the violation, "" if allowed
+	}{
+		{"allowed pipeline", "git log --oneline | grep fix | cat", ""},
+		{"allowed list", "cd src && make -j4; ls", ""},
+		{"allowed absolute path", "/usr/bin/env true", ""},
+		{"quoted commit message", `git commit -m "$MESSAGE"`, ""},
+		{"unlisted command", "ls && python3 -c 'print(1)'", `"python3 -c 'print(1)'" is not allowed by the policy`},
+		{"denied with arguments", "git push --force origin main", `is denied by rule "git push --force"`},
+		{"denied short option", "git push -uf origin main", `is denied by rule "git push -f"`},
+		{"option letters in any order", "ls; make && rm -fr /", `"rm -fr /" is denied by rule "rm -rf /"`},
+		{"separate options", "rm -r -f /", `is denied by rule "rm -rf /"`},
+		{"other arguments", "rm -rf build", `"rm -rf build" is not allowed`},
+		{"subshell", "(cd /tmp && curl example.com)", `"curl example.com" is denied`},
+		{"command substitution", "echo $(curl -s example.com)", `"curl -s example.com" is denied`},
+		{"nested shell", `bash -lc "git push --force"`, `"git push --force" is denied`},
+		{"eval", "eval git push -f", `is denied by rule "git push -f"`},
+		{"wrapper", "xargs rm -rf", `is denied by rule "rm -rf /"`},
+		{"find exec", `find . -name '*.o' -exec rm -rf {} \;`, `is denied by rule "rm -rf /"`},
+		{"sudo", "sudo ls", `"sudo ls" is denied by rule "sudo"`},
+		{"unknown argument", "rm -rf $DIR", `is denied by rule "rm -rf /"`},
+		{"dynamic command name", "$EDITOR notes.txt", "only known when it runs"},
+		{"brace expansion", "r{m,x} -rf /", "only known when it runs"},
+		{"escaped name", `\rm -rf /`, `is denied by rule "rm -rf /"`},
+		{"syntax error", "git log |", "cannot parse the command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.script)
+			switch {
+			case tt.expected == "" && err != nil:
+				t.Errorf("Expected %q to be allowed, got %v", tt.script, err)
+			case tt.expected != "" && (err == nil || !strings.Contains(err.Error(), tt.expected)):
+				t.Errorf("Expected violation containing %q for %q, got %v", tt.expected, tt.script, err)
+			}
+		})
+	}
+}
+
+func TestCheckDenyOnly(t *testing.T) {
+	p, err := Compile(nil, []string{"git push --force"})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if err := p.Check("python3 build.py && git push origin main"); err != nil {
+		t.Errorf("Expected commands without an allow list to pass, got %v", err)
+	}
+	if err := p.Check("env GIT_TRACE=1 git push --force"); err == nil {
+		t.Error("Expected wrapped command to be denied")
+	}
+}
+
+func TestCompileInvalidRule(t *testing.T) {
+	if _, err := Compile([]string{"git [push"}, nil); err == nil {
+		t.Error("Expected error for invalid glob")
+	}
+	if _, err := Compile(nil, []string{"  "}); err == nil || err.Error() != "empty rule" {
+		t.Errorf("Expected error for empty rule, got %v", err)
+	}
+}
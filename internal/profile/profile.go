@@ -0,0 +1,176 @@
+// Package profile limits clients to named sets of tools, the profiles in
+// dizi.yml. Clients pick one with -profile over stdio, or with their auth
+// token or ?profile= over HTTP.
+package profile
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+
+	"dizi/internal/config"
+
+	"github.com/gobwas/glob"
+)
+
+// Profile is a set of tools, given by name patterns and tags
+type Profile struct {
+	Name string
+
+	tools   []glob.Glob
+	tags    []string
+	exclude []glob.Glob
+}
+
+// New compiles the profile called name
+func New(name string, cfg config.ProfileConfig) (*Profile, error) {
+	profile := &Profile{Name: name, tags: cfg.Tags}
+	var err error
+	if profile.tools, err = compile(cfg.Tools); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	if profile.exclude, err = compile(cfg.Exclude); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return profile, nil
+}
+
+// compile compiles tool name patterns
+func compile(patterns []string) ([]glob.Glob, error) {
+	matchers := make([]glob.Glob, 0, len(patterns))
+	for _, pattern := range patterns {
+		matcher, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// Includes reports whether the tool called name, with tags, belongs to the profile
+func (p *Profile) Includes(name string, tags []string) bool {
+	for _, matcher := range p.exclude {
+		if matcher.Match(name) {
+			return false
+		}
+	}
+	for _, matcher := range p.tools {
+		if matcher.Match(name) {
+			return true
+		}
+	}
+	for _, tag := range tags {
+		if slices.Contains(p.tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set holds the profiles of the config and the tags of its tools. It is
+// updated when the config is reloaded, so changed profiles apply to
+// connected clients at once.
+type Set struct {
+	mu       sync.RWMutex
+	configs  map[string]config.ProfileConfig
+	profiles map[string]*Profile
+	tags     map[string][]string
+}
+
+// NewSet creates the set of profiles configured in cfg
+func NewSet(cfg *config.Config) (*Set, error) {
+	s := &Set{}
+	if _, err := s.Update(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the profiles and tool tags with those of cfg and reports
+// whether that changed which tools a profile includes. On error the
+// previous ones stay in use.
+func (s *Set) Update(cfg *config.Config) (bool, error) {
+	profiles := make(map[string]*Profile, len(cfg.Profiles))
+	for name, profileConfig := range cfg.Profiles {
+		profile, err := New(name, profileConfig)
+		if err != nil {
+			return false, err
+		}
+		profiles[name] = profile
+	}
+	tags := make(map[string][]string, len(cfg.Tools))
+	for _, tool := range cfg.Tools {
+		tags[tool.Name] = tool.Tags
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := !reflect.DeepEqual(s.configs, cfg.Profiles) || !reflect.DeepEqual(s.tags, tags)
+	s.configs = cfg.Profiles
+	s.profiles = profiles
+	s.tags = tags
+	return changed, nil
+}
+
+// Names lists the configured profiles
+func (s *Set) Names() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error if there is no profile called name
+func (s *Set) Check(name string) error {
+	if name == "" || slices.Contains(s.Names(), name) {
+		return nil
+	}
+	return fmt.Errorf("unknown profile %q", name)
+}
+
+// Allows reports whether the profile called name includes a tool. No
+// profile allows every tool; a profile that no longer exists allows none.
+func (s *Set) Allows(name, tool string) bool {
+	if name == "" {
+		return true
+	}
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	profile, ok := s.profiles[name]
+	if !ok {
+		return false
+	}
+	tags := s.tags[tool]
+	if tags == nil {
+		tags = config.BuiltinTags(tool)
+	}
+	return profile.Includes(tool, tags)
+}
+
+// profileKey is the context key of the profile a client asked for
+type profileKey struct{}
+
+// WithProfile returns a context limited to the profile called name
+func WithProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, profileKey{}, name)
+}
+
+// FromContext returns the profile a client asked for, "" if none
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(profileKey{}).(string)
+	return name
+}
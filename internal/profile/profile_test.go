@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"context"
+	"testing"
+
+	"dizi/internal/config"
+)
+
+func TestAllows(t *testing.T) {
+	cfg := &config.Config{
+		Tools: []config.ToolConfig{
+			{Name: "build", Tags: []string{"ci"}},
+			{Name: "test_unit", Tags: []string{"ci"}},
+			{Name: "zephyr_flash", Tags: []string{"ci", "hardware"}},
+			{Name: "deploy"},
+		},
+		Profiles: map[string]config.ProfileConfig{
+			"ci":       {Tags: []string{"ci"}, Exclude: []string{"zephyr_*"}},
+			"reviewer": {Tags: []string{config.TagReadOnly}, Tools: []string{"test_*"}},
+		},
+	}
+	profiles, err := NewSet(cfg)
+	if err != nil {
+		t.Fatalf("NewSet failed: %v", err)
+	}
+
+	tests := []struct {
+		profile  string
+		tool     string
+		expected bool
+	}{
+		{"", "zephyr_flash", true},
+		{"ci", "build", true},
+		{"ci", "test_unit", true},
+		{"ci", "zephyr_flash", false},
+		{"ci", "deploy", false},
+		{"reviewer", "read_project_file", true},
+		{"reviewer", "grep_project_files", true},
+		{"reviewer", "write_project_file", false},
+		{"reviewer", "test_unit", true},
+		{"reviewer", "build", false},
+		{"reviewer", "fetch_output", false},
+		{"missing", "build", false},
+	}
+
+	for _, tt := range tests {
+		if allowed := profiles.Allows(tt.profile, tt.tool); allowed != tt.expected {
+			t.Errorf("Allows(%q, %q) = %v, expected %v", tt.profile, tt.tool, allowed, tt.expected)
+		}
+	}
+
+	if err := profiles.Check("reviewer"); err != nil {
+		t.Errorf("Expected reviewer to exist, got %v", err)
+	}
+	if err := profiles.Check("reveiwer"); err == nil {
+		t.Error("Expected an unknown profile to be reported")
+	}
+	var none *Set
+	if !none.Allows("", "build") || none.Allows("ci", "build") || none.Check("ci") == nil {
+		t.Error("Expected no profiles to allow everything without a profile and nothing with one")
+	}
+	if name := FromContext(WithProfile(context.Background(), "ci")); name != "ci" {
+		t.Errorf("Expected the profile from the context, got %q", name)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	cfg := &config.Config{
+		Tools:    []config.ToolConfig{{Name: "build"}},
+		Profiles: map[string]config.ProfileConfig{"ci": {Tools: []string{"build"}}},
+	}
+	profiles, err := NewSet(cfg)
+	if err != nil {
+		t.Fatalf("NewSet failed: %v", err)
+	}
+	if changed, err := profiles.Update(cfg); changed || err != nil {
+		t.Errorf("Expected no change for the same config, got %v %v", changed, err)
+	}
+
+	cfg = &config.Config{
+		Tools:    []config.ToolConfig{{Name: "build"}},
+		Profiles: map[string]config.ProfileConfig{"ci": {Tools: []string{"test_*"}}},
+	}
+	if changed, err := profiles.Update(cfg); !changed || err != nil {
+		t.Errorf("Expected a changed profile, got %v %v", changed, err)
+	}
+	if profiles.Allows("ci", "build") {
+		t.Error("Expected the changed profile to apply at once")
+	}
+
+	broken := &config.Config{Profiles: map[string]config.ProfileConfig{"ci": {Tools: []string{"[build"}}}}
+	if _, err := profiles.Update(broken); err == nil {
+		t.Error("Expected an invalid pattern to be refused")
+	}
+	if !profiles.Allows("ci", "test_unit") {
+		t.Error("Expected the previous profiles to stay in use after an error")
+	}
+}
@@ -0,0 +1,42 @@
+// Package sandbox runs tool processes with a read-only view of the file
+// system, no network and resource limits. On Linux it uses user namespaces,
+// so no root is needed where the kernel allows unprivileged ones.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// helperArg marks a re-execution of the server as the sandbox helper
+const helperArg = "__dizi_sandbox__"
+
+// TempDirPrefix starts the name of the writable temp dir of each sandboxed call
+const TempDirPrefix = "dizi-sandbox-"
+
+// Policy describes what a sandboxed process may do
+type Policy struct {
+	Network     bool          `json:"network,omitempty"`      // keep the network of the server
+	Writable    []string      `json:"writable,omitempty"`     // absolute paths that stay writable
+	CPU         time.Duration `json:"cpu,omitempty"`          // CPU time per process; 0 means no limit
+	MemoryBytes uint64        `json:"memory_bytes,omitempty"` // address space per process; 0 means no limit
+	Processes   uint64        `json:"processes,omitempty"`    // processes of the user; 0 means no limit
+}
+
+// Init runs the sandbox helper and exits if the program was started as
+// one. It must be called first thing in main, and in TestMain of tests
+// that run sandboxed commands.
+func Init() {
+	if len(os.Args) < 3 || os.Args[1] != helperArg {
+		return
+	}
+	os.Exit(runHelper(os.Args[2]))
+}
+
+// helperError reports a failure of the helper on the sandboxed process's
+// stderr, where the tool result picks it up
+func helperError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "dizi sandbox: "+format+"\n", args...)
+	return 126
+}
@@ -0,0 +1,272 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// helperRequest tells the helper what to run and how to confine it
+type helperRequest struct {
+	Policy Policy   `json:"policy"`
+	Path   string   `json:"path"`
+	Args   []string `json:"args"`
+	Dir    string   `json:"dir,omitempty"`
+	UID    int      `json:"uid"`
+	GID    int      `json:"gid"`
+}
+
+// Wrap changes cmd to run inside a sandbox with policy. The server binary
+// is started again as a helper in new user, mount and network namespaces;
+// it makes every mount read-only except the writable paths, applies the
+// limits and then runs the original command as the calling user. A fresh
+// temp dir is writable and set as TMPDIR; it is removed once ctx is done.
+func Wrap(ctx context.Context, cmd *exec.Cmd, policy Policy) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the server executable for the sandbox: %w", err)
+	}
+	tempDir, err := os.MkdirTemp("", TempDirPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create sandbox temp dir: %w", err)
+	}
+	context.AfterFunc(ctx, func() { os.RemoveAll(tempDir) })
+
+	policy.Writable = append(slices.Clone(policy.Writable), tempDir)
+	request, err := json.Marshal(helperRequest{
+		Policy: policy,
+		Path:   cmd.Path,
+		Args:   cmd.Args,
+		Dir:    cmd.Dir,
+		UID:    os.Getuid(),
+		GID:    os.Getgid(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode sandbox request: %w", err)
+	}
+
+	environ := cmd.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	cmd.Env = append(slices.Clip(environ), "TMPDIR="+tempDir)
+	cmd.Path = executable
+	cmd.Args = []string{executable, helperArg, string(request)}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+	if !policy.Network {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	return nil
+}
+
+// runHelper confines its own namespaces as the request asks and runs the
+// command in a nested user namespace, where it has no capabilities left to
+// undo the confinement. It exits with the command's exit code, or 128 plus
+// the signal that killed it.
+func runHelper(encoded string) int {
+	var request helperRequest
+	if err := json.Unmarshal([]byte(encoded), &request); err != nil {
+		return helperError("invalid request: %v", err)
+	}
+	if err := restrictMounts(request.Policy.Writable); err != nil {
+		return helperError("%v", err)
+	}
+
+	// Enter the directory again so that it resolves through the new mounts
+	dir := request.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if err := os.Chdir(dir); err != nil {
+		return helperError("failed to enter %s: %v", dir, err)
+	}
+	if err := setLimits(request.Policy); err != nil {
+		return helperError("%v", err)
+	}
+
+	cmd := &exec.Cmd{
+		Path:   request.Path,
+		Args:   request.Args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags:                 syscall.CLONE_NEWUSER,
+			UidMappings:                []syscall.SysProcIDMap{{ContainerID: request.UID, HostID: 0, Size: 1}},
+			GidMappings:                []syscall.SysProcIDMap{{ContainerID: request.GID, HostID: 0, Size: 1}},
+			GidMappingsEnableSetgroups: false,
+		},
+	}
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "dizi sandbox: %v\n", err)
+			return 127
+		}
+		return helperError("failed to start %s: %v", request.Path, err)
+	}
+	cmd.Wait()
+
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return cmd.ProcessState.ExitCode()
+	}
+	signal := status.Signal()
+	used := cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	if limit := request.Policy.CPU; limit > 0 && (signal == syscall.SIGXCPU || (signal == syscall.SIGKILL && used >= limit)) {
+		fmt.Fprintf(os.Stderr, "dizi sandbox: process exceeded its CPU time limit of %s\n", limit)
+	} else {
+		fmt.Fprintf(os.Stderr, "dizi sandbox: process killed by signal: %v\n", signal)
+	}
+	return 128 + int(signal)
+}
+
+// restrictMounts keeps writable writable and remounts everything else
+// read-only, in the helper's own mount namespace
+func restrictMounts(writable []string) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private (are user namespaces enabled?): %w", err)
+	}
+	for _, path := range writable {
+		if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to keep %s writable: %w", path, err)
+		}
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		// The helper writes the ID maps of the command to /proc; the rest of
+		// it is only writable by the real root anyway
+		if isWithin(m.point, "/proc") || slices.ContainsFunc(writable, func(path string) bool { return isWithin(m.point, path) }) {
+			continue
+		}
+		err := unix.Mount("", m.point, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|m.flags, "")
+		if err != nil && !isPseudoFS(m.point) {
+			return fmt.Errorf("failed to make %s read-only: %w", m.point, err)
+		}
+	}
+	return nil
+}
+
+// mount is an entry of /proc/self/mountinfo
+type mount struct {
+	point string
+	flags uintptr // per-mount flags that a remount must keep
+}
+
+// keptFlags are the per-mount options a remount inside a user namespace
+// may not drop
+var keptFlags = map[string]uintptr{
+	"nosuid":     unix.MS_NOSUID,
+	"nodev":      unix.MS_NODEV,
+	"noexec":     unix.MS_NOEXEC,
+	"noatime":    unix.MS_NOATIME,
+	"nodiratime": unix.MS_NODIRATIME,
+	"relatime":   unix.MS_RELATIME,
+}
+
+// readMounts lists the mounts of the helper's mount namespace
+func readMounts() ([]mount, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mounts: %w", err)
+	}
+	defer file.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		m := mount{point: unescapeMountPath(fields[4])}
+		for _, option := range strings.Split(fields[5], ",") {
+			m.flags |= keptFlags[option]
+		}
+		mounts = append(mounts, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list mounts: %w", err)
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes mountinfo uses for spaces,
+// tabs, newlines and backslashes
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// isPseudoFS reports whether point belongs to the kernel's pseudo file
+// systems, some of which refuse remounts inside a user namespace
+func isPseudoFS(point string) bool {
+	return isWithin(point, "/sys") || isWithin(point, "/dev")
+}
+
+// setLimits applies the resource limits of policy; the command inherits them
+func setLimits(policy Policy) error {
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		// One second of grace between SIGXCPU and SIGKILL
+		{"CPU time", unix.RLIMIT_CPU, uint64((policy.CPU + 999_999_999) / 1_000_000_000)},
+		{"memory", unix.RLIMIT_AS, policy.MemoryBytes},
+		{"process", unix.RLIMIT_NPROC, policy.Processes},
+	}
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		max := limit.value
+		if limit.resource == unix.RLIMIT_CPU {
+			max++
+		}
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.value, Max: max}); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", limit.name, err)
+		}
+	}
+	return nil
+}
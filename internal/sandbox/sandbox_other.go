@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Wrap fails: the sandbox needs Linux namespaces
+func Wrap(ctx context.Context, cmd *exec.Cmd, policy Policy) error {
+	return fmt.Errorf("sandboxed tools are only supported on Linux, not %s", runtime.GOOS)
+}
+
+func runHelper(string) int {
+	return helperError("not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// runSandboxed runs script with sh inside a sandbox with policy
+func runSandboxed(t *testing.T, dir string, policy Policy, script string) (string, int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script)
+	cmd.Dir = dir
+	if err := Wrap(ctx, cmd, policy); err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	output, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("Failed to run sandboxed command: %v", err)
+	}
+	return string(output), cmd.ProcessState.ExitCode()
+}
+
+// requireSandbox skips the test where user namespaces are not available
+func requireSandbox(t *testing.T) {
+	t.Helper()
+	if output, code := runSandboxed(t, "", Policy{}, "true"); code != 0 {
+		t.Skipf("Sandbox not available here: %s", output)
+	}
+}
+
+func TestSandboxFileSystem(t *testing.T) {
+	requireSandbox(t)
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "build"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "main.c"), []byte("int main;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, code := runSandboxed(t, root, Policy{Writable: []string{filepath.Join(root, "build")}}, `
+cat main.c
+echo out > build/out.txt && echo build ok
+echo tmp > "$TMPDIR/scratch" && echo tmp ok
+echo sneaky > main.c || echo main.c protected
+echo sneaky > "$HOME/.dizi-sandbox-test" || echo home protected
+`)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, output)
+	}
+	for _, expected := range []string{"int main;", "build ok", "tmp ok", "main.c protected", "home protected", "Read-only file system"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output, got %q", expected, output)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "main.c")); string(data) != "int main;\n" {
+		t.Errorf("Expected main.c to be unchanged, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "build", "out.txt")); string(data) != "out\n" {
+		t.Errorf("Expected build/out.txt to be written, got %q", data)
+	}
+
+	// The command runs as the calling user and cannot undo the mounts
+	output, _ = runSandboxed(t, root, Policy{}, "id -u; grep CapEff /proc/self/status; mount -o remount,rw / 2>/dev/null || echo remount refused")
+	if !strings.HasPrefix(output, strconv.Itoa(os.Getuid())+"\n") || !strings.Contains(output, "remount refused") {
+		t.Errorf("Expected the calling user to be confined, got %q", output)
+	}
+	if os.Getuid() != 0 && !strings.Contains(output, "CapEff:\t0000000000000000") {
+		t.Errorf("Expected no capabilities, got %q", output)
+	}
+}
+
+func TestSandboxNetwork(t *testing.T) {
+	requireSandbox(t)
+	interfaces := "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '"
+
+	output, _ := runSandboxed(t, "", Policy{}, interfaces)
+	if strings.TrimSpace(output) != "lo" {
+		t.Errorf("Expected only loopback without network, got %q", output)
+	}
+
+	host, err := exec.Command("/bin/sh", "-c", interfaces).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, _ = runSandboxed(t, "", Policy{Network: true}, interfaces)
+	if output != string(host) {
+		t.Errorf("Expected the host interfaces %q with network, got %q", host, output)
+	}
+}
+
+func TestSandboxLimits(t *testing.T) {
+	requireSandbox(t)
+
+	output, code := runSandboxed(t, "", Policy{CPU: time.Second}, "while :; do :; done")
+	if code == 0 || !strings.Contains(output, "exceeded its CPU time limit of 1s") {
+		t.Errorf("Expected the CPU limit to stop the loop, got %d: %q", code, output)
+	}
+
+	output, _ = runSandboxed(t, "", Policy{MemoryBytes: 64 << 20}, "ulimit -v")
+	if strings.TrimSpace(output) != "65536" {
+		t.Errorf("Expected a 64 MiB address space limit, got %q", output)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	if got := unescapeMountPath(`/mnt/my\040disk\134x`); got != `/mnt/my disk\x` {
+		t.Errorf("Expected escapes to be decoded, got %q", got)
+	}
+}
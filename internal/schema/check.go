@@ -0,0 +1,155 @@
+// Package schema validates tool arguments against the JSON Schema subset used
+// for tool parameters in dizi.yml.
+// This file checks that a schema itself is well formed.
+package schema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// knownTypes are the JSON Schema type names
+var knownTypes = []string{"string", "number", "integer", "boolean", "object", "array", "null"}
+
+// Check reports problems in the schema s itself, such as unknown types,
+// keywords with values of the wrong kind, invalid patterns or defaults that
+// do not match their own schema. Violation paths address keywords within the
+// schema, e.g. "properties.board.type" or "required[1]".
+func Check(s map[string]interface{}) []Violation {
+	var violations []Violation
+	check(s, "", &violations)
+	return violations
+}
+
+// check appends the problems of schema s located at path to violations
+func check(s map[string]interface{}, path string, violations *[]Violation) {
+	before := len(*violations)
+	report := func(keyword, format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: join(path, keyword), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if raw, ok := s["type"]; ok {
+		types := StringList(raw)
+		if len(types) == 0 {
+			report("type", "must be a type name or a list of type names")
+		}
+		for _, t := range types {
+			if !contains(knownTypes, t) {
+				report("type", "unknown type %q, expected one of %s", t, strings.Join(knownTypes, ", "))
+			}
+		}
+	}
+
+	if raw, ok := s["properties"]; ok {
+		properties, isMap := raw.(map[string]interface{})
+		if !isMap {
+			report("properties", "must be a mapping of property names to schemas")
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propSchema, isMap := properties[name].(map[string]interface{})
+			if !isMap {
+				report("properties."+name, "must be a schema mapping")
+				continue
+			}
+			check(propSchema, join(path, "properties."+name), violations)
+		}
+	}
+
+	if raw, ok := s["required"]; ok {
+		items := toSlice(raw)
+		if items == nil {
+			report("required", "must be a list of property names")
+		}
+		for i, item := range items {
+			if _, isString := item.(string); !isString {
+				report(fmt.Sprintf("required[%d]", i), "must be a property name")
+			}
+		}
+	}
+
+	if raw, ok := s["enum"]; ok {
+		if items := toSlice(raw); len(items) == 0 {
+			report("enum", "must be a non-empty list")
+		}
+	}
+
+	if raw, ok := s["pattern"]; ok {
+		pattern, isString := raw.(string)
+		if !isString {
+			report("pattern", "must be a string")
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			report("pattern", "invalid regular expression: %v", err)
+		}
+	}
+
+	for _, keyword := range []string{"minLength", "maxLength", "minItems", "maxItems"} {
+		if raw, ok := s[keyword]; ok {
+			if n, isNumber := toFloat(raw); !isNumber || n < 0 || n != math.Trunc(n) {
+				report(keyword, "must be a non-negative integer")
+			}
+		}
+	}
+
+	for _, keyword := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+		if raw, ok := s[keyword]; ok {
+			if _, isNumber := toFloat(raw); !isNumber {
+				report(keyword, "must be a number")
+			}
+		}
+	}
+
+	if raw, ok := s["multipleOf"]; ok {
+		if n, isNumber := toFloat(raw); !isNumber || n <= 0 {
+			report("multipleOf", "must be a positive number")
+		}
+	}
+
+	if raw, ok := s["uniqueItems"]; ok {
+		if _, isBool := raw.(bool); !isBool {
+			report("uniqueItems", "must be true or false")
+		}
+	}
+
+	if raw, ok := s["items"]; ok {
+		if items, isMap := raw.(map[string]interface{}); isMap {
+			check(items, join(path, "items"), violations)
+		} else {
+			report("items", "must be a schema mapping")
+		}
+	}
+
+	if raw, ok := s["additionalProperties"]; ok {
+		switch additional := raw.(type) {
+		case bool:
+		case map[string]interface{}:
+			check(additional, join(path, "additionalProperties"), violations)
+		default:
+			report("additionalProperties", "must be true, false or a schema mapping")
+		}
+	}
+
+	// Only check the default once the schema it is checked against is sound
+	if def, ok := s["default"]; ok && len(*violations) == before {
+		for _, v := range Validate(s, def) {
+			report("default", "%s", v.String())
+		}
+	}
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
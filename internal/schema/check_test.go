@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	if violations := Check(testSchema()); len(violations) != 0 {
+		t.Errorf("Expected valid schema, got %v", violations)
+	}
+
+	tests := []struct {
+		name     string
+		schema   map[string]interface{}
+		path     string
+		contains string
+	}{
+		{"unknown type", map[string]interface{}{"type": "strng"}, "type", "unknown type"},
+		{"properties not a mapping", map[string]interface{}{"properties": "board"}, "properties", "mapping"},
+		{"property not a schema", map[string]interface{}{"properties": map[string]interface{}{"board": "string"}}, "properties.board", "schema mapping"},
+		{"nested unknown type", map[string]interface{}{"properties": map[string]interface{}{"board": map[string]interface{}{"type": "text"}}}, "properties.board.type", "unknown type"},
+		{"required not a list", map[string]interface{}{"required": "board"}, "required", "list"},
+		{"required entry", map[string]interface{}{"required": []interface{}{"board", 3}}, "required[1]", "property name"},
+		{"empty enum", map[string]interface{}{"enum": []interface{}{}}, "enum", "non-empty"},
+		{"bad pattern", map[string]interface{}{"pattern": "(["}, "pattern", "regular expression"},
+		{"negative length", map[string]interface{}{"minLength": -1}, "minLength", "non-negative integer"},
+		{"minimum not a number", map[string]interface{}{"minimum": "1"}, "minimum", "number"},
+		{"items not a schema", map[string]interface{}{"items": "string"}, "items", "schema mapping"},
+		{"additionalProperties", map[string]interface{}{"additionalProperties": "no"}, "additionalProperties", "true, false"},
+		{"default mismatch", map[string]interface{}{"type": "integer", "default": "one"}, "default", "must be of type integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := Check(tt.schema)
+			if len(violations) != 1 {
+				t.Fatalf("Expected 1 violation, got %v", violations)
+			}
+			if violations[0].Path != tt.path {
+				t.Errorf("Expected path %q, got %q", tt.path, violations[0].Path)
+			}
+			if !strings.Contains(violations[0].Message, tt.contains) {
+				t.Errorf("Expected message containing %q, got %q", tt.contains, violations[0].Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+// Package schema validates tool arguments against the JSON Schema subset used
+// for tool parameters in dizi.yml. Schemas are the generic maps produced by the
+// YAML decoder (or built in Go code), not pre-compiled structures.
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation describes one way in which a value fails its schema
+type Violation struct {
+	Path    string `json:"path"` // location of the offending value, e.g. "options.board" or "files[2]"; empty for the root
+	Message string `json:"message"`
+}
+
+// String formats the violation as "path: message"
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// ApplyDefaults fills in the "default" of every declared property that is
+// missing from value, recursing into nested objects. value is modified in
+// place; defaults are copied into it, so s itself is never changed.
+func ApplyDefaults(s map[string]interface{}, value interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	for name, raw := range properties {
+		propSchema, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, present := object[name]; !present {
+			if def, ok := propSchema["default"]; ok {
+				object[name] = copyValue(def)
+			}
+		}
+		if nested, ok := object[name]; ok {
+			ApplyDefaults(propSchema, nested)
+		}
+	}
+}
+
+// copyValue returns a deep copy of the maps and slices in value
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	}
+	if items := toSlice(value); items != nil {
+		copied := make([]interface{}, len(items))
+		for i, item := range items {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// Validate checks value against s and returns every violation found
+func Validate(s map[string]interface{}, value interface{}) []Violation {
+	var violations []Violation
+	validate(s, value, "", &violations)
+	return violations
+}
+
+// validate appends the violations of value against s to violations
+func validate(s map[string]interface{}, value interface{}, path string, violations *[]Violation) {
+	report := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := StringList(s["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if hasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			report("must be of type %s, got %s", strings.Join(types, " or "), typeName(value))
+			// Further keywords would only produce noise for a value of the wrong type
+			return
+		}
+	}
+
+	if enum, ok := s["enum"]; ok {
+		values := toSlice(enum)
+		found := false
+		for _, allowed := range values {
+			if equal(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("must be one of %s", formatList(values))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		validateString(s, v, report)
+	case map[string]interface{}:
+		validateObject(s, v, path, violations)
+	default:
+		if n, ok := toFloat(value); ok {
+			validateNumber(s, n, report)
+		} else if items := toSlice(value); items != nil {
+			validateArray(s, items, path, violations)
+		}
+	}
+}
+
+// validateString checks the string keywords
+func validateString(s map[string]interface{}, v string, report func(string, ...interface{})) {
+	length := utf8.RuneCountInString(v)
+	if min, ok := toFloat(s["minLength"]); ok && float64(length) < min {
+		report("must be at least %v characters long", min)
+	}
+	if max, ok := toFloat(s["maxLength"]); ok && float64(length) > max {
+		report("must be at most %v characters long", max)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			report("schema pattern %q is invalid: %v", pattern, err)
+		} else if !re.MatchString(v) {
+			report("must match pattern %q", pattern)
+		}
+	}
+}
+
+// validateNumber checks the numeric keywords
+func validateNumber(s map[string]interface{}, v float64, report func(string, ...interface{})) {
+	if min, ok := toFloat(s["minimum"]); ok && v < min {
+		report("must be >= %v", min)
+	}
+	if max, ok := toFloat(s["maximum"]); ok && v > max {
+		report("must be <= %v", max)
+	}
+	if min, ok := toFloat(s["exclusiveMinimum"]); ok && v <= min {
+		report("must be > %v", min)
+	}
+	if max, ok := toFloat(s["exclusiveMaximum"]); ok && v >= max {
+		report("must be < %v", max)
+	}
+	if m, ok := toFloat(s["multipleOf"]); ok && m > 0 {
+		if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			report("must be a multiple of %v", m)
+		}
+	}
+}
+
+// validateArray checks the array keywords and every item
+func validateArray(s map[string]interface{}, items []interface{}, path string, violations *[]Violation) {
+	report := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if min, ok := toFloat(s["minItems"]); ok && float64(len(items)) < min {
+		report("must contain at least %v items", min)
+	}
+	if max, ok := toFloat(s["maxItems"]); ok && float64(len(items)) > max {
+		report("must contain at most %v items", max)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if equal(items[i], items[j]) {
+					report("items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	if itemSchema, ok := s["items"].(map[string]interface{}); ok {
+		for i, item := range items {
+			validate(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	}
+}
+
+// validateObject checks required and declared properties, and additionalProperties
+func validateObject(s map[string]interface{}, object map[string]interface{}, path string, violations *[]Violation) {
+	for _, name := range StringList(s["required"]) {
+		if _, ok := object[name]; !ok {
+			*violations = append(*violations, Violation{Path: join(path, name), Message: "is required"})
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]interface{})
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if propSchema, ok := properties[name].(map[string]interface{}); ok {
+			validate(propSchema, object[name], join(path, name), violations)
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*violations = append(*violations, Violation{Path: join(path, name), Message: "is not an allowed property"})
+			}
+		case map[string]interface{}:
+			validate(additional, object[name], join(path, name), violations)
+		}
+	}
+}
+
+// hasType reports whether value is an instance of the JSON Schema type t
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		n, ok := toFloat(value)
+		return ok && n == math.Trunc(n)
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		return toSlice(value) != nil
+	case "null":
+		return value == nil
+	}
+	// Unknown types are a schema problem, not an argument problem
+	return true
+}
+
+// typeName returns the JSON type name of value for error messages
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	}
+	if n, ok := toFloat(value); ok {
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	if toSlice(value) != nil {
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// toFloat converts any Go numeric type to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// toSlice converts any Go slice to []interface{}, returning nil for non-slices
+func toSlice(value interface{}) []interface{} {
+	if items, ok := value.([]interface{}); ok {
+		return items
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items
+}
+
+// StringList returns a schema keyword that may be a single string or a list
+// of strings (such as "type" or "required") as a string slice
+func StringList(value interface{}) []string {
+	if s, ok := value.(string); ok {
+		return []string{s}
+	}
+	var list []string
+	for _, item := range toSlice(value) {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// equal compares two JSON values, treating numbers of different Go types as equal
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// formatList renders enum values for error messages
+func formatList(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			parts[i] = fmt.Sprintf("%q", s)
+		} else {
+			parts[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// join appends a property name to a path
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,192 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"board": map[string]interface{}{
+				"type":    "string",
+				"pattern": "^[a-z0-9_]+$",
+			},
+			"source_dir": map[string]interface{}{
+				"type":    "string",
+				"default": ".",
+			},
+			"jobs": map[string]interface{}{
+				"type":    "integer",
+				"minimum": 1,
+				"maximum": 64,
+			},
+			"mode": map[string]interface{}{
+				"type": "string",
+				"enum": []interface{}{"debug", "release"},
+			},
+			"files": map[string]interface{}{
+				"type":     "array",
+				"items":    map[string]interface{}{"type": "string"},
+				"maxItems": 2,
+			},
+		},
+		"required": []interface{}{"board"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		expected  []string
+	}{
+		{
+			name:      "valid arguments",
+			arguments: map[string]interface{}{"board": "nrf52840dk", "jobs": float64(8), "mode": "debug"},
+			expected:  nil,
+		},
+		{
+			name:      "missing required",
+			arguments: map[string]interface{}{},
+			expected:  []string{"board: is required"},
+		},
+		{
+			name:      "wrong type",
+			arguments: map[string]interface{}{"board": float64(42)},
+			expected:  []string{"board: must be of type string, got integer"},
+		},
+		{
+			name:      "integer with fraction",
+			arguments: map[string]interface{}{"board": "esp32", "jobs": 1.5},
+			expected:  []string{"jobs: must be of type integer, got number"},
+		},
+		{
+			name:      "out of range",
+			arguments: map[string]interface{}{"board": "esp32", "jobs": float64(0)},
+			expected:  []string{"jobs: must be >= 1"},
+		},
+		{
+			name:      "enum and pattern",
+			arguments: map[string]interface{}{"board": "ESP32!", "mode": "fast"},
+			expected:  []string{`board: must match pattern "^[a-z0-9_]+$"`, `mode: must be one of ["debug", "release"]`},
+		},
+		{
+			name:      "array items",
+			arguments: map[string]interface{}{"board": "esp32", "files": []interface{}{"a", true, "c"}},
+			expected:  []string{"files: must contain at most 2 items", "files[1]: must be of type string, got boolean"},
+		},
+		{
+			name:      "every violation reported",
+			arguments: map[string]interface{}{"jobs": float64(100), "mode": "fast"},
+			expected:  []string{"board: is required", "jobs: must be <= 64", `mode: must be one of ["debug", "release"]`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := Validate(testSchema(), tt.arguments)
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Expected violations %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateAdditionalProperties(t *testing.T) {
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+		"additionalProperties": false,
+	}
+
+	violations := Validate(s, map[string]interface{}{"name": "x", "extra": 1})
+	if len(violations) != 1 || violations[0].String() != "extra: is not an allowed property" {
+		t.Errorf("Expected additional property violation, got %v", violations)
+	}
+}
+
+func TestValidateGoTypedSchema(t *testing.T) {
+	// Schemas built in Go code use []string rather than []interface{}
+	s := map[string]interface{}{
+		"type":     "object",
+		"required": []string{"message"},
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": []string{"string", "null"}},
+		},
+	}
+
+	if violations := Validate(s, map[string]interface{}{"message": nil}); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+	if violations := Validate(s, map[string]interface{}{}); len(violations) != 1 {
+		t.Errorf("Expected one violation, got %v", violations)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	arguments := map[string]interface{}{"board": "esp32"}
+	ApplyDefaults(testSchema(), arguments)
+
+	if arguments["source_dir"] != "." {
+		t.Errorf("Expected default source_dir '.', got %v", arguments["source_dir"])
+	}
+	if _, ok := arguments["jobs"]; ok {
+		t.Error("Expected no value for property without default")
+	}
+
+	arguments = map[string]interface{}{"board": "esp32", "source_dir": "app"}
+	ApplyDefaults(testSchema(), arguments)
+	if arguments["source_dir"] != "app" {
+		t.Errorf("Expected explicit source_dir to be kept, got %v", arguments["source_dir"])
+	}
+}
+
+func TestApplyDefaultsCopiesDefaults(t *testing.T) {
+	s := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"options": map[string]interface{}{
+				"type":    "object",
+				"default": map[string]interface{}{"flags": []interface{}{"-v"}},
+				"properties": map[string]interface{}{
+					"board": map[string]interface{}{"type": "string", "default": "esp32"},
+				},
+			},
+		},
+	}
+	original := copyValue(s)
+
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = map[string]interface{}{}
+			ApplyDefaults(s, results[i])
+		}()
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(s, original) {
+		t.Errorf("Expected the schema to be left unchanged, got %v", s)
+	}
+	for _, arguments := range results {
+		options, _ := arguments["options"].(map[string]interface{})
+		if options["board"] != "esp32" || len(options["flags"].([]interface{})) != 1 {
+			t.Errorf("Expected the default options with the nested default filled in, got %v", arguments)
+		}
+	}
+	results[0]["options"].(map[string]interface{})["flags"].([]interface{})[0] = "-q"
+	if results[1]["options"].(map[string]interface{})["flags"].([]interface{})[0] != "-v" {
+		t.Error("Expected every call to get its own copy of the default")
+	}
+}
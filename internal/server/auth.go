@@ -0,0 +1,132 @@
+// Package server serves the MCP server over HTTP, with SSE and streamable
+// HTTP transports on one port.
+// This file requires a bearer token on every request once auth is
+// configured and limits each request to the tools its token may use.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dizi/internal/approval"
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/logger"
+	"dizi/internal/profile"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ResourceMetadataPath describes the server to OAuth clients when JWTs are
+// accepted (RFC 9728)
+const ResourceMetadataPath = "/.well-known/oauth-protected-resource"
+
+// ScopeOptions returns the MCP server options that limit each request to
+// the tools its token and profile may use: other tools are left out of
+// tools/list and calls to them fail. Requests without a token or profile,
+// as over stdio without -profile, may use every tool.
+func ScopeOptions(profiles *profile.Set) []server.ServerOption {
+	return []server.ServerOption{
+		server.WithToolFilter(func(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+			if auth.FromContext(ctx).Unrestricted() && profile.FromContext(ctx) == "" {
+				return tools
+			}
+			allowed := make([]mcp.Tool, 0, len(tools))
+			for _, tool := range tools {
+				if refusal(ctx, profiles, tool.Name) == "" {
+					allowed = append(allowed, tool)
+				}
+			}
+			return allowed
+		}),
+		server.WithToolHandlerMiddleware(func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+			return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				if reason := refusal(ctx, profiles, request.Params.Name); reason != "" {
+					return mcp.NewToolResultError(reason), nil
+				}
+				return next(ctx, request)
+			}
+		}),
+	}
+}
+
+// refusal returns why the request of ctx may not use tool, "" if it may.
+// The profile of the token and the one asked for both apply, so asking for
+// a profile can only hide tools.
+func refusal(ctx context.Context, profiles *profile.Set, tool string) string {
+	identity := auth.FromContext(ctx)
+	if !identity.Allows(tool) {
+		return fmt.Sprintf("Token %s may not use tool %s", identity.Name, tool)
+	}
+	for _, name := range []string{identity.ProfileName(), profile.FromContext(ctx)} {
+		if !profiles.Allows(name, tool) {
+			return fmt.Sprintf("Profile %s does not include tool %s", name, tool)
+		}
+	}
+	return ""
+}
+
+// requireAuth rejects requests without a valid bearer token and passes the
+// identity of the others on in their context. The approval endpoint needs a
+// token that may use every tool, so that a client can't approve its own calls.
+func requireAuth(authenticators []auth.Authenticator, publishMetadata bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		challenge := `Bearer realm="dizi"`
+		if publishMetadata {
+			if r.URL.Path == ResourceMetadataPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			challenge += fmt.Sprintf(`, resource_metadata=%q`, baseURL(r)+ResourceMetadataPath)
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			return
+		}
+		identity, err := auth.Authenticate(authenticators, strings.TrimSpace(token))
+		if err != nil {
+			logger.ErrorLog("Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", challenge+`, error="invalid_token"`)
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, approval.Path) && !identity.Unrestricted() {
+			http.Error(w, fmt.Sprintf("Token %s is limited to some tools and may not approve calls", identity.Name), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	})
+}
+
+// resourceMetadata serves the OAuth protected resource metadata, naming the
+// issuer of the accepted JWTs as the authorization server
+func resourceMetadata(jwt config.JWTConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metadata := map[string]interface{}{
+			"resource":                 baseURL(r),
+			"bearer_methods_supported": []string{"header"},
+			"scopes_supported":         []string{auth.ScopePrefix + "*"},
+		}
+		if jwt.Issuer != "" {
+			metadata["authorization_servers"] = []string{jwt.Issuer}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(metadata)
+	}
+}
+
+// baseURL returns the URL of the server as the client reached it
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
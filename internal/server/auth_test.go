@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dizi/internal/approval"
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/tools"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestAuth(t *testing.T) {
+	cfg := &config.Config{Name: "test", Version: "1.0.0"}
+	cfg.Server.Auth.Tokens = []config.AuthToken{
+		{Name: "admin", Token: "admin-token"},
+		{Name: "ci", Token: "ci-token", Tools: []string{"build*"}},
+	}
+	authenticators, err := auth.New(cfg.Server.Auth)
+	if err != nil {
+		t.Fatalf("auth.New failed: %v", err)
+	}
+
+	hooks := &server.Hooks{}
+	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version, append(tools.ServerOptions(hooks), ScopeOptions(nil)...)...)
+	for _, name := range []string{"build", "zephyr_flash"} {
+		mcpServer.AddTool(mcp.NewTool(name), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("ran " + request.Params.Name), nil
+		})
+	}
+	approvals := approval.NewBroker(approval.Options{})
+	httpServer := httptest.NewServer(newHandler(cfg, mcpServer, hooks, Options{
+		Transports: []string{TransportSSE, TransportStreamableHTTP},
+		Handlers:   map[string]http.Handler{approval.Path: approvals.Handler()},
+		Auth:       authenticators,
+	}))
+	t.Cleanup(httpServer.Close)
+
+	get := func(path, token string) *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, httpServer.URL+path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		response.Body.Close()
+		return response
+	}
+	if response := get("/", ""); response.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(response.Header.Get("WWW-Authenticate"), "Bearer") {
+		t.Errorf("Expected 401 with a bearer challenge without a token, got %d %v", response.StatusCode, response.Header)
+	}
+	if response := get("/sse", "wrong"); response.StatusCode != http.StatusUnauthorized || !strings.Contains(response.Header.Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("Expected 401 for a wrong token, got %d", response.StatusCode)
+	}
+	if response := get("/", "admin-token"); response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 with a valid token, got %d", response.StatusCode)
+	}
+	if response := get(approval.Path, "ci-token"); response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a restricted token not to reach the approvals, got %d", response.StatusCode)
+	}
+	if response := get(approval.Path, "admin-token"); response.StatusCode != http.StatusOK {
+		t.Errorf("Expected the admin to reach the approvals, got %d", response.StatusCode)
+	}
+	if response := get(ResourceMetadataPath, ""); response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected no resource metadata without JWTs, got %d", response.StatusCode)
+	}
+
+	connect := func(token string) *client.Client {
+		t.Helper()
+		c, err := client.NewStreamableHttpClient(httpServer.URL+StreamablePath, transport.WithHTTPHeaders(map[string]string{"Authorization": "Bearer " + token}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		return c
+	}
+	call := func(c *client.Client, name string) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		result, err := c.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Failed to call %s: %v", name, err)
+		}
+		return result
+	}
+
+	ci := connect("ci-token")
+	listed, err := ci.ListTools(context.Background(), mcp.ListToolsRequest{})
+	if err != nil {
+		t.Fatalf("Failed to list tools: %v", err)
+	}
+	if len(listed.Tools) != 1 || listed.Tools[0].Name != "build" {
+		t.Errorf("Expected the ci token to see only build, got %+v", listed.Tools)
+	}
+	if result := call(ci, "zephyr_flash"); !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "Token ci may not use tool zephyr_flash") {
+		t.Errorf("Expected the ci token to be refused zephyr_flash, got %+v", result)
+	}
+	if result := call(ci, "build"); result.IsError {
+		t.Errorf("Expected the ci token to run build, got %+v", result)
+	}
+
+	admin := connect("admin-token")
+	if result := call(admin, "zephyr_flash"); result.IsError {
+		t.Errorf("Expected the admin token to run zephyr_flash, got %+v", result)
+	}
+}
+
+func TestResourceMetadata(t *testing.T) {
+	cfg := &config.Config{Name: "test", Version: "1.0.0"}
+	cfg.Server.Auth.JWT = config.JWTConfig{JWKS: "jwks.json", Issuer: "https://auth.example.com"}
+	static, _ := auth.NewStatic([]config.AuthToken{{Name: "admin", Token: "admin-token"}})
+	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version)
+	httpServer := httptest.NewServer(newHandler(cfg, mcpServer, &server.Hooks{}, Options{
+		Transports: []string{TransportStreamableHTTP},
+		Auth:       []auth.Authenticator{static},
+	}))
+	t.Cleanup(httpServer.Close)
+
+	response, err := http.Get(httpServer.URL + StreamablePath)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	response.Body.Close()
+	if challenge := response.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, `resource_metadata="`+httpServer.URL+ResourceMetadataPath+`"`) {
+		t.Errorf("Expected the challenge to point to the resource metadata, got %q", challenge)
+	}
+
+	response, err = http.Get(httpServer.URL + ResourceMetadataPath)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer response.Body.Close()
+	var metadata struct {
+		Resource             string   `json:"resource"`
+		AuthorizationServers []string `json:"authorization_servers"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		t.Fatalf("Failed to decode metadata: %v", err)
+	}
+	if metadata.Resource != httpServer.URL || len(metadata.AuthorizationServers) != 1 || metadata.AuthorizationServers[0] != "https://auth.example.com" {
+		t.Errorf("Expected the issuer as authorization server, got %+v", metadata)
+	}
+}
@@ -0,0 +1,70 @@
+// Package server serves the MCP server over HTTP, with SSE and streamable
+// HTTP transports on one port.
+// This file limits clients to the profile they ask for with ?profile=.
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"dizi/internal/profile"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// requestedProfile returns the profile r asks for with ?profile=, "" if none
+func requestedProfile(r *http.Request, profiles *profile.Set) (string, error) {
+	name := r.URL.Query().Get("profile")
+	if err := profiles.Check(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// withProfile passes the profile each request asks for on in its context.
+// Streamable HTTP clients send every request to the endpoint URL, so they
+// keep the profile of the URL they were given.
+func withProfile(profiles *profile.Set, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := requestedProfile(r, profiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name != "" {
+			r = r.WithContext(profile.WithProfile(r.Context(), name))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionProfiles remembers the profile of each SSE session, since only the
+// /sse request names it and messages are posted without it
+type sessionProfiles struct {
+	sessions sync.Map // session ID -> profile name
+}
+
+// register records the profile the /sse request of a new session asked for
+func (p *sessionProfiles) register(ctx context.Context, session server.ClientSession) {
+	if name := profile.FromContext(ctx); name != "" {
+		p.sessions.Store(session.SessionID(), name)
+	}
+}
+
+// unregister forgets a closed session
+func (p *sessionProfiles) unregister(ctx context.Context, session server.ClientSession) {
+	p.sessions.Delete(session.SessionID())
+}
+
+// contextFunc limits the messages of a session to its profile
+func (p *sessionProfiles) contextFunc(ctx context.Context, r *http.Request) context.Context {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return ctx
+	}
+	if name, ok := p.sessions.Load(session.SessionID()); ok {
+		return profile.WithProfile(ctx, name.(string))
+	}
+	return ctx
+}
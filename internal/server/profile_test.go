@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/profile"
+	"dizi/internal/tools"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestProfiles(t *testing.T) {
+	cfg := &config.Config{Name: "test", Version: "1.0.0"}
+	cfg.Tools = []config.ToolConfig{{Name: "build"}, {Name: "zephyr_flash", Tags: []string{"hardware"}}}
+	cfg.Profiles = map[string]config.ProfileConfig{
+		"ci":       {Tools: []string{"*"}, Exclude: []string{"zephyr_*"}},
+		"reviewer": {Tags: []string{config.TagReadOnly}},
+	}
+	cfg.Server.Auth.Tokens = []config.AuthToken{
+		{Name: "admin", Token: "admin-token"},
+		{Name: "bot", Token: "bot-token", Profile: "ci"},
+	}
+	profiles, err := profile.NewSet(cfg)
+	if err != nil {
+		t.Fatalf("NewSet failed: %v", err)
+	}
+	authenticators, err := auth.New(cfg.Server.Auth)
+	if err != nil {
+		t.Fatalf("auth.New failed: %v", err)
+	}
+
+	hooks := &server.Hooks{}
+	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version, append(tools.ServerOptions(hooks), ScopeOptions(profiles)...)...)
+	for _, name := range []string{"build", "zephyr_flash", "read_project_file"} {
+		mcpServer.AddTool(mcp.NewTool(name), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("ran " + request.Params.Name), nil
+		})
+	}
+	httpServer := httptest.NewServer(newHandler(cfg, mcpServer, hooks, Options{
+		Transports: []string{TransportSSE, TransportStreamableHTTP},
+		Auth:       authenticators,
+		Profiles:   profiles,
+	}))
+	t.Cleanup(httpServer.Close)
+
+	for _, path := range []string{"/sse?profile=reveiwer", StreamablePath + "?profile=reveiwer"} {
+		request, _ := http.NewRequest(http.MethodGet, httpServer.URL+path, nil)
+		request.Header.Set("Authorization", "Bearer admin-token")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown profile on %s, got %d", path, response.StatusCode)
+		}
+	}
+
+	headers := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	sse := func(token, query string) *client.Client {
+		t.Helper()
+		c, err := client.NewSSEMCPClient(httpServer.URL+"/sse"+query, transport.WithHeaders(headers(token)))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if err := c.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to start client: %v", err)
+		}
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		return c
+	}
+	streamable := func(token, query string) *client.Client {
+		t.Helper()
+		c, err := client.NewStreamableHttpClient(httpServer.URL+StreamablePath+query, transport.WithHTTPHeaders(headers(token)))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		return c
+	}
+	list := func(c *client.Client) string {
+		t.Helper()
+		listed, err := c.ListTools(context.Background(), mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list tools: %v", err)
+		}
+		var names []string
+		for _, tool := range listed.Tools {
+			names = append(names, tool.Name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	call := func(c *client.Client, name string) string {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = name
+		result, err := c.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Failed to call %s: %v", name, err)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	reviewer := sse("admin-token", "?profile=reviewer")
+	if tools := list(reviewer); tools != "read_project_file" {
+		t.Errorf("Expected the reviewer SSE session to see only read-only tools, got %s", tools)
+	}
+	if text := call(reviewer, "build"); text != "Profile reviewer does not include tool build" {
+		t.Errorf("Expected the reviewer to be refused build, got %q", text)
+	}
+	if text := call(reviewer, "read_project_file"); text != "ran read_project_file" {
+		t.Errorf("Expected the reviewer to read files, got %q", text)
+	}
+	if tools := list(sse("admin-token", "")); tools != "build,read_project_file,zephyr_flash" {
+		t.Errorf("Expected other SSE sessions to see every tool, got %s", tools)
+	}
+
+	bot := streamable("bot-token", "")
+	if tools := list(bot); tools != "build,read_project_file" {
+		t.Errorf("Expected the bot token to be limited to the ci profile, got %s", tools)
+	}
+	if text := call(bot, "zephyr_flash"); text != "Profile ci does not include tool zephyr_flash" {
+		t.Errorf("Expected the bot to be refused zephyr_flash, got %q", text)
+	}
+	if tools := list(streamable("bot-token", "?profile=reviewer")); tools != "read_project_file" {
+		t.Errorf("Expected ?profile= to narrow the token's profile, got %s", tools)
+	}
+}
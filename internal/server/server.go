@@ -0,0 +1,144 @@
+// Package server serves the MCP server over HTTP, with SSE and streamable
+// HTTP transports on one port.
+// This file starts the HTTP server and routes its endpoints.
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dizi/internal/config"
+	"dizi/internal/listener"
+	"dizi/internal/logger"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HTTP transports, selected with -transport
+const (
+	TransportSSE            = "sse"
+	TransportStreamableHTTP = "streamable-http"
+)
+
+// ParseTransports splits a comma-separated list of HTTP transports, such as
+// "sse,streamable-http"
+func ParseTransports(value string) ([]string, error) {
+	var transports []string
+	for _, transport := range strings.Split(value, ",") {
+		transport = strings.TrimSpace(transport)
+		switch transport {
+		case TransportSSE, TransportStreamableHTTP:
+			transports = append(transports, transport)
+		default:
+			return nil, fmt.Errorf("unsupported transport %q, expected stdio, %s or %s", transport, TransportSSE, TransportStreamableHTTP)
+		}
+	}
+	return transports, nil
+}
+
+// Start serves mcpServer over the transports in options until the listener
+// fails. hooks must be the hooks mcpServer was created with, which is how
+// new SSE sessions get their filesystem tools.
+func Start(cfg *config.Config, mcpServer *server.MCPServer, hooks *server.Hooks, options Options) error {
+	l, err := listener.Listen(options.Address, options.TLS)
+	if err != nil {
+		return err
+	}
+	base := listener.URL(options.Address, options.TLS != nil)
+	socket, unix := listener.SocketPath(options.Address)
+	if unix {
+		logger.InfoLog("Starting HTTP server on Unix socket %s, e.g. curl --unix-socket %s %s/", socket, socket, base)
+	} else {
+		logger.InfoLog("Starting HTTP server on %s", base)
+	}
+	if serves(options, TransportSSE) {
+		logger.InfoLog("SSE endpoint: %s/sse", base)
+		logger.InfoLog("With filesystem tools: %s/sse?include_fs_tools=true", base)
+	}
+	if serves(options, TransportStreamableHTTP) {
+		logger.InfoLog("Streamable HTTP endpoint: %s%s", base, StreamablePath)
+	}
+	if options.TLS != nil && options.TLS.ClientAuth == tls.RequireAndVerifyClientCert {
+		logger.InfoLog("Clients must present a certificate signed by server.tls.client_ca")
+	}
+
+	// A Unix socket is only open to the user running the server
+	if len(options.Auth) == 0 && !unix {
+		logger.InfoLog("No server.auth configured: anyone who can reach %s may call every tool", options.Address)
+	}
+
+	return http.Serve(l, newHandler(cfg, mcpServer, hooks, options))
+}
+
+// serves reports whether options include transport
+func serves(options Options, transport string) bool {
+	for _, t := range options.Transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// newHandler returns the handler of all endpoints of the HTTP server. The
+// transports share mcpServer, so clients of either see the same tools.
+func newHandler(cfg *config.Config, mcpServer *server.MCPServer, hooks *server.Hooks, options Options) http.Handler {
+	mux := http.NewServeMux()
+	endpoints := map[string]string{
+		"/": "Status endpoint",
+	}
+
+	if serves(options, TransportSSE) {
+		hooks.AddOnRegisterSession(registerSessionTools(mcpServer, options))
+		sessions := &sessionProfiles{}
+		hooks.AddOnRegisterSession(sessions.register)
+		hooks.AddOnUnregisterSession(sessions.unregister)
+
+		// Create SSE server with the shared MCP server
+		sseServer := server.NewSSEServer(mcpServer, server.WithSSEContextFunc(sessions.contextFunc))
+
+		// Handle SSE endpoint with the shared server
+		mux.HandleFunc("/sse", customSSEHandler(sseServer, options))
+
+		// Handle message endpoint
+		mux.Handle("/message", sseServer.MessageHandler())
+
+		endpoints["/sse"] = "SSE endpoint (supports ?include_fs_tools=true&fs_root=/path and ?profile=name)"
+		endpoints["/message"] = "Message endpoint"
+	}
+
+	if serves(options, TransportStreamableHTTP) {
+		mux.Handle(StreamablePath, withProfile(options.Profiles, newStreamableHandler(mcpServer)))
+		endpoints[StreamablePath] = "Streamable HTTP endpoint (sessions via Mcp-Session-Id, resumable with Last-Event-ID, supports ?profile=name)"
+	}
+
+	for pattern, handler := range options.Handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	publishMetadata := len(options.Auth) > 0 && cfg.Server.Auth.JWT.JWKS != ""
+	if publishMetadata {
+		mux.Handle("GET "+ResourceMetadataPath, resourceMetadata(cfg.Server.Auth.JWT))
+	}
+
+	// Add a simple status endpoint
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(map[string]interface{}{
+			"name":        cfg.Name,
+			"version":     cfg.Version,
+			"description": cfg.Description,
+			"endpoints":   endpoints,
+		})
+	})
+
+	if len(options.Auth) == 0 {
+		return mux
+	}
+	return requireAuth(options.Auth, publishMetadata, mux)
+}
@@ -1,32 +1,91 @@
-// Package server provides custom SSE handling with query parameter support.
+// Package server serves the MCP server over HTTP, with SSE and streamable
+// HTTP transports on one port.
+// This file handles the SSE transport and its query parameters.
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
-	"dizi/internal/config"
+	"dizi/internal/auth"
 	"dizi/internal/logger"
+	"dizi/internal/profile"
 	"dizi/internal/tools"
 
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// Options configures Start
+type Options struct {
+	// Address is where the server listens, host:port or unix:/path/to.sock
+	Address string
+	// TLS serves HTTPS if set
+	TLS *tls.Config
+	// Transports are the HTTP transports served, TransportSSE and/or
+	// TransportStreamableHTTP
+	Transports []string
+	// Filesystem holds the settings of the filesystem tools that sessions
+	// ask for with ?include_fs_tools; its RootDirectory is the default root
+	Filesystem tools.FilesystemConfig
+	// FsRoots are the directories ?fs_root may point into besides the default root
+	FsRoots []string
+	// Handlers are further endpoints served next to the transports, by pattern
+	Handlers map[string]http.Handler
+	// Auth checks the bearer token of every request; none leaves the server open
+	Auth []auth.Authenticator
+	// Profiles are the profiles clients may ask for with ?profile=
+	Profiles *profile.Set
+}
+
+// fsRootKey is the context key of the filesystem root a connection asked for
+type fsRootKey struct{}
 
-// customSSEHandler wraps the SSE server to handle query parameters
-func customSSEHandler(sseServer *server.SSEServer, enableFsTools bool, fsRootDir string) http.HandlerFunc {
+// customSSEHandler wraps the SSE server to handle query parameters:
+// ?include_fs_tools=true gives the session its own filesystem tools, rooted
+// at ?fs_root if given, and ?profile limits it to a profile. A root outside
+// the allowed directories or an unknown profile is refused before the
+// session starts.
+func customSSEHandler(sseServer *server.SSEServer, options Options) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parse query parameters for filesystem tools (if needed for future enhancement)
 		query := r.URL.Query()
 
-		// Log query parameters if filesystem tools are requested
-		if includeFsTools := query.Get("include_fs_tools"); includeFsTools != "" {
-			logger.InfoLog("SSE request with include_fs_tools=%s", includeFsTools)
+		include := false
+		if value := query.Get("include_fs_tools"); value != "" {
+			var err error
+			if include, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid include_fs_tools %q, expected true or false", value), http.StatusBadRequest)
+				return
+			}
+		}
+		requested := query.Get("fs_root")
+		if requested != "" && !include {
+			http.Error(w, "fs_root requires include_fs_tools=true", http.StatusBadRequest)
+			return
+		}
+
+		name, err := requestedProfile(r, options.Profiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name != "" {
+			r = r.WithContext(profile.WithProfile(r.Context(), name))
 		}
 
-		if rootDir := query.Get("fs_root"); rootDir != "" {
-			logger.InfoLog("SSE request with fs_root=%s", rootDir)
+		if include {
+			root, err := resolveFsRoot(requested, options)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			logger.InfoLog("SSE session with filesystem tools rooted at %s", root)
+			r = r.WithContext(context.WithValue(r.Context(), fsRootKey{}, root))
 		}
 
 		// Handle the SSE connection with the shared server instance
@@ -34,71 +93,58 @@ func customSSEHandler(sseServer *server.SSEServer, enableFsTools bool, fsRootDir
 	}
 }
 
-// StartCustomSSEServer starts the SSE server with custom handling
-func StartCustomSSEServer(cfg *config.Config, host string, port int, enableFsTools bool, fsRootDir string) error {
-	// Create a single MCP server instance to be shared
-	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version)
-
-	// Register basic tools
-	if err := tools.RegisterTools(mcpServer, cfg.Tools); err != nil {
-		return err
-	}
-
-	// Register filesystem tools if enabled
-	if enableFsTools {
-		fsConfig := &tools.FilesystemConfig{}
-
-		// Use custom root if provided, otherwise default to project directory
-		if fsRootDir != "" {
-			fsConfig.RootDirectory = fsRootDir
-		} else {
-			// Default to current working directory (project directory)
-			pwd, err := os.Getwd()
-			if err != nil {
-				fsConfig.RootDirectory = "."
-			} else {
-				fsConfig.RootDirectory = pwd
-			}
+// registerSessionTools gives a new session the filesystem tools its
+// connection asked for, with a FilesystemServer of its own
+func registerSessionTools(mcpServer *server.MCPServer, options Options) server.OnRegisterSessionHookFunc {
+	return func(ctx context.Context, session server.ClientSession) {
+		root, ok := ctx.Value(fsRootKey{}).(string)
+		if !ok {
+			return
 		}
 
-		if err := tools.RegisterFilesystemTools(mcpServer, fsConfig); err != nil {
-			return err
+		fsConfig := options.Filesystem
+		fsConfig.RootDirectory = root
+		serverTools, err := tools.FilesystemTools(&fsConfig)
+		if err == nil {
+			err = mcpServer.AddSessionTools(session.SessionID(), serverTools...)
+		}
+		if err != nil {
+			logger.ErrorLog("Failed to add filesystem tools to session %s: %v", session.SessionID(), err)
 		}
-
-		logger.InfoLog("Filesystem tools enabled with root: %s", fsConfig.RootDirectory)
 	}
+}
 
-	// Create SSE server with the shared MCP server
-	sseServer := server.NewSSEServer(mcpServer)
-
-	mux := http.NewServeMux()
-
-	// Handle SSE endpoint with the shared server
-	mux.HandleFunc("/sse", customSSEHandler(sseServer, enableFsTools, fsRootDir))
-
-	// Handle message endpoint
-	mux.Handle("/message", sseServer.MessageHandler())
-
-	// Add a simple status endpoint
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{
-			"name": "` + cfg.Name + `",
-			"version": "` + cfg.Version + `",
-			"description": "` + cfg.Description + `",
-			"endpoints": {
-				"/sse": "SSE endpoint (supports ?include_fs_tools=true&fs_root=/path)",
-				"/message": "Message endpoint",
-				"/": "Status endpoint"
-			}
-		}`))
-	})
+// resolveFsRoot returns the root for a session's filesystem tools: the
+// default root, or requested resolved against it. The root must be a
+// directory within the default root or one of options.FsRoots.
+func resolveFsRoot(requested string, options Options) (string, error) {
+	defaultRoot, err := filepath.Abs(options.Filesystem.RootDirectory)
+	if err != nil {
+		return "", fmt.Errorf("invalid filesystem root: %w", err)
+	}
+	if requested == "" {
+		return defaultRoot, nil
+	}
 
-	addr := host + ":" + strconv.Itoa(port)
-	logger.InfoLog("Starting custom SSE server on http://%s", addr)
-	logger.InfoLog("SSE endpoint: http://%s/sse", addr)
-	logger.InfoLog("With filesystem tools: http://%s/sse?include_fs_tools=true", addr)
+	root := requested
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(defaultRoot, root)
+	}
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid fs_root %s: %w", requested, err)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("invalid fs_root %s: not a directory", requested)
+	}
 
-	return http.ListenAndServe(addr, mux)
+	for _, allowed := range append([]string{defaultRoot}, options.FsRoots...) {
+		if allowedResolved, err := filepath.EvalSymlinks(allowed); err == nil {
+			allowed = allowedResolved
+		}
+		if rel, err := filepath.Rel(allowed, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("fs_root %s is outside the project root and server.fs_roots", requested)
 }
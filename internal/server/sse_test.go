@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"dizi/internal/config"
+	"dizi/internal/tools"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestResolveFsRoot(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(tempDir, "project")
+	shared := filepath.Join(tempDir, "shared")
+	for _, dir := range []string{filepath.Join(project, "docs"), filepath.Join(shared, "data"), filepath.Join(tempDir, "other")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(project, "file.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(tempDir, "other"), filepath.Join(project, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	options := Options{Filesystem: tools.FilesystemConfig{RootDirectory: project}, FsRoots: []string{shared}}
+
+	tests := []struct {
+		requested string
+		expected  string
+		err       string
+	}{
+		{"", project, ""},
+		{"docs", filepath.Join(project, "docs"), ""},
+		{filepath.Join(project, "docs"), filepath.Join(project, "docs"), ""},
+		{filepath.Join(shared, "data"), filepath.Join(shared, "data"), ""},
+		{"../shared", shared, ""},
+		{"../other", "", "outside the project root"},
+		{"escape", "", "outside the project root"},
+		{"../project-other", "", "no such file"},
+		{"file.txt", "", "not a directory"},
+		{"/", "", "outside the project root"},
+	}
+	for _, tt := range tests {
+		root, err := resolveFsRoot(tt.requested, options)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolveFsRoot(%q): expected error containing %q, got %v", tt.requested, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || root != tt.expected {
+			t.Errorf("resolveFsRoot(%q) = %q, %v; expected %q", tt.requested, root, err, tt.expected)
+		}
+	}
+}
+
+func TestSessionFilesystemTools(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "notes.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Name: "test", Version: "1.0.0"}
+	hooks := &server.Hooks{}
+	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version, tools.ServerOptions(hooks)...)
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	httpServer := httptest.NewServer(newHandler(cfg, mcpServer, hooks, Options{Transports: []string{TransportSSE}, Filesystem: tools.FilesystemConfig{RootDirectory: root}}))
+	t.Cleanup(httpServer.Close)
+
+	connect := func(query string) *client.Client {
+		t.Helper()
+		c, err := client.NewSSEMCPClient(httpServer.URL + "/sse" + query)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if err := c.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		return c
+	}
+	toolNames := func(c *client.Client) []string {
+		t.Helper()
+		result, err := c.ListTools(context.Background(), mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("Failed to list tools: %v", err)
+		}
+		var names []string
+		for _, tool := range result.Tools {
+			names = append(names, tool.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	plain := connect("")
+	withFs := connect("?include_fs_tools=true&fs_root=docs")
+
+	if names := toolNames(plain); len(names) != 1 || names[0] != "echo" {
+		t.Errorf("Expected only the configured tools without include_fs_tools, got %v", names)
+	}
+	if names := toolNames(withFs); len(names) != 1+len(config.FilesystemTools) {
+		t.Errorf("Expected the filesystem tools next to the configured tools, got %v", names)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "read_project_file"
+	request.Params.Arguments = map[string]interface{}{"path": "notes.txt"}
+	result, err := withFs.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Failed to call read_project_file: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; result.IsError || !strings.Contains(text, "hello") {
+		t.Errorf("Expected notes.txt read relative to fs_root, got %s", text)
+	}
+	if _, err := plain.CallTool(context.Background(), request); err == nil {
+		t.Error("Expected read_project_file to be unknown to a session without filesystem tools")
+	}
+
+	for query, status := range map[string]int{
+		"?include_fs_tools=maybe":                http.StatusBadRequest,
+		"?fs_root=docs":                          http.StatusBadRequest,
+		"?include_fs_tools=true&fs_root=..":      http.StatusForbidden,
+		"?include_fs_tools=true&fs_root=missing": http.StatusForbidden,
+	} {
+		response, err := http.Get(httpServer.URL + "/sse" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != status {
+			t.Errorf("GET /sse%s: expected status %d, got %d", query, status, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package server serves the MCP server over HTTP, with SSE and streamable
+// HTTP transports on one port.
+// This file handles the streamable HTTP transport: session IDs and
+// resumable streams on top of the mcp-go server.
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// StreamablePath is the single endpoint of the streamable HTTP transport
+	StreamablePath = "/mcp"
+	// sessionHeader carries the session ID of streamable HTTP requests
+	sessionHeader = "Mcp-Session-Id"
+	// historySize is how many events of a session's stream are kept for
+	// clients resuming it
+	historySize = 100
+	// historyBytes bounds the size of the events kept per session
+	historyBytes = 1 << 20
+	// sessionIdleTimeout is how long a session is kept without requests or
+	// an open stream
+	sessionIdleTimeout = time.Hour
+	// initializeBytes bounds the body of a POST without a session, which is
+	// read before the server sees it
+	initializeBytes = 1 << 20
+)
+
+// newStreamableHandler returns the handler of the streamable HTTP endpoint.
+// Sessions are tracked, so requests for an unknown, terminated or expired
+// session get 404 and the client starts a new one, and a client that lost
+// its GET stream can reconnect with Last-Event-ID to get the events it missed.
+func newStreamableHandler(mcpServer *server.MCPServer) http.Handler {
+	history := &eventHistory{sessions: make(map[string]*sessionEvents)}
+	sessions := &sessionManager{sessions: make(map[string]*sessionState), idle: sessionIdleTimeout, onTerminate: history.forget}
+	streamable := server.NewStreamableHTTPServer(mcpServer,
+		server.WithEndpointPath(StreamablePath),
+		server.WithSessionIdManager(sessions),
+	)
+	// An expired session is deleted as if by its client, so that mcp-go
+	// forgets it too
+	sessions.expire = func(id string) {
+		request := httptest.NewRequest(http.MethodDelete, StreamablePath, nil)
+		request.Header.Set(sessionHeader, id)
+		streamable.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	return resumableStreams(streamable, sessions, history)
+}
+
+// sessionManager issues the Mcp-Session-Id of streamable HTTP sessions and
+// remembers them until the client deletes them or leaves them idle
+type sessionManager struct {
+	mu          sync.Mutex
+	sessions    map[string]*sessionState
+	idle        time.Duration // sessions unused for longer expire
+	onTerminate func(id string)
+	expire      func(id string) // ends an expired session, Terminate if nil
+}
+
+// sessionState is what is known about the use of a session
+type sessionState struct {
+	lastUsed time.Time
+	open     int // requests and GET streams in progress, which keep the session in use
+}
+
+// Generate starts a new session, expiring those left idle
+func (m *sessionManager) Generate() string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	id := "mcp-session-" + hex.EncodeToString(random)
+
+	m.mu.Lock()
+	m.sessions[id] = &sessionState{lastUsed: time.Now()}
+	expired := m.expiredLocked()
+	m.mu.Unlock()
+
+	m.expireAll(expired)
+	return id
+}
+
+// Validate reports an unknown or expired session as terminated, which the
+// server answers with 404, and marks the others as used
+func (m *sessionManager) Validate(id string) (isTerminated bool, err error) {
+	if id == "" {
+		return false, fmt.Errorf("missing %s header", sessionHeader)
+	}
+	m.mu.Lock()
+	state, ok := m.sessions[id]
+	if ok && m.expiredStateLocked(state) {
+		delete(m.sessions, id)
+		m.mu.Unlock()
+		m.expireAll([]string{id})
+		return true, nil
+	}
+	if ok {
+		state.lastUsed = time.Now()
+	}
+	m.mu.Unlock()
+	return !ok, nil
+}
+
+// use keeps a session in use while a request or GET stream is open and
+// returns a function to call when it ends
+func (m *sessionManager) use(id string) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.sessions[id]
+	if !ok || m.expiredStateLocked(state) {
+		return func() {} // left for Validate to expire
+	}
+	state.open++
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		state.open--
+		state.lastUsed = time.Now()
+	}
+}
+
+// expiredLocked removes the sessions left idle and returns their IDs
+func (m *sessionManager) expiredLocked() []string {
+	var expired []string
+	for id, state := range m.sessions {
+		if m.expiredStateLocked(state) {
+			delete(m.sessions, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// expiredStateLocked reports whether a session has been idle too long
+func (m *sessionManager) expiredStateLocked(state *sessionState) bool {
+	return m.idle > 0 && state.open == 0 && time.Since(state.lastUsed) > m.idle
+}
+
+// expireAll ends the sessions with the given IDs
+func (m *sessionManager) expireAll(ids []string) {
+	for _, id := range ids {
+		if m.expire != nil {
+			m.expire(id)
+		} else {
+			_, _ = m.Terminate(id)
+		}
+	}
+}
+
+// Terminate ends a session when the client deletes it
+func (m *sessionManager) Terminate(id string) (isNotAllowed bool, err error) {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if m.onTerminate != nil {
+		m.onTerminate(id)
+	}
+	return false, nil
+}
+
+// eventHistory keeps the last events sent on each session's GET stream
+type eventHistory struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionEvents
+}
+
+// sessionEvents are the recent events of one session, oldest first
+type sessionEvents struct {
+	lastID uint64
+	events []sseEvent
+	size   int // bytes of data in events
+}
+
+// sseEvent is an event as written to the stream, without its id line
+type sseEvent struct {
+	id   uint64
+	data []byte
+}
+
+// record stores an event of a session and returns its ID
+func (h *eventHistory) record(session string, data []byte) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events, ok := h.sessions[session]
+	if !ok {
+		events = &sessionEvents{}
+		h.sessions[session] = events
+	}
+	events.lastID++
+	events.events = append(events.events, sseEvent{id: events.lastID, data: append([]byte(nil), data...)})
+	events.size += len(data)
+	// Keep at least the latest event, however large
+	for len(events.events) > 1 && (len(events.events) > historySize || events.size > historyBytes) {
+		events.size -= len(events.events[0].data)
+		events.events[0] = sseEvent{}
+		events.events = events.events[1:]
+	}
+	return events.lastID
+}
+
+// since returns the events of a session after lastID that are still kept
+func (h *eventHistory) since(session string, lastID uint64) []sseEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events, ok := h.sessions[session]
+	if !ok {
+		return nil
+	}
+	var missed []sseEvent
+	for _, event := range events.events {
+		if event.id > lastID {
+			missed = append(missed, event)
+		}
+	}
+	return missed
+}
+
+// forget drops the events of a terminated or expired session
+func (h *eventHistory) forget(session string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, session)
+}
+
+// resumableStreams numbers the events of GET streams that belong to a
+// session and replays those after Last-Event-ID when the client reconnects.
+// Events the server sent while no stream was connected are not kept.
+func resumableStreams(next http.Handler, sessions *sessionManager, history *eventHistory) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := r.Header.Get(sessionHeader)
+		if session == "" && r.Method == http.MethodPost {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, initializeBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("requests without %s are limited to %d bytes", sessionHeader, initializeBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil && !initializes(body) {
+				http.Error(w, fmt.Sprintf("missing %s header", sessionHeader), http.StatusBadRequest)
+				return
+			}
+		}
+		if r.Method != http.MethodGet || session == "" {
+			if session != "" {
+				defer sessions.use(session)()
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if terminated, err := sessions.Validate(session); err != nil || terminated {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		defer sessions.use(session)()
+
+		var replay []sseEvent
+		if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+			id, err := strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", lastEventID), http.StatusBadRequest)
+				return
+			}
+			replay = history.since(session, id)
+		}
+
+		next.ServeHTTP(&eventWriter{ResponseWriter: w, session: session, history: history, replay: replay}, r)
+	})
+}
+
+// initializes reports whether the body of a POST starts a session, the only
+// request that may come without one. A body that isn't a JSON-RPC request is
+// left for the server to reject.
+func initializes(body []byte) bool {
+	var message struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return true
+	}
+	return message.Method == "" || message.Method == "initialize"
+}
+
+// eventWriter gives each event written to a stream an ID and records it
+type eventWriter struct {
+	http.ResponseWriter
+	session   string
+	history   *eventHistory
+	replay    []sseEvent
+	streaming bool
+	pending   []byte
+}
+
+// WriteHeader starts the stream with the events the client missed
+func (w *eventWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if code >= 300 {
+		return
+	}
+	w.streaming = true
+	for _, event := range w.replay {
+		if _, err := fmt.Fprintf(w.ResponseWriter, "id: %d\n%s", event.id, event.data); err != nil {
+			break
+		}
+	}
+	w.replay = nil
+}
+
+// Write records complete events before passing them on; an event that
+// can't be delivered is kept for the client's next connection
+func (w *eventWriter) Write(p []byte) (int, error) {
+	if !w.streaming {
+		return w.ResponseWriter.Write(p)
+	}
+	w.pending = append(w.pending, p...)
+	for {
+		end := bytes.Index(w.pending, []byte("\n\n"))
+		if end < 0 {
+			return len(p), nil
+		}
+		event := w.pending[:end+2]
+		id := w.history.record(w.session, event)
+		if _, err := fmt.Fprintf(w.ResponseWriter, "id: %d\n%s", id, event); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[end+2:]
+	}
+}
+
+// Flush sends what was written so far
+func (w *eventWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
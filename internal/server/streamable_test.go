@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/config"
+	"dizi/internal/tools"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestParseTransports(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+		err      bool
+	}{
+		{"sse", []string{TransportSSE}, false},
+		{"streamable-http", []string{TransportStreamableHTTP}, false},
+		{"sse, streamable-http", []string{TransportSSE, TransportStreamableHTTP}, false},
+		{"http", nil, true},
+		{"sse,stdio", nil, true},
+	}
+	for _, tt := range tests {
+		transports, err := ParseTransports(tt.value)
+		if (err != nil) != tt.err || fmt.Sprint(transports) != fmt.Sprint(tt.expected) {
+			t.Errorf("ParseTransports(%q) = %v, %v; expected %v", tt.value, transports, err, tt.expected)
+		}
+	}
+}
+
+func TestBothTransports(t *testing.T) {
+	cfg := &config.Config{Name: "test", Version: "1.0.0"}
+	hooks := &server.Hooks{}
+	mcpServer := server.NewMCPServer(cfg.Name, cfg.Version, tools.ServerOptions(hooks)...)
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	httpServer := httptest.NewServer(newHandler(cfg, mcpServer, hooks, Options{Transports: []string{TransportSSE, TransportStreamableHTTP}}))
+	t.Cleanup(httpServer.Close)
+
+	sseClient, err := client.NewSSEMCPClient(httpServer.URL + "/sse")
+	if err != nil {
+		t.Fatalf("Failed to create SSE client: %v", err)
+	}
+	streamableClient, err := client.NewStreamableHttpClient(httpServer.URL + StreamablePath)
+	if err != nil {
+		t.Fatalf("Failed to create streamable HTTP client: %v", err)
+	}
+	for _, c := range []*client.Client{sseClient, streamableClient} {
+		t.Cleanup(func() { c.Close() })
+		if err := c.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatalf("Failed to initialize: %v", err)
+		}
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "echo"
+		result, err := c.CallTool(context.Background(), request)
+		if err != nil || result.IsError {
+			t.Fatalf("Failed to call echo: %v %+v", err, result)
+		}
+	}
+
+	post := func(session string) *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodPost, httpServer.URL+StreamablePath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+		request.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			request.Header.Set(sessionHeader, session)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		response.Body.Close()
+		return response
+	}
+	if response := post(""); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a session, got %d", response.StatusCode)
+	}
+	if response := post("mcp-session-unknown"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", response.StatusCode)
+	}
+
+	// The body of a request without a session is read before the server sees it
+	large, _ := http.NewRequest(http.MethodPost, httpServer.URL+StreamablePath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"padding":"`+strings.Repeat("x", initializeBytes)+`"}}`))
+	large.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(large)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized request without a session, got %d", response.StatusCode)
+	}
+
+	// A deleted session is gone
+	initialize, _ := http.NewRequest(http.MethodPost, httpServer.URL+StreamablePath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`))
+	initialize.Header.Set("Content-Type", "application/json")
+	response, err = http.DefaultClient.Do(initialize)
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	response.Body.Close()
+	session := response.Header.Get(sessionHeader)
+	if session == "" {
+		t.Fatal("Expected a session ID from initialize")
+	}
+	if response := post(session); response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for a live session, got %d", response.StatusCode)
+	}
+	remove, _ := http.NewRequest(http.MethodDelete, httpServer.URL+StreamablePath, nil)
+	remove.Header.Set(sessionHeader, session)
+	if response, err := http.DefaultClient.Do(remove); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to delete the session: %v %v", err, response)
+	}
+	if response := post(session); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted session, got %d", response.StatusCode)
+	}
+}
+
+func TestResumableStreams(t *testing.T) {
+	history := &eventHistory{sessions: make(map[string]*sessionEvents)}
+	sessions := &sessionManager{sessions: make(map[string]*sessionState), onTerminate: history.forget}
+	session := sessions.Generate()
+
+	// Each stream gets three events, written in pieces as mcp-go may
+	next := 0
+	handler := resumableStreams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		for i := 0; i < 3; i++ {
+			next++
+			fmt.Fprintf(w, "event: message\ndata: {\"n\":%d}", next)
+			io.WriteString(w, "\n\n")
+		}
+	}), sessions, history)
+
+	stream := func(lastEventID string) (int, string) {
+		request := httptest.NewRequest(http.MethodGet, StreamablePath, nil)
+		request.Header.Set(sessionHeader, session)
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	if _, body := stream(""); !strings.HasPrefix(body, "id: 1\nevent: message\ndata: {\"n\":1}\n\nid: 2\n") {
+		t.Errorf("Expected numbered events, got %q", body)
+	}
+	_, body := stream("1")
+	var ids []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		}
+	}
+	if fmt.Sprint(ids) != "[2 3 4 5 6]" || !strings.Contains(body, "id: 2\nevent: message\ndata: {\"n\":2}\n\n") {
+		t.Errorf("Expected events 2 and 3 replayed before the new ones, got %q", body)
+	}
+	if code, _ := stream("x"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid Last-Event-ID, got %d", code)
+	}
+
+	if _, err := sessions.Terminate(session); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := stream("1"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a terminated session, got %d", code)
+	}
+	if len(history.since(session, 0)) != 0 {
+		t.Error("Expected the events of a terminated session to be dropped")
+	}
+
+	for i := 0; i < historySize+10; i++ {
+		history.record("other", []byte("data: x\n\n"))
+	}
+	if missed := history.since("other", 0); len(missed) != historySize || missed[0].id != 11 || !bytes.Equal(missed[0].data, []byte("data: x\n\n")) {
+		t.Errorf("Expected only the last %d events to be kept, got %d from %d", historySize, len(missed), missed[0].id)
+	}
+
+	large := []byte("data: " + strings.Repeat("x", historyBytes/4) + "\n\n")
+	for i := 0; i < 10; i++ {
+		history.record("large", large)
+	}
+	if missed := history.since("large", 0); len(missed) != 3 || missed[0].id != 8 {
+		t.Errorf("Expected the events to be limited to %d bytes, got %d", historyBytes, len(missed))
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	history := &eventHistory{sessions: make(map[string]*sessionEvents)}
+	sessions := &sessionManager{sessions: make(map[string]*sessionState), idle: 50 * time.Millisecond, onTerminate: history.forget}
+	var expired []string
+	sessions.expire = func(id string) {
+		expired = append(expired, id)
+		_, _ = sessions.Terminate(id)
+	}
+
+	idle := sessions.Generate()
+	streaming := sessions.Generate()
+	history.record(idle, []byte("data: x\n\n"))
+	done := sessions.use(streaming)
+	time.Sleep(100 * time.Millisecond)
+
+	// An idle session expires on its next request
+	if terminated, _ := sessions.Validate(idle); !terminated {
+		t.Error("Expected the idle session to have expired")
+	}
+	if fmt.Sprint(expired) != fmt.Sprint([]string{idle}) || len(history.since(idle, 0)) != 0 {
+		t.Errorf("Expected the idle session and its events to be dropped, expired %v", expired)
+	}
+	if terminated, _ := sessions.Validate(streaming); terminated {
+		t.Error("Expected a session with an open stream to be kept")
+	}
+
+	// Others expire when a new session starts
+	done()
+	time.Sleep(100 * time.Millisecond)
+	sessions.Generate()
+	if len(expired) != 2 || expired[1] != streaming {
+		t.Errorf("Expected the session to expire once its stream closed, expired %v", expired)
+	}
+	if len(sessions.sessions) != 1 {
+		t.Errorf("Expected only the new session to be kept, got %d", len(sessions.sessions))
+	}
+}
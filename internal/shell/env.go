@@ -0,0 +1,138 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dizi/internal/logger"
+)
+
+// EnvMode selects how commands get the environment set up by the user's
+// shell configuration files
+type EnvMode string
+
+const (
+	// LoginEnv sources the configuration files before every command
+	LoginEnv EnvMode = "login-env"
+	// CachedEnv sources them once, captures the resulting environment and
+	// runs commands directly with it until one of the files changes
+	CachedEnv EnvMode = "cached-env"
+	// CleanEnv runs commands with the server's own environment
+	CleanEnv EnvMode = "clean-env"
+)
+
+// envCaptureTimeout bounds how long sourcing the configuration files may take
+const envCaptureTimeout = 30 * time.Second
+
+// envMarker separates whatever the configuration files print from the environment
+const envMarker = "__DIZI_ENVIRONMENT__"
+
+// volatileVars are set by each shell for itself and are not taken from the capture
+var volatileVars = []string{"_", "SHLVL", "PWD", "OLDPWD"}
+
+// envCache holds the environment captured from the user's shell, or the
+// error capturing it failed with, and the state of the configuration files
+// it was captured from
+var envCache struct {
+	sync.Mutex
+	shell   string
+	stamp   string
+	environ []string
+	err     error
+}
+
+// loadEnvironment returns the environment a command of shell runs with, or
+// nil to inherit the server's, and the configuration files to source first
+func loadEnvironment(mode EnvMode, shell string) ([]string, []string) {
+	switch mode {
+	case CleanEnv:
+		return nil, nil
+	case CachedEnv:
+		configFiles := configFilesFor(shell)
+		if environ, err := cachedEnvironment(shell, configFiles); err == nil {
+			return environ, nil
+		}
+		// Sourcing on every call still works where capturing doesn't
+		return nil, configFiles
+	default:
+		return nil, configFilesFor(shell)
+	}
+}
+
+// cachedEnvironment returns the environment of shell after sourcing
+// configFiles, captured again only when one of the files has changed
+func cachedEnvironment(shell string, configFiles []string) ([]string, error) {
+	stamp := configStamp(configFiles)
+
+	envCache.Lock()
+	defer envCache.Unlock()
+	if (envCache.environ != nil || envCache.err != nil) && envCache.shell == shell && envCache.stamp == stamp {
+		return envCache.environ, envCache.err
+	}
+
+	// A failure is kept too, so that the slow capture is only tried again
+	// once the configuration files change
+	environ, err := captureEnvironment(shell, configFiles)
+	envCache.shell = shell
+	envCache.stamp = stamp
+	envCache.err = err
+	if err != nil {
+		logger.ErrorLog("%v; sourcing the shell configuration for every command instead", err)
+		envCache.environ = nil
+		return nil, err
+	}
+	// Callers append their own variables; clipping makes append copy
+	envCache.environ = slices.Clip(environ)
+	return envCache.environ, nil
+}
+
+// configStamp describes the state of configFiles, so that any edit, new or
+// removed file gives a different stamp
+func configStamp(configFiles []string) string {
+	var stamp strings.Builder
+	for _, file := range configFiles {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s\x00%d\x00%d\x00", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp.String()
+}
+
+// captureEnvironment runs shell to source configFiles and returns the
+// environment they leave behind
+func captureEnvironment(shell string, configFiles []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), envCaptureTimeout)
+	defer cancel()
+
+	script := sourceScript(filepath.Base(shell), configFiles) + "echo " + envMarker + "\nenv -0\n"
+	cmd := exec.CommandContext(ctx, shell, "-c", script)
+	killProcessTreeOnCancel(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture the environment of %s: %w", shell, err)
+	}
+
+	start := bytes.LastIndex(output, []byte(envMarker+"\n"))
+	if start < 0 {
+		return nil, fmt.Errorf("failed to capture the environment of %s: no output from env", shell)
+	}
+	var environ []string
+	for _, entry := range strings.Split(string(output[start+len(envMarker)+1:]), "\x00") {
+		name, _, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || slices.Contains(volatileVars, name) {
+			continue
+		}
+		environ = append(environ, entry)
+	}
+	return environ, nil
+}
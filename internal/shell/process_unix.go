@@ -0,0 +1,25 @@
+//go:build !windows
+
+package shell
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessTreeOnCancel starts cmd in its own process group and makes context
+// cancellation kill the whole group, so that background jobs and children
+// started by sourced rc files do not outlive the tool call
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		// A negative pid signals every process in the group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait forever on pipes inherited by processes that escaped the group
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package shell
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// killProcessTreeOnCancel makes context cancellation kill cmd and all of its
+// descendants, so that processes started by the PowerShell profile or the
+// script do not outlive the tool call
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+	// Don't wait forever on pipes inherited by processes that escaped the tree
+	cmd.WaitDelay = 5 * time.Second
+}
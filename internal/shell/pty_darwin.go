@@ -0,0 +1,44 @@
+//go:build darwin
+
+package shell
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal and returns its master and slave sides
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var name [128]byte
+	for _, step := range []struct {
+		request uintptr
+		arg     unsafe.Pointer
+	}{
+		{syscall.TIOCPTYGRANT, nil},
+		{syscall.TIOCPTYUNLK, nil},
+		{syscall.TIOCPTYGNAME, unsafe.Pointer(&name[0])},
+	} {
+		if err := ioctl(master, step.request, step.arg); err != nil {
+			master.Close()
+			return nil, nil, err
+		}
+	}
+
+	path := string(name[:])
+	if end := bytes.IndexByte(name[:], 0); end >= 0 {
+		path = string(name[:end])
+	}
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
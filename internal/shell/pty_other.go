@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// StartPTYShell is not supported on this platform
+func StartPTYShell(ctx context.Context, dir string, env []string) (*exec.Cmd, *os.File, error) {
+	return nil, nil, fmt.Errorf("shell sessions are not supported on %s", runtime.GOOS)
+}
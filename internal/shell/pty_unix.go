@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Terminal size reported to programs in a pseudo-terminal
+const (
+	ptyRows = 50
+	ptyCols = 200
+)
+
+// StartPTYShell starts the user's shell interactively on a new pseudo-terminal
+// and returns the terminal's master side, which carries the shell's input
+// and output. The shell leads its own session with the terminal as its
+// controlling terminal; cancelling ctx kills it with its process group.
+func StartPTYShell(ctx context.Context, dir string, env []string) (*exec.Cmd, *os.File, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+	defer slave.Close()
+
+	size := struct{ rows, cols, x, y uint16 }{ptyRows, ptyCols, 0, 0}
+	if err := ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&size)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to set the terminal size: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, getCurrentShell(), "-i")
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	cmd.Cancel = func() error {
+		// The session leader's pid is also its process group ID
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return cmd, master, nil
+}
+
+// ioctl performs an ioctl on file without switching it to blocking mode
+func ioctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -2,6 +2,7 @@
 package shell
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -13,6 +14,11 @@ import (
 // GetShellConfigFiles returns the list of shell configuration files to source
 // based on the current platform and shell
 func GetShellConfigFiles() []string {
+	return configFilesFor(getCurrentShell())
+}
+
+// configFilesFor returns the existing configuration files of currentShell
+func configFilesFor(currentShell string) []string {
 	var configFiles []string
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -27,9 +33,6 @@ func GetShellConfigFiles() []string {
 			filepath.Join(homeDir, "Documents", "WindowsPowerShell", "Microsoft.PowerShell_profile.ps1"),
 		)
 	case "darwin", "linux":
-		// Unix-like systems - get current shell
-		currentShell := getCurrentShell()
-		
 		// Always include common profile files
 		configFiles = append(configFiles,
 			"/etc/profile",
@@ -205,26 +208,56 @@ func getParentShell() string {
 
 // CreateShellCommand creates a command that runs in the user's configured shell environment
 func CreateShellCommand(command string, args ...string) *exec.Cmd {
+	return CreateShellCommandContext(context.Background(), command, args...)
+}
+
+// CreateShellScriptCommand creates a command that runs a script in the user's shell environment
+func CreateShellScriptCommand(script string) *exec.Cmd {
+	return CreateShellScriptCommandContext(context.Background(), script)
+}
+
+// CreateShellCommandContext is like CreateShellCommand but kills the command,
+// together with every process it spawned, when ctx is done
+func CreateShellCommandContext(ctx context.Context, command string, args ...string) *exec.Cmd {
+	return CreateShellCommandEnv(ctx, LoginEnv, command, args...)
+}
+
+// CreateShellCommandEnv is like CreateShellCommandContext but loads the
+// user's shell environment as mode says
+func CreateShellCommandEnv(ctx context.Context, mode EnvMode, command string, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "windows":
-		return createWindowsCommand(command, args...)
+		cmd = createWindowsCommand(ctx, command, args...)
 	default:
-		return createUnixCommand(command, args...)
+		cmd = createUnixCommand(ctx, mode, command, args...)
 	}
+	killProcessTreeOnCancel(cmd)
+	return cmd
 }
 
-// CreateShellScriptCommand creates a command that runs a script in the user's shell environment
-func CreateShellScriptCommand(script string) *exec.Cmd {
+// CreateShellScriptCommandContext is like CreateShellScriptCommand but kills the
+// script, together with every process it spawned, when ctx is done
+func CreateShellScriptCommandContext(ctx context.Context, script string) *exec.Cmd {
+	return CreateShellScriptCommandEnv(ctx, LoginEnv, script)
+}
+
+// CreateShellScriptCommandEnv is like CreateShellScriptCommandContext but
+// loads the user's shell environment as mode says
+func CreateShellScriptCommandEnv(ctx context.Context, mode EnvMode, script string) *exec.Cmd {
+	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "windows":
-		return createWindowsScriptCommand(script)
+		cmd = createWindowsScriptCommand(ctx, script)
 	default:
-		return createUnixScriptCommand(script)
+		cmd = createUnixScriptCommand(ctx, mode, script)
 	}
+	killProcessTreeOnCancel(cmd)
+	return cmd
 }
 
 // createUnixCommand creates a command for Unix-like systems
-func createUnixCommand(command string, args ...string) *exec.Cmd {
+func createUnixCommand(ctx context.Context, mode EnvMode, command string, args ...string) *exec.Cmd {
 	shell := getCurrentShell()
 	shellName := filepath.Base(shell)
 	
@@ -232,7 +265,7 @@ func createUnixCommand(command string, args ...string) *exec.Cmd {
 	var fullCommand strings.Builder
 	
 	// Source configuration files based on shell type
-	configFiles := GetShellConfigFiles()
+	environ, configFiles := loadEnvironment(mode, shell)
 	
 	switch shellName {
 	case "fish":
@@ -255,9 +288,8 @@ func createUnixCommand(command string, args ...string) *exec.Cmd {
 	// Add the actual command
 	fullCommand.WriteString(command)
 	for _, arg := range args {
-		// Escape arguments to prevent shell injection
-		escapedArg := strings.ReplaceAll(arg, "'", "'\"'\"'")
-		fullCommand.WriteString(fmt.Sprintf(" '%s'", escapedArg))
+		// Quote arguments for the shell to prevent shell injection
+		fullCommand.WriteString(" " + Quote(shellName, arg))
 	}
 	
 	// Use appropriate shell flags
@@ -273,11 +305,13 @@ func createUnixCommand(command string, args ...string) *exec.Cmd {
 	}
 	
 	shellArgs = append(shellArgs, fullCommand.String())
-	return exec.Command(shell, shellArgs...)
+	cmd := exec.CommandContext(ctx, shell, shellArgs...)
+	cmd.Env = environ
+	return cmd
 }
 
 // createUnixScriptCommand creates a script command for Unix-like systems
-func createUnixScriptCommand(script string) *exec.Cmd {
+func createUnixScriptCommand(ctx context.Context, mode EnvMode, script string) *exec.Cmd {
 	shell := getCurrentShell()
 	shellName := filepath.Base(shell)
 	
@@ -285,31 +319,39 @@ func createUnixScriptCommand(script string) *exec.Cmd {
 	var fullScript strings.Builder
 	
 	// Source configuration files
-	configFiles := GetShellConfigFiles()
+	environ, configFiles := loadEnvironment(mode, shell)
+	fullScript.WriteString(sourceScript(shellName, configFiles))
 	
+	// Add the actual script
+	fullScript.WriteString(script)
+	
+	cmd := exec.CommandContext(ctx, shell, "-c", fullScript.String())
+	cmd.Env = environ
+	return cmd
+}
+
+// sourceScript returns script lines that source configFiles in the named shell
+func sourceScript(shellName string, configFiles []string) string {
+	var script strings.Builder
 	switch shellName {
 	case "fish":
 		for _, file := range configFiles {
-			fullScript.WriteString(fmt.Sprintf("test -f '%s'; and source '%s'\n", file, file))
+			script.WriteString(fmt.Sprintf("test -f '%s'; and source '%s'\n", file, file))
 		}
 	case "csh", "tcsh":
 		for _, file := range configFiles {
-			fullScript.WriteString(fmt.Sprintf("if (-f '%s') source '%s'\n", file, file))
+			script.WriteString(fmt.Sprintf("if (-f '%s') source '%s'\n", file, file))
 		}
 	default:
 		for _, file := range configFiles {
-			fullScript.WriteString(fmt.Sprintf("[ -f '%s' ] && source '%s' 2>/dev/null\n", file, file))
+			script.WriteString(fmt.Sprintf("[ -f '%s' ] && source '%s' 2>/dev/null\n", file, file))
 		}
 	}
-	
-	// Add the actual script
-	fullScript.WriteString(script)
-	
-	return exec.Command(shell, "-c", fullScript.String())
+	return script.String()
 }
 
 // createWindowsCommand creates a command for Windows systems
-func createWindowsCommand(command string, args ...string) *exec.Cmd {
+func createWindowsCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
 	var psCommand strings.Builder
 	
 	// Load PowerShell profiles
@@ -326,11 +368,11 @@ func createWindowsCommand(command string, args ...string) *exec.Cmd {
 		psCommand.WriteString(fmt.Sprintf(" '%s'", escapedArg))
 	}
 	
-	return exec.Command("powershell", "-NoProfile", "-Command", psCommand.String())
+	return exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", psCommand.String())
 }
 
 // createWindowsScriptCommand creates a script command for Windows systems
-func createWindowsScriptCommand(script string) *exec.Cmd {
+func createWindowsScriptCommand(ctx context.Context, script string) *exec.Cmd {
 	var psScript strings.Builder
 	
 	// Load PowerShell profiles
@@ -342,5 +384,55 @@ func createWindowsScriptCommand(script string) *exec.Cmd {
 	// Add the actual script
 	psScript.WriteString(script)
 	
-	return exec.Command("powershell", "-NoProfile", "-Command", psScript.String())
-}
\ No newline at end of file
+	return exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", psScript.String())
+}
+
+// Name returns the name of the shell that commands and scripts are run with:
+// the base name of the detected Unix shell (e.g. "bash", "fish") or
+// "powershell" on Windows
+func Name() string {
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return filepath.Base(getCurrentShell())
+}
+
+// Quote quotes s so that the named shell treats it as a single literal word
+func Quote(shellName, s string) string {
+	switch shellName {
+	case "powershell", "pwsh":
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	case "fish":
+		// Fish only interprets \\ and \' inside single quotes
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	case "csh", "tcsh":
+		// History expansion and newlines are active even inside single quotes
+		return "'" + strings.NewReplacer(`'`, `'\''`, `!`, `\!`, "\n", "\\\n").Replace(s) + "'"
+	default:
+		// Bourne shell family (bash, zsh, sh, ksh, etc.)
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+}
+
+// PromptSetup returns a command line for the named interactive shell that
+// turns off terminal echo and line editing and sets the prompt to prefix,
+// the exit status of the previous command and suffix, so that a program
+// driving the shell can tell when a command has finished. The continuation
+// prompt, shown while a command is incomplete, is set to more.
+func PromptSetup(shellName, prefix, suffix, more string) string {
+	switch shellName {
+	case "fish":
+		return fmt.Sprintf("stty -echo; set -g fish_greeting ''; function fish_prompt; printf '%%s%%s%%s' %s $status %s; end; function fish_right_prompt; end; function fish_mode_prompt; end",
+			Quote(shellName, prefix), Quote(shellName, suffix))
+	case "zsh":
+		return fmt.Sprintf("stty -echo; unsetopt zle prompt_sp prompt_cr prompt_subst; precmd_functions=(); unfunction precmd 2>/dev/null; PROMPT=%s; RPROMPT=''; PROMPT2=%s",
+			Quote(shellName, strings.ReplaceAll(prefix, "%", "%%")+"%?"+strings.ReplaceAll(suffix, "%", "%%")), Quote(shellName, strings.ReplaceAll(more, "%", "%%")))
+	case "csh", "tcsh":
+		return fmt.Sprintf("stty -echo; unset edit; set prompt=%s; set prompt2=%s",
+			Quote(shellName, prefix+"%?"+suffix), Quote(shellName, more))
+	default:
+		// Bourne shell family; the prompt is expanded each time it is shown
+		return fmt.Sprintf("stty -echo; set +o emacs +o vi 2>/dev/null; unset PROMPT_COMMAND; PS0=''; PS1=%s'$?'%s; PS2=%s",
+			Quote(shellName, prefix), Quote(shellName, suffix), Quote(shellName, more))
+	}
+}
@@ -1,10 +1,14 @@
 package shell
 
 import (
+	"context"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetCurrentShell(t *testing.T) {
@@ -52,6 +56,21 @@ func TestCreateShellCommand(t *testing.T) {
 	}
 }
 
+func TestCreateShellCommandQuotesArguments(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("argument quoting test requires a Unix shell")
+	}
+
+	input := `it's "$HOME" \ $(echo no)`
+	output, err := CreateShellCommandEnv(context.Background(), CleanEnv, "printf %s", input).Output()
+	if err != nil {
+		t.Fatalf("Command execution failed: %v", err)
+	}
+	if string(output) != input {
+		t.Errorf("Expected the argument to be passed literally, got %q", output)
+	}
+}
+
 func TestCreateShellScriptCommand(t *testing.T) {
 	script := "echo 'script test'"
 	cmd := CreateShellScriptCommand(script)
@@ -68,4 +87,153 @@ func TestCreateShellScriptCommand(t *testing.T) {
 	if !strings.Contains(string(output), "script test") {
 		t.Errorf("Script output doesn't contain expected text: %s", string(output))
 	}
-}
\ No newline at end of file
+}
+
+func TestCreateShellScriptCommandContextTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process group test requires a Unix shell")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// The background sleep keeps the output pipe open, so the command only
+	// returns early if the whole process group is killed
+	cmd := CreateShellScriptCommandContext(ctx, "sleep 30 & sleep 30")
+	start := time.Now()
+	_, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("Expected error for timed out script, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("Script was not killed on timeout, took %v", elapsed)
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Expected context deadline exceeded, got %v", ctx.Err())
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		shell    string
+		input    string
+		expected string
+	}{
+		{"bash", "plain", "'plain'"},
+		{"bash", "it's $(rm -rf /)", `'it'\''s $(rm -rf /)'`},
+		{"zsh", "", "''"},
+		{"fish", `a'b\c`, `'a\'b\\c'`},
+		{"tcsh", "don't!", `'don'\''t\!'`},
+		{"csh", "a\nb", "'a\\\nb'"},
+		{"powershell", "it's $env:HOME", "'it''s $env:HOME'"},
+	}
+
+	for _, tt := range tests {
+		if got := Quote(tt.shell, tt.input); got != tt.expected {
+			t.Errorf("Quote(%q, %q) = %q, want %q", tt.shell, tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a Bourne shell")
+	}
+
+	input := `it's "quoted" $HOME; echo injected`
+	output, err := exec.Command("/bin/sh", "-c", "printf %s "+Quote("sh", input)).Output()
+	if err != nil {
+		t.Fatalf("Failed to run shell: %v", err)
+	}
+	if string(output) != input {
+		t.Errorf("Expected %q, got %q", input, string(output))
+	}
+}
+
+func TestShellEnvModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("environment modes apply to Unix shells")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SHELL", bash)
+	profile := filepath.Join(home, ".profile")
+	if err := os.WriteFile(profile, []byte("echo noise\nexport DIZI_TEST_VAR=one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(mode EnvMode) string {
+		t.Helper()
+		output, err := CreateShellScriptCommandEnv(context.Background(), mode, `echo "[$DIZI_TEST_VAR]"`).Output()
+		if err != nil {
+			t.Fatalf("Script failed in %s mode: %v", mode, err)
+		}
+		return string(output)
+	}
+
+	if output := run(LoginEnv); !strings.HasSuffix(output, "[one]\n") || !strings.Contains(output, "noise") {
+		t.Errorf("Expected login-env to source the profile, got %q", output)
+	}
+	if output := run(CachedEnv); output != "[one]\n" {
+		t.Errorf("Expected cached-env to use the captured environment without sourcing, got %q", output)
+	}
+	if output := run(CleanEnv); output != "[]\n" {
+		t.Errorf("Expected clean-env not to load the profile, got %q", output)
+	}
+
+	// Editing the profile invalidates the cache
+	if err := os.WriteFile(profile, []byte("export DIZI_TEST_VAR=two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(profile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if output := run(CachedEnv); output != "[two]\n" {
+		t.Errorf("Expected the changed profile to be captured again, got %q", output)
+	}
+}
+
+func TestCachedEnvironmentFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("environment modes apply to Unix shells")
+	}
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	shell := filepath.Join(dir, "broken-sh")
+	if err := os.WriteFile(shell, []byte("#!/bin/sh\necho call >> "+calls+"\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	profile := filepath.Join(dir, ".profile")
+	if err := os.WriteFile(profile, []byte("export DIZI_TEST_VAR=one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	countCalls := func() int {
+		data, _ := os.ReadFile(calls)
+		return strings.Count(string(data), "call")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cachedEnvironment(shell, []string{profile}); err == nil {
+			t.Fatal("Expected capturing with a failing shell to fail")
+		}
+	}
+	if n := countCalls(); n != 1 {
+		t.Errorf("Expected the failure to be cached, the shell ran %d times", n)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(profile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	cachedEnvironment(shell, []string{profile})
+	if n := countCalls(); n != 2 {
+		t.Errorf("Expected a changed profile to be captured again, the shell ran %d times", n)
+	}
+}
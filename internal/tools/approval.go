@@ -0,0 +1,126 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file holds calls to tools with confirm: true until a person approves them.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"dizi/internal/approval"
+	"dizi/internal/auth"
+	"dizi/internal/profile"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// WithApprovals sets where calls to tools that need confirmation wait for a decision
+func WithApprovals(broker *approval.Broker) RegisterOption {
+	return func(o *registerOptions) {
+		o.approvals = broker
+	}
+}
+
+// withApproval runs handler only once a person has approved the call: the
+// user of a client that supports elicitation is asked right there, for other
+// clients the call waits on the server's approval endpoint.
+func withApproval(name string, broker *approval.Broker, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if broker == nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Tool %s needs approval, but the server has no approval endpoint. Nothing was run.", name)), nil
+		}
+
+		arguments, _ := request.Params.Arguments.(map[string]interface{})
+		result := broker.Ask(ctx, name, arguments, clientSessionID(ctx), elicitApproval, func(pending approval.Request) {
+			notifyWaiting(ctx, request, pending)
+		})
+		if !result.Approved() {
+			message := fmt.Sprintf("Call to %s was not approved (%s by %s)", name, result.Decision, result.By)
+			if result.Reason != "" {
+				message += ": " + result.Reason
+			}
+			return mcp.NewToolResultError(message + ". Nothing was run."), nil
+		}
+		return handler(ctx, request)
+	}
+}
+
+// approvalSchema is the form a client shows its user to decide on a call
+var approvalSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"decision": map[string]interface{}{
+			"type":      "string",
+			"title":     "Decision",
+			"enum":      []string{string(approval.Approve), string(approval.ApproveSession), string(approval.Deny)},
+			"enumNames": []string{"Approve this call", "Approve this tool for the rest of the session", "Deny"},
+		},
+		"reason": map[string]interface{}{
+			"type":        "string",
+			"title":       "Reason",
+			"description": "Optional: why the call is denied, shown to the agent",
+		},
+	},
+	"required": []string{"decision"},
+}
+
+// elicitApproval asks the user of the client whether a call may run, if the
+// client supports elicitation. Clients limited to some tools can't decide on
+// their own calls, like they can't use the approval endpoint.
+func elicitApproval(ctx context.Context, pending approval.Request) (approval.Result, bool) {
+	mcpServer := server.ServerFromContext(ctx)
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithClientInfo)
+	if mcpServer == nil || !ok || session.GetClientCapabilities().Elicitation == nil {
+		return approval.Result{}, false
+	}
+	if !auth.FromContext(ctx).Unrestricted() || profile.FromContext(ctx) != "" {
+		return approval.Result{}, false
+	}
+
+	arguments, _ := json.MarshalIndent(pending.Arguments, "", "  ")
+	response, err := mcpServer.RequestElicitation(ctx, mcp.ElicitationRequest{
+		Params: mcp.ElicitationParams{
+			Message:         fmt.Sprintf("Allow %s to run with these arguments?\n%s", pending.Tool, arguments),
+			RequestedSchema: approvalSchema,
+		},
+	})
+	if errors.Is(err, server.ErrElicitationNotSupported) {
+		return approval.Result{}, false
+	}
+	if err != nil {
+		return approval.Result{Decision: approval.Deny, By: "client", Reason: fmt.Sprintf("failed to ask the user: %v", err)}, true
+	}
+
+	switch response.Action {
+	case mcp.ElicitationResponseActionAccept:
+		content, _ := response.Content.(map[string]interface{})
+		decision, _ := content["decision"].(string)
+		reason, _ := content["reason"].(string)
+		if !slices.Contains(approval.Decisions, approval.Decision(decision)) {
+			return approval.Result{Decision: approval.Deny, By: "client", Reason: fmt.Sprintf("unknown decision %q", decision)}, true
+		}
+		return approval.Result{Decision: approval.Decision(decision), By: "user", Reason: reason}, true
+	case mcp.ElicitationResponseActionDecline:
+		return approval.Result{Decision: approval.Deny, By: "user"}, true
+	default:
+		return approval.Result{Decision: approval.Deny, By: "user", Reason: "the request was dismissed"}, true
+	}
+}
+
+// notifyWaiting tells a client that supplied a progress token that its call
+// waits for approval
+func notifyWaiting(ctx context.Context, request mcp.CallToolRequest, pending approval.Request) {
+	mcpServer := server.ServerFromContext(ctx)
+	if mcpServer == nil || request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return
+	}
+	// Delivery is best effort, like the progress of running tools
+	_ = mcpServer.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+		"progressToken": request.Params.Meta.ProgressToken,
+		"progress":      0,
+		"message":       fmt.Sprintf("Waiting for approval of %s (request %s); run dizi approve to decide", pending.Tool, pending.ID),
+	})
+}
@@ -0,0 +1,158 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/approval"
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/shell"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestConfirmTool(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	tool := config.ToolConfig{
+		Name:    "deploy",
+		Type:    "script",
+		Script:  "echo deployed > " + shell.Quote("sh", marker) + " && echo done",
+		Confirm: true,
+	}
+	broker := approval.NewBroker(approval.Options{Timeout: time.Minute})
+	serverTool, err := buildTool(tool, &registerOptions{shellEnv: shell.CleanEnv, approvals: broker})
+	if err != nil {
+		t.Fatalf("buildTool failed: %v", err)
+	}
+
+	call := func(decision approval.Decision) *mcp.CallToolResult {
+		t.Helper()
+		go func() {
+			for i := 0; i < 500; i++ {
+				if pending := broker.Pending(); len(pending) > 0 {
+					_ = broker.Decide(pending[0].ID, approval.Result{Decision: decision, By: "tester", Reason: "frozen"})
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		result, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Name: tool.Name, Arguments: map[string]interface{}{}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return result
+	}
+
+	result := call(approval.Deny)
+	if text := contentTexts(result)[0]; !result.IsError || text != "Call to deploy was not approved (deny by tester): frozen. Nothing was run." {
+		t.Errorf("Expected the denial, got %q", text)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Expected the denied script not to run")
+	}
+
+	result = call(approval.Approve)
+	if text := contentTexts(result)[0]; result.IsError || !strings.Contains(text, "done") {
+		t.Errorf("Expected the approved script to run, got %q", text)
+	}
+
+	// Without a broker there is no one to ask
+	serverTool, err = buildTool(tool, &registerOptions{shellEnv: shell.CleanEnv})
+	if err != nil {
+		t.Fatalf("buildTool failed: %v", err)
+	}
+	result, _ = serverTool.Handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Name: tool.Name, Arguments: map[string]interface{}{}},
+	})
+	if text := contentTexts(result)[0]; !result.IsError || !strings.Contains(text, "no approval endpoint") {
+		t.Errorf("Expected an error without a broker, got %q", text)
+	}
+}
+
+// elicitingSession is a client session that supports elicitation and gives
+// the same answer to every request
+type elicitingSession struct {
+	*testSession
+	answer   mcp.ElicitationResponse
+	requests []mcp.ElicitationRequest
+}
+
+func (s *elicitingSession) GetClientInfo() mcp.Implementation {
+	return mcp.Implementation{Name: "test"}
+}
+func (s *elicitingSession) SetClientInfo(clientInfo mcp.Implementation) {}
+func (s *elicitingSession) GetClientCapabilities() mcp.ClientCapabilities {
+	return mcp.ClientCapabilities{Elicitation: &mcp.ElicitationCapability{}}
+}
+func (s *elicitingSession) SetClientCapabilities(clientCapabilities mcp.ClientCapabilities) {}
+func (s *elicitingSession) RequestElicitation(ctx context.Context, request mcp.ElicitationRequest) (*mcp.ElicitationResult, error) {
+	s.requests = append(s.requests, request)
+	return &mcp.ElicitationResult{ElicitationResponse: s.answer}, nil
+}
+
+func TestConfirmToolElicitation(t *testing.T) {
+	tool := config.ToolConfig{Name: "deploy", Type: "script", Script: "echo done", Confirm: true}
+	broker := approval.NewBroker(approval.Options{Timeout: time.Minute})
+	serverTool, err := buildTool(tool, &registerOptions{shellEnv: shell.CleanEnv, approvals: broker})
+	if err != nil {
+		t.Fatalf("buildTool failed: %v", err)
+	}
+	session := &elicitingSession{testSession: newTestSession("eliciting")}
+	ctx := sessionContext(t, server.NewMCPServer("test", "1.0"), session)
+	call := func(ctx context.Context) *mcp.CallToolResult {
+		t.Helper()
+		result, err := serverTool.Handler(ctx, mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Name: tool.Name, Arguments: map[string]interface{}{"env": "prod"}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return result
+	}
+
+	session.answer = mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionAccept, Content: map[string]interface{}{"decision": "deny", "reason": "frozen"}}
+	if text := contentTexts(call(ctx))[0]; text != "Call to deploy was not approved (deny by user): frozen. Nothing was run." {
+		t.Errorf("Expected the user's denial, got %q", text)
+	}
+	if len(session.requests) != 1 || !strings.Contains(session.requests[0].Params.Message, `"env": "prod"`) {
+		t.Errorf("Expected the user to be shown the call, got %+v", session.requests)
+	}
+
+	session.answer = mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionDecline}
+	if text := contentTexts(call(ctx))[0]; text != "Call to deploy was not approved (deny by user). Nothing was run." {
+		t.Errorf("Expected the declined request to deny the call, got %q", text)
+	}
+
+	session.answer = mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionAccept, Content: map[string]interface{}{"decision": "approve"}}
+	if result := call(ctx); result.IsError || !strings.Contains(contentTexts(result)[0], "done") {
+		t.Errorf("Expected the approved script to run, got %v", result.Content)
+	}
+
+	// A client limited to some tools can't approve its own calls
+	limited := auth.WithIdentity(ctx, &auth.Identity{Name: "ci", Method: auth.MethodToken, Tools: []string{"deploy"}})
+	go func() {
+		for i := 0; i < 500; i++ {
+			if pending := broker.Pending(); len(pending) > 0 {
+				_ = broker.Decide(pending[0].ID, approval.Result{Decision: approval.Deny, By: "tester"})
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	requests := len(session.requests)
+	if text := contentTexts(call(limited))[0]; text != "Call to deploy was not approved (deny by tester). Nothing was run." {
+		t.Errorf("Expected the endpoint to decide, got %q", text)
+	}
+	if len(session.requests) != requests {
+		t.Error("Expected a limited client not to be asked")
+	}
+}
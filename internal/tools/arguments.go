@@ -0,0 +1,66 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file validates tool arguments against the declared parameter schema.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dizi/internal/config"
+	"dizi/internal/schema"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// withArgumentValidation wraps handler so that the arguments are checked
+// against the tool's parameter schema, with declared defaults filled in,
+// before it runs. Tools without parameters are left unchanged.
+func withArgumentValidation(tool config.ToolConfig, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if tool.Parameters == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var arguments map[string]interface{}
+		switch args := request.Params.Arguments.(type) {
+		case nil:
+			arguments = make(map[string]interface{})
+		case map[string]interface{}:
+			// Copy so that filling in defaults doesn't touch the caller's map
+			arguments = make(map[string]interface{}, len(args))
+			for key, value := range args {
+				arguments[key] = value
+			}
+		default:
+			return mcp.NewToolResultError("Invalid arguments format"), nil
+		}
+
+		schema.ApplyDefaults(tool.Parameters, arguments)
+		if violations := schema.Validate(tool.Parameters, arguments); len(violations) > 0 {
+			return invalidArgumentsResult(tool.Name, violations), nil
+		}
+
+		request.Params.Arguments = arguments
+		return handler(ctx, request)
+	}
+}
+
+// invalidArgumentsResult reports schema violations as a readable list followed
+// by the same violations as JSON, so clients can act on them programmatically
+func invalidArgumentsResult(toolName string, violations []schema.Violation) *mcp.CallToolResult {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Invalid arguments for tool %s:", toolName)
+	for _, v := range violations {
+		text.WriteString("\n- ")
+		text.WriteString(v.String())
+	}
+
+	result := mcp.NewToolResultError(text.String())
+	if data, err := json.Marshal(map[string]interface{}{"violations": violations}); err == nil {
+		result.Content = append(result.Content, mcp.NewTextContent(string(data)))
+	}
+	return result
+}
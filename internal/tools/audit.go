@@ -0,0 +1,149 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file records every tool call in the audit log.
+package tools
+
+import (
+	"context"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"dizi/internal/audit"
+	"dizi/internal/config"
+	"dizi/internal/logger"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// maxAuditErrorBytes bounds the error message kept in an audit entry
+const maxAuditErrorBytes = 1024
+
+// sensitiveArgument matches argument names whose values are never logged
+var sensitiveArgument = regexp.MustCompile(`(?i)password|passwd|secret|token|api[_-]?key|credential`)
+
+// WithAudit sets the audit log every tool call is appended to
+func WithAudit(log *audit.Log) RegisterOption {
+	return func(o *registerOptions) {
+		o.audit = log
+	}
+}
+
+// withAudit appends an entry for each call of handler to log, with the
+// tool's secrets redacted from the arguments and the error
+func withAudit(name string, secrets []string, log *audit.Log, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if log == nil {
+		return handler
+	}
+	r := newRedactor(secrets)
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		arguments, _ := request.Params.Arguments.(map[string]interface{})
+		entry := audit.Entry{
+			Time:      time.Now(),
+			Session:   clientSessionID(ctx),
+			Tool:      name,
+			Arguments: redactArguments(r, arguments),
+		}
+
+		ctx, exitCode := audit.WithRecord(ctx)
+		result, err := handler(ctx, request)
+
+		entry.DurationMS = time.Since(entry.Time).Milliseconds()
+		entry.ExitCode = exitCode()
+		switch {
+		case err != nil:
+			entry.Error = redactText(r, err.Error())
+		case result != nil:
+			entry.OutputBytes = resultSize(result)
+			if result.IsError && len(result.Content) > 0 {
+				if text, ok := result.Content[0].(mcp.TextContent); ok {
+					entry.Error = redactText(r, text.Text)
+				}
+			}
+		}
+		if writeErr := log.Write(entry); writeErr != nil {
+			logger.ErrorLog("Failed to audit call to %s: %v", name, writeErr)
+		}
+		return result, err
+	}
+}
+
+// redactArguments copies arguments with secret values and the values of
+// sensitive-looking names replaced
+func redactArguments(r *redactor, arguments map[string]interface{}) map[string]interface{} {
+	if arguments == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		if sensitiveArgument.MatchString(name) {
+			redacted[name] = config.Redacted
+			continue
+		}
+		redacted[name] = redactValue(r, value)
+	}
+	return redacted
+}
+
+// redactValue redacts the strings in an argument value
+func redactValue(r *redactor, value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return r.Redact(logger.Redact(value))
+	case map[string]interface{}:
+		return redactArguments(r, value)
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, item := range value {
+			redacted[i] = redactValue(r, item)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// redactText redacts an error message and cuts it to maxAuditErrorBytes
+func redactText(r *redactor, text string) string {
+	text = r.Redact(logger.Redact(text))
+	if len(text) <= maxAuditErrorBytes {
+		return text
+	}
+	cut := maxAuditErrorBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
+
+// resultSize returns the size of the content of a result in bytes
+func resultSize(result *mcp.CallToolResult) int {
+	size := 0
+	for _, content := range result.Content {
+		switch content := content.(type) {
+		case mcp.TextContent:
+			size += len(content.Text)
+		case mcp.ImageContent:
+			size += len(content.Data)
+		case mcp.AudioContent:
+			size += len(content.Data)
+		case mcp.EmbeddedResource:
+			switch resource := content.Resource.(type) {
+			case mcp.TextResourceContents:
+				size += len(resource.Text)
+			case mcp.BlobResourceContents:
+				size += len(resource.Blob)
+			}
+		}
+	}
+	return size
+}
+
+// clientSessionID returns the ID of the client session a call came from, or ""
+func clientSessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"dizi/internal/audit"
+	"dizi/internal/config"
+	"dizi/internal/shell"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestAuditedTool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	tool := config.ToolConfig{
+		Name:        "deploy",
+		Type:        "script",
+		Script:      "echo deploying {{target}} with $DEPLOY_KEY; exit 2",
+		Environment: []config.EnvVar{{Name: "DEPLOY_KEY", Value: "k3y", Secret: true}},
+	}
+	serverTool, err := buildTool(tool, &registerOptions{shellEnv: shell.CleanEnv, audit: audit.New(audit.Options{Path: path})})
+	if err != nil {
+		t.Fatalf("buildTool failed: %v", err)
+	}
+
+	for _, arguments := range []map[string]interface{}{
+		{"target": "prod k3y", "password": "hunter2"},
+		{"target": []interface{}{"k3y"}},
+	} {
+		if _, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Name: tool.Name, Arguments: arguments},
+		}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	entries, err := audit.Query(path, audit.Filter{Tool: "deploy"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Arguments["target"] != "prod [REDACTED]" || entry.Arguments["password"] != "[REDACTED]" {
+		t.Errorf("Expected redacted arguments, got %v", entry.Arguments)
+	}
+	if entry.ExitCode == nil || *entry.ExitCode != 2 {
+		t.Errorf("Expected exit code 2, got %v", entry.ExitCode)
+	}
+	if entry.Error != "Script exited with code 2\nOutput: deploying prod [REDACTED] with [REDACTED]\n" || entry.OutputBytes == 0 {
+		t.Errorf("Expected the redacted error and output size, got %+v", entry)
+	}
+	if items, ok := entries[1].Arguments["target"].([]interface{}); !ok || items[0] != "[REDACTED]" {
+		t.Errorf("Expected secrets in lists to be redacted, got %v", entries[1].Arguments)
+	}
+}
@@ -0,0 +1,25 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file tells the clients of a server apart, so that what one of them
+// leaves on the server can't be used by another.
+package tools
+
+import (
+	"context"
+
+	"dizi/internal/auth"
+)
+
+// localCaller owns everything left on a server that doesn't require a token
+const localCaller = "local"
+
+// callerOf returns whom a call belongs to: the token it was made with when
+// the server requires one, so that the same token finds its truncated output,
+// jobs and shell sessions again after reconnecting. Without authentication
+// clients can't be told apart across sessions, so everything belongs to one
+// local owner that an SSE client finds again after reconnecting.
+func callerOf(ctx context.Context) string {
+	if identity := auth.FromContext(ctx); identity != nil {
+		return identity.Method + ":" + identity.Name
+	}
+	return localCaller
+}
@@ -0,0 +1,111 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file wires client-side request cancellation into tool handler contexts.
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// requestIDMetaKey is the _meta field used to hand the JSON-RPC request ID from
+// the BeforeCallTool hook to the cancellation middleware, since tool handlers
+// are not otherwise told which request they are serving
+const requestIDMetaKey = "dizi/requestId"
+
+// callTracker keeps the cancel functions of in-flight tool calls so that
+// notifications/cancelled can stop them
+type callTracker struct {
+	mu    sync.Mutex
+	calls map[string]context.CancelFunc
+}
+
+// ServerOptions returns the MCP server options tool handlers rely on.
+// They must be passed to server.NewMCPServer for notifications/cancelled
+// to reach running commands. The stdio transport runs tool calls on a pool
+// of workers, so cancellations and answers to elicitation requests arrive
+// while a call runs. The server uses hooks, to which
+// callers may add their own; nil creates new hooks. The tools capability is
+// declared even for a server that starts without tools, as reloading the
+// config may add some.
+func ServerOptions(hooks *server.Hooks) []server.ServerOption {
+	tracker := &callTracker{calls: make(map[string]context.CancelFunc)}
+
+	if hooks == nil {
+		hooks = &server.Hooks{}
+	}
+	hooks.AddBeforeCallTool(tracker.beforeCallTool)
+
+	return []server.ServerOption{
+		server.WithHooks(hooks),
+		server.WithToolCapabilities(true),
+		server.WithToolHandlerMiddleware(tracker.middleware),
+		func(s *server.MCPServer) {
+			s.AddNotificationHandler("notifications/cancelled", tracker.handleCancelled)
+		},
+	}
+}
+
+// beforeCallTool stashes the request ID in the request metadata
+func (t *callTracker) beforeCallTool(_ context.Context, id any, request *mcp.CallToolRequest) {
+	if request.Params.Meta == nil {
+		request.Params.Meta = &mcp.Meta{}
+	}
+	if request.Params.Meta.AdditionalFields == nil {
+		request.Params.Meta.AdditionalFields = make(map[string]any)
+	}
+	request.Params.Meta.AdditionalFields[requestIDMetaKey] = id
+}
+
+// middleware gives each tool call a context that is cancelled when the client
+// sends notifications/cancelled for its request
+func (t *callTracker) middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if request.Params.Meta == nil {
+			return next(ctx, request)
+		}
+		id, ok := request.Params.Meta.AdditionalFields[requestIDMetaKey]
+		if !ok {
+			return next(ctx, request)
+		}
+		delete(request.Params.Meta.AdditionalFields, requestIDMetaKey)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		key := callKey(ctx, id)
+		t.mu.Lock()
+		t.calls[key] = cancel
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.calls, key)
+			t.mu.Unlock()
+		}()
+
+		return next(ctx, request)
+	}
+}
+
+// handleCancelled cancels the in-flight call named by a notifications/cancelled message
+func (t *callTracker) handleCancelled(ctx context.Context, notification mcp.JSONRPCNotification) {
+	id, ok := notification.Params.AdditionalFields["requestId"]
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	cancel, ok := t.calls[callKey(ctx, id)]
+	t.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// callKey identifies a request within its client session
+func callKey(ctx context.Context, id any) string {
+	return fmt.Sprintf("%s/%v", clientSessionID(ctx), id)
+}
@@ -0,0 +1,194 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file passes configured environment variables to tools and keeps secret values out of their output.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toolEnviron returns the process environment for a tool: base, or the
+// server's environment if base is nil, with the tool's variables added. It
+// returns nil to inherit the server's environment unchanged.
+func toolEnviron(tool config.ToolConfig, base []string) []string {
+	if len(tool.Environment) == 0 {
+		return base
+	}
+	environ := os.Environ()
+	if base != nil {
+		environ = append([]string(nil), base...)
+	}
+	for _, v := range tool.Environment {
+		environ = append(environ, v.Name+"="+v.Value)
+	}
+	return environ
+}
+
+// setLuaGetenv makes os.getenv in a Lua tool see the tool's environment env
+func setLuaGetenv(L *lua.LState, env []config.EnvVar) {
+	if len(env) == 0 {
+		return
+	}
+	vars := make(map[string]string, len(env))
+	for _, v := range env {
+		vars[v.Name] = v.Value
+	}
+
+	osTable, ok := L.GetGlobal("os").(*lua.LTable)
+	if !ok {
+		return
+	}
+	osTable.RawSetString("getenv", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if value, ok := vars[name]; ok {
+			L.Push(lua.LString(value))
+		} else if value, ok := os.LookupEnv(name); ok {
+			L.Push(lua.LString(value))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+}
+
+// redactor replaces secret values with config.Redacted
+type redactor struct {
+	replacer *strings.Replacer
+}
+
+// newRedactor creates a redactor for secrets, nil if there are none
+func newRedactor(secrets []string) *redactor {
+	if len(secrets) == 0 {
+		return nil
+	}
+	// Longest first so a secret containing another is replaced as a whole
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, config.Redacted)
+	}
+	return &redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Redact returns s with all secrets replaced; a nil redactor returns s unchanged
+func (r *redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactorKey is the context key for the redactor of the running tool
+type redactorKey struct{}
+
+// redactorFromContext returns the redactor of the running tool, or nil
+func redactorFromContext(ctx context.Context) *redactor {
+	r, _ := ctx.Value(redactorKey{}).(*redactor)
+	return r
+}
+
+// toolSecrets returns the secret values in a tool's environment
+func toolSecrets(tool config.ToolConfig) []string {
+	var secrets []string
+	for _, v := range tool.Environment {
+		if v.Secret && v.Value != "" {
+			secrets = append(secrets, v.Value)
+		}
+	}
+	return secrets
+}
+
+// withRedaction wraps handler so that the secret values of the tool's
+// environment never reach the client, neither in the result nor in progress
+// notifications. Tools without secrets are left unchanged.
+func withRedaction(tool config.ToolConfig, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	r := newRedactor(toolSecrets(tool))
+	if r == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := handler(context.WithValue(ctx, redactorKey{}, r), request)
+		if err != nil {
+			return nil, &redactedError{err: err, message: r.Redact(err.Error())}
+		}
+		if result != nil {
+			for i, content := range result.Content {
+				result.Content[i] = r.redactContent(content)
+			}
+			if result.StructuredContent != nil {
+				result.StructuredContent = r.redactValue(result.StructuredContent)
+			}
+		}
+		return result, nil
+	}
+}
+
+// redactContent removes secrets from text and from embedded text resources
+func (r *redactor) redactContent(content mcp.Content) mcp.Content {
+	switch c := content.(type) {
+	case mcp.TextContent:
+		c.Text = r.Redact(c.Text)
+		return c
+	case mcp.EmbeddedResource:
+		if text, ok := c.Resource.(mcp.TextResourceContents); ok {
+			text.Text = r.Redact(text.Text)
+			c.Resource = text
+		}
+		return c
+	}
+	return content
+}
+
+// redactValue removes secrets from the strings of a structured result,
+// keys included. Values that aren't plain JSON values are redacted in their
+// JSON form.
+func (r *redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, float64, int, json.Number:
+		return v
+	case string:
+		return r.Redact(v)
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[r.Redact(key)] = r.redactValue(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = r.redactValue(item)
+		}
+		return redacted
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return r.redactValue(decoded)
+}
+
+// redactedError is an error whose message has had secrets removed
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string { return e.message }
+func (e *redactedError) Unwrap() error { return e.err }
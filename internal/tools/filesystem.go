@@ -5,12 +5,15 @@ package tools
 import (
 	"bufio"
 	"context"
+	"dizi/internal/approval"
+	"dizi/internal/audit"
 	"dizi/internal/gitls"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"unicode/utf8"
 
@@ -22,6 +25,9 @@ import (
 // FilesystemConfig holds configuration for filesystem tools
 type FilesystemConfig struct {
 	RootDirectory string
+	Confirm       []string         // tools whose calls a person must approve
+	Approvals     *approval.Broker // where those calls wait for a decision
+	Audit         *audit.Log       // records every call, if set
 }
 
 // FilesystemServer wraps the filesystem functionality
@@ -55,6 +61,17 @@ func NewFilesystemServer(config *FilesystemConfig) *FilesystemServer {
 
 // RegisterFilesystemTools registers all filesystem-related tools
 func RegisterFilesystemTools(mcpServer *server.MCPServer, config *FilesystemConfig) error {
+	serverTools, err := FilesystemTools(config)
+	if err != nil {
+		return err
+	}
+	mcpServer.AddTools(serverTools...)
+	return nil
+}
+
+// FilesystemTools creates the filesystem tools of a new FilesystemServer,
+// to be registered for the whole server or a single session
+func FilesystemTools(config *FilesystemConfig) ([]server.ServerTool, error) {
 	fs := NewFilesystemServer(config)
 
 	tools := []struct {
@@ -176,36 +193,40 @@ func RegisterFilesystemTools(mcpServer *server.MCPServer, config *FilesystemConf
 		},
 	}
 
+	serverTools := make([]server.ServerTool, 0, len(tools))
 	for _, tool := range tools {
 		schemaBytes, err := json.Marshal(tool.schema)
 		if err != nil {
-			return fmt.Errorf("failed to marshal schema for tool %s: %w", tool.name, err)
+			return nil, fmt.Errorf("failed to marshal schema for tool %s: %w", tool.name, err)
 		}
 
 		mcpTool := mcp.NewToolWithRawSchema(tool.name, tool.desc, json.RawMessage(schemaBytes))
-		mcpServer.AddTool(mcpTool, tool.handler)
+		handler := server.ToolHandlerFunc(tool.handler)
+		if slices.Contains(fs.config.Confirm, tool.name) {
+			handler = withApproval(tool.name, fs.config.Approvals, handler)
+		}
+		handler = withAudit(tool.name, nil, fs.config.Audit, handler)
+		serverTools = append(serverTools, server.ServerTool{Tool: mcpTool, Handler: handler})
 	}
 
-	return nil
+	return serverTools, nil
 }
 
 // validatePath checks if the path is allowed and safe - only allows access within the root directory
 func (fs *FilesystemServer) validatePath(path string) (string, error) {
-	// Clean the path to prevent path traversal attacks
-	cleanPath := filepath.Clean(path)
-
-	// Make both the input path and root directory absolute
-	absPath, err := filepath.Abs(cleanPath)
-	if err != nil {
-		return "", fmt.Errorf("invalid path: %w", err)
-	}
-
 	// Get absolute root directory
 	rootAbs, err := filepath.Abs(fs.config.RootDirectory)
 	if err != nil {
 		return "", fmt.Errorf("invalid root directory: %w", err)
 	}
 
+	// Relative paths are relative to the root, which for SSE sessions need
+	// not be the working directory. Cleaning prevents path traversal attacks.
+	absPath := filepath.Clean(path)
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(rootAbs, absPath)
+	}
+
 	// Ensure path is within root directory (strict containment check)
 	// This prevents access to files outside the project directory
 	if !strings.HasPrefix(absPath+string(filepath.Separator), rootAbs+string(filepath.Separator)) && absPath != rootAbs {
@@ -83,6 +83,16 @@ func TestValidatePath(t *testing.T) {
 			path:        tempDir,
 			expectError: false,
 		},
+		{
+			name:        "relative path within root",
+			path:        filepath.Join("subdir", "test.txt"),
+			expectError: false,
+		},
+		{
+			name:        "relative path traversal attempt",
+			path:        filepath.Join("..", "outside.txt"),
+			expectError: true,
+		},
 		{
 			name:        "absolute path outside root",
 			path:        "/etc/passwd",
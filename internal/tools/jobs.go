@@ -0,0 +1,680 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file runs async tools as background jobs and provides the job_* tools to control them.
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dizi/internal/approval"
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// maxJobOutputBytes bounds the output kept per job; older output is dropped
+// first, so offsets into it stay valid but may point before the start
+const maxJobOutputBytes = 16 << 20
+
+// maxFinishedJobs is the number of finished jobs kept for their status and output
+const maxFinishedJobs = 50
+
+// defaultJobWait is how long job_wait waits when no timeout is given
+const defaultJobWait = 60 * time.Second
+
+// stdinWriteTimeout bounds how long a write to a job's stdin waits for a
+// process that doesn't read it
+const stdinWriteTimeout = 10 * time.Second
+
+// Job states
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+	jobTimedOut  = "timed_out"
+)
+
+// jobManager runs the background jobs of async tools. It belongs to the
+// server rather than a client session, so jobs outlive the request that
+// started them. Each job can only be seen and controlled by the caller that
+// started it, which with auth finds it again after reconnecting.
+type jobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// job is a command or script running in the background
+type job struct {
+	id        string
+	tool      string
+	caller    string
+	config    config.ToolConfig
+	approvals *approval.Broker
+	started   time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+	redactor  *redactor
+
+	stdinMu sync.Mutex
+	stdin   *os.File // write end of the job's stdin, nil once closed
+
+	mu       sync.Mutex
+	output   []byte // combined stdout and stderr, from offset base on
+	base     int
+	status   string
+	exitCode int
+	err      string
+	finished time.Time
+}
+
+// jobStatus is the structured form of a job's state
+type jobStatus struct {
+	ID          string  `json:"job_id"`
+	Tool        string  `json:"tool"`
+	Status      string  `json:"status"`
+	ExitCode    *int    `json:"exit_code,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	StartedAt   string  `json:"started_at"`
+	FinishedAt  string  `json:"finished_at,omitempty"`
+	Seconds     float64 `json:"duration_seconds"`
+	OutputBytes int     `json:"output_bytes"`
+}
+
+// newJobManager creates a manager without jobs
+func newJobManager() *jobManager {
+	return &jobManager{jobs: make(map[string]*job)}
+}
+
+// Start runs the command built by newCmd as a background job for the caller
+// of ctx and returns the result telling the client its ID. The job is bounded
+// by the tool's own timeout only; the server default is meant for calls that
+// block. Its stdin stays open for job_write_stdin, starting with the
+// command's own stdin; input written later needs the same approval as the
+// call, from approvals.
+func (m *jobManager) Start(ctx context.Context, tool config.ToolConfig, approvals *approval.Broker, newCmd func(ctx context.Context) (*exec.Cmd, error)) *mcp.CallToolResult {
+	if m == nil {
+		return mcp.NewToolResultError("Background jobs are not available")
+	}
+
+	// IDs are random so that they can't be guessed
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create job ID: %v", err))
+	}
+	caller := callerOf(ctx)
+	ctx, cancel := withTimeout(context.Background(), tool.Timeout)
+	m.mu.Lock()
+	j := &job{
+		id:        "job-" + hex.EncodeToString(raw[:]),
+		tool:      tool.Name,
+		caller:    caller,
+		config:    tool,
+		approvals: approvals,
+		started:   time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		redactor:  newRedactor(toolSecrets(tool)),
+		status:    jobRunning,
+		exitCode:  -1,
+	}
+	m.jobs[j.id] = j
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	cmd, err := newCmd(ctx)
+	if err != nil {
+		cancel()
+		j.finish(jobFailed, -1, err.Error())
+		return mcp.NewToolResultError(err.Error())
+	}
+	cmd.Stdout = j
+	cmd.Stderr = j
+	input := cmd.Stdin
+	stdin, stdinWriter, err := os.Pipe()
+	if err != nil {
+		cancel()
+		j.finish(jobFailed, -1, err.Error())
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to create stdin for job: %v", err))
+	}
+	cmd.Stdin = stdin
+	j.stdin = stdinWriter
+	err = cmd.Start()
+	stdin.Close()
+	if err != nil {
+		cancel()
+		j.CloseInput()
+		j.finish(jobFailed, -1, err.Error())
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to start job: %v", err))
+	}
+
+	text := fmt.Sprintf("Started job %s for tool %s. Use job_status, job_output, job_wait, job_write_stdin or job_cancel with job_id %q.", j.id, tool.Name, j.id)
+	if input != nil {
+		data, err := io.ReadAll(input)
+		if err == nil {
+			_, err = j.WriteInput(string(data), false)
+		}
+		if err != nil {
+			text += fmt.Sprintf(" Writing stdin failed: %v.", err)
+		}
+	}
+
+	go func() {
+		defer cancel()
+		err := cmd.Wait()
+		j.CloseInput()
+		exitCode := -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			j.finish(jobTimedOut, exitCode, fmt.Sprintf("timed out after %v", tool.Timeout))
+		case ctx.Err() == context.Canceled:
+			j.finish(jobCancelled, exitCode, "")
+		case err != nil && !errors.As(err, &exitErr):
+			j.finish(jobFailed, exitCode, err.Error())
+		case !tool.IgnoreExitCode && !successExitCode(tool, exitCode):
+			j.finish(jobFailed, exitCode, "")
+		default:
+			j.finish(jobSucceeded, exitCode, "")
+		}
+	}()
+
+	result := mcp.NewToolResultText(text)
+	result.Content = append(result.Content, statusContent(j.Status()))
+	return result
+}
+
+// Get returns the job with the given ID if caller started it
+func (m *jobManager) Get(caller, id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.caller != caller {
+		return nil, false
+	}
+	return j, true
+}
+
+// List returns the jobs caller started, oldest first
+func (m *jobManager) List(caller string) []*job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		if j.caller == caller {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].started.Before(jobs[k].started) })
+	return jobs
+}
+
+// pruneLocked forgets the oldest finished jobs beyond maxFinishedJobs
+func (m *jobManager) pruneLocked() {
+	var finished []*job
+	for _, j := range m.jobs {
+		if j.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].started.Before(finished[k].started) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.id)
+	}
+}
+
+// Write implements io.Writer, collecting the job's output
+func (j *job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.output = append(j.output, p...)
+	// Drop in chunks so that a chatty job doesn't copy its output on every write
+	if len(j.output) > maxJobOutputBytes+maxJobOutputBytes/4 {
+		drop := len(j.output) - maxJobOutputBytes
+		j.output = append([]byte(nil), j.output[drop:]...)
+		j.base += drop
+	}
+	return len(p), nil
+}
+
+// WriteInput writes data to the job's stdin and closes it afterwards if
+// closeInput is set. It returns the number of bytes written.
+func (j *job) WriteInput(data string, closeInput bool) (int, error) {
+	j.stdinMu.Lock()
+	defer j.stdinMu.Unlock()
+	if j.stdin == nil {
+		return 0, errors.New("stdin is closed")
+	}
+
+	n := 0
+	if data != "" {
+		// Deadlines aren't supported for pipes everywhere; then the write just blocks
+		_ = j.stdin.SetWriteDeadline(time.Now().Add(stdinWriteTimeout))
+		var err error
+		n, err = j.stdin.WriteString(data)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, fmt.Errorf("the process did not read its input within %v", stdinWriteTimeout)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if closeInput {
+		j.stdin.Close()
+		j.stdin = nil
+	}
+	return n, nil
+}
+
+// checkInput applies the checks of the call that started the job to input
+// for it: the tool's policy, as the job may be a shell that runs its input,
+// and approval for tools with confirm: true. It returns an error result if
+// the input may not be written, nil if it may.
+func (j *job) checkInput(ctx context.Context, request mcp.CallToolRequest, input string) *mcp.CallToolResult {
+	if input != "" {
+		if result := checkPolicy(j.config, input); result != nil {
+			return result
+		}
+	}
+	if !j.config.Confirm {
+		return nil
+	}
+	approved := withApproval(j.tool, j.approvals, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return nil, nil
+	})
+	result, _ := approved(ctx, request)
+	return result
+}
+
+// CloseInput closes the job's stdin if it is still open
+func (j *job) CloseInput() {
+	j.stdinMu.Lock()
+	defer j.stdinMu.Unlock()
+	if j.stdin != nil {
+		j.stdin.Close()
+		j.stdin = nil
+	}
+}
+
+// finish records the final state of the job
+func (j *job) finish(status string, exitCode int, err string) {
+	j.mu.Lock()
+	j.status = status
+	j.exitCode = exitCode
+	j.err = err
+	j.finished = time.Now()
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// Finished reports whether the job has ended
+func (j *job) Finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns a snapshot of the job's state
+func (j *job) Status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := jobStatus{
+		ID:          j.id,
+		Tool:        j.tool,
+		Status:      j.status,
+		Error:       j.redactor.Redact(j.err),
+		StartedAt:   j.started.Format(time.RFC3339),
+		OutputBytes: j.base + len(j.output),
+	}
+	end := time.Now()
+	if j.status != jobRunning {
+		end = j.finished
+		status.FinishedAt = j.finished.Format(time.RFC3339)
+		if j.exitCode >= 0 {
+			exitCode := j.exitCode
+			status.ExitCode = &exitCode
+		}
+	}
+	status.Seconds = end.Sub(j.started).Round(time.Millisecond).Seconds()
+	return status
+}
+
+// Output returns up to maxBytes of output from offset on, and the offset
+// after it. An offset before the kept output starts at the oldest kept byte.
+func (j *job) Output(offset, maxBytes int) (string, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	offset = min(max(offset, j.base), j.base+len(j.output))
+	text := string(j.output[offset-j.base:])
+	if maxBytes > 0 && len(text) > maxBytes {
+		text = headOf(text, maxBytes, 0)
+	}
+	return j.redactor.Redact(text), offset + len(text)
+}
+
+// Tail returns the last lines of output and the offset after it
+func (j *job) Tail(lines int) (string, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.redactor.Redact(tailOf(string(j.output), 0, lines)), j.base + len(j.output)
+}
+
+// describe renders a job's state in a sentence
+func (s jobStatus) describe() string {
+	text := fmt.Sprintf("Job %s (%s) %s", s.ID, s.Tool, s.Status)
+	if s.Status == jobRunning {
+		text = fmt.Sprintf("Job %s (%s) running for %.0fs", s.ID, s.Tool, s.Seconds)
+	}
+	if s.ExitCode != nil {
+		text += fmt.Sprintf(", exit code %d", *s.ExitCode)
+	}
+	if s.Error != "" {
+		text += ": " + s.Error
+	}
+	return text + fmt.Sprintf(", %d bytes of output", s.OutputBytes)
+}
+
+// statusContent renders a job status as JSON content
+func statusContent(value interface{}) mcp.Content {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return mcp.NewTextContent(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+	return mcp.NewTextContent(string(data))
+}
+
+// jobTools creates the builtin tools that control the jobs of m
+func jobTools(m *jobManager) []server.ServerTool {
+	jobID := map[string]interface{}{
+		"type":        "string",
+		"description": "The job_id returned when the job was started.",
+	}
+	definitions := []struct {
+		name       string
+		desc       string
+		properties map[string]interface{}
+		required   []interface{}
+		handler    func(ctx context.Context, m *jobManager, request mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult
+	}{
+		{
+			"job_status",
+			"Returns the state of a background job: running, succeeded, failed, cancelled or timed_out, with its exit code once it has finished.",
+			map[string]interface{}{"job_id": jobID},
+			[]interface{}{"job_id"},
+			handleJobStatus,
+		},
+		{
+			"job_output",
+			"Returns the output of a background job. Pass the next_offset of the previous call as offset to read only new output, or tail to read the last lines.",
+			map[string]interface{}{
+				"job_id": jobID,
+				"offset": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     0,
+					"description": "Optional: the byte offset to read from, usually the next_offset of the previous call. Defaults to 0.",
+				},
+				"max_bytes": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": fmt.Sprintf("Optional: the maximum number of bytes to return. Defaults to %d.", defaultOutputLimitBytes),
+				},
+				"tail": map[string]interface{}{
+					"type":        "integer",
+					"minimum":     1,
+					"description": "Optional: return only the last lines of the output instead of reading from offset.",
+				},
+			},
+			[]interface{}{"job_id"},
+			handleJobOutput,
+		},
+		{
+			"job_wait",
+			"Waits for a background job to finish and returns its state. Returns early with the job still running if the timeout passes first.",
+			map[string]interface{}{
+				"job_id": jobID,
+				"timeout": map[string]interface{}{
+					"type":        "number",
+					"minimum":     0,
+					"description": fmt.Sprintf("Optional: the number of seconds to wait. Defaults to %.0f.", defaultJobWait.Seconds()),
+				},
+			},
+			[]interface{}{"job_id"},
+			handleJobWait,
+		},
+		{
+			"job_write_stdin",
+			"Writes input to the stdin of a running background job, such as the answer to a prompt. Read the response with job_output from the next_offset this returns.",
+			map[string]interface{}{
+				"job_id": jobID,
+				"input": map[string]interface{}{
+					"type":        "string",
+					"description": "Optional: the text to write. End it with a newline to submit a line.",
+				},
+				"close": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Optional: close stdin after writing, so the program sees the end of its input.",
+				},
+			},
+			[]interface{}{"job_id"},
+			handleJobWriteStdin,
+		},
+		{
+			"job_cancel",
+			"Stops a running background job, including the processes it started.",
+			map[string]interface{}{"job_id": jobID},
+			[]interface{}{"job_id"},
+			handleJobCancel,
+		},
+		{
+			"job_list",
+			"Lists the running and recently finished background jobs.",
+			map[string]interface{}{},
+			[]interface{}{},
+			handleJobList,
+		},
+	}
+
+	serverTools := make([]server.ServerTool, 0, len(definitions))
+	for _, definition := range definitions {
+		parameters := map[string]interface{}{
+			"type":       "object",
+			"properties": definition.properties,
+			"required":   definition.required,
+		}
+		schemaBytes, _ := json.Marshal(parameters)
+		handler := definition.handler
+		tool := config.ToolConfig{Name: definition.name, Type: "builtin", Parameters: parameters}
+		serverTools = append(serverTools, server.ServerTool{
+			Tool: mcp.NewToolWithRawSchema(definition.name, definition.desc, json.RawMessage(schemaBytes)),
+			Handler: withArgumentValidation(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				arguments, ok := request.Params.Arguments.(map[string]interface{})
+				if !ok {
+					return mcp.NewToolResultError("Invalid arguments format"), nil
+				}
+				return handler(ctx, m, request, arguments), nil
+			}),
+		})
+	}
+	return serverTools
+}
+
+// findJob looks up the job named by the job_id argument. The jobs of other
+// callers are reported as unknown, like those that never existed.
+func findJob(ctx context.Context, m *jobManager, arguments map[string]interface{}) (*job, *mcp.CallToolResult) {
+	id, _ := arguments["job_id"].(string)
+	j, ok := m.Get(callerOf(ctx), id)
+	if !ok {
+		return nil, mcp.NewToolResultError(fmt.Sprintf("Unknown job %q", id))
+	}
+	return j, nil
+}
+
+// jobStatusResult reports a job's state as a sentence followed by JSON
+func jobStatusResult(status jobStatus) *mcp.CallToolResult {
+	result := mcp.NewToolResultText(status.describe())
+	result.Content = append(result.Content, statusContent(status))
+	return result
+}
+
+// handleJobStatus handles the builtin job_status tool
+func handleJobStatus(ctx context.Context, m *jobManager, _ mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult {
+	j, errResult := findJob(ctx, m, arguments)
+	if errResult != nil {
+		return errResult
+	}
+	return jobStatusResult(j.Status())
+}
+
+// handleJobOutput handles the builtin job_output tool
+func handleJobOutput(ctx context.Context, m *jobManager, _ mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult {
+	j, errResult := findJob(ctx, m, arguments)
+	if errResult != nil {
+		return errResult
+	}
+
+	var text string
+	var next int
+	if tail, ok := arguments["tail"].(float64); ok && tail >= 1 {
+		text, next = j.Tail(int(tail))
+	} else {
+		offset, _ := arguments["offset"].(float64)
+		maxBytes := defaultOutputLimitBytes
+		if value, ok := arguments["max_bytes"].(float64); ok && value >= 1 {
+			maxBytes = int(value)
+		}
+		text, next = j.Output(int(offset), maxBytes)
+	}
+
+	status := j.Status()
+	result := mcp.NewToolResultText(text)
+	result.Content = append(result.Content, statusContent(map[string]interface{}{
+		"job_id":       status.ID,
+		"status":       status.Status,
+		"next_offset":  next,
+		"output_bytes": status.OutputBytes,
+	}))
+	return result
+}
+
+// handleJobWait handles the builtin job_wait tool
+func handleJobWait(ctx context.Context, m *jobManager, _ mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult {
+	j, errResult := findJob(ctx, m, arguments)
+	if errResult != nil {
+		return errResult
+	}
+
+	wait := defaultJobWait
+	if seconds, ok := arguments["timeout"].(float64); ok && seconds >= 0 {
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-j.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return jobStatusResult(j.Status())
+}
+
+// handleJobWriteStdin handles the builtin job_write_stdin tool
+func handleJobWriteStdin(ctx context.Context, m *jobManager, request mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult {
+	j, errResult := findJob(ctx, m, arguments)
+	if errResult != nil {
+		return errResult
+	}
+	if j.Finished() {
+		return mcp.NewToolResultError(fmt.Sprintf("Job %s has already finished", j.id))
+	}
+
+	input, _ := arguments["input"].(string)
+	closeInput, _ := arguments["close"].(bool)
+	if result := j.checkInput(ctx, request, input); result != nil {
+		return result
+	}
+	// Output from here on is the program's response to the input
+	offset := j.Status().OutputBytes
+	n, err := j.WriteInput(input, closeInput)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to write to the stdin of job %s: %v", j.id, err))
+	}
+
+	text := fmt.Sprintf("Wrote %d bytes to the stdin of job %s", n, j.id)
+	if closeInput {
+		text += " and closed it"
+	}
+	status := j.Status()
+	result := mcp.NewToolResultText(text + fmt.Sprintf(". Read the response with job_output from offset %d.", offset))
+	result.Content = append(result.Content, statusContent(map[string]interface{}{
+		"job_id":        status.ID,
+		"status":        status.Status,
+		"bytes_written": n,
+		"next_offset":   offset,
+	}))
+	return result
+}
+
+// handleJobCancel handles the builtin job_cancel tool
+func handleJobCancel(ctx context.Context, m *jobManager, _ mcp.CallToolRequest, arguments map[string]interface{}) *mcp.CallToolResult {
+	j, errResult := findJob(ctx, m, arguments)
+	if errResult != nil {
+		return errResult
+	}
+	if j.Finished() {
+		return jobStatusResult(j.Status())
+	}
+
+	j.cancel()
+	// Give the process group a moment to exit so the reported state is final
+	select {
+	case <-j.done:
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+	}
+	return jobStatusResult(j.Status())
+}
+
+// handleJobList handles the builtin job_list tool
+func handleJobList(ctx context.Context, m *jobManager, _ mcp.CallToolRequest, _ map[string]interface{}) *mcp.CallToolResult {
+	jobs := m.List(callerOf(ctx))
+	if len(jobs) == 0 {
+		result := mcp.NewToolResultText("No jobs")
+		result.Content = append(result.Content, statusContent([]jobStatus{}))
+		return result
+	}
+
+	statuses := make([]jobStatus, len(jobs))
+	lines := make([]string, len(jobs))
+	for i, j := range jobs {
+		statuses[i] = j.Status()
+		lines[i] = statuses[i].describe()
+	}
+	result := mcp.NewToolResultText(strings.Join(lines, "\n"))
+	result.Content = append(result.Content, statusContent(statuses))
+	return result
+}
@@ -0,0 +1,313 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"dizi/internal/approval"
+	"dizi/internal/auth"
+	"dizi/internal/config"
+	"dizi/internal/shell"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// callJobTool calls one of the job_* tools of m
+func callJobTool(t *testing.T, m *jobManager, name string, arguments map[string]interface{}) *mcp.CallToolResult {
+	t.Helper()
+	return callJobToolContext(t, context.Background(), m, name, arguments)
+}
+
+// callJobToolContext calls one of the job_* tools of m as the caller of ctx
+func callJobToolContext(t *testing.T, ctx context.Context, m *jobManager, name string, arguments map[string]interface{}) *mcp.CallToolResult {
+	t.Helper()
+	for _, tool := range jobTools(m) {
+		if tool.Tool.Name != name {
+			continue
+		}
+		result, err := tool.Handler(ctx, mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: arguments},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error from %s: %v", name, err)
+		}
+		return result
+	}
+	t.Fatalf("No tool named %s", name)
+	return nil
+}
+
+// startJob calls an async tool and returns the ID of the job it started
+func startJob(t *testing.T, m *jobManager, tool config.ToolConfig) string {
+	t.Helper()
+	return startJobContext(t, context.Background(), m, tool)
+}
+
+// startJobContext starts a job as the caller of ctx
+func startJobContext(t *testing.T, ctx context.Context, m *jobManager, tool config.ToolConfig) string {
+	t.Helper()
+	serverTool, err := buildTool(tool, &registerOptions{jobs: m})
+	if err != nil {
+		t.Fatalf("Failed to build tool: %v", err)
+	}
+	result, err := serverTool.Handler(ctx, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("Failed to start job: %v %v", err, result.Content)
+	}
+
+	var status jobStatus
+	if err := json.Unmarshal([]byte(contentTexts(result)[1]), &status); err != nil {
+		t.Fatalf("Expected job status, got %v", result.Content)
+	}
+	if status.Status != jobRunning {
+		t.Errorf("Expected job to be running, got %s", status.Status)
+	}
+	return status.ID
+}
+
+func TestAsyncJob(t *testing.T) {
+	m := newJobManager()
+	id := startJob(t, m, config.ToolConfig{
+		Name:   "build",
+		Type:   "script",
+		Script: "echo compiling; sleep 1; echo linked; exit 3",
+		Async:  true,
+		Environment: []config.EnvVar{
+			{Name: "TOKEN", Value: "linked", Secret: true},
+		},
+	})
+
+	result := callJobTool(t, m, "job_wait", map[string]interface{}{"job_id": id, "timeout": float64(30)})
+	var status jobStatus
+	if err := json.Unmarshal([]byte(contentTexts(result)[1]), &status); err != nil {
+		t.Fatalf("Expected job status, got %v", result.Content)
+	}
+	if status.Status != jobFailed || status.ExitCode == nil || *status.ExitCode != 3 {
+		t.Errorf("Expected job to fail with exit code 3, got %+v", status)
+	}
+
+	// Read the output in two steps, continuing from next_offset
+	result = callJobTool(t, m, "job_output", map[string]interface{}{"job_id": id, "max_bytes": float64(10)})
+	first := contentTexts(result)[0]
+	var page struct {
+		NextOffset int `json:"next_offset"`
+	}
+	if err := json.Unmarshal([]byte(contentTexts(result)[1]), &page); err != nil {
+		t.Fatalf("Expected output status, got %v", result.Content)
+	}
+	result = callJobTool(t, m, "job_output", map[string]interface{}{"job_id": id, "offset": float64(page.NextOffset)})
+	if output := first + contentTexts(result)[0]; !strings.Contains(output, "compiling\n") || !strings.Contains(output, config.Redacted) {
+		t.Errorf("Expected full redacted output, got %q", output)
+	}
+
+	result = callJobTool(t, m, "job_output", map[string]interface{}{"job_id": id, "tail": float64(1)})
+	if text := contentTexts(result)[0]; text != config.Redacted+"\n" {
+		t.Errorf("Expected last line, got %q", text)
+	}
+
+	result = callJobTool(t, m, "job_list", map[string]interface{}{})
+	if text := contentTexts(result)[0]; !strings.Contains(text, id) {
+		t.Errorf("Expected job in list, got %q", text)
+	}
+
+	if result := callJobTool(t, m, "job_status", map[string]interface{}{"job_id": "job-999"}); !result.IsError {
+		t.Error("Expected error for unknown job")
+	}
+}
+
+func TestAsyncJobCancel(t *testing.T) {
+	m := newJobManager()
+	id := startJob(t, m, config.ToolConfig{Name: "flash", Type: "script", Script: "sleep 30", Async: true})
+
+	result := callJobTool(t, m, "job_wait", map[string]interface{}{"job_id": id, "timeout": float64(0.1)})
+	if text := contentTexts(result)[0]; !strings.Contains(text, "running") {
+		t.Errorf("Expected job to still be running, got %q", text)
+	}
+
+	result = callJobTool(t, m, "job_cancel", map[string]interface{}{"job_id": id})
+	var status jobStatus
+	if err := json.Unmarshal([]byte(contentTexts(result)[1]), &status); err != nil {
+		t.Fatalf("Expected job status, got %v", result.Content)
+	}
+	if status.Status != jobCancelled {
+		t.Errorf("Expected job to be cancelled, got %+v", status)
+	}
+}
+
+func TestAsyncJobStdin(t *testing.T) {
+	m := newJobManager()
+	id := startJob(t, m, config.ToolConfig{
+		Name:   "select_board",
+		Type:   "script",
+		Script: `read board; echo "board $board"; read ok; echo "confirm $ok"; cat; echo done`,
+		Stdin:  "esp32\n",
+		Async:  true,
+	})
+
+	result := callJobTool(t, m, "job_write_stdin", map[string]interface{}{"job_id": id, "input": "y\nrest", "close": true})
+	if result.IsError {
+		t.Fatalf("Failed to write stdin: %v", result.Content)
+	}
+
+	result = callJobTool(t, m, "job_wait", map[string]interface{}{"job_id": id, "timeout": float64(30)})
+	if text := contentTexts(result)[0]; !strings.Contains(text, "succeeded") {
+		t.Fatalf("Expected job to succeed once stdin is closed, got %q", text)
+	}
+	result = callJobTool(t, m, "job_output", map[string]interface{}{"job_id": id})
+	if text := contentTexts(result)[0]; !strings.Contains(text, "board esp32\nconfirm y\nrest") || !strings.HasSuffix(text, "done\n") {
+		t.Errorf("Expected the program to read all input, got %q", text)
+	}
+
+	if result := callJobTool(t, m, "job_write_stdin", map[string]interface{}{"job_id": id, "input": "more\n"}); !result.IsError {
+		t.Error("Expected error writing to a finished job")
+	}
+}
+
+func TestAsyncJobBelongsToCaller(t *testing.T) {
+	m := newJobManager()
+	id := startJob(t, m, config.ToolConfig{Name: "flash", Type: "script", Script: "sleep 30", Async: true})
+	defer callJobTool(t, m, "job_cancel", map[string]interface{}{"job_id": id})
+	if !regexp.MustCompile(`^job-[0-9a-f]{16}$`).MatchString(id) {
+		t.Errorf("Expected a random job ID, got %q", id)
+	}
+
+	other := auth.WithIdentity(context.Background(), &auth.Identity{Name: "other", Method: auth.MethodToken})
+	for _, name := range []string{"job_status", "job_output", "job_wait", "job_write_stdin", "job_cancel"} {
+		result := callJobToolContext(t, other, m, name, map[string]interface{}{"job_id": id, "input": "x\n", "timeout": float64(0)})
+		if text := contentTexts(result)[0]; !result.IsError || text != fmt.Sprintf("Unknown job %q", id) {
+			t.Errorf("Expected %s to refuse the job of another caller, got %q", name, text)
+		}
+	}
+	if text := contentTexts(callJobToolContext(t, other, m, "job_list", map[string]interface{}{}))[0]; text != "No jobs" {
+		t.Errorf("Expected the jobs of other callers to be left out, got %q", text)
+	}
+	if result := callJobTool(t, m, "job_status", map[string]interface{}{"job_id": id}); result.IsError {
+		t.Errorf("Expected the caller to reach its own job, got %v", result.Content)
+	}
+}
+
+func TestAsyncJobOutlivesSession(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	first := sessionContext(t, mcpServer, newTestSession("first"))
+	second := sessionContext(t, mcpServer, newTestSession("second"))
+
+	// Without authentication a client that reconnects still finds its job
+	m := newJobManager()
+	id := startJobContext(t, first, m, config.ToolConfig{Name: "flash", Type: "script", Script: "sleep 30", Async: true})
+	defer callJobToolContext(t, second, m, "job_cancel", map[string]interface{}{"job_id": id})
+	if result := callJobToolContext(t, second, m, "job_status", map[string]interface{}{"job_id": id}); result.IsError {
+		t.Errorf("Expected the job to be found from another session, got %v", result.Content)
+	}
+	if text := contentTexts(callJobToolContext(t, second, m, "job_list", map[string]interface{}{}))[0]; !strings.Contains(text, id) {
+		t.Errorf("Expected the job to be listed from another session, got %q", text)
+	}
+}
+
+func TestAsyncJobStdinChecks(t *testing.T) {
+	m := newJobManager()
+	broker := approval.NewBroker(approval.Options{Timeout: time.Minute})
+	decide := func(decision approval.Decision) {
+		go func() {
+			for i := 0; i < 500; i++ {
+				if pending := broker.Pending(); len(pending) > 0 {
+					_ = broker.Decide(pending[0].ID, approval.Result{Decision: decision, By: "tester"})
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	tool := config.ToolConfig{
+		Name:    "repl",
+		Type:    "script",
+		Script:  "sh",
+		Async:   true,
+		Confirm: true,
+		Policy:  config.PolicyConfig{Deny: []string{"rm"}},
+	}
+	serverTool, err := buildTool(tool, &registerOptions{jobs: m, approvals: broker, shellEnv: shell.CleanEnv})
+	if err != nil {
+		t.Fatalf("Failed to build tool: %v", err)
+	}
+	decide(approval.Approve)
+	result, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Name: tool.Name, Arguments: map[string]interface{}{}},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("Failed to start job: %v %v", err, result.Content)
+	}
+	var status jobStatus
+	if err := json.Unmarshal([]byte(contentTexts(result)[1]), &status); err != nil {
+		t.Fatalf("Expected job status, got %v", result.Content)
+	}
+	defer callJobTool(t, m, "job_cancel", map[string]interface{}{"job_id": status.ID})
+
+	result = callJobTool(t, m, "job_write_stdin", map[string]interface{}{"job_id": status.ID, "input": "rm -rf /tmp/nothing\n"})
+	if text := contentTexts(result)[0]; !result.IsError || !strings.HasPrefix(text, "Policy violation") {
+		t.Errorf("Expected the tool's policy to apply to input, got %q", text)
+	}
+	decide(approval.Deny)
+	result = callJobTool(t, m, "job_write_stdin", map[string]interface{}{"job_id": status.ID, "input": "echo hi\n"})
+	if text := contentTexts(result)[0]; !result.IsError || !strings.Contains(text, "was not approved") {
+		t.Errorf("Expected input to need approval, got %q", text)
+	}
+	decide(approval.Approve)
+	if result := callJobTool(t, m, "job_write_stdin", map[string]interface{}{"job_id": status.ID, "input": "echo hi\n"}); result.IsError {
+		t.Errorf("Expected approved input to be written, got %v", result.Content)
+	}
+}
+
+func TestToolSetRegistersBuiltinTools(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", ServerOptions(nil)...)
+	toolSet := NewToolSet(mcpServer)
+	registered := func() map[string]bool {
+		t.Helper()
+		response := mcpServer.HandleMessage(context.Background(),
+			json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+		result := response.(mcp.JSONRPCResponse).Result.(mcp.ListToolsResult)
+		names := make(map[string]bool)
+		for _, tool := range result.Tools {
+			names[tool.Name] = true
+		}
+		return names
+	}
+	apply := func(tools ...config.ToolConfig) {
+		t.Helper()
+		if _, err := toolSet.Apply(tools); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	unlimited := config.OutputLimitConfig{Bytes: -1}
+
+	apply()
+	if names := registered(); len(names) != 0 {
+		t.Errorf("Expected no builtin tools without tools that need them, got %v", names)
+	}
+
+	apply(config.ToolConfig{Name: "build", Type: "script", Script: "make", OutputLimit: unlimited})
+	if names := registered(); len(names) != 1 {
+		t.Errorf("Expected no builtin tools for a tool without limit or async, got %v", names)
+	}
+
+	apply(config.ToolConfig{Name: "build", Type: "script", Script: "make", Async: true})
+	names := registered()
+	for _, name := range config.ReservedToolNames {
+		if !names[name] {
+			t.Errorf("Expected builtin tool %s to be registered", name)
+		}
+	}
+
+	apply(config.ToolConfig{Name: "build", Type: "script", Script: "make"})
+	names = registered()
+	if !names[config.FetchOutputTool] || names["job_status"] {
+		t.Errorf("Expected only fetch_output once no tool is async, got %v", names)
+	}
+}
@@ -0,0 +1,443 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file truncates long tool output and pages through it with the fetch_output tool.
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultOutputLimitBytes is the output limit of tools that don't configure
+// one, and the page size of fetch_output
+const defaultOutputLimitBytes = 64 << 10
+
+// maxStoredOutputBytes bounds the truncated output kept for fetch_output;
+// the oldest output is dropped first
+const maxStoredOutputBytes = 64 << 20
+
+// maxCallerOutputBytes bounds the truncated output kept for one caller, so
+// that one client can't push out everybody else's
+const maxCallerOutputBytes = 16 << 20
+
+// WithOutputLimit sets the output limit for tools that don't declare their own
+func WithOutputLimit(limit config.OutputLimitConfig) RegisterOption {
+	return func(o *registerOptions) {
+		o.outputLimit = limit
+	}
+}
+
+// outputLimitFor returns the effective output limit of a tool: its own
+// settings over the server's, over the defaults
+func (o *registerOptions) outputLimitFor(tool config.ToolConfig) config.OutputLimitConfig {
+	limit := o.outputLimit
+	if tool.OutputLimit.Bytes != 0 {
+		limit.Bytes = tool.OutputLimit.Bytes
+	}
+	if tool.OutputLimit.Lines != 0 {
+		limit.Lines = tool.OutputLimit.Lines
+	}
+	if tool.OutputLimit.Truncate != "" {
+		limit.Truncate = tool.OutputLimit.Truncate
+	}
+
+	if limit.Bytes == 0 {
+		limit.Bytes = defaultOutputLimitBytes
+	}
+	if limit.Truncate == "" {
+		limit.Truncate = "both"
+	}
+	return limit
+}
+
+// outputStore keeps the full text of truncated output for fetch_output.
+// Output can only be read by the caller whose call produced it.
+type outputStore struct {
+	mu      sync.Mutex
+	outputs map[string]storedOutput
+	order   []string       // ids, oldest first
+	size    int            // bytes kept in total
+	sizes   map[string]int // bytes kept per caller
+}
+
+// storedOutput is the full text of one truncated output and whose it is
+type storedOutput struct {
+	caller string
+	text   string
+}
+
+// newOutputStore creates an empty store
+func newOutputStore() *outputStore {
+	return &outputStore{outputs: make(map[string]storedOutput), sizes: make(map[string]int)}
+}
+
+// Put keeps text for caller and returns its id, or "" if it is too large to
+// keep. The oldest output of the caller, and then of anyone, is dropped to
+// make room.
+func (s *outputStore) Put(caller, text string) string {
+	if len(text) > maxCallerOutputBytes {
+		return ""
+	}
+
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(raw[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; s.sizes[caller]+len(text) > maxCallerOutputBytes && i < len(s.order); {
+		if s.outputs[s.order[i]].caller == caller {
+			s.drop(i)
+		} else {
+			i++
+		}
+	}
+	for s.size+len(text) > maxStoredOutputBytes && len(s.order) > 0 {
+		s.drop(0)
+	}
+	s.outputs[id] = storedOutput{caller: caller, text: text}
+	s.order = append(s.order, id)
+	s.size += len(text)
+	s.sizes[caller] += len(text)
+	return id
+}
+
+// drop forgets the output at position i of the order
+func (s *outputStore) drop(i int) {
+	id := s.order[i]
+	s.order = append(s.order[:i], s.order[i+1:]...)
+	output := s.outputs[id]
+	delete(s.outputs, id)
+	s.size -= len(output.text)
+	if s.sizes[output.caller] -= len(output.text); s.sizes[output.caller] <= 0 {
+		delete(s.sizes, output.caller)
+	}
+}
+
+// Get returns the text kept under id if it belongs to caller
+func (s *outputStore) Get(caller, id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	output, ok := s.outputs[id]
+	if !ok || output.caller != caller {
+		return "", false
+	}
+	return output.text, true
+}
+
+// limitEnabled reports whether a limit truncates anything
+func limitEnabled(limit config.OutputLimitConfig) bool {
+	return limit.Bytes >= 0 || limit.Lines > 0
+}
+
+// withOutputLimit wraps handler so that text in its results longer than the
+// limit is truncated, with the full text kept in store for fetch_output
+func withOutputLimit(limit config.OutputLimitConfig, store *outputStore, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if !limitEnabled(limit) {
+		return handler
+	}
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := handler(ctx, request)
+		if err != nil || result == nil {
+			return result, err
+		}
+		truncated := false
+		caller := callerOf(ctx)
+		for i, content := range result.Content {
+			if text, ok := content.(mcp.TextContent); ok && exceedsLimit(text.Text, limit) {
+				text.Text = limitText(text.Text, limit, store, caller)
+				result.Content[i] = text
+				truncated = true
+			}
+		}
+		if truncated && result.StructuredContent != nil {
+			result.StructuredContent = limitedStructuredContent(result, limit)
+		}
+		return result, nil
+	}
+}
+
+// limitedStructuredContent returns the structured content of a result whose
+// text was truncated: the object its JSON text still is, or otherwise the
+// structured content with its long string fields truncated. Those aren't
+// kept again; the full output is found with fetch_output through the text.
+func limitedStructuredContent(result *mcp.CallToolResult, limit config.OutputLimitConfig) interface{} {
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			var object map[string]interface{}
+			if err := json.Unmarshal([]byte(text.Text), &object); err == nil {
+				return object
+			}
+			break
+		}
+	}
+
+	object, ok := result.StructuredContent.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	limited := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		if s, ok := value.(string); ok && exceedsLimit(s, limit) {
+			value = truncateOutput(s, limit, nil, "")
+		}
+		limited[key] = value
+	}
+	return limited
+}
+
+// limitText truncates text. A JSON object such as the output of a json mode
+// tool keeps its structure; its long string fields are truncated instead.
+func limitText(text string, limit config.OutputLimitConfig, store *outputStore, caller string) string {
+	if !strings.HasPrefix(text, "{") {
+		return truncateOutput(text, limit, store, caller)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return truncateOutput(text, limit, store, caller)
+	}
+
+	changed := false
+	for key, value := range object {
+		if s, ok := value.(string); ok && exceedsLimit(s, limit) {
+			object[key] = truncateOutput(s, limit, store, caller)
+			changed = true
+		}
+	}
+	if !changed {
+		return text
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return text
+	}
+	return string(data)
+}
+
+// exceedsLimit reports whether text is longer than the limit allows
+func exceedsLimit(text string, limit config.OutputLimitConfig) bool {
+	return (limit.Bytes > 0 && len(text) > limit.Bytes) || (limit.Lines > 0 && countLines(text) > limit.Lines)
+}
+
+// countLines counts the lines of text, including a final unterminated one
+func countLines(text string) int {
+	n := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	return n
+}
+
+// truncateOutput keeps the head, the tail or both of text within the limit
+// and marks what was left out, with the cursor for caller to read it if it
+// was stored
+func truncateOutput(text string, limit config.OutputLimitConfig, store *outputStore, caller string) string {
+	var head, tail string
+	switch limit.Truncate {
+	case "head":
+		head = headOf(text, limit.Bytes, limit.Lines)
+	case "tail":
+		tail = tailOf(text, limit.Bytes, limit.Lines)
+	default:
+		head = headOf(text, half(limit.Bytes), half(limit.Lines))
+		tail = tailOf(text[len(head):], half(limit.Bytes), half(limit.Lines))
+	}
+	omitted := text[len(head) : len(text)-len(tail)]
+
+	marker := fmt.Sprintf("... [%d lines (%d bytes) omitted", countLines(omitted), len(omitted))
+	if store != nil {
+		if id := store.Put(caller, text); id != "" {
+			marker += fmt.Sprintf("; call %s with cursor %q to read the full output", config.FetchOutputTool, id+":0")
+		}
+	}
+	marker += "] ..."
+
+	var out strings.Builder
+	out.WriteString(head)
+	if head != "" && !strings.HasSuffix(head, "\n") {
+		out.WriteString("\n")
+	}
+	out.WriteString(marker)
+	if tail != "" {
+		out.WriteString("\n")
+		out.WriteString(tail)
+	}
+	return out.String()
+}
+
+// half splits a limit between head and tail; 0 and negative mean no limit
+func half(n int) int {
+	if n <= 0 {
+		return n
+	}
+	return max(n/2, 1)
+}
+
+// headOf returns the longest prefix of text within maxBytes and maxLines,
+// ending at a line break where possible and never inside a UTF-8 sequence
+func headOf(text string, maxBytes, maxLines int) string {
+	if maxLines > 0 {
+		n := 0
+		for i := 0; i < len(text); i++ {
+			if text[i] == '\n' {
+				n++
+				if n == maxLines {
+					text = text[:i+1]
+					break
+				}
+			}
+		}
+	}
+	if maxBytes > 0 && len(text) > maxBytes {
+		cut := text[:maxBytes]
+		if i := strings.LastIndexByte(cut, '\n'); i >= 0 {
+			return cut[:i+1]
+		}
+		n := maxBytes
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		return text[:n]
+	}
+	return text
+}
+
+// tailOf returns the longest suffix of text within maxBytes and maxLines,
+// starting at a line break where possible and never inside a UTF-8 sequence
+func tailOf(text string, maxBytes, maxLines int) string {
+	if maxLines > 0 {
+		n := 0
+		end := len(text)
+		if strings.HasSuffix(text, "\n") {
+			end-- // The final line break doesn't start another line
+		}
+		for i := end - 1; i >= 0; i-- {
+			if text[i] == '\n' {
+				n++
+				if n == maxLines {
+					text = text[i+1:]
+					break
+				}
+			}
+		}
+	}
+	if maxBytes > 0 && len(text) > maxBytes {
+		start := len(text) - maxBytes
+		if i := strings.IndexByte(text[start:], '\n'); i >= 0 && start+i+1 < len(text) {
+			return text[start+i+1:]
+		}
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		return text[start:]
+	}
+	return text
+}
+
+// fetchOutputPage is the structured form of a fetch_output result
+type fetchOutputPage struct {
+	Offset     int    `json:"offset"`
+	TotalBytes int    `json:"total_bytes"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// fetchOutputTool creates the fetch_output tool that pages through the output kept in store
+func fetchOutputTool(store *outputStore) server.ServerTool {
+	parameters := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"cursor": map[string]interface{}{
+				"type":        "string",
+				"description": "The cursor from a truncated result, or the next_cursor of the previous page.",
+			},
+			"max_bytes": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"description": fmt.Sprintf("Optional: the maximum number of bytes to return. Defaults to %d.", defaultOutputLimitBytes),
+			},
+		},
+		"required": []interface{}{"cursor"},
+	}
+	schemaBytes, _ := json.Marshal(parameters)
+
+	tool := config.ToolConfig{Name: config.FetchOutputTool, Type: "builtin", Parameters: parameters}
+	return server.ServerTool{
+		Tool: mcp.NewToolWithRawSchema(config.FetchOutputTool,
+			"Returns output that was truncated in a tool result, one page at a time. Pass the cursor from the truncation marker, then the next_cursor of each page until there is none.",
+			json.RawMessage(schemaBytes)),
+		Handler: withArgumentValidation(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return handleFetchOutput(store, callerOf(ctx), request)
+		}),
+	}
+}
+
+// handleFetchOutput returns the page of caller's stored output at the
+// request's cursor
+func handleFetchOutput(store *outputStore, caller string, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	arguments, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return mcp.NewToolResultError("Invalid arguments format"), nil
+	}
+
+	cursor, _ := arguments["cursor"].(string)
+	id, offsetText, _ := strings.Cut(cursor, ":")
+	offset := 0
+	if offsetText != "" {
+		var err error
+		if offset, err = strconv.Atoi(offsetText); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid cursor %q", cursor)), nil
+		}
+	}
+
+	output, ok := store.Get(caller, id)
+	if !ok {
+		return mcp.NewToolResultError(fmt.Sprintf("Unknown or expired cursor %q", cursor)), nil
+	}
+	if offset < 0 || offset > len(output) {
+		return mcp.NewToolResultError(fmt.Sprintf("Invalid cursor %q: offset out of range", cursor)), nil
+	}
+
+	maxBytes := defaultOutputLimitBytes
+	if value, ok := arguments["max_bytes"].(float64); ok && value >= 1 {
+		maxBytes = int(value)
+	}
+	page := headOf(output[offset:], maxBytes, 0)
+	if page == "" && offset < len(output) {
+		// Always make progress, even if the next character is wider than max_bytes
+		_, size := utf8.DecodeRuneInString(output[offset:])
+		page = output[offset : offset+size]
+	}
+
+	status := fetchOutputPage{Offset: offset, TotalBytes: len(output)}
+	text := page
+	if next := offset + len(page); next < len(output) {
+		status.NextCursor = fmt.Sprintf("%s:%d", id, next)
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += fmt.Sprintf("... [%d more bytes; call %s with cursor %q to continue] ...", len(output)-next, config.FetchOutputTool, status.NextCursor)
+	}
+
+	result := mcp.NewToolResultText(text)
+	if data, err := json.Marshal(status); err == nil {
+		result.Content = append(result.Content, mcp.NewTextContent(string(data)))
+	}
+	return result, nil
+}
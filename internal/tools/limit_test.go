@@ -0,0 +1,205 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"dizi/internal/auth"
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// numberedLines returns n lines "line 1\n" to "line n\n"
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestTruncateOutput(t *testing.T) {
+	text := numberedLines(10)
+
+	tests := []struct {
+		name     string
+		limit    config.OutputLimitConfig
+		expected string
+	}{
+		{
+			name:     "head",
+			limit:    config.OutputLimitConfig{Lines: 3, Truncate: "head"},
+			expected: "line 1\nline 2\nline 3\n... [7 lines (50 bytes) omitted] ...",
+		},
+		{
+			name:     "tail",
+			limit:    config.OutputLimitConfig{Lines: 2, Truncate: "tail"},
+			expected: "... [8 lines (56 bytes) omitted] ...\nline 9\nline 10\n",
+		},
+		{
+			name:     "both",
+			limit:    config.OutputLimitConfig{Lines: 4, Truncate: "both"},
+			expected: "line 1\nline 2\n... [6 lines (42 bytes) omitted] ...\nline 9\nline 10\n",
+		},
+		{
+			name:     "bytes end at a line break",
+			limit:    config.OutputLimitConfig{Bytes: 20, Truncate: "head"},
+			expected: "line 1\nline 2\n... [8 lines (57 bytes) omitted] ...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateOutput(text, tt.limit, nil, ""); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	// Never cut inside a UTF-8 sequence
+	if got := headOf("笛子笛子", 4, 0); got != "笛" {
+		t.Errorf("Expected one whole character, got %q", got)
+	}
+	if got := tailOf("笛子笛子", 4, 0); got != "子" {
+		t.Errorf("Expected one whole character, got %q", got)
+	}
+}
+
+func TestOutputLimitAndFetchOutput(t *testing.T) {
+	full := numberedLines(1000)
+	store := newOutputStore()
+	limit := config.OutputLimitConfig{Bytes: 200, Truncate: "both"}
+
+	handler := withOutputLimit(limit, store, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result := mcp.NewToolResultText(full)
+		result.StructuredContent = exitStatus(commandOutput{stdout: []byte(full)})
+		return result, nil
+	})
+	result, err := handler(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	texts := contentTexts(result)
+	if !strings.HasPrefix(texts[0], "line 1\n") || !strings.HasSuffix(texts[0], "line 1000\n") || len(texts[0]) > 400 {
+		t.Errorf("Expected head and tail of the output, got %q", texts[0])
+	}
+	if len(texts) != 1 {
+		t.Errorf("Expected a single text, got %q", texts)
+	}
+	status, ok := result.StructuredContent.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected the exit status to stay structured, got %v", result.StructuredContent)
+	}
+	if stdout, _ := status["stdout"].(string); len(stdout) > 400 || !strings.Contains(stdout, "omitted") || strings.Contains(stdout, "cursor") {
+		t.Errorf("Expected stdout in the exit status to be truncated without a cursor of its own, got %q", stdout)
+	}
+	if len(store.order) != 1 {
+		t.Errorf("Expected the output to be kept once, got %d copies", len(store.order))
+	}
+
+	cursor := regexp.MustCompile(`cursor "([^"]+)"`).FindStringSubmatch(texts[0])
+	if cursor == nil {
+		t.Fatalf("Expected a cursor in the truncation marker, got %q", texts[0])
+	}
+
+	// Page through the full output
+	fetch := fetchOutputTool(store)
+	var fetched strings.Builder
+	next := cursor[1]
+	for pages := 0; next != ""; pages++ {
+		if pages > 100 {
+			t.Fatal("Too many pages")
+		}
+		result, err := fetch.Handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: map[string]interface{}{"cursor": next, "max_bytes": float64(1000)}},
+		})
+		if err != nil || result.IsError {
+			t.Fatalf("Unexpected error: %v %v", err, result.Content)
+		}
+		texts := contentTexts(result)
+		var page fetchOutputPage
+		if err := json.Unmarshal([]byte(texts[1]), &page); err != nil {
+			t.Fatalf("Expected page status, got %q", texts[1])
+		}
+		fetched.WriteString(strings.SplitN(texts[0], "... [", 2)[0])
+		next = page.NextCursor
+	}
+	if fetched.String() != full {
+		t.Errorf("Expected pages to add up to the full output (%d bytes), got %d bytes", len(full), fetched.Len())
+	}
+
+	result, err = fetch.Handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"cursor": "unknown:0"}},
+	})
+	if err != nil || !result.IsError {
+		t.Error("Expected error for unknown cursor")
+	}
+
+	// Another client can't read the output, even with its cursor
+	other := auth.WithIdentity(context.Background(), &auth.Identity{Name: "other", Method: auth.MethodToken})
+	result, err = fetch.Handler(other, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"cursor": cursor[1]}},
+	})
+	if err != nil || !result.IsError {
+		t.Error("Expected the output of another client to be refused")
+	}
+
+	// Without authentication the output is found again after reconnecting
+	reconnected := sessionContext(t, server.NewMCPServer("test", "1.0.0"), newTestSession("reconnected"))
+	result, err = fetch.Handler(reconnected, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"cursor": cursor[1]}},
+	})
+	if err != nil || result.IsError {
+		t.Errorf("Expected the output to be read from another session, got %v %v", err, result.Content)
+	}
+}
+
+func TestOutputStoreLimits(t *testing.T) {
+	store := newOutputStore()
+	chunk := strings.Repeat("x", maxCallerOutputBytes/2)
+
+	first := store.Put("alice", chunk)
+	bob := store.Put("bob", chunk)
+	store.Put("alice", chunk)
+	store.Put("alice", chunk)
+	if _, ok := store.Get("alice", first); ok {
+		t.Error("Expected a caller's oldest output to make room for its new output")
+	}
+	if _, ok := store.Get("bob", bob); !ok {
+		t.Error("Expected one caller's output not to push out another's")
+	}
+	if _, ok := store.Get("alice", bob); ok {
+		t.Error("Expected output to be kept only for the caller that produced it")
+	}
+
+	for i := 0; i < maxStoredOutputBytes/len(chunk); i++ {
+		store.Put(fmt.Sprintf("caller-%d", i), chunk)
+	}
+	if store.size > maxStoredOutputBytes {
+		t.Errorf("Expected at most %d bytes to be kept, got %d", maxStoredOutputBytes, store.size)
+	}
+	if _, ok := store.Get("bob", bob); ok {
+		t.Error("Expected the oldest output to be dropped once the store is full")
+	}
+}
+
+func TestOutputLimitFor(t *testing.T) {
+	options := &registerOptions{outputLimit: config.OutputLimitConfig{Lines: 100, Truncate: "tail"}}
+
+	limit := options.outputLimitFor(config.ToolConfig{})
+	if limit.Bytes != defaultOutputLimitBytes || limit.Lines != 100 || limit.Truncate != "tail" {
+		t.Errorf("Expected server limits over defaults, got %+v", limit)
+	}
+
+	limit = options.outputLimitFor(config.ToolConfig{OutputLimit: config.OutputLimitConfig{Bytes: -1, Lines: -1}})
+	if exceedsLimit(numberedLines(10000), limit) {
+		t.Errorf("Expected negative limits to disable truncation, got %+v", limit)
+	}
+}
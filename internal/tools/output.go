@@ -0,0 +1,391 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file turns the output of a tool into result content according to its output mode.
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"dizi/internal/audit"
+	"dizi/internal/config"
+	"dizi/internal/schema"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxOutputFileSize bounds the file an image or file tool may return
+const maxOutputFileSize = 10 << 20
+
+// maxLuaDepth bounds the nesting of Lua tables converted to JSON, which also
+// stops tables that refer to themselves
+const maxLuaDepth = 64
+
+// commandOutput is what a finished command or script wrote
+type commandOutput struct {
+	combined []byte // stdout and stderr interleaved as written
+	stdout   []byte
+	stderr   []byte
+	exitCode int // -1 if the process didn't start or was killed by a signal
+}
+
+// commandResult builds the result of a finished command or script. An exit
+// code outside the tool's success_exit_codes is an error unless the tool
+// ignores exit codes; timeouts, cancellation and failures to start always are.
+func commandResult(ctx context.Context, kind string, tool config.ToolConfig, arguments map[string]interface{}, dir string, options *registerOptions, output commandOutput, err error) *mcp.CallToolResult {
+	var exitErr *exec.ExitError
+	if err != nil && (ctx.Err() != nil || !errors.As(err, &exitErr)) {
+		return executionError(ctx, kind, options.timeoutFor(tool), err, output.combined)
+	}
+	audit.SetExitCode(ctx, output.exitCode)
+
+	if !tool.IgnoreExitCode && !successExitCode(tool, output.exitCode) {
+		status := fmt.Sprintf("exited with code %d", output.exitCode)
+		if output.exitCode < 0 && exitErr != nil {
+			status = fmt.Sprintf("was terminated: %v", exitErr)
+		}
+		message := fmt.Sprintf("%s %s\nOutput: %s", kind, status, string(output.combined))
+		if hint := sandboxHint(tool, output.combined); hint != "" {
+			message += "\n" + hint
+		}
+		result := mcp.NewToolResultError(message)
+		result.StructuredContent = exitStatus(output)
+		return result
+	}
+	return outputResult(tool, arguments, dir, options, output)
+}
+
+// successExitCode reports whether code counts as success for the tool
+func successExitCode(tool config.ToolConfig, code int) bool {
+	if len(tool.SuccessExitCodes) == 0 {
+		return code == 0
+	}
+	for _, success := range tool.SuccessExitCodes {
+		if code == success {
+			return true
+		}
+	}
+	return false
+}
+
+// exitStatus is the structured content of a command's result: its exit code
+// with stdout and stderr reported separately
+func exitStatus(output commandOutput) map[string]interface{} {
+	return map[string]interface{}{
+		"exit_code": output.exitCode,
+		"stdout":    string(output.stdout),
+		"stderr":    string(output.stderr),
+	}
+}
+
+// outputResult builds the result of a successful command or script from its
+// output. Text mode returns everything the tool wrote as text and the exit
+// status as structured content; the other modes only look at stdout so that
+// warnings on stderr don't corrupt the data.
+func outputResult(tool config.ToolConfig, arguments map[string]interface{}, dir string, options *registerOptions, output commandOutput) *mcp.CallToolResult {
+	switch tool.Output.OutputMode() {
+	case "json":
+		return jsonOutputResult(tool, output.stdout)
+	case "lines":
+		return linesResult(output.stdout)
+	case "image", "file":
+		return fileResult(tool, arguments, dir, options, output.stdout)
+	}
+
+	text := string(output.combined)
+	if output.exitCode != 0 {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += fmt.Sprintf("Exit code: %d", output.exitCode)
+	}
+	result := mcp.NewToolResultText(text)
+	result.StructuredContent = exitStatus(output)
+	return result
+}
+
+// jsonOutputResult parses output as a single JSON value
+func jsonOutputResult(tool config.ToolConfig, output []byte) *mcp.CallToolResult {
+	var value interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(output), &value); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Output is not valid JSON: %v\nOutput: %s", err, string(output)))
+	}
+	return jsonResult(tool, value)
+}
+
+// jsonResult returns value as JSON text content after checking it against
+// the tool's output schema, if it declares one. Objects are also returned as
+// structured content, the form clients check against the output schema.
+func jsonResult(tool config.ToolConfig, value interface{}) *mcp.CallToolResult {
+	if tool.Output.Schema != nil {
+		if violations := schema.Validate(tool.Output.Schema, value); len(violations) > 0 {
+			return invalidOutputResult(tool.Name, violations)
+		}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to encode result as JSON: %v", err))
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		return mcp.NewToolResultStructured(object, string(data))
+	}
+	return mcp.NewToolResultText(string(data))
+}
+
+// outputSchema returns the output schema a tool advertises: the schema of
+// its JSON output, if that describes an object, as MCP requires
+func outputSchema(tool config.ToolConfig) (json.RawMessage, error) {
+	if tool.Output.OutputMode() != "json" || !slices.Contains(schema.StringList(tool.Output.Schema["type"]), "object") {
+		return nil, nil
+	}
+	data, err := json.Marshal(tool.Output.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal output schema for tool %s: %w", tool.Name, err)
+	}
+	return data, nil
+}
+
+// invalidOutputResult reports output that doesn't match the output schema,
+// in the same form as invalid arguments
+func invalidOutputResult(toolName string, violations []schema.Violation) *mcp.CallToolResult {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Output of tool %s does not match its output schema:", toolName)
+	for _, v := range violations {
+		text.WriteString("\n- ")
+		text.WriteString(v.String())
+	}
+
+	result := mcp.NewToolResultError(text.String())
+	if data, err := json.Marshal(map[string]interface{}{"violations": violations}); err == nil {
+		result.Content = append(result.Content, mcp.NewTextContent(string(data)))
+	}
+	return result
+}
+
+// linesResult returns each non-empty line of output as its own text content
+func linesResult(output []byte) *mcp.CallToolResult {
+	result := &mcp.CallToolResult{Content: []mcp.Content{}}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		result.Content = append(result.Content, mcp.NewTextContent(line))
+	}
+	return result
+}
+
+// fileResult returns the file the tool produced as image or embedded resource
+// content, preceded by the tool's output if it printed anything
+func fileResult(tool config.ToolConfig, arguments map[string]interface{}, dir string, options *registerOptions, output []byte) *mcp.CallToolResult {
+	path, err := outputPath(tool, arguments, dir, options)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error())
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Output file %s was not created", path))
+	}
+	if info.IsDir() {
+		return mcp.NewToolResultError(fmt.Sprintf("Output file %s is a directory", path))
+	}
+	if info.Size() > maxOutputFileSize {
+		return mcp.NewToolResultError(fmt.Sprintf("Output file %s is too large (%d bytes, limit %d)", path, info.Size(), maxOutputFileSize))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to read output file: %v", err))
+	}
+
+	mimeType := tool.Output.MimeType
+	if mimeType == "" {
+		mimeType = detectMimeType(path, data)
+	}
+
+	result := &mcp.CallToolResult{Content: []mcp.Content{}}
+	if text := strings.TrimSpace(string(output)); text != "" {
+		result.Content = append(result.Content, mcp.NewTextContent(text))
+	}
+
+	if tool.Output.OutputMode() == "image" {
+		mediaType, _, _ := mime.ParseMediaType(mimeType)
+		if !strings.HasPrefix(mediaType, "image/") {
+			return mcp.NewToolResultError(fmt.Sprintf("Output file %s is not an image (%s)", path, mimeType))
+		}
+		result.Content = append(result.Content, mcp.NewImageContent(base64.StdEncoding.EncodeToString(data), mediaType))
+		return result
+	}
+
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	var resource mcp.ResourceContents
+	if isTextMimeType(mimeType) && utf8.Valid(data) {
+		resource = mcp.TextResourceContents{URI: uri, MIMEType: mimeType, Text: string(data)}
+	} else {
+		resource = mcp.BlobResourceContents{URI: uri, MIMEType: mimeType, Blob: base64.StdEncoding.EncodeToString(data)}
+	}
+	result.Content = append(result.Content, mcp.NewEmbeddedResource(resource))
+	return result
+}
+
+// outputPath resolves the output file of a tool against the directory it ran
+// in. Like the cwd, a path built from arguments must stay within the root.
+func outputPath(tool config.ToolConfig, arguments map[string]interface{}, dir string, options *registerOptions) (string, error) {
+	path, err := renderTemplate(tool.Output.Path, arguments, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid template in output path: %w", err)
+	}
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+
+	if strings.Contains(tool.Output.Path, "{{") {
+		root := options.allowedRoot(tool)
+		if !withinRoot(root, path) {
+			return "", fmt.Errorf("output file %s is outside the allowed root %s", path, root)
+		}
+	}
+	return path, nil
+}
+
+// detectMimeType guesses the type of a file from its extension, falling back
+// to sniffing its content
+func detectMimeType(path string, data []byte) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	return http.DetectContentType(data)
+}
+
+// isTextMimeType reports whether content of this type is best sent as text
+func isTextMimeType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/yaml", "application/javascript", "application/toml":
+		return true
+	}
+	return false
+}
+
+// luaResult builds the result of a Lua tool from its result global. Tables
+// become JSON; other values are returned as text unless the tool asks for JSON.
+func luaResult(tool config.ToolConfig, arguments map[string]interface{}, options *registerOptions, value lua.LValue) *mcp.CallToolResult {
+	mode := tool.Output.OutputMode()
+	if table, ok := value.(*lua.LTable); ok && (mode == "text" || mode == "json") {
+		converted, err := luaToGo(table, 0)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to convert Lua result: %v", err))
+		}
+		return jsonResult(tool, converted)
+	}
+
+	text := ""
+	if value != lua.LNil {
+		text = value.String()
+	}
+	switch mode {
+	case "json":
+		if value.Type() == lua.LTString {
+			return jsonOutputResult(tool, []byte(text))
+		}
+		converted, err := luaToGo(value, 0)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to convert Lua result: %v", err))
+		}
+		return jsonResult(tool, converted)
+	case "lines":
+		return linesResult([]byte(text))
+	case "image", "file":
+		return fileResult(tool, arguments, "", options, []byte(text))
+	}
+	if value == lua.LNil {
+		return mcp.NewToolResultText("Lua script executed successfully")
+	}
+	return mcp.NewToolResultText(text)
+}
+
+// luaToGo converts a Lua value to its JSON equivalent. Tables whose keys are
+// exactly 1..n become arrays, all other tables become objects; an empty table
+// is an empty array.
+func luaToGo(value lua.LValue, depth int) (interface{}, error) {
+	if depth > maxLuaDepth {
+		return nil, fmt.Errorf("tables nested deeper than %d levels", maxLuaDepth)
+	}
+
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		length := v.Len()
+		count := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { count++ })
+
+		if count == length {
+			array := make([]interface{}, 0, length)
+			for i := 1; i <= length; i++ {
+				item, err := luaToGo(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, item)
+			}
+			return array, nil
+		}
+
+		object := make(map[string]interface{}, count)
+		var err error
+		v.ForEach(func(key, item lua.LValue) {
+			if err != nil {
+				return
+			}
+			var name string
+			switch k := key.(type) {
+			case lua.LString:
+				name = string(k)
+			case lua.LNumber:
+				name = strconv.FormatFloat(float64(k), 'f', -1, 64)
+			default:
+				err = fmt.Errorf("unsupported table key of type %s", key.Type())
+				return
+			}
+			object[name], err = luaToGo(item, depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", value.Type())
+}
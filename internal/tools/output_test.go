@@ -0,0 +1,348 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// contentTexts returns the text of each text content in result
+func contentTexts(result *mcp.CallToolResult) []string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return texts
+}
+
+func TestOutputResult(t *testing.T) {
+	countSchema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"count"},
+		"properties": map[string]interface{}{
+			"count": map[string]interface{}{"type": "integer"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		output   config.OutputConfig
+		combined string
+		stdout   string
+		expected []string
+		isError  bool
+	}{
+		{
+			name:     "text returns everything",
+			combined: "warning\nhello\n",
+			stdout:   "hello\n",
+			expected: []string{"warning\nhello\n"},
+		},
+		{
+			name:     "json ignores stderr",
+			output:   config.OutputConfig{Mode: "json"},
+			combined: "warning\n{\"count\": 3}\n",
+			stdout:   "{\"count\": 3}\n",
+			expected: []string{`{"count":3}`},
+		},
+		{
+			name:    "json rejects invalid output",
+			output:  config.OutputConfig{Mode: "json"},
+			stdout:  "not json",
+			isError: true,
+		},
+		{
+			name:     "json matches schema",
+			output:   config.OutputConfig{Mode: "json", Schema: countSchema},
+			stdout:   `{"count": 3}`,
+			expected: []string{`{"count":3}`},
+		},
+		{
+			name:    "json violates schema",
+			output:  config.OutputConfig{Mode: "json", Schema: countSchema},
+			stdout:  `{"count": "three"}`,
+			isError: true,
+		},
+		{
+			name:     "lines",
+			output:   config.OutputConfig{Mode: "lines"},
+			stdout:   "a.go\r\nb.go\n\nc.go\n",
+			expected: []string{"a.go", "b.go", "c.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := config.ToolConfig{Name: "test", Type: "script", Output: tt.output}
+			result := outputResult(tool, nil, "", &registerOptions{}, commandOutput{combined: []byte(tt.combined), stdout: []byte(tt.stdout)})
+			if result.IsError != tt.isError {
+				t.Fatalf("Expected IsError %v, got %v: %v", tt.isError, result.IsError, result.Content)
+			}
+			if tt.isError {
+				return
+			}
+			if texts := contentTexts(result); strings.Join(texts, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("Expected %q, got %q", tt.expected, texts)
+			}
+		})
+	}
+}
+
+func TestOutputResultFiles(t *testing.T) {
+	dir := t.TempDir()
+	// A 1x1 PNG
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+	for name, data := range map[string][]byte{"out/plot.png": png, "report.csv": []byte("a,b\n1,2\n")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	options := &registerOptions{root: dir}
+	arguments := map[string]interface{}{"name": "plot"}
+
+	image := config.ToolConfig{Name: "plot", Type: "script", Output: config.OutputConfig{Mode: "image", Path: "out/{{name}}.png"}}
+	result := outputResult(image, arguments, dir, options, commandOutput{stdout: []byte("rendered\n")})
+	if result.IsError || len(result.Content) != 2 {
+		t.Fatalf("Expected text and image content, got %v", result.Content)
+	}
+	if content, ok := result.Content[1].(mcp.ImageContent); !ok || content.MIMEType != "image/png" || content.Data != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("Expected PNG image content, got %v", result.Content[1])
+	}
+
+	file := config.ToolConfig{Name: "report", Type: "script", Output: config.OutputConfig{Mode: "file", Path: "report.csv"}}
+	result = outputResult(file, arguments, dir, options, commandOutput{})
+	if result.IsError || len(result.Content) != 1 {
+		t.Fatalf("Expected one resource, got %v", result.Content)
+	}
+	resource, ok := result.Content[0].(mcp.EmbeddedResource)
+	if !ok {
+		t.Fatalf("Expected embedded resource, got %T", result.Content[0])
+	}
+	if text, ok := resource.Resource.(mcp.TextResourceContents); !ok || text.Text != "a,b\n1,2\n" || !strings.HasPrefix(text.URI, "file://") {
+		t.Errorf("Expected CSV text resource, got %v", resource.Resource)
+	}
+
+	// Paths built from arguments must stay within the root
+	arguments["name"] = "../../escape"
+	if result := outputResult(image, arguments, dir, options, commandOutput{}); !result.IsError {
+		t.Error("Expected error for output path outside the root")
+	}
+
+	missing := config.ToolConfig{Name: "missing", Type: "script", Output: config.OutputConfig{Mode: "file", Path: "absent.txt"}}
+	if result := outputResult(missing, nil, dir, options, commandOutput{}); !result.IsError {
+		t.Error("Expected error for missing output file")
+	}
+
+	notImage := config.ToolConfig{Name: "csv", Type: "script", Output: config.OutputConfig{Mode: "image", Path: "report.csv"}}
+	if result := outputResult(notImage, nil, dir, options, commandOutput{}); !result.IsError {
+		t.Error("Expected error for image mode with a non-image file")
+	}
+}
+
+func TestLuaHandlerTableResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		output   config.OutputConfig
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "object",
+			script:   `result = {name = "dizi", tags = {"mcp", "lua"}, stars = 3, ok = true}`,
+			expected: `{"name":"dizi","ok":true,"stars":3,"tags":["mcp","lua"]}`,
+		},
+		{
+			name:     "empty table is an array",
+			script:   `result = {}`,
+			expected: `[]`,
+		},
+		{
+			name:     "string stays text",
+			script:   `result = "plain"`,
+			expected: "plain",
+		},
+		{
+			name:     "json string is parsed",
+			script:   `result = '{"a": [1, 2]}'`,
+			output:   config.OutputConfig{Mode: "json"},
+			expected: `{"a":[1,2]}`,
+		},
+		{
+			name:    "self reference",
+			script:  `result = {}; result.self = result`,
+			isError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := filepath.Join(t.TempDir(), "result.lua")
+			if err := os.WriteFile(script, []byte(tt.script), 0644); err != nil {
+				t.Fatalf("Failed to write script: %v", err)
+			}
+
+			tool := config.ToolConfig{Name: "lua_result", Type: "lua", Script: script, Output: tt.output}
+			result, err := createLuaHandler(tool, &registerOptions{})(context.Background(), mcp.CallToolRequest{
+				Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.IsError != tt.isError {
+				t.Fatalf("Expected IsError %v, got %v: %v", tt.isError, result.IsError, result.Content)
+			}
+			if tt.isError {
+				return
+			}
+			if text := result.Content[0].(mcp.TextContent).Text; text != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, text)
+			}
+		})
+	}
+}
+
+func TestScriptHandlerExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		tool     config.ToolConfig
+		isError  bool
+		text     string
+		exitCode int
+	}{
+		{
+			name:     "non-zero exit is an error",
+			tool:     config.ToolConfig{Script: "echo out; echo err >&2; exit 2"},
+			isError:  true,
+			text:     "Script exited with code 2",
+			exitCode: 2,
+		},
+		{
+			name:     "listed exit code is success",
+			tool:     config.ToolConfig{Script: "echo out; exit 1", SuccessExitCodes: []int{0, 1}},
+			text:     "Exit code: 1",
+			exitCode: 1,
+		},
+		{
+			name:     "ignored exit code",
+			tool:     config.ToolConfig{Script: "echo err >&2; exit 3", IgnoreExitCode: true},
+			text:     "Exit code: 3",
+			exitCode: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := tt.tool
+			tool.Name, tool.Type = "exit_script", "script"
+			result, err := createScriptHandler(tool, &registerOptions{})(context.Background(), mcp.CallToolRequest{
+				Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.IsError != tt.isError {
+				t.Fatalf("Expected IsError %v, got %v: %v", tt.isError, result.IsError, result.Content)
+			}
+
+			texts := contentTexts(result)
+			if len(texts) != 1 {
+				t.Fatalf("Expected a single readable text, got %q", texts)
+			}
+			if !strings.Contains(texts[0], tt.text) {
+				t.Errorf("Expected text to contain %q, got %q", tt.text, texts[0])
+			}
+			status, ok := result.StructuredContent.(map[string]interface{})
+			if !ok {
+				t.Fatalf("Expected structured exit status, got %v", result.StructuredContent)
+			}
+			if status["exit_code"] != tt.exitCode {
+				t.Errorf("Expected exit code %d, got %v", tt.exitCode, status["exit_code"])
+			}
+			if strings.Contains(status["stdout"].(string), "err") || strings.Contains(status["stderr"].(string), "out") {
+				t.Errorf("Expected stdout and stderr to be separate, got %v", status)
+			}
+		})
+	}
+}
+
+func TestScriptHandlerJSONOutput(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "json_script",
+		Type:   "script",
+		Script: "echo 'warming up' >&2; echo '{\"files\": 2}'",
+		Output: config.OutputConfig{Mode: "json"},
+	}
+
+	result, err := createScriptHandler(tool, &registerOptions{})(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("Expected success, got %v", result.Content)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; text != `{"files":2}` {
+		t.Errorf("Expected parsed JSON, got %s", text)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "count_files",
+		Type:   "script",
+		Script: `echo '{"count": 3, "log": "'$(printf 'x%.0s' $(seq 1 50))'"}'`,
+		Output: config.OutputConfig{Mode: "json", Schema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"count": map[string]interface{}{"type": "integer"}},
+		}},
+	}
+	serverTool, err := buildTool(tool, &registerOptions{})
+	if err != nil {
+		t.Fatalf("buildTool failed: %v", err)
+	}
+	var advertised map[string]interface{}
+	if err := json.Unmarshal(serverTool.Tool.RawOutputSchema, &advertised); err != nil || advertised["type"] != "object" {
+		t.Errorf("Expected the output schema to be advertised, got %s", serverTool.Tool.RawOutputSchema)
+	}
+
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{}}}
+	result, err := serverTool.Handler(context.Background(), request)
+	if err != nil || result.IsError {
+		t.Fatalf("Expected success, got %v %v", result, err)
+	}
+	structured, ok := result.StructuredContent.(map[string]interface{})
+	if !ok || structured["count"] != float64(3) {
+		t.Errorf("Expected the object as structured content, got %v", result.StructuredContent)
+	}
+
+	// Truncated output must not come back in full as structured content
+	limited := withOutputLimit(config.OutputLimitConfig{Bytes: 20, Truncate: "head"}, newOutputStore(), serverTool.Handler)
+	result, _ = limited(context.Background(), request)
+	structured, _ = result.StructuredContent.(map[string]interface{})
+	if log, _ := structured["log"].(string); structured["count"] != float64(3) || !strings.Contains(log, "omitted") {
+		t.Errorf("Expected the structured content to be truncated like the text, got %v", result.StructuredContent)
+	}
+
+	for _, output := range []config.OutputConfig{{Mode: "json", Schema: map[string]interface{}{"type": "array"}}, {Mode: "text"}} {
+		tool := config.ToolConfig{Name: "other", Type: "script", Script: "echo []", Output: output}
+		if serverTool, err := buildTool(tool, &registerOptions{}); err != nil || serverTool.Tool.RawOutputSchema != nil {
+			t.Errorf("Expected no output schema for %+v, got %s %v", output, serverTool.Tool.RawOutputSchema, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file checks the commands of shell tools against their policy.
+package tools
+
+import (
+	"fmt"
+
+	"dizi/internal/config"
+	"dizi/internal/policy"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// checkPolicy returns an error result if script runs a command that the
+// tool's policy forbids, or nil if it may run
+func checkPolicy(tool config.ToolConfig, script string) *mcp.CallToolResult {
+	if len(tool.Policy.Allow) == 0 && len(tool.Policy.Deny) == 0 {
+		return nil
+	}
+	p, err := policy.Compile(tool.Policy.Allow, tool.Policy.Deny)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Invalid policy: %v", err))
+	}
+	if err := p.Check(script); err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Policy violation: %v. Nothing was run.", err))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file streams tool output to the client as progress notifications.
+package tools
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// runWithProgress runs cmd, streaming its combined output as progress
+// notifications, and returns the full output and exit code once it exits
+func runWithProgress(ctx context.Context, cmd *exec.Cmd, request mcp.CallToolRequest, throttle config.ProgressConfig) (commandOutput, error) {
+	w := newProgressWriter(ctx, request, throttle)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(w, &stdout)
+	cmd.Stderr = io.MultiWriter(w, &stderr)
+	err := cmd.Run()
+	w.Flush()
+
+	output := commandOutput{combined: w.Bytes(), stdout: stdout.Bytes(), stderr: stderr.Bytes(), exitCode: -1}
+	if cmd.ProcessState != nil {
+		output.exitCode = cmd.ProcessState.ExitCode()
+	}
+	return output, err
+}
+
+// progressWriter collects the output of a running tool and, when the client
+// supplied a progress token, forwards it line by line as notifications/progress
+type progressWriter struct {
+	ctx       context.Context
+	mcpServer *server.MCPServer
+	token     mcp.ProgressToken
+	throttle  config.ProgressConfig
+	redactor  *redactor
+
+	mu           sync.Mutex
+	output       bytes.Buffer // everything written, returned as the final result
+	partial      []byte       // trailing output not yet terminated by a newline
+	pending      []string     // complete lines not yet sent
+	pendingBytes int
+	sent         int // lines sent so far, reported as the progress value
+}
+
+// newProgressWriter creates a writer for the given request. Output is only
+// buffered when the request carries no progress token.
+func newProgressWriter(ctx context.Context, request mcp.CallToolRequest, throttle config.ProgressConfig) *progressWriter {
+	w := &progressWriter{
+		ctx:       ctx,
+		mcpServer: server.ServerFromContext(ctx),
+		throttle:  throttle,
+		redactor:  redactorFromContext(ctx),
+	}
+	if request.Params.Meta != nil {
+		w.token = request.Params.Meta.ProgressToken
+	}
+	if w.throttle.Lines <= 0 {
+		w.throttle.Lines = 1
+	}
+	return w
+}
+
+// Write implements io.Writer
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.output.Write(p)
+	if !w.streaming() {
+		return len(p), nil
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.queue(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+
+	if len(w.pending) >= w.throttle.Lines || (w.throttle.Bytes > 0 && w.pendingBytes >= w.throttle.Bytes) {
+		w.send()
+	}
+	return len(p), nil
+}
+
+// Flush sends any output that has not been reported yet
+func (w *progressWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.streaming() {
+		return
+	}
+	if len(w.partial) > 0 {
+		w.queue(string(w.partial))
+		w.partial = nil
+	}
+	w.send()
+}
+
+// Bytes returns everything written so far
+func (w *progressWriter) Bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]byte(nil), w.output.Bytes()...)
+}
+
+// streaming reports whether progress notifications can be delivered
+func (w *progressWriter) streaming() bool {
+	return w.token != nil && w.mcpServer != nil
+}
+
+// queue adds a complete line to the pending batch
+func (w *progressWriter) queue(line string) {
+	w.pending = append(w.pending, strings.TrimSuffix(line, "\r"))
+	w.pendingBytes += len(line)
+}
+
+// send emits the pending batch as a single progress notification
+func (w *progressWriter) send() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	w.sent += len(w.pending)
+	// Delivery is best effort; a slow client must not stall the tool
+	_ = w.mcpServer.SendNotificationToClient(w.ctx, "notifications/progress", map[string]any{
+		"progressToken": w.token,
+		"progress":      w.sent,
+		"message":       w.redactor.Redact(strings.Join(w.pending, "\n")),
+	})
+
+	w.pending = nil
+	w.pendingBytes = 0
+}
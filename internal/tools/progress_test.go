@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testSession is a minimal client session that records notifications
+type testSession struct {
+	id            string
+	notifications chan mcp.JSONRPCNotification
+}
+
+func newTestSession(id string) *testSession {
+	return &testSession{id: id, notifications: make(chan mcp.JSONRPCNotification, 100)}
+}
+
+func (s *testSession) SessionID() string { return s.id }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+
+// sessionContext returns a handler context for session, carrying the server
+// and session the same way the transports provide them
+func sessionContext(t *testing.T, mcpServer *server.MCPServer, session server.ClientSession) context.Context {
+	t.Helper()
+	if err := mcpServer.RegisterSession(context.Background(), session); err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+	t.Cleanup(func() { mcpServer.UnregisterSession(context.Background(), session.SessionID()) })
+
+	var ctx context.Context
+	mcpServer.AddTool(mcp.NewTool("capture_context"), func(c context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx = c
+		return mcp.NewToolResultText(""), nil
+	})
+	mcpServer.HandleMessage(mcpServer.WithContext(context.Background(), session),
+		[]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"capture_context"}}`))
+	if ctx == nil {
+		t.Fatal("Failed to capture handler context")
+	}
+	// Discard the list_changed notification caused by AddTool
+	if s, ok := session.(*testSession); ok {
+		drainProgress(s)
+	}
+	return ctx
+}
+
+// drainProgress returns the messages of all progress notifications received so far
+func drainProgress(session *testSession) []string {
+	var messages []string
+	for {
+		select {
+		case n := <-session.notifications:
+			if n.Method == "notifications/progress" {
+				messages = append(messages, n.Params.AdditionalFields["message"].(string))
+			}
+		default:
+			return messages
+		}
+	}
+}
+
+func TestScriptHandlerStreamsProgress(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	session := newTestSession("progress")
+	ctx := sessionContext(t, mcpServer, session)
+
+	tool := config.ToolConfig{
+		Name:   "steps",
+		Type:   "script",
+		Script: "echo one; echo two >&2; echo three",
+	}
+	handler := createScriptHandler(tool, &registerOptions{})
+
+	request := mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{},
+			Meta:      &mcp.Meta{ProgressToken: "tok"},
+		},
+	}
+
+	result, err := handler(ctx, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("Expected success, got error: %v", result.Content)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"one", "two", "three"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected final result to contain %q, got %q", want, text)
+		}
+	}
+
+	messages := drainProgress(session)
+	if got := strings.Join(messages, "\n"); !strings.Contains(got, "one") || !strings.Contains(got, "two") || !strings.Contains(got, "three") {
+		t.Errorf("Expected progress to stream all lines, got %q", messages)
+	}
+}
+
+func TestProgressWriterThrottle(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	session := newTestSession("throttle")
+	ctx := sessionContext(t, mcpServer, session)
+
+	request := mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Meta: &mcp.Meta{ProgressToken: 1},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		throttle config.ProgressConfig
+		expected int
+	}{
+		{"every line", config.ProgressConfig{}, 6},
+		{"three lines", config.ProgressConfig{Lines: 3}, 2},
+		{"byte limit", config.ProgressConfig{Lines: 100, Bytes: 8}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newProgressWriter(ctx, request, tt.throttle)
+			for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n"} {
+				_, _ = w.Write([]byte(line))
+			}
+			w.Flush()
+
+			if messages := drainProgress(session); len(messages) != tt.expected {
+				t.Errorf("Expected %d notifications, got %d: %q", tt.expected, len(messages), messages)
+			}
+			if string(w.Bytes()) != "aaaa\nbbbb\ncccc\ndddd\neeee\nffff\n" {
+				t.Errorf("Unexpected aggregated output %q", w.Bytes())
+			}
+		})
+	}
+}
+
+func TestProgressWriterWithoutToken(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	session := newTestSession("no-token")
+	ctx := sessionContext(t, mcpServer, session)
+
+	w := newProgressWriter(ctx, mcp.CallToolRequest{}, config.ProgressConfig{})
+	_, _ = w.Write([]byte("partial"))
+	_, _ = w.Write([]byte(" line\n"))
+	w.Flush()
+
+	if messages := drainProgress(session); len(messages) != 0 {
+		t.Errorf("Expected no notifications without a progress token, got %q", messages)
+	}
+	if string(w.Bytes()) != "partial line\n" {
+		t.Errorf("Unexpected aggregated output %q", w.Bytes())
+	}
+}
@@ -0,0 +1,175 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file confines tools that ask for a sandbox.
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"dizi/internal/config"
+	"dizi/internal/sandbox"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sandboxPolicy returns the policy for a sandboxed tool, with its writable
+// paths resolved against the config file
+func sandboxPolicy(tool config.ToolConfig) sandbox.Policy {
+	policy := sandbox.Policy{
+		Network:     tool.Sandbox.Network,
+		CPU:         tool.Sandbox.CPU,
+		MemoryBytes: uint64(tool.Sandbox.MemoryMB) << 20,
+		Processes:   uint64(tool.Sandbox.Processes),
+	}
+	for _, path := range tool.Sandbox.Writable {
+		policy.Writable = append(policy.Writable, toolPath(tool, path))
+	}
+	return policy
+}
+
+// sandboxCommand confines cmd if the tool asks for a sandbox; ctx bounds
+// the lifetime of its temp dir
+func sandboxCommand(ctx context.Context, tool config.ToolConfig, cmd *exec.Cmd) error {
+	if !tool.Sandbox.Enabled {
+		return nil
+	}
+	if err := sandbox.Wrap(ctx, cmd, sandboxPolicy(tool)); err != nil {
+		return fmt.Errorf("failed to sandbox tool %s: %w", tool.Name, err)
+	}
+	return nil
+}
+
+// sandboxSignatures map error messages of programs stopped by the sandbox to
+// what the sandbox allows instead
+var sandboxSignatures = []struct {
+	messages []string
+	hint     string
+}{
+	{
+		[]string{"Read-only file system"},
+		"The sandbox only allows writes to $TMPDIR and the paths in sandbox.writable.",
+	},
+	{
+		[]string{"Network is unreachable", "Could not resolve host", "Temporary failure in name resolution", "Name or service not known"},
+		"The sandbox has no network access; set sandbox.network to allow it.",
+	},
+}
+
+// sandboxHint explains output that shows a sandboxed tool ran into the
+// sandbox, or returns "" if it doesn't
+func sandboxHint(tool config.ToolConfig, output []byte) string {
+	if !tool.Sandbox.Enabled {
+		return ""
+	}
+	var hints []string
+	for _, signature := range sandboxSignatures {
+		if slices.ContainsFunc(signature.messages, func(message string) bool { return strings.Contains(string(output), message) }) {
+			hints = append(hints, signature.hint)
+		}
+	}
+	return strings.Join(hints, " ")
+}
+
+// sandboxTimeout bounds the timeout of a sandboxed Lua tool by its CPU
+// limit, since the script runs on the server's own threads
+func sandboxTimeout(tool config.ToolConfig, timeout time.Duration) time.Duration {
+	if limit := tool.Sandbox.CPU; tool.Sandbox.Enabled && limit > 0 && (timeout <= 0 || limit < timeout) {
+		return limit
+	}
+	return timeout
+}
+
+// sandboxEnvironment returns a copy of env in which TMPDIR is the call's
+// tempDir, leaving env itself untouched as calls run concurrently
+func sandboxEnvironment(env []config.EnvVar, tempDir string) []config.EnvVar {
+	environment := make([]config.EnvVar, 0, len(env)+1)
+	for _, v := range env {
+		if v.Name != "TMPDIR" {
+			environment = append(environment, v)
+		}
+	}
+	return append(environment, config.EnvVar{Name: "TMPDIR", Value: tempDir})
+}
+
+// sandboxLua takes away the functions of a Lua state that run programs or
+// write outside tempDir and the tool's writable paths. Lua tools only get
+// the standard libraries when sandboxed, so there is no network access.
+func sandboxLua(L *lua.LState, tool config.ToolConfig, tempDir string) {
+	writable := append(sandboxPolicy(tool).Writable, tempDir)
+	denied := func(path string) string {
+		return fmt.Sprintf("%s: Read-only file system (the sandbox only allows writes to $TMPDIR and the paths in sandbox.writable)", path)
+	}
+	isWritable := func(path string) bool {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return false
+		}
+		// Resolve the directory, which exists even when the file doesn't yet
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(absPath)); err == nil {
+			absPath = filepath.Join(dir, filepath.Base(absPath))
+		}
+		return slices.ContainsFunc(writable, func(root string) bool { return withinRoot(root, absPath) })
+	}
+
+	forbid := func(table, name string) {
+		if t, ok := L.GetGlobal(table).(*lua.LTable); ok {
+			t.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+				L.RaiseError("%s.%s is not allowed in the sandbox", table, name)
+				return 0
+			}))
+		}
+	}
+	forbid("os", "execute")
+	forbid("os", "exit")
+	forbid("io", "popen")
+
+	// guard wraps table.name so that the paths in its first count arguments
+	// must be writable, or when write reports that the call writes
+	guard := func(table, name string, count int, write func(L *lua.LState) bool) {
+		t, ok := L.GetGlobal(table).(*lua.LTable)
+		if !ok {
+			return
+		}
+		original := t.RawGetString(name)
+		t.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+			if write == nil || write(L) {
+				for i := 1; i <= count; i++ {
+					if path, ok := L.Get(i).(lua.LString); ok && !isWritable(string(path)) {
+						L.Push(lua.LNil)
+						L.Push(lua.LString(denied(string(path))))
+						return 2
+					}
+				}
+			}
+			top := L.GetTop()
+			L.Push(original)
+			for i := 1; i <= top; i++ {
+				L.Push(L.Get(i))
+			}
+			L.Call(top, lua.MultRet)
+			return L.GetTop() - top
+		}))
+	}
+	guard("io", "open", 1, func(L *lua.LState) bool { return strings.ContainsAny(L.OptString(2, "r"), "wa+") })
+	guard("io", "output", 1, nil)
+	guard("os", "remove", 1, nil)
+	guard("os", "rename", 2, nil)
+
+	if t, ok := L.GetGlobal("os").(*lua.LTable); ok {
+		t.RawSetString("tmpname", L.NewFunction(func(L *lua.LState) int {
+			file, err := os.CreateTemp(tempDir, "lua")
+			if err != nil {
+				L.RaiseError("unable to generate a unique filename: %v", err)
+			}
+			file.Close()
+			L.Push(lua.LString(file.Name()))
+			return 1
+		}))
+	}
+}
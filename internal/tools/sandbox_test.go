@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"dizi/internal/config"
+	"dizi/internal/sandbox"
+	"dizi/internal/shell"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestMain(m *testing.M) {
+	sandbox.Init()
+	os.Exit(m.Run())
+}
+
+func TestSandboxedScript(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The sandbox needs Linux")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := config.ToolConfig{
+		Name:    "build",
+		Type:    "script",
+		Script:  `echo obj > out/main.o && echo tmp > "$TMPDIR/scratch" && cat "$TMPDIR/scratch" && echo bad > main.c`,
+		Cwd:     ".",
+		Dir:     dir,
+		Sandbox: config.SandboxConfig{Enabled: true, Writable: []string{"out"}},
+	}
+	handler := createScriptHandler(tool, &registerOptions{shellEnv: shell.CleanEnv})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := contentTexts(result)[0]
+	if strings.Contains(text, "dizi sandbox:") {
+		t.Skipf("Sandbox not available here: %s", text)
+	}
+	if !result.IsError || !strings.Contains(text, "tmp\n") || !strings.Contains(text, "Read-only file system") ||
+		!strings.Contains(text, "only allows writes to $TMPDIR and the paths in sandbox.writable") {
+		t.Errorf("Expected writing main.c to fail with a sandbox hint, got %q", text)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "out", "main.o")); string(data) != "obj\n" {
+		t.Errorf("Expected out/main.o to be written, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.c")); err == nil {
+		t.Error("Expected main.c not to be created")
+	}
+}
+
+func TestSandboxedLua(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "tool.lua")
+	if err := os.WriteFile(script, []byte(`
+local tmp = os.getenv("TMPDIR") .. "/note"
+local f = assert(io.open(tmp, "w"))
+f:write("kept")
+f:close()
+local _, denied = io.open("`+filepath.Join(dir, "escape.txt")+`", "w")
+local ok, exec = pcall(os.execute, "true")
+result = io.open(tmp):read("*a") .. "|" .. tostring(denied) .. "|" .. tostring(exec)
+`), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	tool := config.ToolConfig{Name: "lua_tool", Type: "lua", Script: script, Sandbox: config.SandboxConfig{Enabled: true}}
+	result, err := createLuaHandler(tool, &registerOptions{})(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("Unexpected error: %v %v", err, result.Content)
+	}
+
+	text := contentTexts(result)[0]
+	for _, expected := range []string{"kept|", "escape.txt: Read-only file system", "os.execute is not allowed in the sandbox"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in result, got %q", expected, text)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err == nil {
+		t.Error("Expected escape.txt not to be created")
+	}
+}
+
+func TestSandboxedLuaEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "tool.lua")
+	if err := os.WriteFile(script, []byte(`
+local tmp = os.getenv("TMPDIR")
+local f = assert(io.open(tmp .. "/probe", "w"))
+f:close()
+result = tmp .. "|" .. os.getenv("GREETING")
+`), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	tool := config.ToolConfig{
+		Name:        "lua_tool",
+		Type:        "lua",
+		Script:      script,
+		Sandbox:     config.SandboxConfig{Enabled: true},
+		Environment: []config.EnvVar{{Name: "GREETING", Value: "hello"}, {Name: "TMPDIR", Value: "/configured"}},
+	}
+	handler := createLuaHandler(tool, &registerOptions{})
+	call := func() string {
+		result, err := handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+		})
+		if err != nil || result.IsError {
+			t.Errorf("Unexpected error: %v %v", err, result.Content)
+			return ""
+		}
+		return contentTexts(result)[0]
+	}
+
+	// Each call, one after another or at the same time, gets its own TMPDIR
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	check := func() {
+		text := call()
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[text] || !strings.HasSuffix(text, "|hello") || strings.HasPrefix(text, "/configured") {
+			t.Errorf("Expected a fresh TMPDIR per call, got %q", text)
+		}
+		seen[text] = true
+	}
+	check()
+	check()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			check()
+		}()
+	}
+	wg.Wait()
+
+	env := sandboxEnvironment(tool.Environment, "/tmp/call")
+	count := 0
+	for _, v := range env {
+		if v.Name == "TMPDIR" {
+			count++
+		}
+	}
+	if count != 1 || env[len(env)-1].Value != "/tmp/call" {
+		t.Errorf("Expected TMPDIR exactly once, got %v", env)
+	}
+	if len(tool.Environment) != 2 || tool.Environment[1].Value != "/configured" {
+		t.Errorf("Expected the tool's environment to be left alone, got %v", tool.Environment)
+	}
+}
@@ -0,0 +1,583 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file provides the builtin shell_session tool, which keeps interactive shells running between calls.
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dizi/internal/config"
+	"dizi/internal/shell"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ShellSessionTool is the name of the builtin tool that drives persistent shells
+const ShellSessionTool = "shell_session"
+
+// maxShellSessions is the number of shell sessions that can run at once
+const maxShellSessions = 16
+
+// maxCallerShellSessions is the number of those that one caller can run
+const maxCallerShellSessions = 4
+
+// maxSessionOutputBytes bounds the unread output kept per session; older
+// output is dropped first
+const maxSessionOutputBytes = 1 << 20
+
+// defaultSessionWait is how long shell_session waits for the prompt when no
+// timeout is given
+const defaultSessionWait = 30 * time.Second
+
+// Session states
+const (
+	sessionReady        = "ready"        // waiting at the prompt
+	sessionRunning      = "running"      // a command has not finished yet
+	sessionContinuation = "continuation" // waiting for the rest of an incomplete command
+	sessionExited       = "exited"
+)
+
+// terminalEscapes matches the escape sequences that programs write to a
+// terminal for colors, cursor movement and window titles
+var terminalEscapes = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>78DEHMNOZc]|[\r\x07]`)
+
+// sessionManager keeps the shell sessions of the server. Like background
+// jobs they belong to the server, so they outlive the client connection, but
+// each caller has its own names and can only reach its own sessions.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[sessionKey]*shellSession
+}
+
+// sessionKey identifies a session by the caller that created it and its name
+type sessionKey struct {
+	caller string
+	name   string
+}
+
+// shellSession is an interactive shell on a pseudo-terminal. Its prompt is
+// replaced by a marker so that the end of a command and its exit status can
+// be told from the output.
+type shellSession struct {
+	name    string
+	shell   string
+	dir     string
+	started time.Time
+	cmd     *exec.Cmd
+	pty     *os.File
+	cancel  context.CancelFunc
+	prompt  *regexp.Regexp // matches the marker prompt; group 1 is the exit status or "more"
+	done    chan struct{}  // closed once the shell has exited
+
+	busy sync.Mutex // one call drives the session at a time
+
+	mu       sync.Mutex
+	output   []byte // unread output, from offset base on
+	base     int
+	changed  chan struct{} // closed and replaced whenever output arrives
+	state    string
+	exitCode int
+}
+
+// sessionStatus is the structured form of a session's state
+type sessionStatus struct {
+	Session  string `json:"session"`
+	Shell    string `json:"shell"`
+	Dir      string `json:"cwd,omitempty"`
+	Status   string `json:"status"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+}
+
+// newSessionManager creates a manager without sessions
+func newSessionManager() *sessionManager {
+	return &sessionManager{sessions: make(map[sessionKey]*shellSession)}
+}
+
+// Create starts a shell session of caller named name in dir and waits up to
+// wait for its first prompt. A session that has exited can be replaced under
+// its name.
+func (m *sessionManager) Create(ctx context.Context, caller, name, dir string, env []string, wait time.Duration) (*shellSession, error) {
+	key := sessionKey{caller: caller, name: name}
+
+	m.mu.Lock()
+	live, own := 0, 0
+	for k, s := range m.sessions {
+		if !s.Exited() {
+			live++
+			if k.caller == caller {
+				own++
+			}
+		}
+	}
+	if s, ok := m.sessions[key]; ok && !s.Exited() {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("session %q already exists", name)
+	}
+	if own >= maxCallerShellSessions {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many shell sessions, close one first (at most %d per client)", maxCallerShellSessions)
+	}
+	if live >= maxShellSessions {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many shell sessions on the server (at most %d)", maxShellSessions)
+	}
+	// Reserve the name while the shell starts
+	placeholder := &shellSession{name: name, done: make(chan struct{})}
+	m.sessions[key] = placeholder
+	m.mu.Unlock()
+
+	s, err := startShellSession(ctx, name, dir, env, wait)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		delete(m.sessions, key)
+		return nil, err
+	}
+	m.sessions[key] = s
+	return s, nil
+}
+
+// Get returns the session of caller with the given name
+func (m *sessionManager) Get(caller, name string) (*shellSession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionKey{caller: caller, name: name}]
+	if ok && s.cmd == nil {
+		return nil, false // still starting
+	}
+	return s, ok
+}
+
+// Remove forgets the session of caller with the given name
+func (m *sessionManager) Remove(caller, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, sessionKey{caller: caller, name: name})
+}
+
+// List returns the sessions of caller, oldest first
+func (m *sessionManager) List(caller string) []*shellSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sessions []*shellSession
+	for k, s := range m.sessions {
+		if k.caller == caller && s.cmd != nil {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, k int) bool { return sessions[i].started.Before(sessions[k].started) })
+	return sessions
+}
+
+// startShellSession starts the shell, replaces its prompt and waits for it
+func startShellSession(ctx context.Context, name, dir string, env []string, wait time.Duration) (*shellSession, error) {
+	var raw [6]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, fmt.Errorf("failed to create prompt marker: %w", err)
+	}
+	marker := "<<dizi:" + hex.EncodeToString(raw[:]) + ":"
+
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	cmd, pty, err := shell.StartPTYShell(sessionCtx, dir, env)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	s := &shellSession{
+		name:     name,
+		shell:    shell.Name(),
+		dir:      dir,
+		started:  time.Now(),
+		cmd:      cmd,
+		pty:      pty,
+		cancel:   cancel,
+		prompt:   regexp.MustCompile(regexp.QuoteMeta(marker) + `(\d*|more)>> `),
+		done:     make(chan struct{}),
+		changed:  make(chan struct{}),
+		state:    sessionRunning,
+		exitCode: -1,
+	}
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := pty.Read(buf)
+			if n > 0 {
+				s.write(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		_ = cmd.Wait()
+		// Collect what the shell wrote last, unless a process it left
+		// behind keeps the terminal open
+		select {
+		case <-readDone:
+		case <-time.After(time.Second):
+		}
+		s.mu.Lock()
+		s.state = sessionExited
+		if cmd.ProcessState != nil {
+			s.exitCode = cmd.ProcessState.ExitCode()
+		}
+		s.mu.Unlock()
+		close(s.done)
+		cancel()
+		pty.Close()
+	}()
+
+	// The terminal may echo the setup line before it runs, but only a marker
+	// prompt at the very end of the output counts
+	setup := shell.PromptSetup(s.shell, marker, ">> ", marker+"more>> ")
+	if _, err := pty.WriteString(setup + "\n"); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to set up shell: %w", err)
+	}
+	if _, state, _ := s.Wait(ctx, 1, wait); state != sessionReady {
+		s.Close()
+		if state == sessionExited {
+			return nil, fmt.Errorf("shell exited while starting")
+		}
+		return nil, fmt.Errorf("shell did not show its prompt within %v", wait)
+	}
+	return s, nil
+}
+
+// write collects output from the terminal
+func (s *shellSession) write(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = append(s.output, p...)
+	if len(s.output) > maxSessionOutputBytes+maxSessionOutputBytes/4 {
+		drop := len(s.output) - maxSessionOutputBytes
+		s.output = append([]byte(nil), s.output[drop:]...)
+		s.base += drop
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Send writes text to the shell's terminal
+func (s *shellSession) Send(text string) error {
+	if s.Exited() {
+		return fmt.Errorf("the shell has exited")
+	}
+	s.mu.Lock()
+	s.state = sessionRunning
+	s.mu.Unlock()
+	_, err := s.pty.WriteString(text)
+	return err
+}
+
+// Wait waits until the shell has shown at least prompts prompts and is
+// waiting at one, the shell exits, ctx is done or wait passes. It returns
+// the output since the previous call without the prompts, the session's
+// state and the exit status of the last command, or -1 if unknown.
+func (s *shellSession) Wait(ctx context.Context, prompts int, wait time.Duration) (string, string, int) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		text := terminalEscapes.ReplaceAllString(string(s.output), "")
+		matches := s.prompt.FindAllStringSubmatchIndex(text, -1)
+		if s.state == sessionExited {
+			s.takeLocked()
+			s.mu.Unlock()
+			return s.stripPrompts(text), sessionExited, s.exitCode
+		}
+		if len(matches) > 0 && (s.state != sessionRunning || len(matches) >= prompts) {
+			last := matches[len(matches)-1]
+			if strings.TrimRight(text[last[1]:], " ") == "" {
+				status := text[last[2]:last[3]]
+				s.state = sessionReady
+				exitCode := -1
+				if status == "more" {
+					s.state = sessionContinuation
+				} else if code, err := strconv.Atoi(status); err == nil {
+					exitCode = code
+				}
+				state := s.state
+				s.takeLocked()
+				s.mu.Unlock()
+				return s.stripPrompts(text[:last[0]]), state, exitCode
+			}
+		}
+		if len(matches) == 0 && text == "" && s.state != sessionRunning {
+			state := s.state
+			s.mu.Unlock()
+			return "", state, -1
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-s.done:
+		case <-timer.C:
+			return s.take(), sessionRunning, -1
+		case <-ctx.Done():
+			return s.take(), sessionRunning, -1
+		}
+	}
+}
+
+// take returns and forgets the output collected so far
+func (s *shellSession) take() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := terminalEscapes.ReplaceAllString(string(s.output), "")
+	s.takeLocked()
+	return s.stripPrompts(text)
+}
+
+// takeLocked forgets the output collected so far
+func (s *shellSession) takeLocked() {
+	s.base += len(s.output)
+	s.output = nil
+}
+
+// stripPrompts removes the marker prompts between the outputs of commands
+func (s *shellSession) stripPrompts(text string) string {
+	return s.prompt.ReplaceAllString(text, "")
+}
+
+// Exited reports whether the shell has exited
+func (s *shellSession) Exited() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close kills the shell with the processes it started and waits briefly for it to exit
+func (s *shellSession) Close() {
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// Status returns a snapshot of the session's state
+func (s *shellSession) Status() sessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := sessionStatus{Session: s.name, Shell: s.shell, Dir: s.dir, Status: s.state}
+	if s.state == sessionExited && s.exitCode >= 0 {
+		exitCode := s.exitCode
+		status.ExitCode = &exitCode
+	}
+	return status
+}
+
+// shellSessionParameters is the input schema of the shell_session tool
+func shellSessionParameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []interface{}{"create", "send", "read", "close", "list"},
+				"description": "create starts a shell, send runs a command in it, read returns output that arrived since, close ends it and list shows your sessions.",
+			},
+			"session": map[string]interface{}{
+				"type":        "string",
+				"default":     "default",
+				"description": "Optional: the name of the session. Defaults to \"default\".",
+			},
+			"command": map[string]interface{}{
+				"type":        "string",
+				"description": "send: the command line to run, or the answer to a prompt of the running program.",
+			},
+			"interrupt": map[string]interface{}{
+				"type":        "boolean",
+				"description": "send: press Ctrl-C before sending the command, to stop the running program.",
+			},
+			"cwd": map[string]interface{}{
+				"type":        "string",
+				"description": "create: the directory to start in, relative to the project root.",
+			},
+			"timeout": map[string]interface{}{
+				"type":        "number",
+				"minimum":     0,
+				"description": fmt.Sprintf("Optional: the number of seconds to wait for the prompt. Defaults to %.0f; the command keeps running if it takes longer.", defaultSessionWait.Seconds()),
+			},
+		},
+		"required": []interface{}{"action"},
+	}
+}
+
+// handleShellSession handles the builtin shell_session tool
+func handleShellSession(ctx context.Context, tool config.ToolConfig, options *registerOptions, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	arguments, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return mcp.NewToolResultError("Invalid arguments format"), nil
+	}
+	m := options.sessions
+	if m == nil {
+		return mcp.NewToolResultError("Shell sessions are not available"), nil
+	}
+
+	caller := callerOf(ctx)
+	action, _ := arguments["action"].(string)
+	name, _ := arguments["session"].(string)
+	if name == "" {
+		name = "default"
+	}
+	wait := defaultSessionWait
+	if seconds, ok := arguments["timeout"].(float64); ok && seconds >= 0 {
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+
+	switch action {
+	case "create":
+		dir, err := sessionDir(tool, arguments, options)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		env := toolEnviron(tool, nil)
+		if env == nil {
+			env = os.Environ()
+		}
+		// Nothing reads the terminal interactively, so keep output plain and unpaged
+		env = append(env, "TERM=dumb", "PAGER=cat", "GIT_PAGER=cat")
+		s, err := m.Create(ctx, caller, name, dir, env, wait)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to create session %q: %v", name, err)), nil
+		}
+		status := s.Status()
+		result := mcp.NewToolResultText(fmt.Sprintf("Started %s session %q. Run commands with action \"send\".", status.Shell, name))
+		result.Content = append(result.Content, statusContent(status))
+		return result, nil
+
+	case "list":
+		sessions := m.List(caller)
+		statuses := make([]sessionStatus, len(sessions))
+		lines := make([]string, len(sessions))
+		for i, s := range sessions {
+			statuses[i] = s.Status()
+			lines[i] = fmt.Sprintf("%s (%s) %s", statuses[i].Session, statuses[i].Shell, statuses[i].Status)
+		}
+		text := strings.Join(lines, "\n")
+		if len(sessions) == 0 {
+			text = "No sessions"
+		}
+		result := mcp.NewToolResultText(text)
+		result.Content = append(result.Content, statusContent(statuses))
+		return result, nil
+	}
+
+	s, ok := m.Get(caller, name)
+	if !ok {
+		return mcp.NewToolResultError(fmt.Sprintf("Unknown session %q, create it first", name)), nil
+	}
+
+	if action == "close" {
+		s.Close()
+		m.Remove(caller, name)
+		return mcp.NewToolResultText(fmt.Sprintf("Closed session %q", name)), nil
+	}
+
+	if !s.busy.TryLock() {
+		return mcp.NewToolResultError(fmt.Sprintf("Session %q is busy with another call", name)), nil
+	}
+	defer s.busy.Unlock()
+
+	prompts := 1
+	switch action {
+	case "send":
+		command, _ := arguments["command"].(string)
+		if result := checkPolicy(tool, command); result != nil {
+			return result, nil
+		}
+		interrupt, _ := arguments["interrupt"].(bool)
+		var input strings.Builder
+		if interrupt {
+			input.WriteString("\x03")
+		}
+		if command != "" || !interrupt {
+			input.WriteString(command + "\n")
+			// Every line of the command ends in a prompt of its own, and so does Ctrl-C
+			prompts = strings.Count(command, "\n") + 1
+			if interrupt {
+				prompts++
+			}
+		}
+		if err := s.Send(input.String()); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to send to session %q: %v", name, err)), nil
+		}
+	case "read":
+	default:
+		return mcp.NewToolResultError(fmt.Sprintf("Unknown action %q", action)), nil
+	}
+
+	output, state, exitCode := s.Wait(ctx, prompts, wait)
+	if command, _ := arguments["command"].(string); command != "" {
+		// Shells that edit the command line themselves, like fish, echo it back
+		output = strings.TrimPrefix(output, command+"\n")
+	}
+	status := s.Status()
+	status.Status = state
+	if exitCode >= 0 {
+		status.ExitCode = &exitCode
+	}
+
+	result := mcp.NewToolResultText(output)
+	switch state {
+	case sessionRunning:
+		result.Content = append(result.Content, mcp.NewTextContent(fmt.Sprintf(
+			"[The command is still running after %v. Use action \"read\" to wait for more output, or \"send\" with interrupt to stop it.]", wait)))
+	case sessionContinuation:
+		result.Content = append(result.Content, mcp.NewTextContent(
+			"[The shell is waiting for the rest of an incomplete command, such as an unterminated quote. Send the rest, or send with interrupt to discard it.]"))
+	case sessionExited:
+		result.Content = append(result.Content, mcp.NewTextContent(
+			"[The shell has exited. Close the session and create it again to continue.]"))
+	}
+	result.Content = append(result.Content, statusContent(status))
+	return result, nil
+}
+
+// sessionDir returns the directory a session starts in: the cwd argument
+// resolved against the project root and kept within it, or the root itself
+func sessionDir(tool config.ToolConfig, arguments map[string]interface{}, options *registerOptions) (string, error) {
+	cwd, _ := arguments["cwd"].(string)
+	if cwd == "" {
+		return options.root, nil
+	}
+
+	root := options.allowedRoot(tool)
+	dir := cwd
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	dir = filepath.Clean(dir)
+	if !withinRoot(root, dir) {
+		return "", fmt.Errorf("working directory %s is outside the allowed root %s", dir, root)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("working directory %s does not exist", dir)
+	}
+	return dir, nil
+}
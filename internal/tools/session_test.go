@@ -0,0 +1,160 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"dizi/internal/auth"
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// callShellSession calls the shell_session tool and returns its output and state
+func callShellSession(t *testing.T, options *registerOptions, arguments map[string]interface{}) (string, sessionStatus) {
+	t.Helper()
+	tool := config.ToolConfig{Name: ShellSessionTool, Type: "builtin"}
+	serverTool, err := buildTool(tool, options)
+	if err != nil {
+		t.Fatalf("Failed to build tool: %v", err)
+	}
+	result, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: arguments},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("shell_session %v failed: %v %v", arguments, err, result.Content)
+	}
+
+	texts := contentTexts(result)
+	var status sessionStatus
+	if arguments["action"] == "list" {
+		return texts[0], status
+	}
+	if err := json.Unmarshal([]byte(texts[len(texts)-1]), &status); err != nil {
+		t.Fatalf("Expected session status, got %v", result.Content)
+	}
+	return texts[0], status
+}
+
+func TestShellSession(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("shell sessions need a pseudo-terminal")
+	}
+	root := t.TempDir()
+	options := &registerOptions{root: root, sessions: newSessionManager()}
+	defer func() {
+		for _, s := range options.sessions.List(callerOf(context.Background())) {
+			s.Close()
+		}
+	}()
+
+	callShellSession(t, options, map[string]interface{}{"action": "create", "session": "dev", "timeout": float64(60)})
+
+	// State carries over between calls
+	output, status := callShellSession(t, options, map[string]interface{}{"action": "send", "session": "dev", "command": "mkdir sub && cd sub; export GREETING=hello"})
+	if status.Status != sessionReady || status.ExitCode == nil || *status.ExitCode != 0 {
+		t.Fatalf("Expected the command to succeed, got %+v with output %q", status, output)
+	}
+	output, _ = callShellSession(t, options, map[string]interface{}{"action": "send", "session": "dev", "command": "pwd; echo $GREETING"})
+	if !strings.HasSuffix(strings.TrimSpace(output), "sub\nhello") {
+		t.Errorf("Expected working directory and variable to persist, got %q", output)
+	}
+
+	_, status = callShellSession(t, options, map[string]interface{}{"action": "send", "session": "dev", "command": "false"})
+	if status.ExitCode == nil || *status.ExitCode != 1 {
+		t.Errorf("Expected exit status 1, got %+v", status)
+	}
+
+	// A command that outlasts the timeout keeps running until interrupted
+	_, status = callShellSession(t, options, map[string]interface{}{"action": "send", "session": "dev", "command": "sleep 30", "timeout": float64(0.5)})
+	if status.Status != sessionRunning {
+		t.Errorf("Expected the command to still be running, got %+v", status)
+	}
+	_, status = callShellSession(t, options, map[string]interface{}{"action": "send", "session": "dev", "interrupt": true, "timeout": float64(10)})
+	if status.Status != sessionReady {
+		t.Errorf("Expected the interrupt to return to the prompt, got %+v", status)
+	}
+
+	output, _ = callShellSession(t, options, map[string]interface{}{"action": "list"})
+	if !strings.Contains(output, "dev") {
+		t.Errorf("Expected the session in the list, got %q", output)
+	}
+	result, _ := createBuiltinHandler(config.ToolConfig{Name: ShellSessionTool}, options)(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"action": "close", "session": "dev"}},
+	})
+	if result.IsError {
+		t.Errorf("Failed to close session: %v", result.Content)
+	}
+	if _, ok := options.sessions.Get(callerOf(context.Background()), "dev"); ok {
+		t.Error("Expected the session to be gone after closing it")
+	}
+}
+
+func TestShellSessionBelongsToCaller(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("shell sessions need a pseudo-terminal")
+	}
+	options := &registerOptions{root: t.TempDir(), sessions: newSessionManager()}
+	defer func() {
+		for _, s := range options.sessions.sessions {
+			if s.cmd != nil {
+				s.Close()
+			}
+		}
+	}()
+	handler := createBuiltinHandler(config.ToolConfig{Name: ShellSessionTool}, options)
+	call := func(ctx context.Context, arguments map[string]interface{}) *mcp.CallToolResult {
+		t.Helper()
+		arguments["timeout"] = float64(60)
+		result, err := handler(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: arguments}})
+		if err != nil {
+			t.Fatalf("shell_session %v failed: %v", arguments, err)
+		}
+		return result
+	}
+
+	if result := call(context.Background(), map[string]interface{}{"action": "create"}); result.IsError {
+		t.Fatalf("Failed to create session: %v", result.Content)
+	}
+
+	// Without authentication a client that reconnects finds its shell again
+	reconnected := sessionContext(t, server.NewMCPServer("test", "1.0.0"), newTestSession("reconnected"))
+	if result := call(reconnected, map[string]interface{}{"action": "read"}); result.IsError {
+		t.Errorf("Expected the session to be reached from another session, got %v", result.Content)
+	}
+
+	other := auth.WithIdentity(context.Background(), &auth.Identity{Name: "other", Method: auth.MethodToken})
+	for _, action := range []string{"send", "read", "close"} {
+		result := call(other, map[string]interface{}{"action": action, "command": "echo hi"})
+		if text := contentTexts(result)[0]; !result.IsError || text != `Unknown session "default", create it first` {
+			t.Errorf("Expected %s to refuse the session of another caller, got %q", action, text)
+		}
+	}
+	if text := contentTexts(call(other, map[string]interface{}{"action": "list"}))[0]; text != "No sessions" {
+		t.Errorf("Expected the sessions of other callers to be left out, got %q", text)
+	}
+
+	// The other caller has names of its own, up to its share of sessions
+	for i := 0; i < maxCallerShellSessions; i++ {
+		name := "default"
+		if i > 0 {
+			name = fmt.Sprintf("s%d", i)
+		}
+		if result := call(other, map[string]interface{}{"action": "create", "session": name}); result.IsError {
+			t.Fatalf("Failed to create session %q: %v", name, result.Content)
+		}
+	}
+	result := call(other, map[string]interface{}{"action": "create", "session": "one-too-many"})
+	if !result.IsError || !strings.Contains(contentTexts(result)[0], "too many shell sessions") {
+		t.Errorf("Expected the caller's session limit to apply, got %v", result.Content)
+	}
+
+	if _, ok := options.sessions.Get(callerOf(context.Background()), "default"); !ok {
+		t.Error("Expected the first caller's session to be unaffected")
+	}
+}
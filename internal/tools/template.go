@@ -0,0 +1,296 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file implements the placeholder templates used in tool scripts and args.
+//
+// Template syntax:
+//
+//	{{name}}                  value of the argument, quoted for the target shell
+//	{{name|default:"."}}      fall back to "." when the argument is unset
+//	{{name|raw}}              insert the value without quoting
+//	{{name|json}}             JSON-encode the value (objects and arrays always are)
+//	{{#if name}}...{{/if}}    keep the fragment only when the argument is set and
+//	                          not empty, false or zero; {{else}} is supported
+//
+// Tags that don't follow this syntax (such as docker's {{.Names}}) are kept as
+// literal text.
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dizi/internal/config"
+)
+
+// placeholderName matches the argument names usable in templates
+var placeholderName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateNode is a piece of a parsed template
+type templateNode interface{}
+
+// textNode is literal template text
+type textNode string
+
+// valueNode is a {{name|filter...}} placeholder
+type valueNode struct {
+	name       string
+	defaultVal *string
+	raw        bool
+	json       bool
+}
+
+// ifNode is a {{#if name}}...{{else}}...{{/if}} block
+type ifNode struct {
+	name      string
+	then      []templateNode
+	otherwise []templateNode
+}
+
+// parseTemplate parses text into template nodes
+func parseTemplate(text string) ([]templateNode, error) {
+	nodes, rest, closer, err := parseNodes(text)
+	if err != nil {
+		return nil, err
+	}
+	if closer != "" {
+		return nil, fmt.Errorf("unexpected {{%s}}", closer)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("internal error: unparsed template text %q", rest)
+	}
+	return nodes, nil
+}
+
+// parseNodes parses nodes until the end of text or an {{else}}/{{/if}} tag,
+// returning the remaining text after that tag and the tag itself
+func parseNodes(text string) (nodes []templateNode, rest string, closer string, err error) {
+	for text != "" {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			nodes = append(nodes, textNode(text))
+			return nodes, "", "", nil
+		}
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			nodes = append(nodes, textNode(text))
+			return nodes, "", "", nil
+		}
+		end += start
+
+		if start > 0 {
+			nodes = append(nodes, textNode(text[:start]))
+		}
+		tag := strings.TrimSpace(text[start+2 : end])
+		literal := text[start : end+2]
+		text = text[end+2:]
+
+		switch {
+		case tag == "else" || tag == "/if":
+			return nodes, text, tag, nil
+		case strings.HasPrefix(tag, "#if"):
+			name := strings.TrimSpace(strings.TrimPrefix(tag, "#if"))
+			if !placeholderName.MatchString(name) {
+				return nil, "", "", fmt.Errorf("invalid condition in %s", literal)
+			}
+			block := &ifNode{name: name}
+			var c string
+			block.then, text, c, err = parseNodes(text)
+			if err != nil {
+				return nil, "", "", err
+			}
+			if c == "else" {
+				block.otherwise, text, c, err = parseNodes(text)
+				if err != nil {
+					return nil, "", "", err
+				}
+			}
+			if c != "/if" {
+				return nil, "", "", fmt.Errorf("missing {{/if}} for %s", literal)
+			}
+			nodes = append(nodes, block)
+		default:
+			value, ok, err := parseValue(tag)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("invalid placeholder %s: %w", literal, err)
+			}
+			if ok {
+				nodes = append(nodes, value)
+			} else {
+				nodes = append(nodes, textNode(literal))
+			}
+		}
+	}
+	return nodes, "", "", nil
+}
+
+// parseValue parses "name|filter|..." and reports whether tag is a placeholder at all
+func parseValue(tag string) (*valueNode, bool, error) {
+	parts := splitFilters(tag)
+	name := strings.TrimSpace(parts[0])
+	if !placeholderName.MatchString(name) {
+		return nil, false, nil
+	}
+
+	node := &valueNode{name: name}
+	for _, filter := range parts[1:] {
+		filter = strings.TrimSpace(filter)
+		switch {
+		case filter == "raw":
+			node.raw = true
+		case filter == "json":
+			node.json = true
+		case strings.HasPrefix(filter, "default:"):
+			value, err := parseLiteral(strings.TrimSpace(strings.TrimPrefix(filter, "default:")))
+			if err != nil {
+				return nil, false, err
+			}
+			node.defaultVal = &value
+		default:
+			return nil, false, fmt.Errorf("unknown filter %q", filter)
+		}
+	}
+	return node, true, nil
+}
+
+// splitFilters splits a tag on '|' outside of double-quoted strings
+func splitFilters(tag string) []string {
+	var parts []string
+	inQuotes := false
+	last := 0
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case '|':
+			if !inQuotes {
+				parts = append(parts, tag[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, tag[last:])
+}
+
+// parseLiteral parses a default value, either a double-quoted string or a bare word
+func parseLiteral(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return strconv.Unquote(s)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\"") {
+		return "", fmt.Errorf("default value %q must be quoted", s)
+	}
+	return s, nil
+}
+
+// checkTemplates parses the script, args, cwd and output path of a tool to catch template errors
+func checkTemplates(tool config.ToolConfig) error {
+	switch tool.Type {
+	case "script":
+		if _, err := parseTemplate(tool.Script); err != nil {
+			return err
+		}
+	case "command":
+		for _, arg := range tool.Args {
+			if _, err := parseTemplate(arg); err != nil {
+				return err
+			}
+		}
+	}
+	for _, text := range []string{tool.Cwd, tool.Stdin, tool.Output.Path} {
+		if _, err := parseTemplate(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderTemplate expands the placeholders in text. Values are passed through
+// quote unless marked raw; a nil quote inserts all values verbatim.
+func renderTemplate(text string, arguments map[string]interface{}, quote func(string) string) (string, error) {
+	nodes, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	renderNodes(&out, nodes, arguments, quote)
+	return out.String(), nil
+}
+
+// renderNodes writes nodes to out
+func renderNodes(out *strings.Builder, nodes []templateNode, arguments map[string]interface{}, quote func(string) string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case textNode:
+			out.WriteString(string(n))
+		case *ifNode:
+			if isTruthy(arguments[n.name]) {
+				renderNodes(out, n.then, arguments, quote)
+			} else {
+				renderNodes(out, n.otherwise, arguments, quote)
+			}
+		case *valueNode:
+			value, ok := arguments[n.name]
+			var text string
+			switch {
+			case (!ok || value == nil) && n.defaultVal != nil:
+				text = *n.defaultVal
+			case n.json:
+				data, _ := json.Marshal(value)
+				text = string(data)
+			default:
+				text = formatValue(value)
+			}
+			if quote != nil && !n.raw {
+				text = quote(text)
+			}
+			out.WriteString(text)
+		}
+	}
+}
+
+// formatValue renders an argument as text, JSON-encoding objects and arrays
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// isTruthy reports whether an argument enables an {{#if}} block
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
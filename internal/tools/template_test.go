@@ -0,0 +1,161 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+
+	"dizi/internal/config"
+	"dizi/internal/shell"
+)
+
+func TestRenderTemplateQuoted(t *testing.T) {
+	quote := func(value string) string { return shell.Quote("bash", value) }
+
+	tests := []struct {
+		name      string
+		text      string
+		arguments map[string]interface{}
+		expected  string
+	}{
+		{
+			name:      "value is quoted",
+			text:      "cd {{path}} && git status",
+			arguments: map[string]interface{}{"path": "x; rm -rf ~"},
+			expected:  "cd 'x; rm -rf ~' && git status",
+		},
+		{
+			name:      "embedded quote",
+			text:      "echo {{msg}}",
+			arguments: map[string]interface{}{"msg": "it's"},
+			expected:  `echo 'it'\''s'`,
+		},
+		{
+			name:      "raw opt-out",
+			text:      "{{command|raw}}",
+			arguments: map[string]interface{}{"command": "ls -la | head"},
+			expected:  "ls -la | head",
+		},
+		{
+			name:      "default when unset",
+			text:      `west build -s {{source_dir|default:"."}}`,
+			arguments: map[string]interface{}{},
+			expected:  "west build -s '.'",
+		},
+		{
+			name:      "default not used when set",
+			text:      `west build -s {{source_dir|default:"."}}`,
+			arguments: map[string]interface{}{"source_dir": "app"},
+			expected:  "west build -s 'app'",
+		},
+		{
+			name:      "default with pipe and raw",
+			text:      `{{filter|default:"a|b"|raw}}`,
+			arguments: map[string]interface{}{},
+			expected:  "a|b",
+		},
+		{
+			name:      "missing value is empty word",
+			text:      "echo {{missing}} done",
+			arguments: map[string]interface{}{},
+			expected:  "echo '' done",
+		},
+		{
+			name:      "conditional fragment set",
+			text:      "west build{{#if board}} -b {{board}}{{/if}}",
+			arguments: map[string]interface{}{"board": "esp32"},
+			expected:  "west build -b 'esp32'",
+		},
+		{
+			name:      "conditional fragment unset",
+			text:      "west build{{#if board}} -b {{board}}{{/if}}",
+			arguments: map[string]interface{}{},
+			expected:  "west build",
+		},
+		{
+			name:      "conditional else",
+			text:      "{{#if verbose}}-v{{else}}-q{{/if}}",
+			arguments: map[string]interface{}{"verbose": false},
+			expected:  "-q",
+		},
+		{
+			name:      "nested conditionals",
+			text:      "{{#if a}}A{{#if b}}B{{/if}}{{/if}}",
+			arguments: map[string]interface{}{"a": true, "b": "x"},
+			expected:  "AB",
+		},
+		{
+			name:      "object is JSON encoded",
+			text:      "curl -d {{body}}",
+			arguments: map[string]interface{}{"body": map[string]interface{}{"k": "v"}},
+			expected:  `curl -d '{"k":"v"}'`,
+		},
+		{
+			name:      "array is JSON encoded",
+			text:      "echo {{items}}",
+			arguments: map[string]interface{}{"items": []interface{}{"a", float64(1)}},
+			expected:  `echo '["a",1]'`,
+		},
+		{
+			name:      "json filter on string",
+			text:      "echo {{name|json|raw}}",
+			arguments: map[string]interface{}{"name": "x"},
+			expected:  `echo "x"`,
+		},
+		{
+			name:      "whole numbers stay integers",
+			text:      "head -n {{lines}}",
+			arguments: map[string]interface{}{"lines": float64(20)},
+			expected:  "head -n '20'",
+		},
+		{
+			name:      "foreign template syntax is kept",
+			text:      "docker ps --format '{{.Names}}' {{ not a placeholder }}",
+			arguments: map[string]interface{}{},
+			expected:  "docker ps --format '{{.Names}}' {{ not a placeholder }}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := renderTemplate(tt.text, tt.arguments, quote)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{{#if a}}unterminated", "missing {{/if}}"},
+		{"stray {{/if}}", "unexpected {{/if}}"},
+		{"{{#if}}x{{/if}}", "invalid condition"},
+		{"{{name|upper}}", `unknown filter "upper"`},
+		{"{{name|default:two words}}", "must be quoted"},
+	}
+
+	for _, tt := range tests {
+		_, err := renderTemplate(tt.text, map[string]interface{}{}, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("renderTemplate(%q) error = %v, want containing %q", tt.text, err, tt.expected)
+		}
+	}
+}
+
+func TestCheckTemplates(t *testing.T) {
+	if err := checkTemplates(config.ToolConfig{Type: "script", Script: "{{#if a}}"}); err == nil {
+		t.Error("Expected error for malformed script template")
+	}
+	if err := checkTemplates(config.ToolConfig{Type: "command", Args: []string{"ok", "{{x|bogus}}"}}); err == nil {
+		t.Error("Expected error for malformed args template")
+	}
+	if err := checkTemplates(config.ToolConfig{Type: "lua", Script: "dizi_bin/{{#if}}.lua"}); err != nil {
+		t.Errorf("Expected lua script paths to be ignored, got %v", err)
+	}
+}
@@ -6,9 +6,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"os/exec"
 	"strings"
+	"time"
 
+	"dizi/internal/approval"
+	"dizi/internal/audit"
 	"dizi/internal/config"
+	"dizi/internal/sandbox"
 	"dizi/internal/shell"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -17,56 +23,126 @@ import (
 	lua "github.com/yuin/gopher-lua"
 )
 
+// RegisterOption customizes how RegisterTools builds tool handlers
+type RegisterOption func(*registerOptions)
+
+// registerOptions holds settings shared by all handlers created in one RegisterTools call
+type registerOptions struct {
+	defaultTimeout time.Duration
+	root           string
+	outputLimit    config.OutputLimitConfig
+	shellEnv       shell.EnvMode
+	outputs        *outputStore     // keeps truncated output for fetch_output, set by the ToolSet
+	jobs           *jobManager      // runs async tools, set by the ToolSet
+	sessions       *sessionManager  // keeps the shells of shell_session, set by the ToolSet
+	approvals      *approval.Broker // where calls to tools with confirm: true wait, set by WithApprovals
+	audit          *audit.Log       // records every call, set by WithAudit
+}
+
+// WithDefaultTimeout sets the timeout for tools that don't declare their own
+func WithDefaultTimeout(timeout time.Duration) RegisterOption {
+	return func(o *registerOptions) {
+		o.defaultTimeout = timeout
+	}
+}
+
+// WithShellEnv sets how command and script tools load the user's shell environment
+func WithShellEnv(mode string) RegisterOption {
+	return func(o *registerOptions) {
+		o.shellEnv = shell.EnvMode(mode)
+	}
+}
+
+// WithRoot sets the directory that a tool's templated cwd must stay within
+func WithRoot(root string) RegisterOption {
+	return func(o *registerOptions) {
+		o.root = root
+	}
+}
+
 // RegisterTools registers all tools from the configuration
-func RegisterTools(mcpServer *server.MCPServer, tools []config.ToolConfig) error {
-	for _, tool := range tools {
-		// Marshal the parameters to JSON
-		var schemaBytes []byte
-		var err error
-		if tool.Parameters != nil {
-			schemaBytes, err = json.Marshal(tool.Parameters)
-			if err != nil {
-				return fmt.Errorf("failed to marshal parameters for tool %s: %w", tool.Name, err)
-			}
-		} else {
-			// Default empty object schema
-			schemaBytes = []byte(`{"type": "object", "properties": {}}`)
-		}
+func RegisterTools(mcpServer *server.MCPServer, tools []config.ToolConfig, opts ...RegisterOption) error {
+	_, err := NewToolSet(mcpServer).Apply(tools, opts...)
+	return err
+}
 
-		// Create MCP tool with raw schema
-		mcpTool := mcp.NewToolWithRawSchema(tool.Name, tool.Description, json.RawMessage(schemaBytes))
-
-		// Create handler based on tool type
-		var handler func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
-
-		switch tool.Type {
-		case "builtin":
-			handler = createBuiltinHandler(tool)
-		case "command":
-			handler = createCommandHandler(tool)
-		case "script":
-			handler = createScriptHandler(tool)
-		case "lua":
-			handler = createLuaHandler(tool)
-		default:
-			return fmt.Errorf("unsupported tool type: %s for tool %s", tool.Type, tool.Name)
+// newRegisterOptions applies opts to the default options
+func newRegisterOptions(opts []RegisterOption) *registerOptions {
+	options := &registerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// buildTool creates the MCP tool definition and handler for a configured tool
+func buildTool(tool config.ToolConfig, options *registerOptions) (server.ServerTool, error) {
+	if tool.Type == "builtin" && tool.Name == ShellSessionTool && tool.Parameters == nil {
+		tool.Parameters = shellSessionParameters()
+	}
+
+	// Marshal the parameters to JSON
+	var schemaBytes []byte
+	var err error
+	if tool.Parameters != nil {
+		schemaBytes, err = json.Marshal(tool.Parameters)
+		if err != nil {
+			return server.ServerTool{}, fmt.Errorf("failed to marshal parameters for tool %s: %w", tool.Name, err)
 		}
+	} else {
+		// Default empty object schema
+		schemaBytes = []byte(`{"type": "object", "properties": {}}`)
+	}
+
+	// Create MCP tool with raw schema
+	mcpTool := mcp.NewToolWithRawSchema(tool.Name, tool.Description, json.RawMessage(schemaBytes))
+	if mcpTool.RawOutputSchema, err = outputSchema(tool); err != nil {
+		return server.ServerTool{}, err
+	}
+
+	// Reject malformed templates up front rather than on every call
+	if err := checkTemplates(tool); err != nil {
+		return server.ServerTool{}, fmt.Errorf("invalid template in tool %s: %w", tool.Name, err)
+	}
+
+	// Create handler based on tool type
+	var handler func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
+
+	switch tool.Type {
+	case "builtin":
+		handler = createBuiltinHandler(tool, options)
+	case "command":
+		handler = createCommandHandler(tool, options)
+	case "script":
+		handler = createScriptHandler(tool, options)
+	case "lua":
+		handler = createLuaHandler(tool, options)
+	default:
+		return server.ServerTool{}, fmt.Errorf("unsupported tool type: %s for tool %s", tool.Type, tool.Name)
+	}
 
-		// Register the tool
-		mcpServer.AddTool(mcpTool, handler)
+	// Approval is asked with the validated arguments the tool will run with
+	if tool.Confirm {
+		handler = withApproval(tool.Name, options.approvals, handler)
 	}
 
-	return nil
+	// Secrets are removed before truncating so that a cut can't expose part of one
+	handler = withRedaction(tool, withArgumentValidation(tool, handler))
+	handler = withOutputLimit(options.outputLimitFor(tool), options.outputs, handler)
+	handler = withAudit(tool.Name, toolSecrets(tool), options.audit, handler)
+	return server.ServerTool{Tool: mcpTool, Handler: handler}, nil
 }
 
 // createBuiltinHandler creates a handler for builtin tools
-func createBuiltinHandler(tool config.ToolConfig) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func createBuiltinHandler(tool config.ToolConfig, options *registerOptions) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		switch tool.Name {
 		case "echo":
 			return handleEcho(request)
 		case "lua_eval":
 			return handleLuaEval(request)
+		case ShellSessionTool:
+			return handleShellSession(ctx, tool, options, request)
 		default:
 			return mcp.NewToolResultError(fmt.Sprintf("Unknown builtin tool: %s", tool.Name)), nil
 		}
@@ -74,52 +150,152 @@ func createBuiltinHandler(tool config.ToolConfig) func(ctx context.Context, requ
 }
 
 // createCommandHandler creates a handler for command tools
-func createCommandHandler(tool config.ToolConfig) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func createCommandHandler(tool config.ToolConfig, options *registerOptions) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		// Extract arguments
 		arguments, ok := request.Params.Arguments.(map[string]interface{})
 		if !ok {
 			return mcp.NewToolResultError("Invalid arguments format"), nil
 		}
 
-		// Replace placeholders in args
-		processedArgs := make([]string, len(tool.Args))
-		for i, arg := range tool.Args {
-			processedArgs[i] = replacePlaceholders(arg, arguments)
+		// Expand templates in args; they are quoted as whole words later
+		processedArgs := make([]string, 0, len(tool.Args))
+		for _, arg := range tool.Args {
+			processed, err := renderTemplate(arg, arguments, nil)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Invalid template in args: %v", err)), nil
+			}
+			// An argument made only of a false {{#if}} block is left out entirely
+			if processed == "" && strings.Contains(arg, "{{#if") {
+				continue
+			}
+			processedArgs = append(processedArgs, processed)
 		}
 
-		// Execute command with shell environment
-		cmd := shell.CreateShellCommand(tool.Command, processedArgs...)
-		output, err := cmd.CombinedOutput()
+		// Check the command line as the shell will see it
+		commandLine := tool.Command
+		for _, arg := range processedArgs {
+			commandLine += " " + shell.Quote("sh", arg)
+		}
+		if result := checkPolicy(tool, commandLine); result != nil {
+			return result, nil
+		}
+
+		dir, err := workingDir(tool, arguments, options)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("Command failed: %v\nOutput: %s", err, string(output))), nil
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		input, err := renderTemplate(tool.Stdin, arguments, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid template in stdin: %v", err)), nil
+		}
+
+		// Execute command with shell environment
+		newCmd := func(ctx context.Context) (*exec.Cmd, error) {
+			cmd := shell.CreateShellCommandEnv(ctx, options.shellEnv, tool.Command, processedArgs...)
+			cmd.Env = toolEnviron(tool, cmd.Env)
+			cmd.Dir = dir
+			if input != "" {
+				cmd.Stdin = strings.NewReader(input)
+			}
+			return cmd, sandboxCommand(ctx, tool, cmd)
+		}
+		if tool.Async {
+			return options.jobs.Start(ctx, tool, options.approvals, newCmd), nil
 		}
 
-		return mcp.NewToolResultText(string(output)), nil
+		ctx, cancel := withTimeout(ctx, options.timeoutFor(tool))
+		defer cancel()
+		cmd, err := newCmd(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		output, err := runWithProgress(ctx, cmd, request, tool.Progress)
+		return commandResult(ctx, "Command", tool, arguments, dir, options, output, err), nil
 	}
 }
 
 // createScriptHandler creates a handler for script tools
-func createScriptHandler(tool config.ToolConfig) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func createScriptHandler(tool config.ToolConfig, options *registerOptions) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		// Extract arguments
 		arguments, ok := request.Params.Arguments.(map[string]interface{})
 		if !ok {
 			return mcp.NewToolResultError("Invalid arguments format"), nil
 		}
 
-		// Replace placeholders in script
-		processedScript := replacePlaceholders(tool.Script, arguments)
+		// Expand templates in the script, quoting values for the target shell
+		shellName := shell.Name()
+		processedScript, err := renderTemplate(tool.Script, arguments, func(value string) string {
+			return shell.Quote(shellName, value)
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid template in script: %v", err)), nil
+		}
+		if result := checkPolicy(tool, processedScript); result != nil {
+			return result, nil
+		}
+
+		dir, err := workingDir(tool, arguments, options)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		input, err := renderTemplate(tool.Stdin, arguments, nil)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid template in stdin: %v", err)), nil
+		}
 
 		// Execute script with shell environment
-		cmd := shell.CreateShellScriptCommand(processedScript)
-		output, err := cmd.CombinedOutput()
+		newCmd := func(ctx context.Context) (*exec.Cmd, error) {
+			cmd := shell.CreateShellScriptCommandEnv(ctx, options.shellEnv, processedScript)
+			cmd.Env = toolEnviron(tool, cmd.Env)
+			cmd.Dir = dir
+			if input != "" {
+				cmd.Stdin = strings.NewReader(input)
+			}
+			return cmd, sandboxCommand(ctx, tool, cmd)
+		}
+		if tool.Async {
+			return options.jobs.Start(ctx, tool, options.approvals, newCmd), nil
+		}
+
+		ctx, cancel := withTimeout(ctx, options.timeoutFor(tool))
+		defer cancel()
+		cmd, err := newCmd(ctx)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("Script failed: %v\nOutput: %s", err, string(output))), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		output, err := runWithProgress(ctx, cmd, request, tool.Progress)
+		return commandResult(ctx, "Script", tool, arguments, dir, options, output, err), nil
+	}
+}
 
-		return mcp.NewToolResultText(string(output)), nil
+// timeoutFor returns the effective timeout of a tool, falling back to the server default
+func (o *registerOptions) timeoutFor(tool config.ToolConfig) time.Duration {
+	if tool.Timeout > 0 {
+		return tool.Timeout
 	}
+	return o.defaultTimeout
+}
+
+// withTimeout bounds ctx by timeout; a zero timeout leaves it unbounded
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// executionError builds the error result for a failed command or script,
+// telling timeouts and client cancellations apart from ordinary failures
+func executionError(ctx context.Context, kind string, timeout time.Duration, err error, output []byte) *mcp.CallToolResult {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return mcp.NewToolResultError(fmt.Sprintf("%s timed out after %v\nOutput: %s", kind, timeout, string(output)))
+	case context.Canceled:
+		return mcp.NewToolResultError(fmt.Sprintf("%s cancelled\nOutput: %s", kind, string(output)))
+	}
+	return mcp.NewToolResultError(fmt.Sprintf("%s failed: %v\nOutput: %s", kind, err, string(output)))
 }
 
 // handleEcho handles the builtin echo tool
@@ -139,20 +315,55 @@ func handleEcho(request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 }
 
 // createLuaHandler creates a handler for lua tools
-func createLuaHandler(tool config.ToolConfig) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return func(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func createLuaHandler(tool config.ToolConfig, options *registerOptions) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		// Extract arguments
 		arguments, ok := request.Params.Arguments.(map[string]interface{})
 		if !ok {
 			return mcp.NewToolResultError("Invalid arguments format"), nil
 		}
 
+		timeout := sandboxTimeout(tool, options.timeoutFor(tool))
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
 		// Create Lua state
 		L := lua.NewState()
 		defer L.Close()
 		
-		// Load gopher-lua-libs
-		libs.Preload(L)
+		env := tool.Environment
+		if tool.Sandbox.Enabled {
+			// Only the standard libraries, with writes limited to a temp dir
+			tempDir, err := os.MkdirTemp("", sandbox.TempDirPrefix)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to create sandbox temp dir: %v", err)), nil
+			}
+			defer os.RemoveAll(tempDir)
+			sandboxLua(L, tool, tempDir)
+			env = sandboxEnvironment(env, tempDir)
+		} else {
+			// Load gopher-lua-libs
+			libs.Preload(L)
+		}
+		setLuaGetenv(L, env)
+
+		// Abort the script when the call times out or is cancelled
+		L.SetContext(ctx)
+
+		// Stream print output to the client as progress notifications
+		progress := newProgressWriter(ctx, request, tool.Progress)
+		defer progress.Flush()
+		L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+			top := L.GetTop()
+			for i := 1; i <= top; i++ {
+				if i > 1 {
+					_, _ = progress.Write([]byte("\t"))
+				}
+				_, _ = progress.Write([]byte(L.Get(i).String()))
+			}
+			_, _ = progress.Write([]byte("\n"))
+			return 0
+		}))
 
 		// Set arguments as global variables in Lua
 		for key, value := range arguments {
@@ -168,18 +379,19 @@ func createLuaHandler(tool config.ToolConfig) func(ctx context.Context, request
 			}
 		}
 
-		// Execute the Lua script from file
-		if err := L.DoFile(tool.Script); err != nil {
+		// Execute the Lua script from file, relative to the config file
+		if err := L.DoFile(toolPath(tool, tool.Script)); err != nil {
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				return mcp.NewToolResultError(fmt.Sprintf("Lua script timed out after %v", timeout)), nil
+			case context.Canceled:
+				return mcp.NewToolResultError("Lua script cancelled"), nil
+			}
 			return mcp.NewToolResultError(fmt.Sprintf("Lua script failed: %v", err)), nil
 		}
 
 		// Get the result from a global variable called 'result' if it exists
-		result := L.GetGlobal("result")
-		if result != lua.LNil {
-			return mcp.NewToolResultText(result.String()), nil
-		}
-
-		return mcp.NewToolResultText("Lua script executed successfully"), nil
+		return luaResult(tool, arguments, options, L.GetGlobal("result")), nil
 	}
 }
 
@@ -323,15 +535,3 @@ func handleLuaEval(request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
 	return mcp.NewToolResultText("Lua code executed successfully (no output)"), nil
 }
-
-// replacePlaceholders replaces {{parameter_name}} placeholders with actual values
-func replacePlaceholders(text string, arguments map[string]interface{}) string {
-	result := text
-	for key, value := range arguments {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		replacement := fmt.Sprintf("%v", value)
-		result = strings.ReplaceAll(result, placeholder, replacement)
-	}
-	return result
-}
-
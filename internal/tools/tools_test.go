@@ -2,10 +2,15 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"dizi/internal/config"
+	"dizi/internal/shell"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -210,7 +215,7 @@ func TestCreateBuiltinHandler(t *testing.T) {
 		Type: "builtin",
 	}
 	
-	handler := createBuiltinHandler(tool)
+	handler := createBuiltinHandler(tool, &registerOptions{})
 	if handler == nil {
 		t.Error("Expected handler function, got nil")
 	}
@@ -221,7 +226,7 @@ func TestCreateBuiltinHandler(t *testing.T) {
 		Type: "builtin",
 	}
 	
-	unknownHandler := createBuiltinHandler(unknownTool)
+	unknownHandler := createBuiltinHandler(unknownTool, &registerOptions{})
 	
 	request := mcp.CallToolRequest{
 		Params: mcp.CallToolParams{
@@ -247,7 +252,7 @@ func TestCreateCommandHandler(t *testing.T) {
 		Args:    []string{"Hello", "{{name}}"},
 	}
 	
-	handler := createCommandHandler(tool)
+	handler := createCommandHandler(tool, &registerOptions{})
 	if handler == nil {
 		t.Error("Expected handler function, got nil")
 	}
@@ -278,7 +283,7 @@ func TestCreateScriptHandler(t *testing.T) {
 		Script: "echo 'Hello {{name}}'",
 	}
 	
-	handler := createScriptHandler(tool)
+	handler := createScriptHandler(tool, &registerOptions{})
 	if handler == nil {
 		t.Error("Expected handler function, got nil")
 	}
@@ -302,7 +307,7 @@ func TestCreateScriptHandler(t *testing.T) {
 	}
 }
 
-func TestReplacePlaceholders(t *testing.T) {
+func TestRenderTemplateUnquoted(t *testing.T) {
 	tests := []struct {
 		name      string
 		text      string
@@ -348,13 +353,13 @@ func TestReplacePlaceholders(t *testing.T) {
 			arguments: map[string]interface{}{
 				"name": "World",
 			},
-			expected: "Hello World and {{missing}}",
+			expected: "Hello World and ",
 		},
 		{
 			name:      "empty arguments",
 			text:      "{{empty}} placeholder",
 			arguments: map[string]interface{}{},
-			expected:  "{{empty}} placeholder",
+			expected:  " placeholder",
 		},
 		{
 			name: "numeric values",
@@ -376,7 +381,10 @@ func TestReplacePlaceholders(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := replacePlaceholders(tt.text, tt.arguments)
+			result, err := renderTemplate(tt.text, tt.arguments, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
 			if result != tt.expected {
 				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
 			}
@@ -409,4 +417,391 @@ func TestRegisterToolsNilParameters(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected no error for nil parameters, got %v", err)
 	}
-}
\ No newline at end of file
+}
+func TestCreateScriptHandlerTimeout(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:    "slow_script",
+		Type:    "script",
+		Script:  "sleep 30",
+		Timeout: 500 * time.Millisecond,
+	}
+
+	handler := createScriptHandler(tool, &registerOptions{})
+	request := mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{},
+		},
+	}
+
+	start := time.Now()
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Script was not stopped by its timeout, took %v", elapsed)
+	}
+
+	if !result.IsError {
+		t.Fatal("Expected error result for timed out script")
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "timed out after 500ms") {
+		t.Errorf("Expected timeout message, got '%s'", text)
+	}
+}
+
+func TestCreateCommandHandlerDefaultTimeout(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:    "slow_command",
+		Type:    "command",
+		Command: "sleep",
+		Args:    []string{"30"},
+	}
+
+	handler := createCommandHandler(tool, &registerOptions{defaultTimeout: 500 * time.Millisecond})
+	request := mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{},
+		},
+	}
+
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !result.IsError {
+		t.Fatal("Expected error result for timed out command")
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "Command timed out") {
+		t.Errorf("Expected timeout message, got '%s'", text)
+	}
+}
+
+func TestCancelledNotificationStopsTool(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", ServerOptions(nil)...)
+
+	tools := []config.ToolConfig{
+		{
+			Name:   "slow_script",
+			Type:   "script",
+			Script: "sleep 30",
+		},
+	}
+	if err := RegisterTools(mcpServer, tools); err != nil {
+		t.Fatalf("Failed to register tools: %v", err)
+	}
+
+	done := make(chan mcp.JSONRPCMessage, 1)
+	go func() {
+		done <- mcpServer.HandleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"slow_script","arguments":{}}}`))
+	}()
+
+	// Keep cancelling until the call has registered itself and returned
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case response := <-done:
+			resp, ok := response.(mcp.JSONRPCResponse)
+			if !ok {
+				t.Fatalf("Expected JSON-RPC response, got %T", response)
+			}
+			result := resp.Result.(*mcp.CallToolResult)
+			text := result.Content[0].(mcp.TextContent).Text
+			if !result.IsError || !strings.Contains(text, "Script cancelled") {
+				t.Errorf("Expected cancelled result, got '%s'", text)
+			}
+			return
+		case <-ticker.C:
+			mcpServer.HandleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`))
+		case <-deadline:
+			t.Fatal("Tool call was not cancelled")
+		}
+	}
+}
+
+func TestArgumentValidation(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "build",
+		Type:   "script",
+		Script: "echo {{board}} {{source_dir}}",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"board":      map[string]interface{}{"type": "string"},
+				"source_dir": map[string]interface{}{"type": "string", "default": "."},
+				"jobs":       map[string]interface{}{"type": "integer", "minimum": 1},
+			},
+			"required": []interface{}{"board"},
+		},
+	}
+
+	var received map[string]interface{}
+	handler := withArgumentValidation(tool, func(_ context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		received = request.Params.Arguments.(map[string]interface{})
+		return mcp.NewToolResultText("ok"), nil
+	})
+
+	// Invalid arguments never reach the handler and every violation is listed
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{"jobs": float64(0)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.IsError || received != nil {
+		t.Fatal("Expected validation error before the handler runs")
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "board: is required") || !strings.Contains(text, "jobs: must be >= 1") {
+		t.Errorf("Expected all violations in error, got '%s'", text)
+	}
+	if len(result.Content) != 2 || !strings.Contains(result.Content[1].(mcp.TextContent).Text, `"violations"`) {
+		t.Errorf("Expected structured violations, got %v", result.Content)
+	}
+
+	// Valid arguments get their defaults filled in
+	result, err = handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{"board": "esp32"},
+		},
+	})
+	if err != nil || result.IsError {
+		t.Fatalf("Expected success, got %v, %v", result, err)
+	}
+	if received["source_dir"] != "." {
+		t.Errorf("Expected default source_dir, got %v", received["source_dir"])
+	}
+}
+
+func TestScriptHandlerQuotesArguments(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "echo_path",
+		Type:   "script",
+		Script: "printf '%s' {{path}}",
+	}
+
+	handler := createScriptHandler(tool, &registerOptions{})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{"path": "a b; echo injected"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasSuffix(text, "a b; echo injected") || strings.Contains(text, "\ninjected") {
+		t.Errorf("Expected argument to be passed literally, got '%s'", text)
+	}
+}
+
+func TestScriptHandlerStdin(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "confirm",
+		Type:   "script",
+		Script: "read reply; echo \"reply: $reply\"",
+		Stdin:  "{{answer}}\n",
+	}
+
+	handler := createScriptHandler(tool, &registerOptions{})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{"answer": "yes 'y'"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "reply: yes 'y'") {
+		t.Errorf("Expected stdin to be passed verbatim, got '%s'", text)
+	}
+}
+
+func TestCommandHandlerDropsEmptyConditionalArgs(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:    "echo_flags",
+		Type:    "command",
+		Command: "printf",
+		Args:    []string{"[%s]", "{{#if flag}}--flag{{/if}}", "{{name}}"},
+	}
+
+	handler := createCommandHandler(tool, &registerOptions{})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]interface{}{"name": "x"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasSuffix(text, "[x]") {
+		t.Errorf("Expected conditional arg to be dropped, got '%s'", text)
+	}
+}
+
+func TestRegisterToolsInvalidTemplate(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+
+	tools := []config.ToolConfig{
+		{
+			Name:   "broken",
+			Type:   "script",
+			Script: "echo {{#if name}}",
+		},
+	}
+
+	err := RegisterTools(mcpServer, tools)
+	if err == nil || !strings.Contains(err.Error(), "invalid template in tool broken") {
+		t.Errorf("Expected template error, got %v", err)
+	}
+}
+
+func TestToolEnvironmentAndRedaction(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "show_env",
+		Type:   "script",
+		Script: "echo \"region=$REGION token=$API_TOKEN\"",
+		Environment: []config.EnvVar{
+			{Name: "REGION", Value: "cn-north"},
+			{Name: "API_TOKEN", Value: "s3cr3t-value", Secret: true},
+		},
+	}
+
+	serverTool, err := buildTool(tool, &registerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to build tool: %v", err)
+	}
+	result, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "region=cn-north") {
+		t.Errorf("Expected environment variable to be set, got '%s'", text)
+	}
+	if strings.Contains(text, "s3cr3t-value") || !strings.Contains(text, "token="+config.Redacted) {
+		t.Errorf("Expected secret to be redacted, got '%s'", text)
+	}
+}
+
+func TestStructuredResultRedaction(t *testing.T) {
+	dir := t.TempDir()
+	environment := []config.EnvVar{{Name: "API_TOKEN", Value: "s3cr3t-value", Secret: true}}
+	tools := []config.ToolConfig{
+		{
+			Name:        "show_json",
+			Type:        "script",
+			Script:      `printf '{"token": "%s", "nested": [{"%s": "x"}]}' "$API_TOKEN" "$API_TOKEN"`,
+			Output:      config.OutputConfig{Mode: "json"},
+			Environment: environment,
+		},
+		{
+			Name:        "write_file",
+			Type:        "script",
+			Script:      `echo "token=$API_TOKEN" > ` + shell.Quote("sh", filepath.Join(dir, "out.txt")),
+			Output:      config.OutputConfig{Mode: "file", Path: filepath.Join(dir, "out.txt")},
+			Environment: environment,
+		},
+	}
+
+	for _, tool := range tools {
+		serverTool, err := buildTool(tool, &registerOptions{shellEnv: shell.CleanEnv})
+		if err != nil {
+			t.Fatalf("Failed to build %s: %v", tool.Name, err)
+		}
+		result, err := serverTool.Handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+		})
+		if err != nil || result.IsError {
+			t.Fatalf("%s failed: %v %v", tool.Name, err, result.Content)
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("Failed to encode result: %v", err)
+		}
+		if strings.Contains(string(data), "s3cr3t-value") || !strings.Contains(string(data), config.Redacted) {
+			t.Errorf("Expected the secret to be redacted from the result of %s, got %s", tool.Name, data)
+		}
+	}
+}
+
+func TestLuaHandlerGetenv(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "env.lua")
+	if err := os.WriteFile(script, []byte(`result = os.getenv("REGION") .. "/" .. tostring(os.getenv("DIZI_TEST_UNSET"))`), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	tool := config.ToolConfig{
+		Name:        "lua_env",
+		Type:        "lua",
+		Script:      script,
+		Environment: []config.EnvVar{{Name: "REGION", Value: "cn-north"}},
+	}
+
+	handler := createLuaHandler(tool, &registerOptions{})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if text := result.Content[0].(mcp.TextContent).Text; text != "cn-north/nil" {
+		t.Errorf("Expected 'cn-north/nil', got '%s'", text)
+	}
+}
+
+func TestScriptHandlerPolicy(t *testing.T) {
+	tool := config.ToolConfig{
+		Name:   "shell_eval",
+		Type:   "script",
+		Script: "{{command|raw}}",
+		Policy: config.PolicyConfig{Allow: []string{"echo", "git"}, Deny: []string{"git push --force"}},
+	}
+	handler := createScriptHandler(tool, &registerOptions{shellEnv: shell.CleanEnv})
+
+	tests := []struct {
+		command  string
+		expected string
+		isError  bool
+	}{
+		{"echo allowed | tr a-z A-Z", "Policy violation: \"tr a-z A-Z\" is not allowed by the policy", true},
+		{"git push --force origin main", "is denied by rule \"git push --force\"", true},
+		{"echo ok && echo done", "ok\ndone", false},
+	}
+	for _, tt := range tests {
+		result, err := handler(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: map[string]interface{}{"command": tt.command}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if text := result.Content[0].(mcp.TextContent).Text; result.IsError != tt.isError || !strings.Contains(text, tt.expected) {
+			t.Errorf("Expected %q for %q, got %q", tt.expected, tt.command, text)
+		}
+	}
+
+	// Command tools are checked with their arguments quoted
+	command := config.ToolConfig{Name: "push", Type: "command", Command: "git push", Args: []string{"{{flags}}"}, Policy: tool.Policy}
+	result, err := createCommandHandler(command, &registerOptions{})(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"flags": "--force"}},
+	})
+	if err != nil || !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "is denied") {
+		t.Errorf("Expected command tool to be denied, got %v %v", err, result.Content)
+	}
+}
@@ -0,0 +1,152 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file keeps the configured tools of a running server in sync with the configuration.
+package tools
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ToolSet tracks the configured tools registered on an MCP server so that a
+// changed configuration can be applied as a diff while clients stay connected
+type ToolSet struct {
+	mcpServer *server.MCPServer
+
+	mu       sync.Mutex
+	tools    map[string]config.ToolConfig
+	builtins []string // names of the registered fetch_output and job_* tools
+	options  *registerOptions
+	outputs  *outputStore
+	jobs     *jobManager
+	sessions *sessionManager
+}
+
+// ToolChanges lists the tool names affected by ToolSet.Apply
+type ToolChanges struct {
+	Added   []string
+	Updated []string
+	Removed []string
+}
+
+// Empty reports whether nothing changed
+func (c ToolChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// NewToolSet creates an empty tool set for mcpServer
+func NewToolSet(mcpServer *server.MCPServer) *ToolSet {
+	return &ToolSet{
+		mcpServer: mcpServer,
+		tools:     make(map[string]config.ToolConfig),
+		outputs:   newOutputStore(),
+		jobs:      newJobManager(),
+		sessions:  newSessionManager(),
+	}
+}
+
+// Apply makes the server's configured tools match tools: new tools are added,
+// changed ones replaced and missing ones removed. All tools are built before
+// anything is touched, so on error the previously applied tools stay active.
+// Connected clients receive notifications/tools/list_changed for every change.
+// The builtin fetch_output and job_* tools are registered while a tool needs
+// them; running jobs and shell sessions are kept when their tool changes.
+func (ts *ToolSet) Apply(tools []config.ToolConfig, opts ...RegisterOption) (ToolChanges, error) {
+	options := newRegisterOptions(opts)
+	options.outputs = ts.outputs
+	options.jobs = ts.jobs
+	options.sessions = ts.sessions
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// Server-wide options affect every handler
+	optionsChanged := ts.options != nil && !reflect.DeepEqual(*ts.options, *options)
+
+	var changes ToolChanges
+	var serverTools []server.ServerTool
+	next := make(map[string]config.ToolConfig, len(tools))
+	for _, tool := range tools {
+		next[tool.Name] = tool
+
+		previous, exists := ts.tools[tool.Name]
+		if exists && !optionsChanged && reflect.DeepEqual(previous, tool) {
+			continue
+		}
+
+		serverTool, err := buildTool(tool, options)
+		if err != nil {
+			return ToolChanges{}, err
+		}
+		serverTools = append(serverTools, serverTool)
+
+		if exists {
+			changes.Updated = append(changes.Updated, tool.Name)
+		} else {
+			changes.Added = append(changes.Added, tool.Name)
+		}
+	}
+
+	for name := range ts.tools {
+		if _, ok := next[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	sort.Strings(changes.Removed)
+
+	builtins := ts.builtinTools(tools, options)
+	names := make([]string, len(builtins))
+	for i, builtin := range builtins {
+		names[i] = builtin.Tool.Name
+	}
+	removed := slices.Clone(changes.Removed)
+	for _, name := range ts.builtins {
+		if !slices.Contains(names, name) {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) > 0 {
+		ts.mcpServer.DeleteTools(removed...)
+	}
+	for _, builtin := range builtins {
+		if optionsChanged || !slices.Contains(ts.builtins, builtin.Tool.Name) {
+			serverTools = append(serverTools, builtin)
+		}
+	}
+	if len(serverTools) > 0 {
+		ts.mcpServer.AddTools(serverTools...)
+	}
+
+	ts.tools = next
+	ts.builtins = names
+	ts.options = options
+	return changes, nil
+}
+
+// builtinTools returns the builtin tools that tools need: fetch_output if
+// any of them truncates long output, the job_* tools if any runs as a
+// background job
+func (ts *ToolSet) builtinTools(tools []config.ToolConfig, options *registerOptions) []server.ServerTool {
+	var truncates, async bool
+	for _, tool := range tools {
+		truncates = truncates || limitEnabled(options.outputLimitFor(tool))
+		async = async || tool.Async
+	}
+
+	var builtins []server.ServerTool
+	if truncates {
+		builtins = append(builtins, fetchOutputTool(ts.outputs))
+	}
+	if async {
+		builtins = append(builtins, jobTools(ts.jobs)...)
+	}
+	for i := range builtins {
+		builtins[i].Handler = withAudit(builtins[i].Tool.Name, nil, options.audit, builtins[i].Handler)
+	}
+	return builtins
+}
@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// listToolNames returns the sorted names of the configured tools the server
+// advertises, leaving out the builtin ones every server has
+func listToolNames(t *testing.T, mcpServer *server.MCPServer) []string {
+	t.Helper()
+
+	response := mcpServer.HandleMessage(context.Background(),
+		json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	resp, ok := response.(mcp.JSONRPCResponse)
+	if !ok {
+		t.Fatalf("Expected JSONRPCResponse, got %T", response)
+	}
+	result, ok := resp.Result.(mcp.ListToolsResult)
+	if !ok {
+		t.Fatalf("Expected ListToolsResult, got %T", resp.Result)
+	}
+
+	var names []string
+	for _, tool := range result.Tools {
+		if !slices.Contains(config.ReservedToolNames, tool.Name) {
+			names = append(names, tool.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestToolSetApply(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	toolSet := NewToolSet(mcpServer)
+
+	echo := config.ToolConfig{Name: "echo", Type: "command", Command: "echo", Args: []string{"a"}}
+	greet := config.ToolConfig{Name: "greet", Type: "script", Script: "echo hi"}
+
+	changes, err := toolSet.Apply([]config.ToolConfig{echo, greet})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(changes.Added) != 2 || len(changes.Updated) != 0 || len(changes.Removed) != 0 {
+		t.Errorf("Unexpected changes on first apply: %+v", changes)
+	}
+
+	// Applying the same configuration again changes nothing
+	changes, err = toolSet.Apply([]config.ToolConfig{echo, greet})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !changes.Empty() {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+
+	// Modify one tool, remove another and add a new one
+	echo.Args = []string{"b"}
+	date := config.ToolConfig{Name: "date", Type: "command", Command: "date"}
+	changes, err = toolSet.Apply([]config.ToolConfig{echo, date})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(changes.Added) != 1 || changes.Added[0] != "date" {
+		t.Errorf("Expected date to be added, got %+v", changes)
+	}
+	if len(changes.Updated) != 1 || changes.Updated[0] != "echo" {
+		t.Errorf("Expected echo to be updated, got %+v", changes)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0] != "greet" {
+		t.Errorf("Expected greet to be removed, got %+v", changes)
+	}
+
+	names := listToolNames(t, mcpServer)
+	if len(names) != 2 || names[0] != "date" || names[1] != "echo" {
+		t.Errorf("Expected tools [date echo], got %v", names)
+	}
+
+	// Changing the server-wide options rebuilds every tool
+	changes, err = toolSet.Apply([]config.ToolConfig{echo, date}, WithDefaultTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(changes.Updated) != 2 {
+		t.Errorf("Expected all tools to be updated, got %+v", changes)
+	}
+}
+
+func TestToolSetApplyInvalidKeepsPrevious(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	toolSet := NewToolSet(mcpServer)
+
+	echo := config.ToolConfig{Name: "echo", Type: "command", Command: "echo"}
+	if _, err := toolSet.Apply([]config.ToolConfig{echo}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	broken := config.ToolConfig{Name: "broken", Type: "unknown"}
+	if _, err := toolSet.Apply([]config.ToolConfig{broken}); err == nil {
+		t.Fatal("Expected error for unsupported tool type")
+	}
+
+	names := listToolNames(t, mcpServer)
+	if len(names) != 1 || names[0] != "echo" {
+		t.Errorf("Expected previous tools to stay active, got %v", names)
+	}
+
+	// The failed apply must not be remembered as the current state
+	changes, err := toolSet.Apply([]config.ToolConfig{echo})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !changes.Empty() {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+}
+
+func TestToolSetNotifiesListChanged(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(true))
+	session := newTestSession("reload")
+	if err := mcpServer.RegisterSession(context.Background(), session); err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+
+	toolSet := NewToolSet(mcpServer)
+	if _, err := toolSet.Apply([]config.ToolConfig{{Name: "echo", Type: "command", Command: "echo"}}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	select {
+	case notification := <-session.notifications:
+		if notification.Method != "notifications/tools/list_changed" {
+			t.Errorf("Expected tools/list_changed, got %s", notification.Method)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected tools/list_changed notification")
+	}
+}
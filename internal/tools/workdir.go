@@ -0,0 +1,82 @@
+// Package tools provides tool registration and execution for the MCP server.
+// This file resolves the working directory and file paths of tools.
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dizi/internal/config"
+)
+
+// workingDir returns the directory a command or script tool runs in, or ""
+// to inherit the server's. The cwd is rendered with the call's arguments and
+// resolved against the directory of the config file defining the tool; a cwd
+// built from arguments must stay within the allowed root.
+func workingDir(tool config.ToolConfig, arguments map[string]interface{}, options *registerOptions) (string, error) {
+	if tool.Cwd == "" {
+		return "", nil
+	}
+
+	dir, err := renderTemplate(tool.Cwd, arguments, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid template in cwd: %w", err)
+	}
+	dir = toolPath(tool, dir)
+
+	if strings.Contains(tool.Cwd, "{{") {
+		root := options.allowedRoot(tool)
+		if !withinRoot(root, dir) {
+			return "", fmt.Errorf("working directory %s is outside the allowed root %s", dir, root)
+		}
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("working directory %s does not exist", dir)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("working directory %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// allowedRoot returns the directory that paths built from a tool's arguments
+// must stay within: the configured root, or the directory of the tool's config file
+func (o *registerOptions) allowedRoot(tool config.ToolConfig) string {
+	if o.root != "" {
+		return o.root
+	}
+	return toolPath(tool, ".")
+}
+
+// toolPath resolves path against the directory of the config file defining
+// the tool, or the server's working directory if that is unknown
+func toolPath(tool config.ToolConfig, path string) string {
+	if !filepath.IsAbs(path) && tool.Dir != "" {
+		path = filepath.Join(tool.Dir, path)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return filepath.Clean(path)
+}
+
+// withinRoot reports whether path is root or below it, following symlinks
+// so that a link inside the root cannot point outside of it
+func withinRoot(root, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
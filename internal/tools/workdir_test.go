@@ -0,0 +1,114 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dizi/internal/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestWorkingDir(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"build", "src/app"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	options := &registerOptions{root: root}
+
+	tests := []struct {
+		name      string
+		cwd       string
+		arguments map[string]interface{}
+		expected  string
+		wantErr   string
+	}{
+		{"inherit", "", nil, "", ""},
+		{"relative to config", "build", nil, filepath.Join(root, "build"), ""},
+		{"static outside root", outside, nil, outside, ""},
+		{"templated", "{{source_dir}}", map[string]interface{}{"source_dir": "src/app"}, filepath.Join(root, "src/app"), ""},
+		{"templated default", "{{source_dir|default:.}}", map[string]interface{}{}, root, ""},
+		{"templated escape", "{{source_dir}}", map[string]interface{}{"source_dir": "../.."}, "", "outside the allowed root"},
+		{"templated absolute", "{{source_dir}}", map[string]interface{}{"source_dir": outside}, "", "outside the allowed root"},
+		{"templated symlink", "{{source_dir}}", map[string]interface{}{"source_dir": "escape"}, "", "outside the allowed root"},
+		{"missing", "{{source_dir}}", map[string]interface{}{"source_dir": "absent"}, "", "does not exist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := config.ToolConfig{Name: "build", Type: "command", Cwd: tt.cwd, Dir: root}
+			dir, err := workingDir(tool, tt.arguments, options)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if dir != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, dir)
+			}
+		})
+	}
+}
+
+func TestCommandHandlerCwd(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	tool := config.ToolConfig{Name: "where", Type: "command", Command: "pwd", Cwd: "{{dir}}", Dir: root}
+	handler := createCommandHandler(tool, &registerOptions{root: root})
+
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"dir": "sub"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected, _ := filepath.EvalSymlinks(filepath.Join(root, "sub"))
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.HasSuffix(strings.TrimSpace(text), expected) {
+		t.Errorf("Expected to run in %s, got '%s'", expected, text)
+	}
+
+	result, err = handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"dir": "../"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.IsError {
+		t.Error("Expected escaping the root to fail")
+	}
+}
+
+func TestLuaHandlerScriptRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.lua"), []byte(`result = "hello"`), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	tool := config.ToolConfig{Name: "hello", Type: "lua", Script: "hello.lua", Dir: dir}
+	handler := createLuaHandler(tool, &registerOptions{})
+	result, err := handler(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; text != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", text)
+	}
+}